@@ -39,6 +39,7 @@ const (
 	MimetypeDataWithValidator = "data/validator"
 	MimetypeTypedData         = "data/typed"
 	MimetypeClique            = "application/x-clique-header"
+	MimetypeSenate            = "application/x-senate-header"
 	MimetypeTextPlain         = "text/plain"
 )
 
@@ -77,6 +77,10 @@ var (
 		utils.EthashDatasetsInMemoryFlag,
 		utils.EthashDatasetsOnDiskFlag,
 		utils.EthashDatasetsLockMmapFlag,
+		utils.SenateVerifyWorkersFlag,
+		utils.SenateSnapshotRetentionFlag,
+		utils.SenateReadRetriesFlag,
+		utils.SenateStrictVerificationFlag,
 		utils.TxPoolLocalsFlag,
 		utils.TxPoolNoLocalsFlag,
 		utils.TxPoolJournalFlag,
@@ -87,6 +87,15 @@ var AppHelpFlagGroups = []flags.FlagGroup{
 			utils.EthashDatasetsLockMmapFlag,
 		},
 	},
+	{
+		Name: "SENATE",
+		Flags: []cli.Flag{
+			utils.SenateVerifyWorkersFlag,
+			utils.SenateSnapshotRetentionFlag,
+			utils.SenateReadRetriesFlag,
+			utils.SenateStrictVerificationFlag,
+		},
+	},
 	{
 		Name: "TRANSACTION POOL",
 		Flags: []cli.Flag{
@@ -290,6 +290,26 @@ var (
 		Name:  "ethash.dagslockmmap",
 		Usage: "Lock memory maps for recent ethash mining DAGs",
 	}
+	// Senate settings
+	SenateVerifyWorkersFlag = cli.Uint64Flag{
+		Name:  "senate.verifyworkers",
+		Usage: "Number of goroutines checking a batch of senate headers (0 = number of CPUs)",
+		Value: eth.DefaultConfig.Senate.VerifyWorkers,
+	}
+	SenateSnapshotRetentionFlag = cli.Uint64Flag{
+		Name:  "senate.snapshotretention",
+		Usage: "Number of recent blocks whose senate snapshots are kept when pruning (0 = never pruned)",
+		Value: eth.DefaultConfig.Senate.SnapshotRetention,
+	}
+	SenateReadRetriesFlag = cli.Uint64Flag{
+		Name:  "senate.readretries",
+		Usage: "Number of times a transient senate snapshot read failure is retried",
+		Value: eth.DefaultConfig.Senate.ReadRetries,
+	}
+	SenateStrictVerificationFlag = cli.BoolFlag{
+		Name:  "senate.strictverification",
+		Usage: "Rebuild every senate snapshot trie of verified headers to localize a root mismatch (slow)",
+	}
 	// Transaction pool settings
 	TxPoolLocalsFlag = cli.StringFlag{
 		Name:  "txpool.locals",
@@ -1371,6 +1391,21 @@ func setEthash(ctx *cli.Context, cfg *eth.Config) {
 	}
 }
 
+func setSenate(ctx *cli.Context, cfg *senate.Options) {
+	if ctx.GlobalIsSet(SenateVerifyWorkersFlag.Name) {
+		cfg.VerifyWorkers = ctx.GlobalUint64(SenateVerifyWorkersFlag.Name)
+	}
+	if ctx.GlobalIsSet(SenateSnapshotRetentionFlag.Name) {
+		cfg.SnapshotRetention = ctx.GlobalUint64(SenateSnapshotRetentionFlag.Name)
+	}
+	if ctx.GlobalIsSet(SenateReadRetriesFlag.Name) {
+		cfg.ReadRetries = ctx.GlobalUint64(SenateReadRetriesFlag.Name)
+	}
+	if ctx.GlobalIsSet(SenateStrictVerificationFlag.Name) {
+		cfg.StrictVerification = ctx.GlobalBool(SenateStrictVerificationFlag.Name)
+	}
+}
+
 func setMiner(ctx *cli.Context, cfg *miner.Config) {
 	if ctx.GlobalIsSet(MinerNotifyFlag.Name) {
 		cfg.Notify = strings.Split(ctx.GlobalString(MinerNotifyFlag.Name), ",")
@@ -1500,6 +1535,7 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	setGPO(ctx, &cfg.GPO, ctx.GlobalString(SyncModeFlag.Name) == "light")
 	setTxPool(ctx, &cfg.TxPool)
 	setEthash(ctx, cfg)
+	setSenate(ctx, &cfg.Senate)
 	setMiner(ctx, &cfg.Miner)
 	setWhitelist(ctx, cfg)
 	setLes(ctx, cfg)
@@ -1812,7 +1848,12 @@ func MakeChain(ctx *cli.Context, stack *node.Node, readOnly bool) (chain *core.B
 	if config.Clique != nil {
 		engine = clique.New(config.Clique, chainDb)
 	} else if config.Senate != nil {
-		engine = senate.New(config.Senate, chainDb)
+		if err := config.Senate.Validate(); err != nil {
+			Fatalf("Invalid senate config: %v", err)
+		}
+		options := eth.DefaultConfig.Senate
+		setSenate(ctx, &options)
+		engine = senate.NewWithOptions(config.Senate, config.ChainID, chainDb, options)
 	} else {
 		engine = ethash.NewFaker()
 		if !ctx.GlobalBool(FakePoWFlag.Name) {
@@ -122,6 +122,16 @@ type Engine interface {
 	Close() error
 }
 
+// FinalizeVerifier is a consensus engine whose Finalize may find a block invalid,
+// although it has no way to return the error.
+type FinalizeVerifier interface {
+	Engine
+
+	// VerifyFinalize returns the error Finalize found the block of header
+	// invalid for, nil if it didn't.
+	VerifyFinalize(header *types.Header) error
+}
+
 // PoW is a consensus engine based on proof-of-work.
 type PoW interface {
 	Engine
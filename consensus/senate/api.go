@@ -1,10 +1,19 @@
 package senate
 
 import (
+	"errors"
+	"fmt"
+	"math/big"
 	"sort"
+	"sync/atomic"
+	"time"
 
+	"github.com/SecretBlockChain/go-secret/common"
+	"github.com/SecretBlockChain/go-secret/common/hexutil"
 	"github.com/SecretBlockChain/go-secret/consensus"
+	"github.com/SecretBlockChain/go-secret/core/state"
 	"github.com/SecretBlockChain/go-secret/core/types"
+	"github.com/SecretBlockChain/go-secret/params"
 	"github.com/SecretBlockChain/go-secret/rpc"
 )
 
@@ -15,32 +24,839 @@ type API struct {
 	senate *Senate
 }
 
-// GetValidators retrieves the list of the validators at specified block
-func (api *API) GetValidators(number *rpc.BlockNumber) (SortableAddresses, error) {
+// GetSnapshot retrieves the state snapshot at a given block.
+func (api *API) GetSnapshot(number *rpc.BlockNumber) (*Snapshot, error) {
 	var header *types.Header
 	if number == nil || *number == rpc.LatestBlockNumber {
 		header = api.chain.CurrentHeader()
 	} else {
 		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
 	}
+	return api.snapshot(header)
+}
+
+// GetSnapshotAtHash retrieves the state snapshot at a given block.
+func (api *API) GetSnapshotAtHash(hash common.Hash) (*Snapshot, error) {
+	return api.snapshot(api.chain.GetHeaderByHash(hash))
+}
+
+// DumpSnapshot retrieves the full contents of the state snapshot at a given
+// block, to diff the snapshots of two nodes.
+func (api *API) DumpSnapshot(number *rpc.BlockNumber) (*SnapshotDump, error) {
+	_, snap, err := api.snapshotAt(number)
+	if err != nil {
+		return nil, err
+	}
+	return snap.Dump()
+}
+
+// GetValidators retrieves the list of the validators at specified block, in
+// the order used to decide which validator is in turn. The snapshot of the
+// first block of an epoch already contains the newly elected validators.
+func (api *API) GetValidators(number *rpc.BlockNumber) ([]common.Address, error) {
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	snap, err := api.snapshot(header)
+	if err != nil {
+		return nil, err
+	}
+	if header.Number.Uint64() == 0 {
+		return []common.Address{}, nil
+	}
+	return api.validators(snap)
+}
+
+// stateReader is implemented by chains which can open the state of a block.
+type stateReader interface {
+	StateAt(root common.Hash) (*state.StateDB, error)
+}
+
+// CandidateInfo is a candidate with its stake and votes.
+type CandidateInfo struct {
+	Address        common.Address `json:"address"`
+	SelfStake      *big.Int       `json:"self_stake"`      // Deposit locked by the candidate
+	DelegatedStake *big.Int       `json:"delegated_stake"` // Balance of the delegators
+	TotalStake     *big.Int       `json:"total_stake"`
+	Votes          int            `json:"votes"` // Count of the delegators
+}
+
+// GetCandidates retrieves the candidates at specified block ordered by total
+// stake descending.
+func (api *API) GetCandidates(number *rpc.BlockNumber) ([]CandidateInfo, error) {
+	header, snap, err := api.snapshotAt(number)
+	if err != nil {
+		return nil, err
+	}
+	statedb, err := api.stateAt(header)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := snap.GetCandidates()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]CandidateInfo, 0, len(candidates))
+	for _, candidate := range candidates {
+		deposit, err := snap.GetDeposit(candidate)
+		if err != nil {
+			return nil, err
+		}
+		delegators, err := snap.GetDelegators(candidate)
+		if err != nil {
+			return nil, err
+		}
+		delegated := big.NewInt(0)
+		for _, delegator := range delegators {
+			delegated.Add(delegated, statedb.GetBalance(delegator))
+		}
+		result = append(result, CandidateInfo{
+			Address:        candidate,
+			SelfStake:      deposit,
+			DelegatedStake: delegated,
+			TotalStake:     new(big.Int).Add(deposit, delegated),
+			Votes:          len(delegators),
+		})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].TotalStake.Cmp(result[j].TotalStake) > 0
+	})
+	return result, nil
+}
+
+// Delegation is a delegator and the candidate it delegated to. The amount is
+// the vote weight in wei counted in elections, the balance of the delegator
+// reduced by the decay of votes not renewed.
+type Delegation struct {
+	Delegator common.Address `json:"delegator"`
+	Candidate common.Address `json:"candidate"`
+	Amount    *big.Int       `json:"amount"`
+}
+
+// GetDelegations retrieves the delegations of delegator at specified block. A
+// delegator delegates to one candidate at most, delegations staged until the
+// next epoch are not included.
+func (api *API) GetDelegations(delegator common.Address, number *rpc.BlockNumber) ([]Delegation, error) {
+	header, snap, err := api.snapshotAt(number)
+	if err != nil {
+		return nil, err
+	}
+	return api.delegations(header, snap, delegator)
+}
+
+// delegations returns the delegations of delegator in the snapshot of header.
+func (api *API) delegations(header *types.Header, snap *Snapshot, delegator common.Address) ([]Delegation, error) {
+	candidate, ok, err := snap.GetDelegatedCandidate(delegator)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return []Delegation{}, nil
+	}
+	statedb, err := api.stateAt(header)
+	if err != nil {
+		return nil, err
+	}
+	weight, err := snap.VoteWeight(statedb, delegator)
+	if err != nil {
+		return nil, err
+	}
+	return []Delegation{{Delegator: delegator, Candidate: candidate, Amount: weight}}, nil
+}
+
+// GetDelegators retrieves the delegations to candidate at specified block
+// ordered by delegator address.
+func (api *API) GetDelegators(candidate common.Address, number *rpc.BlockNumber) ([]Delegation, error) {
+	header, snap, err := api.snapshotAt(number)
+	if err != nil {
+		return nil, err
+	}
+	delegators, err := snap.GetDelegators(candidate)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Delegation, 0, len(delegators))
+	if len(delegators) == 0 {
+		return result, nil
+	}
+	statedb, err := api.stateAt(header)
+	if err != nil {
+		return nil, err
+	}
+	for _, delegator := range delegators {
+		weight, err := snap.VoteWeight(statedb, delegator)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, Delegation{Delegator: delegator, Candidate: candidate, Amount: weight})
+	}
+	return result, nil
+}
+
+// StakingSummary is the staking position of an address.
+type StakingSummary struct {
+	TotalDelegated *big.Int     `json:"total_delegated"` // Vote weight of the delegations
+	Delegations    []Delegation `json:"delegations"`
+	Unbondings     []Unbonding  `json:"unbondings"`     // Refunds locked until their release time
+	PendingReward  *big.Int     `json:"pending_reward"` // Reward claimable by withdraw
+	IsCandidate    bool         `json:"is_candidate"`
+}
+
+// GetStakingSummary retrieves the staking position of address at specified
+// block, all zeroed for an address without any activity.
+func (api *API) GetStakingSummary(address common.Address, number *rpc.BlockNumber) (StakingSummary, error) {
+	header, snap, err := api.snapshotAt(number)
+	if err != nil {
+		return StakingSummary{}, err
+	}
+	delegations, err := api.delegations(header, snap, address)
+	if err != nil {
+		return StakingSummary{}, err
+	}
+	summary := StakingSummary{
+		TotalDelegated: big.NewInt(0),
+		Delegations:    delegations,
+		Unbondings:     []Unbonding{},
+	}
+	for _, delegation := range delegations {
+		summary.TotalDelegated.Add(summary.TotalDelegated, delegation.Amount)
+	}
+
+	unbondings, err := snap.GetUnbondings()
+	if err != nil {
+		return StakingSummary{}, err
+	}
+	for _, unbonding := range unbondings {
+		if unbonding.Address == address {
+			summary.Unbondings = append(summary.Unbondings, unbonding)
+		}
+	}
+	if summary.PendingReward, err = snap.GetPendingReward(address); err != nil {
+		return StakingSummary{}, err
+	}
+	if summary.IsCandidate, err = snap.IsCandidate(address); err != nil {
+		return StakingSummary{}, err
+	}
+	return summary, nil
+}
+
+// CandidateValidity tells whether an address can be delegated to, with the
+// reason if it can't.
+type CandidateValidity struct {
+	Valid  bool   `json:"valid"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// IsValidCandidate checks at specified block whether the address is a
+// registered candidate, not slashed in the current epoch and eligible for
+// the next election, so delegations to it count.
+func (api *API) IsValidCandidate(address common.Address, number *rpc.BlockNumber) (CandidateValidity, error) {
+	header, snap, err := api.snapshotAt(number)
+	if err != nil {
+		return CandidateValidity{}, err
+	}
+	isCandidate, err := snap.IsCandidate(address)
+	if err != nil {
+		return CandidateValidity{}, err
+	}
+	if !isCandidate {
+		return CandidateValidity{Reason: "not a registered candidate"}, nil
+	}
+
+	headerExtra, err := decodeHeaderExtra(header)
+	if err != nil {
+		return CandidateValidity{}, err
+	}
+	slashes, err := snap.GetSlashes(headerExtra.Epoch)
+	if err != nil {
+		return CandidateValidity{}, err
+	}
+	for _, slash := range slashes {
+		if slash.Validator == address {
+			return CandidateValidity{Reason: fmt.Sprintf("slashed in epoch %d", headerExtra.Epoch)}, nil
+		}
+	}
+
+	config, err := api.senate.chainConfig(header)
+	if err != nil {
+		return CandidateValidity{}, err
+	}
+	if config.MinSelfStakePercent > 0 {
+		statedb, err := api.stateAt(header)
+		if err != nil {
+			return CandidateValidity{}, err
+		}
+		shortfalls, err := snap.SelfStakeShortfalls(statedb, config.MinSelfStakePercent)
+		if err != nil {
+			return CandidateValidity{}, err
+		}
+		if containsAddress(shortfalls, address) {
+			return CandidateValidity{Reason: fmt.Sprintf("self-stake below %d%% of the delegated stake",
+				config.MinSelfStakePercent)}, nil
+		}
+	}
+	return CandidateValidity{Valid: true}, nil
+}
+
+// stateAt opens the state of the header.
+func (api *API) stateAt(header *types.Header) (*state.StateDB, error) {
+	chain, ok := api.chain.(stateReader)
+	if !ok {
+		return nil, errors.New("state of chain unavailable")
+	}
+	return chain.StateAt(header.Root)
+}
+
+// ProposalStatus is a proposal with the tally of the current epoch.
+type ProposalStatus struct {
+	Proposal
+	Epoch       uint64 `json:"epoch"`        // Epoch the declarations are counted in
+	Deadline    uint64 `json:"deadline"`     // Time the proposal expires, or the declarations of the epoch if it never expires
+	Yes         int    `json:"yes"`          // Count of validators declared yes
+	No          int    `json:"no"`           // Count of validators declared no
+	VotesNeeded int    `json:"votes_needed"` // Count of yes declarations still needed to approve, 0 if weighed by stake
+	Approved    bool   `json:"approved"`
+}
+
+// GetProposals retrieves the pending proposals at specified block.
+func (api *API) GetProposals(number *rpc.BlockNumber) ([]ProposalStatus, error) {
+	header, snap, err := api.snapshotAt(number)
+	if err != nil {
+		return nil, err
+	}
+	proposals, err := snap.GetProposals()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ProposalStatus, 0, len(proposals))
+	for _, proposal := range proposals {
+		if proposal.ApprovedHash != nil {
+			continue
+		}
+		status, err := api.proposalStatus(header, snap, proposal)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, status)
+	}
+	return result, nil
+}
+
+// GetProposal retrieves the proposal at specified block, ErrUnknownProposal is
+// returned if the proposal doesn't exist.
+func (api *API) GetProposal(id common.Hash, number *rpc.BlockNumber) (*ProposalStatus, error) {
+	header, snap, err := api.snapshotAt(number)
+	if err != nil {
+		return nil, err
+	}
+	proposal, err := snap.GetProposal(id)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := api.proposalStatus(header, snap, proposal)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// GetCandidateKey retrieves the auxiliary public key registered by candidate
+// at specified block, empty if the candidate registered without a key.
+func (api *API) GetCandidateKey(candidate common.Address, number *rpc.BlockNumber) (hexutil.Bytes, error) {
+	_, snap, err := api.snapshotAt(number)
+	if err != nil {
+		return nil, err
+	}
+	return snap.GetCandidateKey(candidate)
+}
+
+// GetCandidateDeclaration retrieves the self-description published by candidate
+// at specified block, nil if the candidate never published one.
+func (api *API) GetCandidateDeclaration(candidate common.Address, number *rpc.BlockNumber) (*CandidateDeclaration, error) {
+	_, snap, err := api.snapshotAt(number)
+	if err != nil {
+		return nil, err
+	}
+	return snap.GetCandidateDeclaration(candidate)
+}
+
+// proposalStatus counts the declarations on the proposal in the epoch of header.
+func (api *API) proposalStatus(header *types.Header, snap *Snapshot, proposal Proposal) (ProposalStatus, error) {
+	status := ProposalStatus{Proposal: proposal, Approved: proposal.ApprovedHash != nil}
+	if header.Number.Uint64() == 0 {
+		return status, nil
+	}
+
+	headerExtra, err := decodeHeaderExtra(header)
+	if err != nil {
+		return ProposalStatus{}, err
+	}
+	config, err := api.senate.chainConfig(header)
+	if err != nil {
+		return ProposalStatus{}, err
+	}
+	validators, err := snap.GetValidators()
+	if err != nil {
+		return ProposalStatus{}, err
+	}
+
+	status.Epoch = headerExtra.Epoch
+	status.Deadline = headerExtra.EpochTime + config.Epoch
+	if proposal.ExpireEpoch > headerExtra.Epoch {
+		status.Deadline = headerExtra.EpochTime + (proposal.ExpireEpoch-headerExtra.Epoch)*config.Epoch
+	}
+	status.Yes, status.No, err = snap.CountDeclarations(proposal.Hash, headerExtra.Epoch, validators)
+	if err != nil {
+		return ProposalStatus{}, err
+	}
+	if config.StakeWeightedQuorum {
+		return status, nil
+	}
+	if quorum := approvalQuorum(config, len(validators)); !status.Approved && status.Yes < quorum {
+		status.VotesNeeded = quorum - status.Yes
+	}
+	return status, nil
+}
+
+// snapshotAt loads the header and the state snapshot at specified block.
+func (api *API) snapshotAt(number *rpc.BlockNumber) (*types.Header, *Snapshot, error) {
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	snap, err := api.snapshot(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, snap, nil
+}
+
+// snapshot loads the state snapshot of the header, the genesis block has an
+// empty snapshot.
+func (api *API) snapshot(header *types.Header) (*Snapshot, error) {
 	if header == nil {
 		return nil, errUnknownBlock
 	}
+	if header.Number.Uint64() == 0 {
+		return newSnapshot(api.senate.db)
+	}
+
+	headerExtra, err := decodeHeaderExtra(header)
+	if err != nil {
+		return nil, err
+	}
+	return api.senate.loadSnapshot(headerExtra.Root)
+}
+
+// maxScheduleSlots is the maximum number of slots GetValidatorSchedule projects.
+const maxScheduleSlots = 1024
+
+// ValidatorSlot is a projected time slot and the validator in turn to seal it.
+type ValidatorSlot struct {
+	Time      uint64         `json:"time"`
+	Validator common.Address `json:"validator"`
+}
+
+// GetValidatorSchedule projects the next count slots after the specified block
+// and the validators in turn to seal them. The election of an epoch depends on
+// the hash of its parent block, so only an epoch starting at the next block is
+// elected ahead, the projection stops at any later epoch boundary.
+func (api *API) GetValidatorSchedule(number *rpc.BlockNumber, count int) ([]ValidatorSlot, error) {
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if count < 0 || count > maxScheduleSlots {
+		return nil, fmt.Errorf("invalid count of slots, max %d", maxScheduleSlots)
+	}
+	snap, err := api.snapshot(header)
+	if err != nil {
+		return nil, err
+	}
+	config, err := api.senate.chainConfig(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var epoch, epochTime uint64
+	var validators []common.Address
+	if header.Number.Uint64() > 0 {
+		headerExtra, err := decodeHeaderExtra(header)
+		if err != nil {
+			return nil, err
+		}
+		epoch, epochTime = headerExtra.Epoch, headerExtra.EpochTime
+		if validators, err = api.validators(snap); err != nil {
+			return nil, err
+		}
+	}
+
+	slots := make([]ValidatorSlot, 0, count)
+	for i := 1; i <= count; i++ {
+		time := header.Time + uint64(i)*config.Period
+		if epoch == 0 || isNewEpoch(config, epochTime, time, header.Number.Uint64()+uint64(i)) {
+			// Only the election of next block is predictable
+			if i > 1 {
+				break
+			}
+			next := &types.Header{
+				Number:     new(big.Int).Add(header.Number, big.NewInt(1)),
+				Time:       time,
+				ParentHash: header.Hash(),
+			}
+			var statedb *state.StateDB
+			if chain, ok := api.chain.(stateReader); ok {
+				if statedb, err = chain.StateAt(header.Root); err != nil {
+					return nil, err
+				}
+			}
+			headerExtra := HeaderExtra{Epoch: epoch + 1, EpochTime: time}
+			if err = api.senate.applyPendingConfig(next, snap, &headerExtra); err != nil {
+				return nil, err
+			}
+			if err = api.senate.tryElect(config, statedb, next, snap, &headerExtra); err != nil {
+				return nil, err
+			}
+			if validators, err = api.validators(snap); err != nil {
+				return nil, err
+			}
+			epoch, epochTime = headerExtra.Epoch, headerExtra.EpochTime
+		}
+		if len(validators) == 0 {
+			break
+		}
+
+		idx := (time - epochTime) / config.Period % uint64(len(validators))
+		slots = append(slots, ValidatorSlot{Time: time, Validator: validators[idx]})
+	}
+	return slots, nil
+}
+
+// GetCurrentProposer returns the validator in turn to seal the block after the
+// specified one and the time of its slot, electing the validators of the next
+// epoch if the block ends an epoch.
+func (api *API) GetCurrentProposer(number *rpc.BlockNumber) (ValidatorSlot, error) {
+	slots, err := api.GetValidatorSchedule(number, 1)
+	if err != nil {
+		return ValidatorSlot{}, err
+	}
+	if len(slots) == 0 {
+		return ValidatorSlot{}, errors.New("no validators elected")
+	}
+	return slots[0], nil
+}
 
+// SigningStatus is the count of in-turn slots and minted blocks of a validator
+// in the epoch of a block.
+type SigningStatus struct {
+	Validator common.Address `json:"validator"`
+	Epoch     uint64         `json:"epoch"`
+	Scheduled uint64         `json:"scheduled"` // Count of slots the validator was in turn for up to the block
+	Minted    uint64         `json:"minted"`    // Count of blocks minted by the validator up to the block
+	NextSlot  uint64         `json:"next_slot"` // Time of the next in-turn slot, 0 if it's in the next epoch
+}
+
+// GetSigningStatus reports how many slots of the current epoch the validator
+// was in turn for and how many blocks it actually minted, up to the specified
+// block. A zeroed status is returned if the address isn't a current validator.
+func (api *API) GetSigningStatus(validator common.Address, number *rpc.BlockNumber) (SigningStatus, error) {
+	header, snap, err := api.snapshotAt(number)
+	if err != nil {
+		return SigningStatus{}, err
+	}
+	status := SigningStatus{Validator: validator}
+	if header.Number.Uint64() == 0 {
+		return status, nil
+	}
 	headerExtra, err := decodeHeaderExtra(header)
+	if err != nil {
+		return SigningStatus{}, err
+	}
+	config, err := api.senate.chainConfig(header)
+	if err != nil {
+		return SigningStatus{}, err
+	}
+	validators, err := api.validators(snap)
+	if err != nil {
+		return SigningStatus{}, err
+	}
+	position := -1
+	for idx, address := range validators {
+		if address == validator {
+			position = idx
+			break
+		}
+	}
+	if position < 0 {
+		return status, nil
+	}
+	status.Epoch = headerExtra.Epoch
+
+	// Slots are assigned round-robin since the start of the epoch
+	count, idx := uint64(len(validators)), uint64(position)
+	slots := (header.Time-headerExtra.EpochTime)/config.Period + 1
+	status.Scheduled = slots / count
+	if idx < slots%count {
+		status.Scheduled++
+	}
+	next := slots + (idx+count-slots%count)%count
+	time, block := headerExtra.EpochTime+next*config.Period, header.Number.Uint64()+next+1-slots
+	if !isNewEpoch(config, headerExtra.EpochTime, time, block) {
+		status.NextSlot = time
+	}
+
+	minted, err := snap.CountMinted(headerExtra.Epoch)
+	if err != nil {
+		return SigningStatus{}, err
+	}
+	for _, address := range minted {
+		if address.Address == validator {
+			status.Minted = address.Weight.Uint64()
+		}
+	}
+	return status, nil
+}
+
+// GetMintCounts retrieves the count of blocks minted by each validator in the
+// epoch, up to the specified block if the epoch is still in progress.
+func (api *API) GetMintCounts(epoch uint64, number *rpc.BlockNumber) (map[common.Address]uint64, error) {
+	_, snap, err := api.snapshotAt(number)
 	if err != nil {
 		return nil, err
 	}
+	return snap.MintCounts(epoch)
+}
+
+// GetEpochStats retrieves the aggregates of the epoch recorded up to the
+// specified block, nil if epoch stats aren't enabled or the epoch didn't start.
+func (api *API) GetEpochStats(epoch uint64, number *rpc.BlockNumber) (*EpochStats, error) {
+	_, snap, err := api.snapshotAt(number)
+	if err != nil {
+		return nil, err
+	}
+	return snap.GetEpochStats(epoch)
+}
+
+// GetValidatorUptime retrieves the ratio of blocks minted by the validator to
+// the slots it was in turn for in the epoch of the specified block, so far if
+// the epoch is in progress. Blocks minted out of turn are counted too, so the
+// ratio may exceed 1. Addresses out of the validators have no uptime.
+func (api *API) GetValidatorUptime(validator common.Address, number *rpc.BlockNumber) (float64, error) {
+	status, err := api.GetSigningStatus(validator, number)
+	if err != nil {
+		return 0, err
+	}
+	if status.Scheduled == 0 {
+		return 0, nil
+	}
+	return float64(status.Minted) / float64(status.Scheduled), nil
+}
+
+// EpochInfo is the timing of the epoch of a block.
+type EpochInfo struct {
+	Epoch     uint64 `json:"epoch"`
+	EpochTime uint64 `json:"epoch_time"` // Time of the first block in the epoch
+	Duration  uint64 `json:"duration"`   // Configured length of an epoch in seconds, nominal for block-based epochs
+	EndTime   uint64 `json:"end_time"`   // Time after which the next block starts a new epoch
+	Remaining uint64 `json:"remaining"`  // Seconds from the block time to the end time
+}
+
+// GetEpochInfo retrieves the timing of the epoch at specified block, the
+// genesis block reports the first epoch starting at its own time.
+func (api *API) GetEpochInfo(number *rpc.BlockNumber) (EpochInfo, error) {
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if header == nil {
+		return EpochInfo{}, errUnknownBlock
+	}
+	config, err := api.senate.chainConfig(header)
+	if err != nil {
+		return EpochInfo{}, err
+	}
+
+	info := EpochInfo{Epoch: 1, EpochTime: header.Time, Duration: config.Epoch}
+	if config.EpochBlocks > 0 {
+		info.Duration = config.EpochBlocks * config.Period
+	}
+	if header.Number.Uint64() > 0 {
+		headerExtra, err := decodeHeaderExtra(header)
+		if err != nil {
+			return EpochInfo{}, err
+		}
+		info.Epoch, info.EpochTime = headerExtra.Epoch, headerExtra.EpochTime
+	}
+	info.EndTime = info.EpochTime + info.Duration
+	if header.Time < info.EndTime {
+		info.Remaining = info.EndTime - header.Time
+	}
+	return info, nil
+}
+
+// syncLagPeriods is the number of block periods the head may lag behind the
+// local clock before the node is reported syncing.
+const syncLagPeriods = 10
+
+// EngineStatus is the liveness of the engine and of the node as a validator.
+type EngineStatus struct {
+	Syncing      bool   `json:"syncing"`       // Whether the head lags behind the local clock
+	LastVerified uint64 `json:"last_verified"` // Number of the highest header verified
+	Epoch        uint64 `json:"epoch"`         // Epoch of the head, 0 at genesis
+	Validator    bool   `json:"validator"`     // Whether a key of the node signs for a validator of the epoch
+	NextSlot     uint64 `json:"next_slot"`     // Time of the next slot of the node in the epoch, 0 if none
+	NextSlotIn   uint64 `json:"next_slot_in"`  // Seconds from now to the next slot
+}
+
+// Status reports the liveness of the engine for orchestration tooling. It only
+// reads the head and its snapshot, so it's cheap and safe to call concurrently.
+// The slots of the node are looked for until the end of the current epoch.
+func (api *API) Status() (EngineStatus, error) {
+	header := api.chain.CurrentHeader()
+	if header == nil {
+		return EngineStatus{}, errUnknownBlock
+	}
+	config, err := api.senate.chainConfig(header)
+	if err != nil {
+		return EngineStatus{}, err
+	}
+	now := uint64(time.Now().Unix())
+	status := EngineStatus{
+		Syncing:      header.Time+syncLagPeriods*config.Period < now,
+		LastVerified: atomic.LoadUint64(&api.senate.lastVerified),
+	}
+	if header.Number.Uint64() == 0 || config.Period == 0 {
+		return status, nil
+	}
+
+	headerExtra, err := decodeHeaderExtra(header)
+	if err != nil {
+		return EngineStatus{}, err
+	}
+	status.Epoch = headerExtra.Epoch
+	snap, err := api.snapshot(header)
+	if err != nil {
+		return EngineStatus{}, err
+	}
+	validators, err := api.validators(snap)
+	if err != nil {
+		return EngineStatus{}, err
+	}
+
+	// The keys of the node sign for the candidates they were rotated to
+	mine := make(map[common.Address]bool)
+	for _, signer := range api.senate.authorized() {
+		if signer.signFn == nil {
+			continue
+		}
+		validator, err := snap.ValidatorOf(signer.address)
+		if err != nil {
+			return EngineStatus{}, err
+		}
+		mine[validator] = true
+	}
+	for _, validator := range validators {
+		status.Validator = status.Validator || mine[validator]
+	}
+	if !status.Validator {
+		return status, nil
+	}
+
+	// Slots passed since the head are skipped, they can no longer be sealed
+	var skipped uint64
+	if now > header.Time {
+		skipped = (now - header.Time) / config.Period
+	}
+	for i := uint64(1); i <= uint64(len(validators)); i++ {
+		slot := header.Time + (skipped+i)*config.Period
+		if isNewEpoch(config, headerExtra.EpochTime, slot, header.Number.Uint64()+i) {
+			break
+		}
+		idx := (slot - headerExtra.EpochTime) / config.Period % uint64(len(validators))
+		if mine[validators[idx]] {
+			status.NextSlot = slot
+			if slot > now {
+				status.NextSlotIn = slot - now
+			}
+			break
+		}
+	}
+	return status, nil
+}
+
+// GetRejectedTransactions retrieves the hashes of the custom transactions of
+// specified block which were rejected by the senate, such as an operation
+// conflicting with an earlier one of the same block. The receipts only tell
+// whether the transactions were executed.
+func (api *API) GetRejectedTransactions(number *rpc.BlockNumber) ([]common.Hash, error) {
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	if header.Number.Uint64() == 0 {
+		return []common.Hash{}, nil
+	}
 
-	snap, err := loadSnapshot(api.senate.db, headerExtra.Root)
+	headerExtra, err := decodeHeaderExtra(header)
 	if err != nil {
 		return nil, err
 	}
+	if headerExtra.CurrentBlockRejects == nil {
+		return []common.Hash{}, nil
+	}
+	return headerExtra.CurrentBlockRejects, nil
+}
 
+// GetConfigAt retrieves the senate config in effect at specified block, which
+// changes once an approved proposal takes effect. The genesis config is
+// returned for the first two blocks.
+func (api *API) GetConfigAt(number *rpc.BlockNumber) (params.SenateConfig, error) {
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if header == nil {
+		return params.SenateConfig{}, errUnknownBlock
+	}
+	if header.Number.Uint64() <= 1 {
+		return *api.senate.config, nil
+	}
+
+	headerExtra, err := decodeHeaderExtra(header)
+	if err != nil {
+		return params.SenateConfig{}, err
+	}
+	return api.senate.chainConfigByHash(headerExtra.Root.ConfigHash)
+}
+
+// validators returns addresses of the current epoch validators in snapshot.
+func (api *API) validators(snap *Snapshot) ([]common.Address, error) {
 	validators, err := snap.GetValidators()
 	if err != nil {
 		return nil, err
 	}
-	sort.Sort(validators)
-	return validators, nil
+	addresses := make([]common.Address, 0, len(validators))
+	for _, validator := range validators {
+		addresses = append(addresses, validator.Address)
+	}
+	return addresses, nil
 }
@@ -0,0 +1,723 @@
+package senate
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SecretBlockChain/go-secret/accounts"
+	"github.com/SecretBlockChain/go-secret/common"
+	"github.com/SecretBlockChain/go-secret/core/rawdb"
+	"github.com/SecretBlockChain/go-secret/core/state"
+	"github.com/SecretBlockChain/go-secret/core/types"
+	"github.com/SecretBlockChain/go-secret/params"
+	"github.com/SecretBlockChain/go-secret/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIGetSnapshot(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	config := params.SenateConfig{Period: 5, Epoch: 10}
+	senate := New(&config, nil, db)
+
+	// Commit a snapshot with one candidate for block 1
+	candidate := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.BecomeCandidate(candidate))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	header := newTestHeader(t, 1, genesis.Hash(), HeaderExtra{Root: root, Epoch: 1})
+	api := &API{chain: &testChainReader{headers: []*types.Header{genesis, header}}, senate: senate}
+
+	latest := rpc.LatestBlockNumber
+	snap, err = api.GetSnapshot(&latest)
+	assert.Nil(t, err)
+	isCandidate, err := snap.IsCandidate(candidate)
+	assert.Nil(t, err)
+	assert.True(t, isCandidate)
+
+	snap, err = api.GetSnapshotAtHash(genesis.Hash())
+	assert.Nil(t, err)
+	assert.Equal(t, Root{}, snap.root)
+
+	number := rpc.BlockNumber(2)
+	_, err = api.GetSnapshot(&number)
+	assert.Equal(t, errUnknownBlock, err)
+	_, err = api.GetSnapshotAtHash(common.Hash{})
+	assert.Equal(t, errUnknownBlock, err)
+}
+
+func TestAPIGetValidators(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	config := params.SenateConfig{Period: 5, Epoch: 10}
+	senate := New(&config, nil, db)
+
+	// Validators keep the elected order rather than sorted by weight
+	validator1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	validator2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.SetValidators(SortableAddresses{
+		{Address: validator2, Weight: big.NewInt(1)},
+		{Address: validator1, Weight: big.NewInt(2)},
+	}))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	header := newTestHeader(t, 1, genesis.Hash(), HeaderExtra{Root: root, Epoch: 1})
+	api := &API{chain: &testChainReader{headers: []*types.Header{genesis, header}}, senate: senate}
+
+	validators, err := api.GetValidators(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []common.Address{validator2, validator1}, validators)
+
+	number := rpc.BlockNumber(0)
+	validators, err = api.GetValidators(&number)
+	assert.Nil(t, err)
+	assert.NotNil(t, validators)
+	assert.Equal(t, 0, len(validators))
+}
+
+func TestAPIGetValidatorSchedule(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	validator1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	validator2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	config := params.SenateConfig{
+		Period:             5,
+		Epoch:              20,
+		MaxValidatorsCount: 2,
+		Validators:         []common.Address{validator1},
+	}
+	senate := New(&config, nil, db)
+
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.SetValidators(SortableAddresses{
+		{Address: validator1, Weight: big.NewInt(0)},
+		{Address: validator2, Weight: big.NewInt(0)},
+	}))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	header := newTestHeader(t, 1, genesis.Hash(), HeaderExtra{Root: root, Epoch: 1, EpochTime: 100})
+	header.Time = 100
+	api := &API{chain: &testChainReader{headers: []*types.Header{genesis, header}}, senate: senate}
+
+	// The projection stops at the boundary of next epoch
+	slots, err := api.GetValidatorSchedule(nil, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []ValidatorSlot{
+		{Time: 105, Validator: validator2},
+		{Time: 110, Validator: validator1},
+		{Time: 115, Validator: validator2},
+		{Time: 120, Validator: validator1},
+	}, slots)
+
+	// The first epoch is elected from the genesis validators
+	number := rpc.BlockNumber(0)
+	slots, err = api.GetValidatorSchedule(&number, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []ValidatorSlot{
+		{Time: 5, Validator: validator1},
+		{Time: 10, Validator: validator1},
+	}, slots)
+
+	_, err = api.GetValidatorSchedule(nil, maxScheduleSlots+1)
+	assert.NotNil(t, err)
+}
+
+func TestAPIGetCurrentProposer(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	validator1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	validator2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	candidate := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	config := params.SenateConfig{
+		Period:             5,
+		Epoch:              20,
+		MaxValidatorsCount: 2,
+		MinMintPercent:     50,
+		Validators:         []common.Address{validator1},
+	}
+	senate := New(&config, nil, db)
+
+	// The validators of the current epoch aren't candidates of the next one
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.SetValidators(SortableAddresses{
+		{Address: validator1, Weight: big.NewInt(0)},
+		{Address: validator2, Weight: big.NewInt(0)},
+	}))
+	assert.Nil(t, snap.BecomeCandidate(candidate))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	header1 := newTestHeader(t, 1, genesis.Hash(), HeaderExtra{Root: root, Epoch: 1, EpochTime: 100})
+	header1.Time = 110
+	header2 := newTestHeader(t, 2, header1.Hash(), HeaderExtra{Root: root, Epoch: 1, EpochTime: 100})
+	header2.Time = 120
+	api := &API{chain: &testChainReader{headers: []*types.Header{genesis, header1, header2}}, senate: senate}
+
+	number := rpc.BlockNumber(1)
+	proposer, err := api.GetCurrentProposer(&number)
+	assert.Nil(t, err)
+	assert.Equal(t, ValidatorSlot{Time: 115, Validator: validator2}, proposer)
+
+	// The block after the last one of the epoch is sealed by the new set
+	proposer, err = api.GetCurrentProposer(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, ValidatorSlot{Time: 125, Validator: candidate}, proposer)
+}
+
+func TestAPIGetProposals(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	config := params.SenateConfig{Period: 5, Epoch: 20}
+	senate := New(&config, nil, db)
+
+	validator1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	validator2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	validator3 := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.SetValidators(SortableAddresses{
+		{Address: validator1, Weight: big.NewInt(0)},
+		{Address: validator2, Weight: big.NewInt(0)},
+		{Address: validator3, Weight: big.NewInt(0)},
+	}))
+	proposal := Proposal{Key: ProposalPeriodChange, Value: "10", Hash: common.HexToHash("0x01"), Proposer: validator1}
+	assert.Nil(t, snap.SubmitProposal(proposal))
+	assert.Nil(t, snap.Declare(1, Declare{Hash: common.HexToHash("0x02"), ProposalHash: proposal.Hash, Declarer: validator1, Decision: true}))
+	assert.Nil(t, snap.Declare(1, Declare{Hash: common.HexToHash("0x03"), ProposalHash: proposal.Hash, Declarer: validator2, Decision: false}))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	header := newTestHeader(t, 1, genesis.Hash(), HeaderExtra{Root: root, Epoch: 1, EpochTime: 100})
+	api := &API{chain: &testChainReader{headers: []*types.Header{genesis, header}}, senate: senate}
+
+	expected := ProposalStatus{
+		Proposal:    proposal,
+		Epoch:       1,
+		Deadline:    120,
+		Yes:         1,
+		No:          1,
+		VotesNeeded: 2,
+	}
+	proposals, err := api.GetProposals(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []ProposalStatus{expected}, proposals)
+
+	status, err := api.GetProposal(proposal.Hash, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, *status)
+
+	_, err = api.GetProposal(common.HexToHash("0x04"), nil)
+	assert.Equal(t, ErrUnknownProposal, err)
+}
+
+// testStateChainReader is a testChainReader with the state of all blocks.
+type testStateChainReader struct {
+	*testChainReader
+	statedb *state.StateDB
+}
+
+func (chain *testStateChainReader) StateAt(root common.Hash) (*state.StateDB, error) {
+	return chain.statedb, nil
+}
+
+func TestAPIGetCandidates(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	config := params.SenateConfig{Period: 5, Epoch: 10}
+	senate := New(&config, nil, db)
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	candidate1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	candidate2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	candidate3 := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	delegator1 := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	delegator2 := common.HexToAddress("0x0000000000000000000000000000000000000002")
+	delegator3 := common.HexToAddress("0x0000000000000000000000000000000000000003")
+	statedb.SetBalance(delegator1, big.NewInt(1000))
+	statedb.SetBalance(delegator2, big.NewInt(2000))
+	statedb.SetBalance(delegator3, big.NewInt(10))
+
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	for _, candidate := range []common.Address{candidate1, candidate2, candidate3} {
+		assert.Nil(t, snap.BecomeCandidate(candidate))
+	}
+	assert.Nil(t, snap.SetDeposit(candidate1, big.NewInt(100)))
+	assert.Nil(t, snap.SetDeposit(candidate3, big.NewInt(50)))
+	assert.Nil(t, snap.Delegate(delegator1, candidate1))
+	assert.Nil(t, snap.Delegate(delegator2, candidate3))
+	assert.Nil(t, snap.Delegate(delegator3, candidate3))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	header := newTestHeader(t, 1, genesis.Hash(), HeaderExtra{Root: root, Epoch: 1, EpochTime: 100})
+	chain := &testChainReader{headers: []*types.Header{genesis, header}}
+
+	// Stake of delegators is read from the state
+	api := &API{chain: chain, senate: senate}
+	_, err = api.GetCandidates(nil)
+	assert.NotNil(t, err)
+
+	api = &API{chain: &testStateChainReader{testChainReader: chain, statedb: statedb}, senate: senate}
+	candidates, err := api.GetCandidates(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []CandidateInfo{
+		{
+			Address:        candidate3,
+			SelfStake:      big.NewInt(50),
+			DelegatedStake: big.NewInt(2010),
+			TotalStake:     big.NewInt(2060),
+			Votes:          2,
+		},
+		{
+			Address:        candidate1,
+			SelfStake:      big.NewInt(100),
+			DelegatedStake: big.NewInt(1000),
+			TotalStake:     big.NewInt(1100),
+			Votes:          1,
+		},
+		{
+			Address:        candidate2,
+			SelfStake:      big.NewInt(0),
+			DelegatedStake: big.NewInt(0),
+			TotalStake:     big.NewInt(0),
+			Votes:          0,
+		},
+	}, candidates)
+}
+
+func TestAPIIsValidCandidate(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	config := params.SenateConfig{Period: 5, Epoch: 10}
+	senate := New(&config, nil, db)
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	candidate1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	candidate2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	candidate3 := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	delegator := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	statedb.SetBalance(delegator, big.NewInt(1000))
+
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	for _, candidate := range []common.Address{candidate1, candidate2} {
+		assert.Nil(t, snap.BecomeCandidate(candidate))
+	}
+	assert.Nil(t, snap.Delegate(delegator, candidate1))
+	assert.Nil(t, snap.Slash(2, candidate2, big.NewInt(10)))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	header := newTestHeader(t, 1, genesis.Hash(), HeaderExtra{Root: root, Epoch: 2, EpochTime: 100})
+	chain := &testStateChainReader{testChainReader: &testChainReader{headers: []*types.Header{genesis, header}}, statedb: statedb}
+	api := &API{chain: chain, senate: senate}
+
+	validity, err := api.IsValidCandidate(candidate1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, CandidateValidity{Valid: true}, validity)
+	validity, err = api.IsValidCandidate(candidate2, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, CandidateValidity{Reason: "slashed in epoch 2"}, validity)
+	validity, err = api.IsValidCandidate(candidate3, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, CandidateValidity{Reason: "not a registered candidate"}, validity)
+
+	// Candidates without enough self-stake aren't elected
+	config.MinSelfStakePercent = 10
+	validity, err = api.IsValidCandidate(candidate1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, CandidateValidity{Reason: "self-stake below 10% of the delegated stake"}, validity)
+}
+
+func TestAPIGetDelegations(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	config := params.SenateConfig{Period: 5, Epoch: 10}
+	senate := New(&config, nil, db)
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	candidate1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	candidate2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	delegator1 := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	delegator2 := common.HexToAddress("0x0000000000000000000000000000000000000002")
+	statedb.SetBalance(delegator1, big.NewInt(1000))
+	statedb.SetBalance(delegator2, big.NewInt(2000))
+
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.BecomeCandidate(candidate1))
+	assert.Nil(t, snap.BecomeCandidate(candidate2))
+	assert.Nil(t, snap.Delegate(delegator1, candidate1))
+	assert.Nil(t, snap.Delegate(delegator2, candidate1))
+	assert.Nil(t, snap.DecayVotes(50))
+	assert.Nil(t, snap.Delegate(delegator1, candidate1))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	header := newTestHeader(t, 1, genesis.Hash(), HeaderExtra{Root: root, Epoch: 1, EpochTime: 100})
+	chain := &testChainReader{headers: []*types.Header{genesis, header}}
+	api := &API{chain: &testStateChainReader{testChainReader: chain, statedb: statedb}, senate: senate}
+
+	// The amount is the vote weight after decay
+	delegations, err := api.GetDelegations(delegator2, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []Delegation{{Delegator: delegator2, Candidate: candidate1, Amount: big.NewInt(1000)}}, delegations)
+	delegators, err := api.GetDelegators(candidate1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []Delegation{
+		{Delegator: delegator1, Candidate: candidate1, Amount: big.NewInt(1000)},
+		{Delegator: delegator2, Candidate: candidate1, Amount: big.NewInt(1000)},
+	}, delegators)
+
+	// Empty results are not nil, even at genesis
+	delegations, err = api.GetDelegations(candidate2, nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, delegations)
+	assert.Empty(t, delegations)
+	delegators, err = api.GetDelegators(candidate2, nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, delegators)
+	assert.Empty(t, delegators)
+	number := rpc.BlockNumber(0)
+	delegations, err = api.GetDelegations(delegator1, &number)
+	assert.Nil(t, err)
+	assert.NotNil(t, delegations)
+	assert.Empty(t, delegations)
+}
+
+func TestAPIGetStakingSummary(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	config := params.SenateConfig{Period: 5, Epoch: 10}
+	senate := New(&config, nil, db)
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	candidate := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	delegator := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	other := common.HexToAddress("0x0000000000000000000000000000000000000002")
+	statedb.SetBalance(delegator, big.NewInt(1000))
+
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.BecomeCandidate(candidate))
+	assert.Nil(t, snap.Delegate(delegator, candidate))
+	assert.Nil(t, snap.Unbond(delegator, big.NewInt(30), 200))
+	assert.Nil(t, snap.Unbond(other, big.NewInt(40), 200))
+	assert.Nil(t, snap.Unbond(delegator, big.NewInt(50), 300))
+	assert.Nil(t, snap.AccrueReward(delegator, big.NewInt(7)))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	header := newTestHeader(t, 1, genesis.Hash(), HeaderExtra{Root: root, Epoch: 1, EpochTime: 100})
+	chain := &testChainReader{headers: []*types.Header{genesis, header}}
+	api := &API{chain: &testStateChainReader{testChainReader: chain, statedb: statedb}, senate: senate}
+
+	summary, err := api.GetStakingSummary(delegator, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, StakingSummary{
+		TotalDelegated: big.NewInt(1000),
+		Delegations:    []Delegation{{Delegator: delegator, Candidate: candidate, Amount: big.NewInt(1000)}},
+		Unbondings: []Unbonding{
+			{Address: delegator, Amount: big.NewInt(30), Release: 200},
+			{Address: delegator, Amount: big.NewInt(50), Release: 300},
+		},
+		PendingReward: big.NewInt(7),
+	}, summary)
+
+	summary, err = api.GetStakingSummary(candidate, nil)
+	assert.Nil(t, err)
+	assert.True(t, summary.IsCandidate)
+	assert.Empty(t, summary.Delegations)
+
+	// An address without activity has a zeroed summary, even at genesis
+	number := rpc.BlockNumber(0)
+	for _, number := range []*rpc.BlockNumber{nil, &number} {
+		summary, err = api.GetStakingSummary(common.HexToAddress("0x03"), number)
+		assert.Nil(t, err)
+		assert.Equal(t, StakingSummary{
+			TotalDelegated: big.NewInt(0),
+			Delegations:    []Delegation{},
+			Unbondings:     []Unbonding{},
+			PendingReward:  big.NewInt(0),
+		}, summary)
+	}
+}
+
+func TestAPIGetSigningStatus(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	config := params.SenateConfig{Period: 5, Epoch: 100}
+	senate := New(&config, nil, db)
+
+	validator1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	validator2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	validator3 := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.SetValidators(SortableAddresses{
+		{Address: validator1, Weight: big.NewInt(0)},
+		{Address: validator2, Weight: big.NewInt(0)},
+		{Address: validator3, Weight: big.NewInt(0)},
+	}))
+
+	// Validator 3 missed its slot at 110, validator 1 minted it out of turn
+	for number, validator := range []common.Address{validator1, validator2, validator1, validator1, validator2} {
+		assert.Nil(t, snap.MintBlock(1, uint64(number+1), validator))
+	}
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	header := newTestHeader(t, 5, genesis.Hash(), HeaderExtra{Root: root, Epoch: 1, EpochTime: 100})
+	header.Time = 120
+	chain := &testChainReader{headers: []*types.Header{genesis, header}}
+	api := &API{chain: chain, senate: senate}
+
+	status, err := api.GetSigningStatus(validator1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, SigningStatus{Validator: validator1, Epoch: 1, Scheduled: 2, Minted: 3, NextSlot: 130}, status)
+	status, err = api.GetSigningStatus(validator3, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, SigningStatus{Validator: validator3, Epoch: 1, Scheduled: 1, Minted: 0, NextSlot: 125}, status)
+
+	// The next slot in a new epoch isn't predictable
+	header.Time = 195
+	status, err = api.GetSigningStatus(validator3, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(200), status.NextSlot)
+	status, err = api.GetSigningStatus(validator1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), status.NextSlot)
+
+	// Addresses out of the validators have a zeroed status
+	other := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	status, err = api.GetSigningStatus(other, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, SigningStatus{Validator: other}, status)
+}
+
+func TestAPIGetMintCounts(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	config := params.SenateConfig{Period: 5, Epoch: 100}
+	senate := New(&config, nil, db)
+
+	validator1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	validator2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.SetValidators(SortableAddresses{
+		{Address: validator1, Weight: big.NewInt(0)},
+		{Address: validator2, Weight: big.NewInt(0)},
+	}))
+
+	// Epoch 1 is complete, epoch 2 is in progress at block 6
+	for number, validator := range []common.Address{validator1, validator2, validator1, validator1} {
+		assert.Nil(t, snap.MintBlock(1, uint64(number+1), validator))
+	}
+	assert.Nil(t, snap.MintBlock(2, 5, validator2))
+	assert.Nil(t, snap.MintBlock(2, 6, validator2))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	header := newTestHeader(t, 6, genesis.Hash(), HeaderExtra{Root: root, Epoch: 2, EpochTime: 200})
+	header.Time = 205
+	chain := &testChainReader{headers: []*types.Header{genesis, header}}
+	api := &API{chain: chain, senate: senate}
+
+	counts, err := api.GetMintCounts(1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[common.Address]uint64{validator1: 3, validator2: 1}, counts)
+	counts, err = api.GetMintCounts(2, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[common.Address]uint64{validator2: 2}, counts)
+	counts, err = api.GetMintCounts(3, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, counts)
+
+	// Validator 1 missed its only slot so far, validator 2 minted it instead
+	uptime, err := api.GetValidatorUptime(validator1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(0), uptime)
+	uptime, err = api.GetValidatorUptime(validator2, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(2), uptime)
+	uptime, err = api.GetValidatorUptime(common.HexToAddress("0x0000000000000000000000000000000000000001"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(0), uptime)
+}
+
+func TestAPIGetEpochInfo(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	config := params.SenateConfig{Period: 5, Epoch: 100}
+	senate := New(&config, nil, db)
+
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	genesis.Time = 50
+	header := newTestHeader(t, 1, genesis.Hash(), HeaderExtra{Epoch: 3, EpochTime: 300})
+	header.Time = 340
+	api := &API{chain: &testChainReader{headers: []*types.Header{genesis, header}}, senate: senate}
+
+	info, err := api.GetEpochInfo(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, EpochInfo{Epoch: 3, EpochTime: 300, Duration: 100, EndTime: 400, Remaining: 60}, info)
+
+	// Nothing remains once the block time has passed the end time
+	header.Time = 410
+	info, err = api.GetEpochInfo(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), info.Remaining)
+
+	number := rpc.BlockNumber(0)
+	info, err = api.GetEpochInfo(&number)
+	assert.Nil(t, err)
+	assert.Equal(t, EpochInfo{Epoch: 1, EpochTime: 50, Duration: 100, EndTime: 150, Remaining: 100}, info)
+
+	number = rpc.BlockNumber(2)
+	_, err = api.GetEpochInfo(&number)
+	assert.Equal(t, errUnknownBlock, err)
+}
+
+func TestAPIGetRejectedTransactions(t *testing.T) {
+	config := params.SenateConfig{Period: 5, Epoch: 20}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+
+	rejects := []common.Hash{common.HexToHash("0x01"), common.HexToHash("0x02")}
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	header := newTestHeader(t, 1, genesis.Hash(), HeaderExtra{Epoch: 1, EpochTime: 100, CurrentBlockRejects: rejects})
+	api := &API{chain: &testChainReader{headers: []*types.Header{genesis, header}}, senate: senate}
+
+	hashes, err := api.GetRejectedTransactions(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, rejects, hashes)
+
+	number := rpc.BlockNumber(0)
+	hashes, err = api.GetRejectedTransactions(&number)
+	assert.Nil(t, err)
+	assert.Empty(t, hashes)
+}
+
+func TestAPIGetConfigAt(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               20,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+	}
+	senate := New(&config, nil, db)
+
+	// The proposal is approved in the first epoch and takes effect at the
+	// first block of the next one
+	genesis := &types.Header{Number: big.NewInt(0), Time: uint64(time.Now().Unix()) + 3600}
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	proposal := signTestTransaction(t, 0, testUserAddress, "senate:1:event:proposal:period:10")
+	declare := signTestTransaction(t, 1, testUserAddress, fmt.Sprintf("senate:1:event:declare:%s:yes", proposal.Hash().Hex()))
+	txs := map[uint64][]*types.Transaction{2: {proposal}, 3: {declare}}
+	for number := uint64(1); number <= 7; number++ {
+		parent := chain.CurrentHeader()
+		header := &types.Header{Number: new(big.Int).SetUint64(number), ParentHash: parent.Hash(), Coinbase: testUserAddress}
+		assert.Nil(t, senate.Prepare(chain, header))
+		block, err := senate.FinalizeAndAssemble(chain, header, statedb, txs[number], nil, nil)
+		assert.Nil(t, err)
+		chain.headers = append(chain.headers, block.Header())
+	}
+
+	api := &API{chain: chain, senate: senate}
+	for number := int64(0); number <= 7; number++ {
+		blockNumber := rpc.BlockNumber(number)
+		active, err := api.GetConfigAt(&blockNumber)
+		assert.Nil(t, err)
+		switch {
+		case number <= 1:
+			assert.True(t, config.Equal(active))
+		case number < 6:
+			assert.Equal(t, uint64(5), active.Period)
+		default:
+			assert.Equal(t, uint64(10), active.Period)
+		}
+	}
+	latest, err := api.GetConfigAt(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), latest.Period)
+
+	unknown := rpc.BlockNumber(8)
+	_, err = api.GetConfigAt(&unknown)
+	assert.Equal(t, errUnknownBlock, err)
+}
+
+func TestAPIStatus(t *testing.T) {
+	config := params.SenateConfig{
+		Period:              1,
+		Epoch:               100,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+		AllowedFutureDrift:  3600,
+	}
+	headers := newSignedTestChain(t, config, 5, func(uint64) *ecdsa.PrivateKey { return testUserKey })
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	_, results := senate.VerifyHeaders(&testChainReader{headers: headers[:1]}, headers[1:], nil)
+	for range headers[1:] {
+		assert.Nil(t, <-results)
+	}
+	api := &API{chain: &testChainReader{headers: headers}, senate: senate}
+
+	// A node without keys follows the chain
+	status, err := api.Status()
+	assert.Nil(t, err)
+	assert.Equal(t, EngineStatus{LastVerified: 5, Epoch: 1}, status)
+
+	// The validator node is in turn for every slot, starting right after the head
+	senate.Authorize(testUserAddress, func(accounts.Account, string, []byte) ([]byte, error) { return nil, nil })
+	status, err = api.Status()
+	assert.Nil(t, err)
+	head := headers[len(headers)-1]
+	assert.False(t, status.Syncing)
+	assert.Equal(t, uint64(5), status.LastVerified)
+	assert.Equal(t, uint64(1), status.Epoch)
+	assert.True(t, status.Validator)
+	assert.Equal(t, head.Time+1, status.NextSlot)
+	assert.True(t, status.NextSlotIn > 0)
+
+	// A head far behind the clock is syncing
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	genesis.Time = 100
+	api = &API{chain: &testChainReader{headers: []*types.Header{genesis}}, senate: senate}
+	status, err = api.Status()
+	assert.Nil(t, err)
+	assert.Equal(t, EngineStatus{Syncing: true, LastVerified: 5}, status)
+}
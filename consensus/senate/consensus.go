@@ -2,15 +2,20 @@ package senate
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"math/big"
+	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/SecretBlockChain/go-secret/accounts"
 	"github.com/SecretBlockChain/go-secret/common"
 	"github.com/SecretBlockChain/go-secret/consensus"
+	"github.com/SecretBlockChain/go-secret/consensus/misc"
 	"github.com/SecretBlockChain/go-secret/core/state"
 	"github.com/SecretBlockChain/go-secret/core/types"
 	"github.com/SecretBlockChain/go-secret/crypto"
@@ -22,8 +27,9 @@ import (
 	"golang.org/x/crypto/sha3"
 )
 
-// ecrecover extracts the Ethereum account address from a signed header.
-func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, error) {
+// ecrecover extracts the Ethereum account address from a signed header, the
+// chainID is bound into the seal hash if not nil and the domain tag prefixes it.
+func ecrecover(header *types.Header, sigcache *lru.ARCCache, chainID *big.Int, domain []byte) (common.Address, error) {
 	// If the signature's already cached, return that
 	hash := header.Hash()
 	if address, known := sigcache.Get(hash); known {
@@ -36,7 +42,7 @@ func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, er
 	signature := header.Extra[len(header.Extra)-extraSeal:]
 
 	// Recover the public key and the Ethereum address
-	pubkey, err := crypto.Ecrecover(SealHash(header).Bytes(), signature)
+	pubkey, err := crypto.Ecrecover(SealHash(header, chainID, domain).Bytes(), signature)
 	if err != nil {
 		return common.Address{}, err
 	}
@@ -51,14 +57,14 @@ func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, er
 // block, which may be different from the header's coinbase if a consensus
 // engine is based on signatures.
 func (senate *Senate) Author(header *types.Header) (common.Address, error) {
-	return ecrecover(header, senate.signatures)
+	return ecrecover(header, senate.signatures, senate.sealChainID(header), senate.sealDomain(header))
 }
 
 // VerifyHeader checks whether a header conforms to the consensus rules of a
 // given engine. Verifying the seal may be done optionally here, or explicitly
 // via the VerifySeal method.
 func (senate *Senate) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header, seal bool) error {
-	return senate.verifyHeader(chain, header, nil)
+	return senate.verifyHeader(context.Background(), chain, header, nil)
 }
 
 // VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers
@@ -72,32 +78,273 @@ func (senate *Senate) VerifyHeaders(chain consensus.ChainHeaderReader, headers [
 	for _, header := range headers {
 		numbers = append(numbers, header.Number.Int64())
 	}
+	if !senate.track(2) {
+		for range headers {
+			results <- errEngineClosed
+		}
+		return abort, results
+	}
+
+	// Cancel the verification in progress once aborted or closed
+	ctx, cancel := context.WithCancel(senate.ctx)
+	go func() {
+		defer senate.running.Done()
+		select {
+		case <-abort:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// Check the standalone fields and recover the signers in parallel, the
+	// cascading fields depend on the snapshot of parent so they are verified
+	// in order. Workers stay at most verifyAhead headers ahead to keep the
+	// recovered signers cached.
+	var (
+		pending = make([]chan error, len(headers))
+		ahead   = make(chan struct{}, verifyAhead)
+		next    int64
+		workers sync.WaitGroup
+	)
+	for i := range pending {
+		pending[i] = make(chan error, 1)
+	}
+	for n := 0; n < senate.verifyWorkers() && n < len(headers); n++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				select {
+				case ahead <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				i := int(atomic.AddInt64(&next, 1) - 1)
+				if i >= len(headers) {
+					return
+				}
+				// The recovered signer is cached for verifying the seal
+				err := senate.verifyHeaderFields(headers[i])
+				if err == nil {
+					ecrecover(headers[i], senate.signatures, senate.sealChainID(headers[i]), senate.sealDomain(headers[i]))
+				}
+				pending[i] <- err
+			}
+		}()
+	}
 
 	go func() {
+		defer senate.running.Done()
+		defer workers.Wait()
+		defer cancel()
+
+		// Headers may arrive out of order, a header whose parent is later in the
+		// batch is buffered until the parent is verified. Results are delivered
+		// in the order of the batch once resolved.
+		var (
+			hashes   = make([]common.Hash, len(headers))
+			index    = make(map[common.Hash]int, len(headers))
+			verified = make(map[common.Hash]*types.Header, len(headers))
+			buffer   = newHeaderBuffer(maxBufferedHeaders)
+			errs     = make([]error, len(headers))
+			resolved = make([]bool, len(headers))
+			sent     int
+		)
+		for i, header := range headers {
+			hashes[i] = header.Hash()
+			index[hashes[i]] = i
+		}
+		// fail resolves the headers whose ancestors won't be verified in this
+		// batch, along with the ones buffered on them
+		var fail func(orphans []int, err error)
+		fail = func(orphans []int, err error) {
+			for _, i := range orphans {
+				errs[i], resolved[i] = err, true
+				fail(buffer.take(hashes[i]), consensus.ErrUnknownAncestor)
+			}
+		}
+		// verify checks the header against its parent in the batch or the chain,
+		// and then the headers buffered on it
+		verify := func(i int) {
+			for queue := []int{i}; len(queue) > 0; queue = queue[1:] {
+				i := queue[0]
+				var parents []*types.Header
+				if parent, ok := verified[headers[i].ParentHash]; ok {
+					parents = []*types.Header{parent}
+				}
+				if err := senate.verifyHeader(ctx, chain, headers[i], parents); err != nil {
+					fail([]int{i}, err)
+					continue
+				}
+				resolved[i], verified[hashes[i]] = true, headers[i]
+				queue = append(queue, buffer.take(hashes[i])...)
+			}
+		}
+		// flush delivers the results resolved in order, false if aborted
+		flush := func() bool {
+			for ; sent < len(headers) && resolved[sent]; sent++ {
+				// Drop the result if aborted during the verification
+				select {
+				case <-abort:
+					return false
+				default:
+				}
+				select {
+				case <-abort:
+					return false
+				case results <- errs[sent]:
+				}
+			}
+			return true
+		}
 		for i, header := range headers {
-			err := senate.verifyHeader(chain, header, headers[:i])
+			var err error
 			select {
-			case <-abort:
+			case err = <-pending[i]:
+				<-ahead
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			switch parent, ok := index[header.ParentHash]; {
+			case err != nil:
+				fail([]int{i}, err)
+			case ok && !resolved[parent]:
+				fail(buffer.add(i, header.ParentHash), consensus.ErrUnknownAncestor)
+			default:
+				verify(i)
+			}
+			if !flush() {
 				return
-			case results <- err:
 			}
 		}
+		fail(buffer.rest(), consensus.ErrUnknownAncestor)
+		flush()
 	}()
 	return abort, results
 }
 
+// headerBuffer holds the headers of a batch whose parent isn't verified yet,
+// the earliest buffered headers are evicted once the limit is exceeded.
+type headerBuffer struct {
+	limit   int
+	waiting map[common.Hash][]int // Batch indexes of the buffered headers by parent hash
+	parents map[int]common.Hash   // Parent hashes of the buffered headers by batch index
+	order   []int                 // Batch indexes in the order buffered, may hold taken ones
+}
+
+// newHeaderBuffer creates a buffer holding at most limit headers.
+func newHeaderBuffer(limit int) *headerBuffer {
+	return &headerBuffer{
+		limit:   limit,
+		waiting: make(map[common.Hash][]int),
+		parents: make(map[int]common.Hash),
+	}
+}
+
+// add buffers the header at index i until parent is verified, and returns the
+// indexes of the headers evicted to keep the buffer bounded.
+func (buffer *headerBuffer) add(i int, parent common.Hash) []int {
+	buffer.waiting[parent] = append(buffer.waiting[parent], i)
+	buffer.parents[i] = parent
+	buffer.order = append(buffer.order, i)
+
+	var evicted []int
+	for len(buffer.parents) > buffer.limit {
+		oldest := buffer.order[0]
+		buffer.order = buffer.order[1:]
+		parent, ok := buffer.parents[oldest]
+		if !ok {
+			continue
+		}
+		delete(buffer.parents, oldest)
+		waiting := buffer.waiting[parent][:0]
+		for _, j := range buffer.waiting[parent] {
+			if j != oldest {
+				waiting = append(waiting, j)
+			}
+		}
+		if len(waiting) == 0 {
+			delete(buffer.waiting, parent)
+		} else {
+			buffer.waiting[parent] = waiting
+		}
+		evicted = append(evicted, oldest)
+	}
+	return evicted
+}
+
+// take removes and returns the indexes of the headers buffered on parent.
+func (buffer *headerBuffer) take(parent common.Hash) []int {
+	waiting := buffer.waiting[parent]
+	delete(buffer.waiting, parent)
+	for _, i := range waiting {
+		delete(buffer.parents, i)
+	}
+	return waiting
+}
+
+// rest removes and returns the indexes of all the buffered headers, in the
+// order buffered.
+func (buffer *headerBuffer) rest() []int {
+	var rest []int
+	for _, i := range buffer.order {
+		if _, ok := buffer.parents[i]; ok {
+			rest = append(rest, i)
+		}
+	}
+	buffer.waiting, buffer.parents, buffer.order = make(map[common.Hash][]int), make(map[int]common.Hash), nil
+	return rest
+}
+
 // verifyHeader checks whether a header conforms to the consensus rules.The
 // caller may optionally pass in a batch of parents (ascending order) to avoid
 // looking those up from the database. This is useful for concurrently verifying
-// a batch of new headers.
-func (senate *Senate) verifyHeader(chain consensus.ChainHeaderReader, header *types.Header, parents []*types.Header) error {
+// a batch of new headers. The verification stops early once ctx is cancelled.
+func (senate *Senate) verifyHeader(ctx context.Context, chain consensus.ChainHeaderReader,
+	header *types.Header, parents []*types.Header) error {
+
+	defer verifyHeaderTimer.UpdateSince(time.Now())
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := senate.verifyHeaderFields(header); err != nil {
+		return err
+	}
+	if err := senate.VerifyFinalize(header); err != nil {
+		return err
+	}
+	log.Trace("[DPOS] VerifyHeader", "number", header.Number.Int64())
+
+	// All basic checks passed, verify cascading fields
+	err := senate.verifyCascadingFields(ctx, chain, header, parents)
+	if err != nil {
+		log.Warn("[DPOS] Failed to verify cascading fields", "number", header.Number.Int64(), "reason", err)
+		return err
+	}
+	senate.markVerified(header.Number.Uint64())
+	return nil
+}
+
+// markVerified raises the number of the highest header verified.
+func (senate *Senate) markVerified(number uint64) {
+	for {
+		last := atomic.LoadUint64(&senate.lastVerified)
+		if number <= last || atomic.CompareAndSwapUint64(&senate.lastVerified, last, number) {
+			return
+		}
+	}
+}
+
+// verifyHeaderFields checks the fields of header which don't depend on other
+// headers, so headers of a batch may be checked in any order.
+func (senate *Senate) verifyHeaderFields(header *types.Header) error {
 	if header.Number == nil {
 		return errUnknownBlock
 	}
-	log.Trace("[DPOS] VerifyHeader", "number", header.Number.Int64())
 
-	// Don't waste time checking blocks from the future
-	if header.Time > uint64(time.Now().Unix()) {
+	// Don't waste time checking blocks from the future, headers slightly ahead
+	// of the local clock are accepted to tolerate clock skew across nodes
+	if header.Time > uint64(time.Now().Add(senate.futureDrift()).Unix()) {
 		return consensus.ErrFutureBlock
 	}
 
@@ -108,6 +355,9 @@ func (senate *Senate) verifyHeader(chain consensus.ChainHeaderReader, header *ty
 	if len(header.Extra) < extraVanity+extraSeal {
 		return errMissingSignature
 	}
+	if err := senate.verifyExtraSize(header); err != nil {
+		return err
+	}
 
 	// Ensure that the mix digest is zero as we don't have fork protection currently
 	if header.MixDigest != (common.Hash{}) {
@@ -118,109 +368,211 @@ func (senate *Senate) verifyHeader(chain consensus.ChainHeaderReader, header *ty
 	if header.UncleHash != uncleHash {
 		return errInvalidUncleHash
 	}
+	return nil
+}
 
-	// All basic checks passed, verify cascading fields
-	err := senate.verifyCascadingFields(chain, header, parents)
-	if err != nil {
-		log.Warn("[DPOS] Failed to verify cascading fields", "number", header.Number.Int64(), "reason", err)
+// verifyEpoch checks the epoch of header continues the parent, a new epoch
+// starts exactly at the header and strictly after the parent epoch, otherwise
+// the epoch is carried over unchanged. The genesis block has no epoch, so the
+// first block always starts epoch 1.
+func verifyEpoch(header *types.Header, headerExtra, parentHeaderExtra HeaderExtra) error {
+	if parentHeaderExtra.Epoch > 0 && headerExtra.Epoch == parentHeaderExtra.Epoch &&
+		headerExtra.EpochTime == parentHeaderExtra.EpochTime {
+		return nil
 	}
-	return err
+	if headerExtra.Epoch != parentHeaderExtra.Epoch+1 {
+		return fmt.Errorf("%w: have %d, parent %d", errInvalidEpoch, headerExtra.Epoch, parentHeaderExtra.Epoch)
+	}
+	if headerExtra.EpochTime != header.Time || headerExtra.EpochTime <= parentHeaderExtra.EpochTime {
+		return fmt.Errorf("%w: epoch time %d, block time %d, parent epoch time %d", errInvalidEpoch,
+			headerExtra.EpochTime, header.Time, parentHeaderExtra.EpochTime)
+	}
+	return nil
+}
+
+// verifyGasLimit checks the gas limit of header is within the bounds and
+// changes less than 1/GasLimitBoundDivisor of the parent gas limit.
+func verifyGasLimit(header, parent *types.Header) error {
+	if header.GasLimit > params.MaxGasLimit {
+		return fmt.Errorf("%w: have %d, max %d", errInvalidGasLimit, header.GasLimit, params.MaxGasLimit)
+	}
+	if header.GasUsed > header.GasLimit {
+		return fmt.Errorf("invalid gasUsed: have %d, gasLimit %d", header.GasUsed, header.GasLimit)
+	}
+
+	diff := int64(parent.GasLimit) - int64(header.GasLimit)
+	if diff < 0 {
+		diff *= -1
+	}
+	limit := parent.GasLimit / params.GasLimitBoundDivisor
+	if uint64(diff) >= limit || header.GasLimit < params.MinGasLimit {
+		return fmt.Errorf("%w: have %d, want %d += %d", errInvalidGasLimit, header.GasLimit, parent.GasLimit, limit)
+	}
+	return nil
+}
+
+// verifyBaseFee checks the base fee of header follows EIP-1559 since the London
+// fork, and is absent before it.
+func verifyBaseFee(config *params.ChainConfig, header, parent *types.Header) error {
+	if !config.IsLondon(header.Number) {
+		if header.BaseFee != nil {
+			return fmt.Errorf("%w: have %d, want <nil>", errInvalidBaseFee, header.BaseFee)
+		}
+		return nil
+	}
+	if err := misc.VerifyEip1559Header(config, parent, header); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidBaseFee, err)
+	}
+	return nil
 }
 
 // verifyCascadingFields verifies all the header fields that are not standalone,
 // rather depend on a batch of previous headers. The caller may optionally pass
 // in a batch of parents (ascending order) to avoid looking those up from the
 // database. This is useful for concurrently verifying a batch of new headers.
-func (senate *Senate) verifyCascadingFields(chain consensus.ChainHeaderReader, header *types.Header, parents []*types.Header) error {
+func (senate *Senate) verifyCascadingFields(ctx context.Context, chain consensus.ChainHeaderReader,
+	header *types.Header, parents []*types.Header) error {
+
+	defer verifyCascadingTimer.UpdateSince(time.Now())
+
 	// The genesis block is the always valid dead-end
 	number := header.Number.Uint64()
 	if number == 0 {
 		return nil
 	}
 
-	// Ensure that the block's timestamp isn't too close to it's parent
+	// Ensure that the block follows right after its parent, a parent found by
+	// hash only tells the number of block is wrong
 	var parent *types.Header
 	if len(parents) > 0 {
 		parent = parents[len(parents)-1]
-	} else {
-		parent = chain.GetHeader(header.ParentHash, number-1)
+	} else if parent = chain.GetHeader(header.ParentHash, number-1); parent == nil {
+		parent = chain.GetHeaderByHash(header.ParentHash)
 	}
-	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+	if parent == nil || parent.Hash() != header.ParentHash {
 		return consensus.ErrUnknownAncestor
 	}
+	if parent.Number.Uint64()+1 != number {
+		return errInvalidNumber
+	}
+
+	// Ensure that the block's timestamp isn't too close to it's parent
 	if parent.Time > header.Time {
 		return ErrInvalidTimestamp
 	}
+	if err := verifyGasLimit(header, parent); err != nil {
+		return err
+	}
+	if err := verifyBaseFee(chain.Config(), header, parent); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	// Load snapshot of parent block
-	var snap *Snapshot
+	// Load config of parent block
 	config := *senate.config
 	headerExtra, err := decodeHeaderExtra(header)
 	if err != nil {
 		return err
 	}
 
-	parentHeaderExtra := headerExtra
-	if parent.Number.Int64() == 0 {
-		snap, err = newSnapshot(senate.db)
-		if err != nil {
-			return err
-		}
-	} else {
+	var parentHeaderExtra HeaderExtra
+	if parent.Number.Int64() > 0 {
 		parentHeaderExtra, err = decodeHeaderExtra(parent)
 		if err != nil {
 			return err
 		}
 
-		config, err = senate.chainConfigByHash(parentHeaderExtra.Root.ConfigHash)
-		if err != nil {
+		err = senate.retryRead(ctx, func() (err error) {
+			config, err = senate.chainConfigByHash(parentHeaderExtra.Root.ConfigHash)
 			return err
-		}
-
-		snap, err = loadSnapshot(senate.db, parentHeaderExtra.Root)
+		})
 		if err != nil {
 			return err
 		}
 	}
 
+	// Ensure that the block's timestamp keeps the period of the parent config
+	if header.Time < parent.Time+blockPeriod(config, header) {
+		return ErrInvalidTimestamp
+	}
+
 	// Ensure that the epoch timestamp and parent block are continuous
-	if headerExtra.Epoch != parentHeaderExtra.Epoch || headerExtra.EpochTime != parentHeaderExtra.EpochTime {
-		if headerExtra.Epoch != parentHeaderExtra.Epoch+1 || headerExtra.EpochTime != header.Time {
-			return ErrInvalidTimestamp
+	if err = verifyEpoch(header, headerExtra, parentHeaderExtra); err != nil {
+		return err
+	}
+	if number > 1 && isNewEpoch(config, parentHeaderExtra.EpochTime, header.Time, number) !=
+		(headerExtra.Epoch != parentHeaderExtra.Epoch) {
+		return fmt.Errorf("%w: epoch %d, parent %d", errInvalidEpoch, headerExtra.Epoch, parentHeaderExtra.Epoch)
+	}
+
+	// Ensure that the first block of a finalized epoch is the checkpoint
+	if headerExtra.Epoch != parentHeaderExtra.Epoch {
+		if hash, ok := senate.checkpoint(headerExtra.Epoch); ok && header.Hash() != hash {
+			return fmt.Errorf("%w: epoch %d, have %s, want %s", errCheckpointMismatch,
+				headerExtra.Epoch, header.Hash().Hex(), hash.Hex())
 		}
 	}
 
-	// Retrieve the snapshot needed to verify this header and cache it
-	err = snap.apply(header, headerExtra)
-	if err != nil {
+	// Verify the seal before replaying the snapshot, the trie nodes written by
+	// the replay stay in the shared trie database until committed
+	if err = senate.verifySeal(config, header, parent); err != nil {
 		return err
 	}
 
-	root, err := snap.Root()
+	// Retrieve the snapshot needed to verify this header and cache it, the
+	// block is replayed on a fresh parent snapshot if reading the database fails
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	var snap *Snapshot
+	var root Root
+	err = senate.retryRead(ctx, func() (err error) {
+		if parent.Number.Int64() == 0 {
+			snap, err = newSnapshot(senate.db)
+		} else {
+			snap, err = senate.loadSnapshot(parentHeaderExtra.Root)
+		}
+		if err != nil {
+			return err
+		}
+		if err = snap.apply(config, header, headerExtra); err != nil {
+			return err
+		}
+		root, err = snap.Root()
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	if senate.options.StrictVerification {
+		if err = snap.verifyRoot(headerExtra.Root); err != nil {
+			return err
+		}
+	}
 	if root != headerExtra.Root {
-		log.Info(fmt.Sprintf("root \n %s \n headerExtra.Root %s ",Root2String(root),Root2String(headerExtra.Root)))
-		return errors.New("invalid trie root")
+		log.Warn("[DPOS] Invalid trie root", "number", header.Number, "hash", header.Hash(), "local", root, "header", headerExtra.Root)
+		return errInvalidTrieRoot
 	}
 
-	// Verify the seal and return
-	err = senate.verifySeal(config, header, parent)
-	if err != nil {
-		return err
+	// Ensure that the first block of epoch carries the validators of the epoch
+	if header.Time == headerExtra.EpochTime {
+		if err = verifyCheckpoint(snap, headerExtra); err != nil {
+			return err
+		}
 	}
 
 	// All basic checks passed, save snapshot to disk
-	if err = snap.Commit(root); err != nil {
-		return errors.New("failed to write snapshot")
+	if err = senate.commitSnapshot(snap, root); err != nil {
+		return &snapshotCommitError{err: err}
 	}
+	senate.cacheSnapshot(snap)
+	updateEpochMetrics(snap, headerExtra)
+	senate.schedulePruning(chain, header, headerExtra.Epoch)
 	return nil
 }
 
-func Root2String(root Root) string {
-	return fmt.Sprintf("\nCandidateHash=%s \nConfigHash=%s \nDeclareHash=%s \nDelegateHash= %s \nCandidateHash=%s \nEpochHash=%s \nMintCntHash=%s \nProposalHash=%s \nVoteHash=%s",root.CandidateHash.String(),root.ConfigHash.String(),root.DeclareHash.String(),root.DelegateHash.String(),root.CandidateHash.String(),root.EpochHash.String(),root.MintCntHash.String(),root.ProposalHash.String(),root.VoteHash.String())
-}
 // VerifyUncles verifies that the given block's uncles conform to the consensus
 // rules of a given engine.
 func (senate *Senate) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
@@ -258,14 +610,99 @@ func (senate *Senate) verifySeal(config params.SenateConfig, header, parent *typ
 		return errUnknownBlock
 	}
 
+	// If the seal of header was verified recently, return the same result
+	hash := header.Hash()
+	if result, ok := senate.verified.Get(hash); ok {
+		if result == nil {
+			return nil
+		}
+		return result.(error)
+	}
+	err := senate.checkSeal(config, header, parent)
+	if isDefinitiveSealError(err) {
+		senate.verified.Add(hash, err)
+	}
+	return err
+}
+
+// isDefinitiveSealError reports whether the result of verifying a seal depends
+// on the header and its parent only. Errors of loading the snapshots may be
+// transient, errUnauthorized is excluded as well since a missing snapshot looks
+// like an unauthorized signer.
+func isDefinitiveSealError(err error) bool {
+	switch err {
+	case nil, errInvalidCoinbase, errWrongDifficulty, errRecentlySigned, errDoubleSign:
+		return true
+	}
+	return false
+}
+
+// checkSeal checks the signature contained in the header without the recently
+// verified results.
+func (senate *Senate) checkSeal(config params.SenateConfig, header, parent *types.Header) error {
 	// Resolve the authorization key and check against signers
-	signer, err := ecrecover(header, senate.signatures)
+	signer, err := ecrecover(header, senate.signatures, senate.sealChainID(header), senate.sealDomain(header))
 	if err != nil {
 		return err
 	}
-	if !senate.inTurn(config, parent, header.Time, signer) {
+	if signer != header.Coinbase {
+		return errInvalidCoinbase
+	}
+	inturn := senate.inTurn(config, parent, header.Time, signer)
+	if !inturn && !senate.isFallback(config, parent, header.Time, signer) &&
+		(config.NoTurnDelay == 0 || !senate.isValidator(config, parent, signer)) {
 		return errUnauthorized
 	}
+
+	// Ensure that the difficulty corresponds to the turn-ness of the signer, the
+	// total difficulty of a chain then counts its in-turn blocks with more weight
+	expected := big.NewInt(defaultDifficulty)
+	if config.NoTurnDelay > 0 || config.FallbackSlots > 0 {
+		expected = diffNoTurn
+		if inturn {
+			expected = diffInTurn
+		}
+	}
+	if header.Difficulty == nil || header.Difficulty.Cmp(expected) != 0 {
+		return errWrongDifficulty
+	}
+
+	// Ensure that the signer didn't sign a block recently
+	recently, err := senate.recentlySigned(config, header, parent, signer)
+	if err != nil {
+		return err
+	}
+	if recently {
+		return errRecentlySigned
+	}
+	return senate.detectEquivocation(signer, header)
+}
+
+// sealKey identifies the block a validator sealed at a height.
+type sealKey struct {
+	signer common.Address
+	number uint64
+}
+
+// sealSlot is the slot and the hash of a sealed block.
+type sealSlot struct {
+	time uint64
+	hash common.Hash
+}
+
+// detectEquivocation checks whether the signer has sealed another block for
+// the same slot of the header, which is a double signing.
+func (senate *Senate) detectEquivocation(signer common.Address, header *types.Header) error {
+	key := sealKey{signer: signer, number: header.Number.Uint64()}
+	slot := sealSlot{time: header.Time, hash: header.Hash()}
+	if seen, ok := senate.seals.Get(key); ok {
+		if seen := seen.(sealSlot); seen.time == slot.time && seen.hash != slot.hash {
+			log.Warn("[DPOS] Detected double sign", "signer", signer, "number", key.number,
+				"hash", slot.hash, "sealed", seen.hash)
+			return errDoubleSign
+		}
+	}
+	senate.seals.Add(key, slot)
 	return nil
 }
 
@@ -277,9 +714,6 @@ func (senate *Senate) Prepare(chain consensus.ChainHeaderReader, header *types.H
 	// Mix digest is reserved for now, set to empty
 	header.MixDigest = common.Hash{}
 
-	// Set the correct difficulty
-	header.Difficulty = senate.CalcDifficulty(chain, 0, nil)
-
 	// Initialize HeaderExtra, update epoch for block
 	var headerExtra HeaderExtra
 	var config params.SenateConfig
@@ -318,26 +752,27 @@ func (senate *Senate) Prepare(chain consensus.ChainHeaderReader, header *types.H
 		headerExtra.Root = parentHeaderExtra.Root
 		headerExtra.Epoch = parentHeaderExtra.Epoch
 		headerExtra.EpochTime = parentHeaderExtra.EpochTime
-		duration := header.Time - parentHeaderExtra.EpochTime
-		if duration/config.Epoch >= 1 && duration%config.Epoch > 0 {
+		if isNewEpoch(config, parentHeaderExtra.EpochTime, header.Time, number) {
 			headerExtra.Epoch = parentHeaderExtra.Epoch + 1
 			headerExtra.EpochTime = header.Time
 		}
 	}
 
-	// Ensure the extra data has HeaderExtra struct
-	data, err := headerExtra.Encode()
-	if err != nil {
-		return err
+	// Mint with the authorized key allowed to seal the block
+	if signer, ok := senate.sealer(config, parent, header.Time); ok {
+		header.Coinbase = signer.address
 	}
 
-	if len(header.Extra) < extraVanity {
-		header.Extra = append(header.Extra, bytes.Repeat([]byte{0x00}, extraVanity-len(header.Extra))...)
+	// Set the correct difficulty
+	header.Difficulty = senate.CalcDifficulty(chain, header.Time, parent)
+
+	// Set the base fee of London blocks from the parent
+	if chain.Config().IsLondon(header.Number) {
+		header.BaseFee = misc.CalcBaseFee(chain.Config(), parent)
 	}
-	header.Extra = header.Extra[:extraVanity]
-	header.Extra = append(header.Extra, data...)
-	header.Extra = append(header.Extra, bytes.Repeat([]byte{0x00}, extraSeal)...)
-	return nil
+
+	// Ensure the extra data has HeaderExtra struct
+	return encodeHeaderExtra(header, headerExtra)
 }
 
 // Finalize runs any post-transaction state modifications (e.g. block rewards)
@@ -350,51 +785,106 @@ func (senate *Senate) Finalize(chain consensus.ChainHeaderReader, header *types.
 
 	log.Trace("[DPOS] Finalize", "number", header.Number.Int64())
 
+	// Leave the header and state unchanged on failure, the block is rejected by
+	// VerifyFinalize unless the failure may not happen again. A HeaderExtra
+	// differing from the replayed one is forged by its validator, which must
+	// not crash the nodes importing the block.
+	revision := state.Snapshot()
+	if err := senate.finalize(chain, header, state, txs); err != nil {
+		state.RevertToSnapshot(revision)
+		log.Error("[DPOS] Failed to finalize block", "number", header.Number, "hash", header.Hash(), "err", err)
+		if !isTransientError(err) && !errors.Is(err, consensus.ErrUnknownAncestor) {
+			senate.rejected.Add(header.Hash(), err)
+		}
+	}
+}
+
+// VerifyFinalize returns the error Finalize found the block of header invalid
+// for, nil if it didn't.
+func (senate *Senate) VerifyFinalize(header *types.Header) error {
+	if err, ok := senate.rejected.Get(header.Hash()); ok {
+		return err.(error)
+	}
+	return nil
+}
+
+// finalize replays the post-transaction state modifications of Finalize and
+// checks the HeaderExtra of block header against the replayed one.
+func (senate *Senate) finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction) error {
 	// Load snapshot of parent block
-	var snap *Snapshot
-	number := header.Number.Uint64()
 	headerExtra, err := decodeHeaderExtra(header)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
+	// The parent may be on a fork whose snapshots are missing
 	parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
-	if number <= 1 {
-		snap, err = newSnapshot(senate.db)
-	} else {
-		parentHeaderExtra, err := decodeHeaderExtra(parent)
-		if err != nil {
-			panic(err)
-		}
-		snap, err = loadSnapshot(senate.db, parentHeaderExtra.Root)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
 	}
+	snap, err := senate.snapshotAt(chain, parent)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// Get the chain configuration
 	config, err := senate.chainConfig(parent)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	// Accumulate any block rewards and commit the final state root
-	senate.accumulateRewards(config, state, header)
-
 	// Replay custom transactions and check HeaderExtra of block header
 	temp := HeaderExtra{
 		Root:      headerExtra.Root,
 		Epoch:     headerExtra.Epoch,
 		EpochTime: headerExtra.EpochTime,
 	}
-	senate.processTransactions(config, state, header, snap, &temp, txs, nil)
-	if err = senate.tryElect(config, state, header, snap, &temp); err != nil || !temp.Equal(headerExtra) {
-		panic(err)
+
+	// Accumulate any block rewards and commit the final state root
+	validator, err := snap.ValidatorOf(header.Coinbase)
+	if err != nil {
+		return err
+	}
+	if err = senate.accumulateRewards(config, state, snap, header, validator, &temp); err != nil {
+		return err
+	}
+	burnBaseFee(state, header)
+
+	if err = senate.releaseRefunds(state, header, snap, &temp); err != nil {
+		return err
+	}
+	if err = senate.releaseUnbonded(state, header, snap); err != nil {
+		return err
+	}
+	if err = senate.applyPendingConfig(header, snap, &temp); err != nil {
+		return err
+	}
+	if err = senate.expireProposals(header, snap, &temp); err != nil {
+		return err
+	}
+	if err = senate.processTransactions(config, state, header, snap, &temp, txs, nil); err != nil {
+		return err
+	}
+	if err = senate.tryElect(config, state, header, snap, &temp); err != nil {
+		return err
+	}
+	if err = senate.fixStakes(config, state, header, snap, &temp); err != nil {
+		return err
+	}
+	if err = senate.applyStagedDelegates(header, snap, &temp); err != nil {
+		return err
+	}
+	if err = senate.accumulateSlash(config, state, snap, &temp); err != nil {
+		return err
+	}
+	if !temp.Equal(headerExtra) {
+		return errInvalidHeaderExtra
 	}
 
 	// Accumulate any block and uncle rewards and commit the final state root
 	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
 	header.UncleHash = types.CalcUncleHash(nil)
+	return nil
 }
 
 // FinalizeAndAssemble runs any post-transaction state modifications (e.g. block
@@ -417,14 +907,10 @@ func (senate *Senate) FinalizeAndAssemble(chain consensus.ChainHeaderReader, hea
 		EpochTime: oldHeaderExtra.EpochTime,
 	}
 	parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
-	if header.Number.Int64() > 1 {
-		parentHeaderExtra, err := decodeHeaderExtra(parent)
-		if err != nil {
-			return nil, err
-		}
-		headerExtra.Root = parentHeaderExtra.Root
+	if parent == nil {
+		return nil, consensus.ErrUnknownAncestor
 	}
-	snap, err := loadSnapshot(senate.db, headerExtra.Root)
+	snap, err := senate.snapshotAt(chain, parent)
 	if err != nil {
 		return nil, err
 	}
@@ -436,15 +922,46 @@ func (senate *Senate) FinalizeAndAssemble(chain consensus.ChainHeaderReader, hea
 	}
 
 	// Accumulate any block rewards and commit the final state root
-	senate.accumulateRewards(config, state, header)
+	validator, err := snap.ValidatorOf(header.Coinbase)
+	if err != nil {
+		return nil, err
+	}
+	if err = senate.accumulateRewards(config, state, snap, header, validator, &headerExtra); err != nil {
+		return nil, err
+	}
+
+	// Burn the base fee of gas consumed by transactions
+	burnBaseFee(state, header)
 
 	// Save validator of block to snapshot
-	if err = snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase); err != nil {
+	if err = snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), validator); err != nil {
+		return nil, err
+	}
+
+	// Refund the deposits vested in the epoch
+	if err = senate.releaseRefunds(state, header, snap, &headerExtra); err != nil {
+		return nil, err
+	}
+
+	// Refund the deposits unlocked since this block
+	if err = senate.releaseUnbonded(state, header, snap); err != nil {
+		return nil, err
+	}
+
+	// Apply the chain config approved in the last epoch
+	if err = senate.applyPendingConfig(header, snap, &headerExtra); err != nil {
+		return nil, err
+	}
+
+	// Remove the proposals not approved in time
+	if err = senate.expireProposals(header, snap, &headerExtra); err != nil {
 		return nil, err
 	}
 
 	// Parse and process custom transactions
-	senate.processTransactions(config, state, header, snap, &headerExtra, txs, receipts)
+	if err = senate.processTransactions(config, state, header, snap, &headerExtra, txs, receipts); err != nil {
+		return nil, err
+	}
 
 	// Elect validators in first block for epoch
 	if err = senate.tryElect(config, state, header, snap, &headerExtra); err != nil {
@@ -452,23 +969,45 @@ func (senate *Senate) FinalizeAndAssemble(chain consensus.ChainHeaderReader, hea
 		return nil, err
 	}
 
+	// Fix the stakes which the block rewards of the epoch are shared by
+	if err = senate.fixStakes(config, state, header, snap, &headerExtra); err != nil {
+		return nil, err
+	}
+
+	// Count the votes staged in the last epoch since the next election
+	if err = senate.applyStagedDelegates(header, snap, &headerExtra); err != nil {
+		return nil, err
+	}
+
+	// Slash the stake of validators kicked out for inactivity
+	if err = senate.accumulateSlash(config, state, snap, &headerExtra); err != nil {
+		return nil, err
+	}
+
+	// Add the block to the aggregates of the epoch
+	if err = snap.AccumulateEpochStats(config, header, headerExtra.Epoch); err != nil {
+		return nil, err
+	}
+
 	// Save snapshot of current block to db
 	headerExtra.Root, err = snap.Root()
 	if err != nil {
 		return nil, err
 	}
-	if err = snap.Commit(headerExtra.Root); err != nil {
+	if err = senate.commitSnapshot(snap, headerExtra.Root); err != nil {
 		return nil, err
 	}
+	senate.cacheSnapshot(snap)
+	updateEpochMetrics(snap, headerExtra)
+	senate.schedulePruning(chain, header, headerExtra.Epoch)
 
 	// Write HeaderExtra of current block into header.Extra
-	data, err := headerExtra.Encode()
-	if err != nil {
+	if err = encodeHeaderExtra(header, headerExtra); err != nil {
+		return nil, err
+	}
+	if err = senate.verifyExtraSize(header); err != nil {
 		return nil, err
 	}
-	header.Extra = header.Extra[:extraVanity]
-	header.Extra = append(header.Extra, data...)
-	header.Extra = append(header.Extra, bytes.Repeat([]byte{0x00}, extraSeal)...)
 
 	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
 	header.UncleHash = types.CalcUncleHash(nil)
@@ -483,80 +1022,222 @@ func (senate *Senate) FinalizeAndAssemble(chain consensus.ChainHeaderReader, hea
 func (senate *Senate) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
 	log.Trace("[DPOS] Seal", "number", block.Number().Int64())
 
+	header, delay, err := senate.signBlock(chain, block)
+	switch err {
+	case nil:
+	case errEmptyBlockHeld:
+		log.Debug("[DPOS] Skip empty block, waiting for transactions", "number", block.Number().Int64())
+		return nil
+	case errRecentlySigned:
+		log.Info("[DPOS] Signed recently, must wait for others")
+		return nil
+	default:
+		return err
+	}
+
+	// Wait until sealing is terminated or delay timeout.
+	log.Info("[DPOS] Waiting for slot to sign and propagate", "delay", common.PrettyDuration(delay))
+	if !senate.track(1) {
+		return errEngineClosed
+	}
+	go func() {
+		defer senate.running.Done()
+		select {
+		case <-stop:
+			return
+		case <-senate.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		// An overloaded node may wake up once the slot passed, the block would
+		// likely be orphaned by the one of the validator in turn now
+		if senate.staleSeal(chain, header, time.Now()) {
+			staleSealMeter.Mark(1)
+			log.Warn("[DPOS] Drop sealed block, slot passed", "number", header.Number, "slot", header.Time)
+			return
+		}
+		select {
+		case results <- block.WithSeal(header):
+			// Delay between the slot and the block handed over for propagation
+			sealDelayHistogram.Update(time.Since(time.Unix(int64(header.Time), 0)).Milliseconds())
+		default:
+			log.Warn("[DPOS] Sealing result is not read by miner", "sealhash", senate.SealHash(header))
+		}
+	}()
+	return nil
+}
+
+// DryRunSeal checks and signs the block exactly like Seal, but returns the
+// sealed block at once instead of waiting for the slot and handing it over
+// for propagation. Blocks Seal would hold back are reported as errors.
+func (senate *Senate) DryRunSeal(chain consensus.ChainHeaderReader, block *types.Block) (*types.Block, error) {
+	header, _, err := senate.signBlock(chain, block)
+	if err != nil {
+		return nil, err
+	}
+	return block.WithSeal(header), nil
+}
+
+// signBlock checks the local validator is allowed to seal the block and signs
+// a copy of its header, returning it along with the delay before the block
+// may be propagated.
+func (senate *Senate) signBlock(chain consensus.ChainHeaderReader, block *types.Block) (*types.Header, time.Duration, error) {
+	// Bail out if the engine has no key to sign with at all
+	if !senate.canSeal() {
+		return nil, 0, errNotAuthorizedToSeal
+	}
+
 	// Sealing the genesis block is not supported
 	header := block.Header()
 	number := header.Number.Uint64()
 	if number == 0 {
-		return errUnknownBlock
+		return nil, 0, errUnknownBlock
 	}
 
 	// Check that the extra-data contains both the vanity and signature
 	if len(header.Extra) < extraVanity {
-		return errMissingVanity
+		return nil, 0, errMissingVanity
 	}
 
 	if len(header.Extra) < extraVanity+extraSeal {
-		return errMissingSignature
+		return nil, 0, errMissingSignature
 	}
 
 	// Get the chain configuration
 	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return nil, 0, consensus.ErrUnknownAncestor
+	}
 	config, err := senate.chainConfig(parent)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 
 	// Bail out if we're unauthorized to sign a block
-	if !senate.inTurn(config, parent, header.Time, header.Coinbase) {
-		return errUnauthorized
+	inturn := senate.inTurn(config, parent, header.Time, header.Coinbase)
+	fallback := !inturn && senate.isFallback(config, parent, header.Time, header.Coinbase)
+	if !inturn && !fallback && (config.NoTurnDelay == 0 || !senate.isValidator(config, parent, header.Coinbase)) {
+		return nil, 0, errUnauthorized
+	}
+
+	// Hold back empty blocks until the empty block period passed
+	if header.Time < parent.Time+blockPeriod(config, header) {
+		return nil, 0, errEmptyBlockHeld
 	}
 
+	// Sign with the authorized key of coinbase, which is chosen in Prepare.
 	// Don't hold the signer fields for the entire sealing procedure
-	senate.lock.RLock()
-	signer, signFn := senate.signer, senate.signFn
-	senate.lock.RUnlock()
+	var signer common.Address
+	var signFn SignerFn
+	for _, authorized := range senate.authorized() {
+		if authorized.address == header.Coinbase {
+			signer, signFn = authorized.address, authorized.signFn
+			break
+		}
+	}
+	if signFn == nil {
+		return nil, 0, errUnauthorized
+	}
+
+	// If we're amongst the recent signers, wait for the next block
+	recently, err := senate.recentlySigned(config, header, parent, signer)
+	if err != nil {
+		return nil, 0, err
+	}
+	if recently {
+		return nil, 0, errRecentlySigned
+	}
 
 	// Sign all the things!
-	sigHash, err := signFn(accounts.Account{Address: signer}, accounts.MimetypeClique, SenateRLP(header))
+	sigHash, err := signFn(accounts.Account{Address: signer}, accounts.MimetypeSenate, SenateRLP(header, senate.sealChainID(header), senate.sealDomain(header)))
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 	copy(header.Extra[len(header.Extra)-extraSeal:], sigHash)
 
-	// Wait until sealing is terminated or delay timeout.
 	delay := time.Unix(int64(header.Time), 0).Sub(time.Now())
-	log.Info("[DPOS] Waiting for slot to sign and propagate", "delay", common.PrettyDuration(delay))
-	go func() {
-		select {
-		case <-stop:
-			return
-		case <-time.After(delay):
+	if !inturn && !fallback {
+		// It's not our turn explicitly to sign, delay it a bit
+		validators, _, err := senate.signers(config, parent)
+		if err != nil {
+			return nil, 0, err
 		}
+		wiggle := time.Duration(len(validators)/2+1) * wiggleTime
+		delay += time.Duration(config.NoTurnDelay)*time.Second + time.Duration(rand.Int63n(int64(wiggle)))
+		log.Trace("[DPOS] Out-of-turn signing requested", "wiggle", common.PrettyDuration(wiggle))
+	}
+	return header, delay, nil
+}
 
-		select {
-		case results <- block.WithSeal(header):
-		default:
-			log.Warn("[DPOS] Sealing result is not read by miner", "sealhash", SealHash(header))
-		}
-	}()
-	return nil
+// staleSeal returns whether the slot of a block signed in turn passed at now,
+// and the signer is no longer in turn. Blocks signed out of turn are delayed
+// past their slot on purpose, so they are never stale.
+func (senate *Senate) staleSeal(chain consensus.ChainHeaderReader, header *types.Header, now time.Time) bool {
+	parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	if parent == nil {
+		return false
+	}
+	config, err := senate.chainConfig(parent)
+	if err != nil {
+		return false
+	}
+	current := uint64(now.Unix())
+	if current < header.Time+config.Period {
+		return false
+	}
+	if !senate.inTurn(config, parent, header.Time, header.Coinbase) &&
+		!senate.isFallback(config, parent, header.Time, header.Coinbase) {
+		return false
+	}
+	return !senate.inTurn(config, parent, current, header.Coinbase) &&
+		!senate.isFallback(config, parent, current, header.Coinbase)
 }
 
 // SealHash returns the hash of a block prior to it being sealed.
 func (senate *Senate) SealHash(header *types.Header) (hash common.Hash) {
-	return SealHash(header)
+	return SealHash(header, senate.sealChainID(header), senate.sealDomain(header))
+}
+
+// Gets the chain id bound into the seal hash of header, nil before activation.
+func (senate *Senate) sealChainID(header *types.Header) *big.Int {
+	if senate.chainID == nil || senate.config.ChainIDBlock == 0 || header.Number == nil {
+		return nil
+	}
+	if header.Number.Uint64() < senate.config.ChainIDBlock {
+		return nil
+	}
+	return senate.chainID
+}
+
+// Gets the domain tag prefixing the seal hash of header, nil before activation.
+func (senate *Senate) sealDomain(header *types.Header) []byte {
+	if senate.config.SealDomainBlock == 0 || header.Number == nil {
+		return nil
+	}
+	if header.Number.Uint64() < senate.config.SealDomainBlock {
+		return nil
+	}
+	return sealDomainTag
 }
 
 // CalcDifficulty is the difficulty adjustment algorithm. It returns the difficulty
 // that a new block should have.
 func (senate *Senate) CalcDifficulty(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
-	return big.NewInt(defaultDifficulty)
+	config, err := senate.chainConfig(parent)
+	if err != nil {
+		return big.NewInt(defaultDifficulty)
+	}
+
+	signer, _ := senate.sealer(config, parent, time)
+	return senate.turnDifficulty(config, parent, time, signer.address)
 }
 
-// SealHash returns the hash of a block prior to it being sealed.
-func SealHash(header *types.Header) (hash common.Hash) {
+// SealHash returns the hash of a block prior to it being sealed, the chainID
+// is bound into the hash if not nil and the domain tag prefixes the hashed data.
+func SealHash(header *types.Header, chainID *big.Int, domain []byte) (hash common.Hash) {
 	hasher := sha3.NewLegacyKeccak256()
-	encodeSigHeader(hasher, header)
+	encodeSigHeader(hasher, header, chainID, domain)
 	hasher.Sum(hash[:0])
 	return hash
 }
@@ -568,14 +1249,95 @@ func SealHash(header *types.Header) (hash common.Hash) {
 // Note, the method requires the extra data to be at least 65 bytes, otherwise it
 // panics. This is done to avoid accidentally using both forms (signature present
 // or not), which could be abused to produce different hashes for the same header.
-func SenateRLP(header *types.Header) []byte {
+func SenateRLP(header *types.Header, chainID *big.Int, domain []byte) []byte {
 	b := new(bytes.Buffer)
-	encodeSigHeader(b, header)
+	encodeSigHeader(b, header, chainID, domain)
 	return b.Bytes()
 }
 
-func encodeSigHeader(w io.Writer, header *types.Header) {
-	err := rlp.Encode(w, []interface{}{
+// sigHeader is the RLP list of the signed fields of a header, apart from the
+// chain id.
+type sigHeader struct {
+	ParentHash  common.Hash
+	UncleHash   common.Hash
+	Coinbase    common.Address
+	Root        common.Hash
+	TxHash      common.Hash
+	ReceiptHash common.Hash
+	Bloom       types.Bloom
+	Difficulty  *big.Int
+	Number      *big.Int
+	GasLimit    uint64
+	GasUsed     uint64
+	Time        uint64
+	Extra       []byte
+	MixDigest   common.Hash
+	Nonce       types.BlockNonce
+	BaseFee     *big.Int `rlp:"optional"`
+}
+
+// DecodeSenateRLP parses the data returned by SenateRLP back into the header
+// being sealed, with a zero signature, the chain id and the domain tag bound
+// into the seal. Data which SenateRLP can't produce is rejected.
+func DecodeSenateRLP(data []byte) (*types.Header, *big.Int, []byte, error) {
+	var domain []byte
+	if bytes.HasPrefix(data, sealDomainTag) {
+		domain = sealDomainTag
+	}
+	var fields []rlp.RawValue
+	if err := rlp.DecodeBytes(data[len(domain):], &fields); err != nil {
+		return nil, nil, nil, err
+	}
+	// The chain id shifts the bloom from the seventh field to the eighth
+	var chainID *big.Int
+	if len(fields) > 7 {
+		if bloom, _, err := rlp.SplitString(fields[7]); err == nil && len(bloom) == types.BloomByteLength {
+			chainID = new(big.Int)
+			if err := rlp.DecodeBytes(fields[0], chainID); err != nil {
+				return nil, nil, nil, err
+			}
+			fields = fields[1:]
+		}
+	}
+	enc, err := rlp.EncodeToBytes(fields)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var sig sigHeader
+	if err := rlp.DecodeBytes(enc, &sig); err != nil {
+		return nil, nil, nil, err
+	}
+	header := &types.Header{
+		ParentHash:  sig.ParentHash,
+		UncleHash:   sig.UncleHash,
+		Coinbase:    sig.Coinbase,
+		Root:        sig.Root,
+		TxHash:      sig.TxHash,
+		ReceiptHash: sig.ReceiptHash,
+		Bloom:       sig.Bloom,
+		Difficulty:  sig.Difficulty,
+		Number:      sig.Number,
+		GasLimit:    sig.GasLimit,
+		GasUsed:     sig.GasUsed,
+		Time:        sig.Time,
+		Extra:       append(sig.Extra, make([]byte, crypto.SignatureLength)...),
+		MixDigest:   sig.MixDigest,
+		Nonce:       sig.Nonce,
+		BaseFee:     sig.BaseFee,
+	}
+	if !bytes.Equal(SenateRLP(header, chainID, domain), data) {
+		return nil, nil, nil, errInvalidSenateRLP
+	}
+	return header, chainID, domain, nil
+}
+
+// encodeSigHeader writes the signed fields of header as a RLP list. Fields added
+// by later forks are appended only if the header has them, so the seal hash of
+// blocks before the fork stays the same. Header verification ensures a field is
+// present exactly from the block its fork activates. The domain tag is written
+// ahead of the list, it isn't part of the RLP.
+func encodeSigHeader(w io.Writer, header *types.Header, chainID *big.Int, domain []byte) {
+	enc := []interface{}{
 		header.ParentHash,
 		header.UncleHash,
 		header.Coinbase,
@@ -591,8 +1353,18 @@ func encodeSigHeader(w io.Writer, header *types.Header) {
 		header.Extra[:len(header.Extra)-crypto.SignatureLength], // Yes, this will panic if extra is too short
 		header.MixDigest,
 		header.Nonce,
-	})
-	if err != nil {
+	}
+	if header.BaseFee != nil { // London
+		enc = append(enc, header.BaseFee)
+	}
+	// Prevent the signed header from being replayed on other chains
+	if chainID != nil {
+		enc = append([]interface{}{chainID}, enc...)
+	}
+	if _, err := w.Write(domain); err != nil {
+		panic("can't write domain: " + err.Error())
+	}
+	if err := rlp.Encode(w, enc); err != nil {
 		panic("can't encode: " + err.Error())
 	}
 }
@@ -1,11 +1,29 @@
 package senate
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"io"
+	"math/big"
+	"math/rand"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/SecretBlockChain/go-secret/accounts"
+	"github.com/SecretBlockChain/go-secret/common"
+	"github.com/SecretBlockChain/go-secret/consensus"
+	"github.com/SecretBlockChain/go-secret/consensus/misc"
+	"github.com/SecretBlockChain/go-secret/core/rawdb"
+	"github.com/SecretBlockChain/go-secret/core/state"
 	"github.com/SecretBlockChain/go-secret/core/types"
 	"github.com/SecretBlockChain/go-secret/crypto"
+	"github.com/SecretBlockChain/go-secret/ethdb"
+	"github.com/SecretBlockChain/go-secret/event"
+	"github.com/SecretBlockChain/go-secret/params"
+	"github.com/SecretBlockChain/go-secret/rlp"
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/stretchr/testify/assert"
 )
@@ -17,12 +35,1418 @@ func TestSealHash(t *testing.T) {
 	signFn := func(account accounts.Account, s string, data []byte) ([]byte, error) {
 		return crypto.Sign(crypto.Keccak256(data), testUserKey)
 	}
-	sigHash, err := signFn(accounts.Account{Address: testUserAddress}, accounts.MimetypeClique, SenateRLP(&header))
+	sigHash, err := signFn(accounts.Account{Address: testUserAddress}, accounts.MimetypeSenate, SenateRLP(&header, nil, nil))
 	assert.Nil(t, err)
 	copy(header.Extra, sigHash)
 
 	signatures, _ := lru.NewARC(inMemorySignatures)
-	signer, err := ecrecover(&header, signatures)
+	signer, err := ecrecover(&header, signatures, nil, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, signer.String(), testUserAddress.String())
 }
+
+func TestSealHashGolden(t *testing.T) {
+	header := &types.Header{
+		ParentHash: common.HexToHash("0x90fcc640d56532c8d4f1255a44533b8d097149c67e298fc7baa1d920925e235f"),
+		UncleHash:  uncleHash,
+		Coinbase:   common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910"),
+		Difficulty: big.NewInt(1),
+		Number:     big.NewInt(100),
+		GasLimit:   8000000,
+		Time:       1600000000,
+		Extra:      make([]byte, extraVanity+extraSeal),
+	}
+	assert.Equal(t, "0xe059965b074557e9f8c58821862f6a866a559a1206010a81e1634ac9e663399e", SealHash(header, nil, nil).Hex())
+	assert.Equal(t, "0x95bec24df1b7351111d97b4e9d2ad3f87abfa433ddd63f9c1e6da5d4ae8e9eff", SealHash(header, big.NewInt(1), nil).Hex())
+
+	config := params.SenateConfig{ChainIDBlock: 101}
+	senate := New(&config, big.NewInt(1), rawdb.NewMemoryDatabase())
+	assert.Equal(t, SealHash(header, nil, nil), senate.SealHash(header))
+	config.ChainIDBlock = 100
+	assert.Equal(t, SealHash(header, big.NewInt(1), nil), senate.SealHash(header))
+
+	// Headers since London append the base fee to the signed fields
+	countFields := func(header *types.Header, chainID *big.Int) int {
+		content, _, err := rlp.SplitList(SenateRLP(header, chainID, nil))
+		assert.Nil(t, err)
+		count, err := rlp.CountValues(content)
+		assert.Nil(t, err)
+		return count
+	}
+	assert.Equal(t, 15, countFields(header, nil))
+	header.BaseFee = big.NewInt(params.InitialBaseFee)
+	assert.Equal(t, 16, countFields(header, nil))
+	assert.Equal(t, 17, countFields(header, big.NewInt(1)))
+	assert.Equal(t, "0x9c03807c3940e89887a2fc0ff7daa59ff41d1f73c72e180473f6dea0d7a92da5", SealHash(header, nil, nil).Hex())
+	assert.Equal(t, "0xe06d8243c0b1b438fb0701403d14852359f583c557bb9c0c0c9ad43795b977c9", SealHash(header, big.NewInt(1), nil).Hex())
+}
+
+func TestSealHashDomain(t *testing.T) {
+	header := &types.Header{
+		Number:     big.NewInt(100),
+		Time:       1600000000,
+		Difficulty: big.NewInt(defaultDifficulty),
+		Coinbase:   testUserAddress,
+		Extra:      make([]byte, extraVanity+extraSeal),
+	}
+
+	// The tag prefixes the signed data ahead of the RLP list
+	tagged := SenateRLP(header, nil, sealDomainTag)
+	assert.Equal(t, append(append([]byte{}, sealDomainTag...), SenateRLP(header, nil, nil)...), tagged)
+	assert.Equal(t, crypto.Keccak256Hash(tagged), SealHash(header, nil, sealDomainTag))
+	assert.NotEqual(t, SealHash(header, nil, nil), SealHash(header, nil, sealDomainTag))
+	_, _, err := rlp.SplitList(tagged)
+	assert.NotNil(t, err)
+
+	// The tag applies since the fork only
+	config := params.SenateConfig{SealDomainBlock: 101}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	assert.Equal(t, SealHash(header, nil, nil), senate.SealHash(header))
+	config.SealDomainBlock = 100
+	assert.Equal(t, SealHash(header, nil, sealDomainTag), senate.SealHash(header))
+
+	// A seal signed without the tag doesn't recover the signer after the fork
+	sig, err := crypto.Sign(SealHash(header, nil, nil).Bytes(), testUserKey)
+	assert.Nil(t, err)
+	copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+	signer, err := New(&config, nil, rawdb.NewMemoryDatabase()).Author(header)
+	assert.Nil(t, err)
+	assert.NotEqual(t, testUserAddress, signer)
+
+	sig, err = crypto.Sign(SealHash(header, nil, sealDomainTag).Bytes(), testUserKey)
+	assert.Nil(t, err)
+	copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+	signer, err = New(&config, nil, rawdb.NewMemoryDatabase()).Author(header)
+	assert.Nil(t, err)
+	assert.Equal(t, testUserAddress, signer)
+}
+
+func TestDecodeSenateRLP(t *testing.T) {
+	header := &types.Header{
+		ParentHash: common.HexToHash("0x90fcc640d56532c8d4f1255a44533b8d097149c67e298fc7baa1d920925e235f"),
+		UncleHash:  uncleHash,
+		Coinbase:   testUserAddress,
+		Difficulty: big.NewInt(defaultDifficulty),
+		Number:     big.NewInt(100),
+		GasLimit:   8000000,
+		Time:       1600000000,
+		Extra:      make([]byte, extraVanity+extraSeal),
+	}
+	london := types.CopyHeader(header)
+	london.BaseFee = big.NewInt(params.InitialBaseFee)
+
+	// Every form of the signed data decodes to the header, chain id and tag
+	for _, header := range []*types.Header{header, london} {
+		for _, chainID := range []*big.Int{nil, big.NewInt(1)} {
+			for _, domain := range [][]byte{nil, sealDomainTag} {
+				decoded, decodedID, decodedDomain, err := DecodeSenateRLP(SenateRLP(header, chainID, domain))
+				assert.Nil(t, err)
+				assert.Equal(t, header.Hash(), decoded.Hash())
+				assert.Equal(t, chainID, decodedID)
+				assert.Equal(t, domain, decodedDomain)
+				assert.Equal(t, SealHash(header, chainID, domain), SealHash(decoded, decodedID, decodedDomain))
+			}
+		}
+	}
+
+	// Arbitrary data isn't signed as a header
+	_, _, _, err := DecodeSenateRLP([]byte("EHLO world"))
+	assert.NotNil(t, err)
+	short, err := rlp.EncodeToBytes([]interface{}{header.ParentHash, header.Number})
+	assert.Nil(t, err)
+	_, _, _, err = DecodeSenateRLP(short)
+	assert.NotNil(t, err)
+	_, _, _, err = DecodeSenateRLP(append(SenateRLP(header, nil, nil), 0x80))
+	assert.NotNil(t, err)
+}
+
+func TestSealChainIDReplay(t *testing.T) {
+	config := params.SenateConfig{
+		Period:           1,
+		GenesisTimestamp: 1600000000,
+		ChainIDBlock:     1,
+		Validators:       []common.Address{testUserAddress},
+	}
+	header := &types.Header{
+		Number:     big.NewInt(1),
+		Time:       1600000000,
+		Difficulty: big.NewInt(defaultDifficulty),
+		Coinbase:   testUserAddress,
+		Extra:      make([]byte, extraVanity+extraSeal),
+	}
+	sign := func(senate *Senate) {
+		sig, err := crypto.Sign(crypto.Keccak256(SenateRLP(header, senate.sealChainID(header), nil)), testUserKey)
+		assert.Nil(t, err)
+		copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+	}
+
+	// Header sealed for chain 1 is accepted on chain 1 only
+	senate := New(&config, big.NewInt(1), rawdb.NewMemoryDatabase())
+	sign(senate)
+	assert.Nil(t, senate.verifySeal(config, header, nil))
+	fork := New(&config, big.NewInt(2), rawdb.NewMemoryDatabase())
+	assert.Equal(t, errInvalidCoinbase, fork.verifySeal(config, header, nil))
+
+	// Before activation the same header is valid on both chains
+	config.ChainIDBlock = 2
+	header.Time++
+	senate = New(&config, big.NewInt(1), rawdb.NewMemoryDatabase())
+	sign(senate)
+	assert.Nil(t, senate.verifySeal(config, header, nil))
+	fork = New(&config, big.NewInt(2), rawdb.NewMemoryDatabase())
+	assert.Nil(t, fork.verifySeal(config, header, nil))
+}
+
+func TestSealChainIDCrossChain(t *testing.T) {
+	genesis := &types.Header{Number: big.NewInt(0), Time: uint64(time.Now().Unix()) - 10}
+	config := params.SenateConfig{
+		Period:              1,
+		Epoch:               100,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		GenesisTimestamp:    genesis.Time,
+		ChainIDBlock:        1,
+		Validators:          []common.Address{testUserAddress},
+	}
+	senate := New(&config, big.NewInt(1), rawdb.NewMemoryDatabase())
+	senate.Authorize(testUserAddress, func(account accounts.Account, s string, data []byte) ([]byte, error) {
+		return crypto.Sign(crypto.Keccak256(data), testUserKey)
+	})
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	header := &types.Header{Number: big.NewInt(1), ParentHash: genesis.Hash()}
+	assert.Nil(t, senate.Prepare(chain, header))
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	assert.Nil(t, err)
+	block, err := senate.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+	assert.Nil(t, err)
+	sealed, err := senate.DryRunSeal(chain, block)
+	assert.Nil(t, err)
+
+	// The seal signs the chain id, a fork recovers another signer from it
+	assert.Nil(t, senate.verifySeal(config, sealed.Header(), genesis))
+	for _, chainID := range []*big.Int{big.NewInt(2), nil} {
+		fork := New(&config, chainID, rawdb.NewMemoryDatabase())
+		signer, err := fork.Author(sealed.Header())
+		assert.Nil(t, err)
+		assert.NotEqual(t, testUserAddress, signer)
+		assert.Equal(t, errInvalidCoinbase, fork.verifySeal(config, sealed.Header(), genesis))
+	}
+}
+
+func TestSealNotAuthorized(t *testing.T) {
+	config := params.SenateConfig{Period: 1, Epoch: 100, Validators: []common.Address{testUserAddress}}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	genesis := &types.Header{Number: big.NewInt(0), Time: uint64(time.Now().Unix())}
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	block := types.NewBlockWithHeader(&types.Header{
+		Number:     big.NewInt(1),
+		ParentHash: genesis.Hash(),
+		Coinbase:   testUserAddress,
+		Extra:      make([]byte, extraVanity+extraSeal),
+	})
+	signFn := func(account accounts.Account, s string, data []byte) ([]byte, error) {
+		return crypto.Sign(crypto.Keccak256(data), testUserKey)
+	}
+
+	// Neither an engine without key, nor one with a zero signer or without
+	// signing function panics
+	results := make(chan *types.Block, 1)
+	assert.Equal(t, errNotAuthorizedToSeal, senate.Seal(chain, block, results, nil))
+	senate.Authorize(common.Address{}, signFn)
+	assert.Equal(t, errNotAuthorizedToSeal, senate.Seal(chain, block, results, nil))
+	senate.Authorize(testUserAddress, nil)
+	assert.Equal(t, errNotAuthorizedToSeal, senate.Seal(chain, block, results, nil))
+	_, err := senate.DryRunSeal(chain, block)
+	assert.Equal(t, errNotAuthorizedToSeal, err)
+	assert.Equal(t, 0, len(results))
+}
+
+func TestVerifySealDoubleSign(t *testing.T) {
+	config := params.SenateConfig{
+		Period:           1,
+		GenesisTimestamp: 1600000000,
+		Validators:       []common.Address{testUserAddress},
+	}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	newHeader := func(parent common.Hash) *types.Header {
+		header := &types.Header{
+			ParentHash: parent,
+			Number:     big.NewInt(1),
+			Time:       1600000000,
+			Difficulty: big.NewInt(defaultDifficulty),
+			Coinbase:   testUserAddress,
+			Extra:      make([]byte, extraVanity+extraSeal),
+		}
+		sig, err := crypto.Sign(SealHash(header, nil, nil).Bytes(), testUserKey)
+		assert.Nil(t, err)
+		copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+		return header
+	}
+
+	header := newHeader(common.HexToHash("0x01"))
+	assert.Nil(t, senate.verifySeal(config, header, nil))
+	assert.Nil(t, senate.verifySeal(config, header, nil))
+
+	// Another block for the same slot from the same signer is rejected
+	conflict := newHeader(common.HexToHash("0x02"))
+	assert.Equal(t, errDoubleSign, senate.verifySeal(config, conflict, nil))
+}
+
+func TestVerifySealCoinbase(t *testing.T) {
+	validator := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	config := params.SenateConfig{
+		Period:           1,
+		GenesisTimestamp: 1600000000,
+		NoTurnDelay:      1,
+		Validators:       []common.Address{testUserAddress, validator},
+	}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	newHeader := func(coinbase common.Address) *types.Header {
+		header := &types.Header{
+			Number:     big.NewInt(1),
+			Time:       1600000000,
+			Difficulty: diffInTurn,
+			Coinbase:   coinbase,
+			Extra:      make([]byte, extraVanity+extraSeal),
+		}
+		sig, err := crypto.Sign(SealHash(header, nil, nil).Bytes(), testUserKey)
+		assert.Nil(t, err)
+		copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+		return header
+	}
+
+	// A block crediting another validator than its signer is rejected
+	header := newHeader(validator)
+	assert.Equal(t, errInvalidCoinbase, senate.verifySeal(config, header, nil))
+	assert.True(t, senate.verified.Contains(header.Hash()))
+	assert.Nil(t, senate.verifySeal(config, newHeader(testUserAddress), nil))
+}
+
+func TestVerifySealCache(t *testing.T) {
+	config := params.SenateConfig{
+		Period:           1,
+		GenesisTimestamp: 1600000000,
+		Validators:       []common.Address{testUserAddress},
+	}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	newHeader := func(time uint64) *types.Header {
+		header := &types.Header{
+			Number:     big.NewInt(1),
+			Time:       time,
+			Difficulty: big.NewInt(defaultDifficulty),
+			Coinbase:   testUserAddress,
+			Extra:      make([]byte, extraVanity+extraSeal),
+		}
+		sig, err := crypto.Sign(SealHash(header, nil, nil).Bytes(), testUserKey)
+		assert.Nil(t, err)
+		copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+		return header
+	}
+
+	// Valid seals are cached, the cached result is returned again
+	header := newHeader(1600000000)
+	assert.Nil(t, senate.verifySeal(config, header, nil))
+	assert.True(t, senate.verified.Contains(header.Hash()))
+	assert.Nil(t, senate.verifySeal(config, header, nil))
+
+	// Unauthorized signers may be caused by a missing snapshot, never cached
+	header = newHeader(1600000001)
+	config.Validators = []common.Address{{}}
+	assert.Equal(t, errUnauthorized, senate.verifySeal(config, header, nil))
+	assert.False(t, senate.verified.Contains(header.Hash()))
+	config.Validators = []common.Address{testUserAddress}
+	assert.Nil(t, senate.verifySeal(config, header, nil))
+}
+
+func BenchmarkVerifySeal(b *testing.B) {
+	config := params.SenateConfig{
+		Period:           1,
+		GenesisTimestamp: 1600000000,
+		Validators:       []common.Address{testUserAddress},
+	}
+	header := &types.Header{
+		Number:     big.NewInt(1),
+		Time:       1600000000,
+		Difficulty: big.NewInt(defaultDifficulty),
+		Coinbase:   testUserAddress,
+		Extra:      make([]byte, extraVanity+extraSeal),
+	}
+	sig, _ := crypto.Sign(SealHash(header, nil, nil).Bytes(), testUserKey)
+	copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+
+	b.Run("cached", func(b *testing.B) {
+		senate := New(&config, nil, rawdb.NewMemoryDatabase())
+		for i := 0; i < b.N; i++ {
+			if err := senate.verifySeal(config, header, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		senate := New(&config, nil, rawdb.NewMemoryDatabase())
+		for i := 0; i < b.N; i++ {
+			senate.verified.Purge()
+			senate.signatures.Purge()
+			if err := senate.verifySeal(config, header, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestVerifySealOutOfTurn(t *testing.T) {
+	validator := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	config := params.SenateConfig{
+		Period:           1,
+		GenesisTimestamp: 1600000000,
+		Validators:       []common.Address{testUserAddress, validator},
+	}
+	newHeader := func(time uint64, difficulty *big.Int) *types.Header {
+		header := &types.Header{
+			Number:     big.NewInt(1),
+			Time:       time,
+			Difficulty: difficulty,
+			Coinbase:   testUserAddress,
+			Extra:      make([]byte, extraVanity+extraSeal),
+		}
+		sig, err := crypto.Sign(SealHash(header, nil, nil).Bytes(), testUserKey)
+		assert.Nil(t, err)
+		copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+		return header
+	}
+
+	// Only in-turn signing is allowed by default
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	assert.Nil(t, senate.verifySeal(config, newHeader(1600000000, big.NewInt(defaultDifficulty)), nil))
+	assert.Equal(t, errUnauthorized, senate.verifySeal(config, newHeader(1600000001, big.NewInt(defaultDifficulty)), nil))
+	assert.Equal(t, errWrongDifficulty, senate.verifySeal(config, newHeader(1600000000, diffInTurn), nil))
+
+	// The difficulty has to match the turn of signer
+	config.NoTurnDelay = 2
+	senate = New(&config, nil, rawdb.NewMemoryDatabase())
+	assert.Nil(t, senate.verifySeal(config, newHeader(1600000000, diffInTurn), nil))
+	assert.Nil(t, senate.verifySeal(config, newHeader(1600000001, diffNoTurn), nil))
+	assert.Equal(t, errWrongDifficulty, senate.verifySeal(config, newHeader(1600000002, diffNoTurn), nil))
+	assert.Equal(t, errWrongDifficulty, senate.verifySeal(config, newHeader(1600000003, diffInTurn), nil))
+
+	senate.Authorize(testUserAddress, nil)
+	assert.Equal(t, diffInTurn, senate.CalcDifficulty(nil, 1600000000, nil))
+	assert.Equal(t, diffNoTurn, senate.CalcDifficulty(nil, 1600000001, nil))
+}
+
+func TestSealAuthorizedSigners(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	signers := make([]common.Address, len(keys))
+	signFns := make([]SignerFn, len(keys))
+	for i := range keys {
+		key, _ := crypto.GenerateKey()
+		keys[i], signers[i] = key, crypto.PubkeyToAddress(key.PublicKey)
+		signFns[i] = func(account accounts.Account, s string, data []byte) ([]byte, error) {
+			return crypto.Sign(crypto.Keccak256(data), key)
+		}
+	}
+	genesis := &types.Header{Number: big.NewInt(0), Time: uint64(time.Now().Unix()) - 10}
+	config := params.SenateConfig{
+		Period:              1,
+		Epoch:               100,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		GenesisTimestamp:    genesis.Time,
+		Validators:          signers[:2],
+	}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	senate.Authorize(signers[0], signFns[0])
+	senate.AuthorizeFallback(signers[1], signFns[1])
+
+	// Each slot is sealed by the key in turn
+	for slot := uint64(0); slot < 4; slot++ {
+		sealer, ok := senate.sealer(config, genesis, genesis.Time+slot)
+		assert.True(t, ok)
+		assert.Equal(t, signers[slot%2], sealer.address)
+	}
+
+	// Rotating the primary key drops the replaced one
+	senate.Authorize(signers[2], signFns[2])
+	_, ok := senate.sealer(config, genesis, genesis.Time)
+	assert.False(t, ok)
+	sealer, ok := senate.sealer(config, genesis, genesis.Time+1)
+	assert.True(t, ok)
+	assert.Equal(t, signers[1], sealer.address)
+	senate.Deauthorize(signers[1])
+	assert.Equal(t, []common.Address{signers[2]}, func() (addresses []common.Address) {
+		for _, authorized := range senate.authorized() {
+			addresses = append(addresses, authorized.address)
+		}
+		return addresses
+	}())
+
+	// The block is minted and signed by the key in turn
+	senate.Authorize(signers[0], signFns[0])
+	senate.AuthorizeFallback(signers[1], signFns[1])
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	header := &types.Header{Number: big.NewInt(1), ParentHash: genesis.Hash()}
+	assert.Nil(t, senate.Prepare(chain, header))
+	expected := signers[(header.Time-genesis.Time)/config.Period%2]
+	assert.Equal(t, expected, header.Coinbase)
+
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	assert.Nil(t, err)
+	block, err := senate.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+	assert.Nil(t, err)
+	results := make(chan *types.Block, 1)
+	assert.Nil(t, senate.Seal(chain, block, results, nil))
+	select {
+	case sealed := <-results:
+		signer, err := senate.Author(sealed.Header())
+		assert.Nil(t, err)
+		assert.Equal(t, expected, signer)
+	case <-time.After(5 * time.Second):
+		t.Fatal("block not sealed")
+	}
+}
+
+func TestSealStale(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 2)
+	signers := make([]common.Address, len(keys))
+	signFns := make([]SignerFn, len(keys))
+	for i := range keys {
+		key, _ := crypto.GenerateKey()
+		keys[i], signers[i] = key, crypto.PubkeyToAddress(key.PublicKey)
+		signFns[i] = func(account accounts.Account, s string, data []byte) ([]byte, error) {
+			return crypto.Sign(crypto.Keccak256(data), key)
+		}
+	}
+
+	// The clock is in the middle of slot 100, which is the one of signers[0]
+	period := uint64(30)
+	genesis := &types.Header{Number: big.NewInt(0), Time: uint64(time.Now().Unix()) - 100*period - period/2}
+	config := params.SenateConfig{
+		Period:              period,
+		Epoch:               1000 * period,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		GenesisTimestamp:    genesis.Time,
+		Validators:          signers,
+	}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	senate.Authorize(signers[0], signFns[0])
+	senate.AuthorizeFallback(signers[1], signFns[1])
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	seal := func(slot uint64) *types.Block {
+		block := types.NewBlockWithHeader(&types.Header{
+			Number:     big.NewInt(1),
+			ParentHash: genesis.Hash(),
+			Time:       genesis.Time + slot*period,
+			Coinbase:   signers[slot%2],
+			Extra:      make([]byte, extraVanity+extraSeal),
+		})
+		results := make(chan *types.Block, 1)
+		assert.Nil(t, senate.Seal(chain, block, results, nil))
+		select {
+		case sealed := <-results:
+			return sealed
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+
+	// The block of the current slot is propagated right away
+	assert.NotNil(t, seal(100))
+
+	// The block delayed into the slot of another validator is dropped
+	assert.Nil(t, seal(99))
+	assert.True(t, senate.staleSeal(chain, &types.Header{
+		Number:     big.NewInt(1),
+		ParentHash: genesis.Hash(),
+		Time:       genesis.Time + 99*period,
+		Coinbase:   signers[1],
+	}, time.Unix(int64(genesis.Time+100*period), 0)))
+	assert.False(t, senate.staleSeal(chain, &types.Header{
+		Number:     big.NewInt(1),
+		ParentHash: genesis.Hash(),
+		Time:       genesis.Time + 99*period,
+		Coinbase:   signers[1],
+	}, time.Unix(int64(genesis.Time+100*period-1), 0)))
+}
+
+func TestVerifySealRecentlySigned(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	keys := make([]*ecdsa.PrivateKey, 4)
+	validators := make(SortableAddresses, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		validators[i] = SortableAddress{Address: crypto.PubkeyToAddress(keys[i].PublicKey), Weight: big.NewInt(0)}
+	}
+	config := params.SenateConfig{Period: 1, Epoch: 100, NoTurnDelay: 1, RecentSigners: true}
+	for _, validator := range validators {
+		config.Validators = append(config.Validators, validator.Address)
+	}
+	senate := New(&config, nil, db)
+
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.SetValidators(validators))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	// Sign blocks round-robin, each validator has to wait 2 blocks to sign again
+	parent := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	sign := func(number uint64, key *ecdsa.PrivateKey, headerExtra HeaderExtra) *types.Header {
+		header := newTestHeader(t, number, parent.Hash(), headerExtra)
+		header.Time = 100 + number
+		header.Coinbase = crypto.PubkeyToAddress(key.PublicKey)
+		header.Difficulty = senate.turnDifficulty(config, parent, header.Time, header.Coinbase)
+		sig, err := crypto.Sign(SealHash(header, nil, nil).Bytes(), key)
+		assert.Nil(t, err)
+		copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+		return header
+	}
+	for number, idx := range []int{0, 1, 2, 3, 0, 1} {
+		number := uint64(number + 1)
+		header := sign(number, keys[idx], HeaderExtra{Root: root, Epoch: 1, EpochTime: 100})
+		assert.Nil(t, senate.verifySeal(config, header, parent))
+
+		// An early repeat of the last signer is rejected
+		if number > 1 {
+			header := sign(number, keys[(idx+3)%4], HeaderExtra{Root: root, Epoch: 1, EpochTime: 100})
+			assert.Equal(t, errRecentlySigned, senate.verifySeal(config, header, parent))
+
+			// The window is reset in a new epoch
+			header = sign(number, keys[(idx+3)%4], HeaderExtra{Root: root, Epoch: 2, EpochTime: 100 + number})
+			assert.Nil(t, senate.verifySeal(config, header, parent))
+		}
+
+		assert.Nil(t, snap.MintBlock(1, number, validators[idx].Address))
+		root, err = snap.Root()
+		assert.Nil(t, err)
+		assert.Nil(t, snap.Commit(root))
+		parent = newTestHeader(t, number, parent.Hash(), HeaderExtra{Root: root, Epoch: 1, EpochTime: 100})
+		parent.Time = 100 + number
+	}
+}
+
+// abortingChainReader runs the hook on each header lookup.
+type abortingChainReader struct {
+	*testChainReader
+	lookups int
+	hook    func()
+}
+
+func (chain *abortingChainReader) GetHeader(hash common.Hash, number uint64) *types.Header {
+	chain.lookups++
+	chain.hook()
+	return chain.testChainReader.GetHeader(hash, number)
+}
+
+func TestVerifyHeaderFutureDrift(t *testing.T) {
+	config := params.SenateConfig{Period: 1, AllowedFutureDrift: 15}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	chain := &testChainReader{headers: []*types.Header{{Number: big.NewInt(0)}}}
+	verify := func(ahead time.Duration) error {
+		header := &types.Header{Number: big.NewInt(1), Time: uint64(time.Now().Add(ahead).Unix())}
+		return senate.verifyHeader(context.Background(), chain, header, nil)
+	}
+
+	// Headers within the drift pass on to the next checks
+	assert.Equal(t, errMissingVanity, verify(time.Second))
+	assert.Equal(t, consensus.ErrFutureBlock, verify(30*time.Second))
+
+	// Without a configured drift the default one applies
+	config.AllowedFutureDrift = 0
+	assert.Equal(t, errMissingVanity, verify(time.Second))
+	assert.Equal(t, consensus.ErrFutureBlock, verify(futureDrift+2*time.Second))
+}
+
+func TestVerifyHeaderMaxExtraSize(t *testing.T) {
+	config := params.SenateConfig{Period: 1, MaxExtraSize: uint64(extraVanity + extraSeal + 16)}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	verify := func(size int) error {
+		header := &types.Header{Number: big.NewInt(1), Extra: make([]byte, size), MixDigest: common.Hash{0x01}}
+		return senate.verifyHeaderFields(header)
+	}
+
+	// Extra-data within the max size passes on to the next checks
+	assert.Equal(t, errInvalidMixDigest, verify(extraVanity+extraSeal+16))
+	assert.True(t, errors.Is(verify(extraVanity+extraSeal+17), errExtraTooLong))
+
+	// Without a configured max size any length is accepted
+	config.MaxExtraSize = 0
+	assert.Equal(t, errInvalidMixDigest, verify(4096))
+}
+
+func TestVerifyHeadersAbort(t *testing.T) {
+	config := params.SenateConfig{Period: 1, Validators: []common.Address{testUserAddress}}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	genesis := &types.Header{Number: big.NewInt(0), Time: 1600000000}
+	headers := make([]*types.Header, 3)
+	parent := genesis
+	for i := range headers {
+		headers[i] = &types.Header{
+			ParentHash: parent.Hash(),
+			UncleHash:  uncleHash,
+			Number:     big.NewInt(int64(i + 1)),
+			Time:       parent.Time + 1,
+			Extra:      make([]byte, extraVanity+extraSeal),
+		}
+		parent = headers[i]
+	}
+
+	goroutines := runtime.NumGoroutine()
+	var abort chan<- struct{}
+	ready := make(chan struct{})
+	chain := &abortingChainReader{testChainReader: &testChainReader{headers: []*types.Header{genesis}}}
+	chain.hook = func() {
+		<-ready
+		close(abort)
+	}
+	abort, results := senate.VerifyHeaders(chain, headers, nil)
+	close(ready)
+
+	// The worker stops once aborted in the middle of the first header
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutines; {
+		if time.Now().After(deadline) {
+			t.Fatal("verification goroutines leaked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 1, chain.lookups)
+	assert.Len(t, results, 0)
+}
+
+func TestClose(t *testing.T) {
+	config := params.SenateConfig{Period: 1, Validators: []common.Address{testUserAddress}}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	genesis := &types.Header{Number: big.NewInt(0), Time: 1600000000}
+	headers := make([]*types.Header, 3)
+	parent := genesis
+	for i := range headers {
+		headers[i] = &types.Header{
+			ParentHash: parent.Hash(),
+			UncleHash:  uncleHash,
+			Number:     big.NewInt(int64(i + 1)),
+			Time:       parent.Time + 1,
+			Extra:      make([]byte, extraVanity+extraSeal),
+		}
+		parent = headers[i]
+	}
+
+	// Close concurrently in the middle of the first header
+	goroutines := runtime.NumGoroutine()
+	closed := make(chan error, 2)
+	chain := &abortingChainReader{testChainReader: &testChainReader{headers: []*types.Header{genesis}}}
+	chain.hook = func() {
+		for i := 0; i < cap(closed); i++ {
+			go func() { closed <- senate.Close() }()
+		}
+		for {
+			senate.closeLock.Lock()
+			done := senate.closed
+			senate.closeLock.Unlock()
+			if done {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	_, results := senate.VerifyHeaders(chain, headers, nil)
+	for i := 0; i < cap(closed); i++ {
+		assert.Nil(t, <-closed)
+	}
+
+	// Close returns after the verification goroutines exit
+	assert.Len(t, results, len(headers))
+	assert.NotNil(t, <-results)
+	for range headers[1:] {
+		assert.Equal(t, context.Canceled, <-results)
+	}
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutines; {
+		if time.Now().After(deadline) {
+			t.Fatal("goroutines leaked after close")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 1, chain.lookups)
+
+	// Nothing is verified once closed
+	assert.Nil(t, senate.Close())
+	_, results = senate.VerifyHeaders(chain, headers, nil)
+	for range headers {
+		assert.Equal(t, errEngineClosed, <-results)
+	}
+	assert.Equal(t, goroutines, runtime.NumGoroutine())
+}
+
+func TestSealClose(t *testing.T) {
+	// The slot of the block is an hour ahead, so sealing waits for it
+	genesis := &types.Header{Number: big.NewInt(0), Time: uint64(time.Now().Unix()) + 3600}
+	config := params.SenateConfig{
+		Period:              1,
+		Epoch:               100,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		GenesisTimestamp:    genesis.Time,
+		Validators:          []common.Address{testUserAddress},
+	}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	senate.Authorize(testUserAddress, func(account accounts.Account, s string, data []byte) ([]byte, error) {
+		return crypto.Sign(crypto.Keccak256(data), testUserKey)
+	})
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	header := &types.Header{Number: big.NewInt(1), ParentHash: genesis.Hash(), Coinbase: testUserAddress}
+	assert.Nil(t, senate.Prepare(chain, header))
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	assert.Nil(t, err)
+	block, err := senate.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+	assert.Nil(t, err)
+
+	goroutines := runtime.NumGoroutine()
+	results := make(chan *types.Block, 1)
+	assert.Nil(t, senate.Seal(chain, block, results, make(chan struct{})))
+	assert.True(t, runtime.NumGoroutine() > goroutines)
+
+	// Tearing down the engine mid-delay stops the sealing goroutine at once,
+	// although the per-call stop channel is never closed
+	closed := make(chan error, 1)
+	go func() { closed <- senate.Close() }()
+	select {
+	case err := <-closed:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("close blocked by the sealing goroutine")
+	}
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutines; {
+		if time.Now().After(deadline) {
+			t.Fatal("sealing goroutine leaked after close")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Len(t, results, 0)
+
+	// Nothing is sealed once closed
+	assert.Equal(t, errEngineClosed, senate.Seal(chain, block, results, make(chan struct{})))
+}
+
+// newSignedTestChain assembles n blocks on genesis sealed by key, the headers
+// are ahead of the local clock so the config must allow the drift.
+func newSignedTestChain(tb testing.TB, config params.SenateConfig, n int, key func(number uint64) *ecdsa.PrivateKey) []*types.Header {
+	return assembleSignedTestChain(tb, config, newTestGenesis(), n, 0, key)
+}
+
+// newSignedTestFork assembles two chains like newSignedTestChain on the same
+// genesis, the blocks of the second one after fork are shifted past the slots
+// of the first one, so no slot is signed twice.
+func newSignedTestFork(tb testing.TB, config params.SenateConfig, n int, fork uint64, key func(number uint64) *ecdsa.PrivateKey) ([]*types.Header, []*types.Header) {
+	genesis := newTestGenesis()
+	return assembleSignedTestChain(tb, config, genesis, n, 0, key), assembleSignedTestChain(tb, config, genesis, n, fork, key)
+}
+
+// newTestGenesis creates a genesis header at the local time.
+func newTestGenesis() *types.Header {
+	return &types.Header{
+		Number:   big.NewInt(0),
+		Time:     uint64(time.Now().Unix()),
+		GasLimit: params.GenesisGasLimit,
+		BaseFee:  big.NewInt(params.InitialBaseFee),
+	}
+}
+
+// assembleSignedTestChain assembles n blocks on genesis sealed by key, the
+// blocks after fork are shifted by n slots unless fork is 0.
+func assembleSignedTestChain(tb testing.TB, config params.SenateConfig, genesis *types.Header, n int, fork uint64,
+	key func(number uint64) *ecdsa.PrivateKey) []*types.Header {
+
+	miner := New(&config, nil, rawdb.NewMemoryDatabase())
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	for number := uint64(1); number <= uint64(n); number++ {
+		parent := chain.CurrentHeader()
+		header := &types.Header{Number: new(big.Int).SetUint64(number), ParentHash: parent.Hash(), GasLimit: parent.GasLimit, UncleHash: uncleHash}
+		if err := miner.Prepare(chain, header); err != nil {
+			tb.Fatal(err)
+		}
+		if fork > 0 && number == fork+1 {
+			header.Time += uint64(n) * config.Period
+		}
+		header.Coinbase = crypto.PubkeyToAddress(key(number).PublicKey)
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		block, err := miner.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		header = block.Header()
+		sig, err := crypto.Sign(SealHash(header, nil, nil).Bytes(), key(number))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+		chain.headers = append(chain.headers, header)
+	}
+	return chain.headers
+}
+
+func TestVerifyHeadersWorkers(t *testing.T) {
+	config := params.SenateConfig{
+		Period:              1,
+		Epoch:               10,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+		AllowedFutureDrift:  3600,
+	}
+	otherKey, _ := crypto.GenerateKey()
+	headers := newSignedTestChain(t, config, 40, func(number uint64) *ecdsa.PrivateKey {
+		if number == 30 {
+			return otherKey
+		}
+		return testUserKey
+	})
+
+	// Results keep the order of headers whatever the number of workers
+	for _, workers := range []uint64{1, 4, 64} {
+		senate := NewWithOptions(&config, nil, rawdb.NewMemoryDatabase(), Options{VerifyWorkers: workers})
+		_, results := senate.VerifyHeaders(&testChainReader{headers: headers[:1]}, headers[1:], nil)
+		for number := 1; number < 30; number++ {
+			assert.Nil(t, <-results)
+		}
+		assert.Equal(t, errUnauthorized, <-results)
+		for number := 31; number < len(headers); number++ {
+			assert.NotNil(t, <-results)
+		}
+	}
+}
+
+func TestVerifyHeadersOutOfOrder(t *testing.T) {
+	config := params.SenateConfig{
+		Period:              1,
+		Epoch:               10,
+		EpochBlocks:         10,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+		AllowedFutureDrift:  3600,
+	}
+	headers := newSignedTestChain(t, config, 30, func(uint64) *ecdsa.PrivateKey { return testUserKey })
+
+	// Every header verifies once its parent arrives, results keep the order of
+	// the shuffled batch
+	shuffled := append([]*types.Header(nil), headers[1:]...)
+	rand.New(rand.NewSource(1)).Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	_, results := senate.VerifyHeaders(&testChainReader{headers: headers[:1]}, shuffled, nil)
+	for i := range shuffled {
+		assert.Nil(t, <-results, "number %d", shuffled[i].Number)
+	}
+
+	// Headers whose parent never arrives are unknown ancestors
+	senate = New(&config, nil, rawdb.NewMemoryDatabase())
+	_, results = senate.VerifyHeaders(&testChainReader{headers: headers[:1]}, []*types.Header{headers[3], headers[1]}, nil)
+	assert.Equal(t, consensus.ErrUnknownAncestor, <-results)
+	assert.Nil(t, <-results)
+
+	// The earliest buffered headers are evicted once the buffer is full
+	buffer := newHeaderBuffer(2)
+	assert.Nil(t, buffer.add(2, headers[1].Hash()))
+	assert.Nil(t, buffer.add(3, headers[2].Hash()))
+	assert.Equal(t, []int{2}, buffer.add(4, common.HexToHash("0x01")))
+	assert.Nil(t, buffer.take(headers[1].Hash()))
+	assert.Equal(t, []int{3}, buffer.take(headers[2].Hash()))
+	assert.Equal(t, []int{4}, buffer.rest())
+}
+
+func TestVerifyHeadersCheckpoint(t *testing.T) {
+	config := params.SenateConfig{
+		Period:              1,
+		Epoch:               10,
+		EpochBlocks:         10,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+		AllowedFutureDrift:  3600,
+	}
+	headers := newSignedTestChain(t, config, 30, func(uint64) *ecdsa.PrivateKey { return testUserKey })
+	first, err := decodeHeaderExtra(headers[21])
+	assert.Nil(t, err)
+	parent, err := decodeHeaderExtra(headers[20])
+	assert.Nil(t, err)
+	assert.NotEqual(t, parent.Epoch, first.Epoch)
+
+	// A chain agreeing with the checkpoint is imported
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	senate.AddCheckpoint(first.Epoch, headers[21].Hash())
+	_, results := senate.VerifyHeaders(&testChainReader{headers: headers[:1]}, headers[1:], nil)
+	for number := 1; number < len(headers); number++ {
+		assert.Nil(t, <-results)
+	}
+
+	// A chain rewriting the finalized epoch is rejected from its first block
+	senate = New(&config, nil, rawdb.NewMemoryDatabase())
+	senate.AddCheckpoint(first.Epoch, common.HexToHash("0x01"))
+	_, results = senate.VerifyHeaders(&testChainReader{headers: headers[:1]}, headers[1:], nil)
+	for number := 1; number < 21; number++ {
+		assert.Nil(t, <-results)
+	}
+	assert.True(t, errors.Is(<-results, errCheckpointMismatch))
+	for number := 22; number < len(headers); number++ {
+		assert.NotNil(t, <-results)
+	}
+}
+
+// testHeadChain posts the canonical heads of a testChainReader, the headers
+// of the side chain are known as well.
+type testHeadChain struct {
+	*testChainReader
+	side  []*types.Header
+	heads event.Feed
+}
+
+func (chain *testHeadChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := chain.testChainReader.GetHeader(hash, number); header != nil {
+		return header
+	}
+	if number < uint64(len(chain.side)) && chain.side[number].Hash() == hash {
+		return chain.side[number]
+	}
+	return nil
+}
+
+func (chain *testHeadChain) SubscribeChainHead(ch chan<- *types.Header) event.Subscription {
+	return chain.heads.Subscribe(ch)
+}
+
+func TestSubscribeReorg(t *testing.T) {
+	config := params.SenateConfig{
+		Period:              1,
+		Epoch:               100,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+		AllowedFutureDrift:  3600,
+	}
+	headers, side := newSignedTestFork(t, config, 10, 5, func(uint64) *ecdsa.PrivateKey { return testUserKey })
+	assert.Equal(t, headers[5].Hash(), side[5].Hash())
+	assert.NotEqual(t, headers[6].Hash(), side[6].Hash())
+
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	defer senate.Close()
+	reorgs := make(chan ReorgEvent, 10)
+	sub := senate.SubscribeReorg(reorgs)
+	defer sub.Unsubscribe()
+	chain := &testHeadChain{testChainReader: &testChainReader{headers: headers}, side: side}
+	senate.WatchChainHead(chain)
+
+	// Verifying the headers of either chain is no reorg
+	_, results := senate.VerifyHeaders(&testChainReader{headers: headers[:1]}, headers[1:], nil)
+	for range headers[1:] {
+		assert.Nil(t, <-results)
+	}
+	_, results = senate.VerifyHeaders(chain, side[6:], nil)
+	for range side[6:] {
+		assert.Nil(t, <-results)
+	}
+	chain.heads.Send(headers[10])
+
+	// The side chain becoming canonical replaces the blocks after the fork
+	chain.heads.Send(side[10])
+	select {
+	case reorg := <-reorgs:
+		assert.Equal(t, headers[5].Hash(), reorg.Ancestor.Hash())
+		assert.Equal(t, headers[10].Hash(), reorg.OldHead.Hash())
+		assert.Equal(t, side[10].Hash(), reorg.NewHead.Hash())
+	case <-time.After(time.Second):
+		t.Fatal("reorg not posted")
+	}
+	assert.Empty(t, reorgs)
+}
+
+func TestEpochStats(t *testing.T) {
+	config := params.SenateConfig{
+		Period:              1,
+		Epoch:               5,
+		EpochBlocks:         5,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+		InitialStakes:       []*big.Int{big.NewInt(1000)},
+		InitialReward:       big.NewInt(100),
+		HalvingInterval:     4,
+		AllowedFutureDrift:  3600,
+		EpochStats:          true,
+	}
+	headers := newSignedTestChain(t, config, 12, func(uint64) *ecdsa.PrivateKey { return testUserKey })
+
+	// The stats are part of the roots checked by verifiers
+	db := rawdb.NewMemoryDatabase()
+	senate := New(&config, nil, db)
+	_, results := senate.VerifyHeaders(&testChainReader{headers: headers[:1]}, headers[1:], nil)
+	for number := 1; number < len(headers); number++ {
+		assert.Nil(t, <-results)
+	}
+
+	// Aggregate the blocks of each epoch independently
+	blocks := make(map[uint64]uint64)
+	rewards := make(map[uint64]*big.Int)
+	for _, header := range headers[1:] {
+		headerExtra, err := decodeHeaderExtra(header)
+		assert.Nil(t, err)
+		if rewards[headerExtra.Epoch] == nil {
+			rewards[headerExtra.Epoch] = new(big.Int)
+		}
+		blocks[headerExtra.Epoch]++
+		rewards[headerExtra.Epoch].Add(rewards[headerExtra.Epoch], blockReward(config, header.Number.Uint64()))
+	}
+	assert.Equal(t, 3, len(blocks))
+
+	last, err := decodeHeaderExtra(headers[len(headers)-1])
+	assert.Nil(t, err)
+	snap, err := loadSnapshot(db, last.Root)
+	assert.Nil(t, err)
+	for epoch := uint64(1); epoch <= last.Epoch; epoch++ {
+		participation := uint64(100)
+		if epoch == last.Epoch {
+			participation = 0
+		}
+		stats, err := snap.GetEpochStats(epoch)
+		assert.Nil(t, err)
+		assert.Equal(t, &EpochStats{
+			Epoch:         epoch,
+			Validators:    1,
+			TotalStake:    big.NewInt(1000),
+			Blocks:        blocks[epoch],
+			Rewards:       rewards[epoch],
+			Participation: participation,
+		}, stats)
+	}
+
+	// Nothing is recorded unless enabled
+	config.EpochStats = false
+	headers = newSignedTestChain(t, config, 6, func(uint64) *ecdsa.PrivateKey { return testUserKey })
+	last, err = decodeHeaderExtra(headers[len(headers)-1])
+	assert.Nil(t, err)
+	assert.Equal(t, common.Hash{}, last.Root.StatsHash)
+}
+
+// flakyDatabase fails the first reads of a key like a database hitting a
+// transient IO error.
+type flakyDatabase struct {
+	ethdb.Database
+	key      []byte
+	failures int
+}
+
+func (db *flakyDatabase) Get(key []byte) ([]byte, error) {
+	if db.failures > 0 && bytes.Equal(key, db.key) {
+		db.failures--
+		return nil, io.ErrUnexpectedEOF
+	}
+	return db.Database.Get(key)
+}
+
+func TestVerifyHeadersRetryRead(t *testing.T) {
+	config := params.SenateConfig{
+		Period:              1,
+		Epoch:               10,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+		AllowedFutureDrift:  3600,
+	}
+	headers := newSignedTestChain(t, config, 4, func(number uint64) *ecdsa.PrivateKey {
+		return testUserKey
+	})
+	configHash := mustDecodeHeaderExtra(t, headers[1]).Root.ConfigHash
+	assert.NotEqual(t, common.Hash{}, configHash)
+
+	// The config of block 1 is read once its snapshot is committed, the
+	// failure surfaces without retries
+	db := &flakyDatabase{Database: rawdb.NewMemoryDatabase(), key: configHash.Bytes(), failures: 1}
+	senate := New(&config, nil, db)
+	_, results := senate.VerifyHeaders(&testChainReader{headers: headers[:1]}, headers[1:], nil)
+	assert.Nil(t, <-results)
+	err := <-results
+	assert.True(t, errors.Is(err, errSnapshotRead))
+	assert.True(t, errors.Is(err, ErrChainConfigMissing))
+
+	// The batch resumes if the read succeeds on the second attempt
+	db = &flakyDatabase{Database: rawdb.NewMemoryDatabase(), key: configHash.Bytes(), failures: 1}
+	senate = NewWithOptions(&config, nil, db, Options{ReadRetries: 2})
+	_, results = senate.VerifyHeaders(&testChainReader{headers: headers[:1]}, headers[1:], nil)
+	for range headers[1:] {
+		assert.Nil(t, <-results)
+	}
+	assert.Equal(t, 0, db.failures)
+
+	// Invalid blocks aren't retried
+	assert.False(t, isTransientError(errInvalidTrieRoot))
+	assert.False(t, isTransientError(nil))
+}
+
+func BenchmarkVerifyHeaders(b *testing.B) {
+	config := params.SenateConfig{
+		Period:              1,
+		Epoch:               100,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+		AllowedFutureDrift:  86400,
+	}
+	headers := newSignedTestChain(b, config, 10000, func(uint64) *ecdsa.PrivateKey { return testUserKey })
+	chain := &testChainReader{headers: headers[:1]}
+
+	for _, bench := range []struct {
+		name    string
+		workers uint64
+	}{{"sequential", 1}, {"parallel", 0}} {
+		options := Options{VerifyWorkers: bench.workers}
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				senate := NewWithOptions(&config, nil, rawdb.NewMemoryDatabase(), options)
+				_, results := senate.VerifyHeaders(chain, headers[1:], nil)
+				for range headers[1:] {
+					if err := <-results; err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyCascadingFieldsErrors(t *testing.T) {
+	config := params.SenateConfig{Period: 1, Validators: []common.Address{testUserAddress}}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	genesis := &types.Header{Number: big.NewInt(0), Time: 1600000000, GasLimit: params.GenesisGasLimit}
+	header := newTestHeader(t, 1, genesis.Hash(), HeaderExtra{
+		Root:      Root{EpochHash: common.HexToHash("0x01")},
+		Epoch:     1,
+		EpochTime: genesis.Time + 1,
+	})
+	header.Time = genesis.Time + 1
+	header.GasLimit = genesis.GasLimit
+	sealTestHeader(t, header)
+
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	err := senate.verifyCascadingFields(context.Background(), chain, header, nil)
+	assert.True(t, errors.Is(err, errInvalidTrieRoot))
+
+	// Commit failures are distinguishable and keep the database error
+	err = &snapshotCommitError{err: io.ErrUnexpectedEOF}
+	assert.True(t, errors.Is(err, errSnapshotCommit))
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	assert.False(t, errors.Is(err, errInvalidTrieRoot))
+}
+
+func TestVerifyCascadingFieldsNumber(t *testing.T) {
+	config := params.SenateConfig{Period: 1, Validators: []common.Address{testUserAddress}}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	genesis := &types.Header{Number: big.NewInt(0), Time: 1600000000, GasLimit: params.GenesisGasLimit}
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+
+	// The header skips ahead of its parent, either looked up or in the batch
+	header := newTestHeader(t, 3, genesis.Hash(), HeaderExtra{Epoch: 1, EpochTime: genesis.Time + 1})
+	header.Time = genesis.Time + 1
+	header.GasLimit = genesis.GasLimit
+	assert.Equal(t, errInvalidNumber, senate.verifyCascadingFields(context.Background(), chain, header, nil))
+	assert.Equal(t, errInvalidNumber, senate.verifyCascadingFields(context.Background(), chain, header, []*types.Header{genesis}))
+
+	// A header going back behind its parent
+	parent := newTestHeader(t, 5, genesis.Hash(), HeaderExtra{})
+	chain.headers = append(chain.headers, parent)
+	header = newTestHeader(t, 2, parent.Hash(), HeaderExtra{})
+	assert.Equal(t, errInvalidNumber, senate.verifyCascadingFields(context.Background(), chain, header, nil))
+
+	// An unknown parent is still reported as such
+	header = newTestHeader(t, 1, common.HexToHash("0x01"), HeaderExtra{})
+	assert.Equal(t, consensus.ErrUnknownAncestor, senate.verifyCascadingFields(context.Background(), chain, header, nil))
+}
+
+func TestVerifyGasLimit(t *testing.T) {
+	parent := &types.Header{GasLimit: 1024 * 10000}
+	bound := parent.GasLimit / params.GasLimitBoundDivisor
+	tests := []struct {
+		gasLimit uint64
+		valid    bool
+	}{
+		{parent.GasLimit, true},
+		{parent.GasLimit + bound - 1, true},
+		{parent.GasLimit - bound + 1, true},
+		{parent.GasLimit + bound, false},
+		{parent.GasLimit - bound, false},
+		{params.MaxGasLimit + 1, false},
+	}
+	for _, test := range tests {
+		err := verifyGasLimit(&types.Header{GasLimit: test.gasLimit}, parent)
+		assert.Equal(t, test.valid, err == nil)
+		if !test.valid {
+			assert.True(t, errors.Is(err, errInvalidGasLimit))
+		}
+	}
+
+	// The gas limit never goes below the minimum
+	parent.GasLimit = params.MinGasLimit
+	assert.True(t, errors.Is(verifyGasLimit(&types.Header{GasLimit: params.MinGasLimit - 1}, parent), errInvalidGasLimit))
+}
+
+func TestVerifyEpoch(t *testing.T) {
+	parentHeaderExtra := HeaderExtra{Epoch: 3, EpochTime: 1600000100}
+	header := &types.Header{Time: 1600000200}
+	tests := []struct {
+		headerExtra HeaderExtra
+		valid       bool
+	}{
+		// Continuation of the parent epoch
+		{HeaderExtra{Epoch: 3, EpochTime: 1600000100}, true},
+		// New epoch starting at the block
+		{HeaderExtra{Epoch: 4, EpochTime: 1600000200}, true},
+		// Regressed epoch time
+		{HeaderExtra{Epoch: 3, EpochTime: 1600000050}, false},
+		{HeaderExtra{Epoch: 4, EpochTime: 1600000050}, false},
+		// New epoch not starting at the block
+		{HeaderExtra{Epoch: 4, EpochTime: 1600000150}, false},
+		{HeaderExtra{Epoch: 4, EpochTime: 1600000100}, false},
+		// Epoch skipped or regressed
+		{HeaderExtra{Epoch: 5, EpochTime: 1600000200}, false},
+		{HeaderExtra{Epoch: 2, EpochTime: 1600000100}, false},
+	}
+	for _, test := range tests {
+		err := verifyEpoch(header, test.headerExtra, parentHeaderExtra)
+		assert.Equal(t, test.valid, err == nil)
+		if !test.valid {
+			assert.True(t, errors.Is(err, errInvalidEpoch))
+		}
+	}
+
+	// The first block always starts the first epoch
+	assert.Nil(t, verifyEpoch(header, HeaderExtra{Epoch: 1, EpochTime: header.Time}, HeaderExtra{}))
+	assert.True(t, errors.Is(verifyEpoch(header, HeaderExtra{}, HeaderExtra{}), errInvalidEpoch))
+	assert.True(t, errors.Is(verifyEpoch(header, HeaderExtra{Epoch: 1, EpochTime: header.Time - 1}, HeaderExtra{}), errInvalidEpoch))
+}
+
+func TestVerifyEmptyBlockPeriod(t *testing.T) {
+	config := params.SenateConfig{Period: 1, MinEmptyBlockPeriod: 5, Validators: []common.Address{testUserAddress}}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	genesis := &types.Header{Number: big.NewInt(0), Time: 1600000000, GasLimit: params.GenesisGasLimit}
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	newHeader := func(txHash common.Hash, time uint64) *types.Header {
+		header := newTestHeader(t, 1, genesis.Hash(), HeaderExtra{
+			Root:      Root{EpochHash: common.HexToHash("0x01")},
+			Epoch:     1,
+			EpochTime: time,
+		})
+		header.TxHash = txHash
+		header.Time = time
+		header.GasLimit = genesis.GasLimit
+		sealTestHeader(t, header)
+		return header
+	}
+
+	// Blocks with transactions only keep the period
+	assert.Equal(t, uint64(1), blockPeriod(config, newHeader(common.HexToHash("0x01"), 0)))
+	err := senate.verifyCascadingFields(context.Background(), chain, newHeader(common.HexToHash("0x01"), genesis.Time+1), nil)
+	assert.Equal(t, errInvalidTrieRoot, err)
+
+	// Empty blocks wait for the empty block period
+	assert.Equal(t, uint64(5), blockPeriod(config, newHeader(types.EmptyRootHash, 0)))
+	err = senate.verifyCascadingFields(context.Background(), chain, newHeader(types.EmptyRootHash, genesis.Time+4), nil)
+	assert.Equal(t, ErrInvalidTimestamp, err)
+	err = senate.verifyCascadingFields(context.Background(), chain, newHeader(types.EmptyRootHash, genesis.Time+5), nil)
+	assert.Equal(t, errInvalidTrieRoot, err)
+
+	// Disabled if not longer than the period
+	config.MinEmptyBlockPeriod = 1
+	assert.Equal(t, uint64(1), blockPeriod(config, newHeader(types.EmptyRootHash, 0)))
+}
+
+func TestVerifyBaseFee(t *testing.T) {
+	chainConfig := *params.AllCliqueProtocolChanges
+	chainConfig.LondonBlock = big.NewInt(2)
+
+	// The first London block starts from the initial base fee
+	parent := &types.Header{Number: big.NewInt(1), GasLimit: 10000000}
+	assert.Equal(t, big.NewInt(params.InitialBaseFee), misc.CalcBaseFee(&chainConfig, parent))
+
+	// The base fee moves up to 1/8 towards the gas target of the parent
+	parent = &types.Header{Number: big.NewInt(2), GasLimit: 10000000, BaseFee: big.NewInt(params.InitialBaseFee)}
+	tests := []struct {
+		gasUsed uint64
+		baseFee int64
+	}{
+		{10000000, 1125000000}, // congested
+		{7500000, 1062500000},
+		{5000000, 1000000000}, // on target
+		{2500000, 937500000},
+		{0, 875000000}, // idle
+	}
+	for _, test := range tests {
+		parent.GasUsed = test.gasUsed
+		header := &types.Header{Number: big.NewInt(3), BaseFee: big.NewInt(test.baseFee)}
+		assert.Equal(t, big.NewInt(test.baseFee), misc.CalcBaseFee(&chainConfig, parent))
+		assert.Nil(t, verifyBaseFee(&chainConfig, header, parent))
+
+		header.BaseFee = big.NewInt(test.baseFee + 1)
+		assert.True(t, errors.Is(verifyBaseFee(&chainConfig, header, parent), errInvalidBaseFee))
+	}
+
+	// The base fee is required since the fork only
+	assert.True(t, errors.Is(verifyBaseFee(&chainConfig, &types.Header{Number: big.NewInt(3)}, parent), errInvalidBaseFee))
+	header := &types.Header{Number: big.NewInt(1), BaseFee: big.NewInt(params.InitialBaseFee)}
+	assert.True(t, errors.Is(verifyBaseFee(&chainConfig, header, parent), errInvalidBaseFee))
+	header.BaseFee = nil
+	assert.Nil(t, verifyBaseFee(&chainConfig, header, parent))
+}
+
+func TestBaseFeeBurn(t *testing.T) {
+	chainConfig := *params.AllCliqueProtocolChanges
+	chainConfig.LondonBlock = big.NewInt(1)
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               20,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+	}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	genesis := &types.Header{Number: big.NewInt(0), GasLimit: 10000000}
+	chain := &testChainReader{headers: []*types.Header{genesis}, config: &chainConfig}
+
+	header := &types.Header{Number: big.NewInt(1), ParentHash: genesis.Hash(), Coinbase: testUserAddress, GasLimit: 10000000}
+	assert.Nil(t, senate.Prepare(chain, header))
+	assert.Equal(t, big.NewInt(params.InitialBaseFee), header.BaseFee)
+
+	// The base fee of consumed gas is taken back from the fees of coinbase
+	balance := big.NewInt(1e18)
+	header.GasUsed = 21000
+	statedbs := make([]*state.StateDB, 2)
+	for i := range statedbs {
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		assert.Nil(t, err)
+		statedb.SetBalance(testUserAddress, balance)
+		statedbs[i] = statedb
+	}
+	block, err := senate.FinalizeAndAssemble(chain, header, statedbs[0], nil, nil, nil)
+	assert.Nil(t, err)
+	burnt := new(big.Int).Mul(header.BaseFee, big.NewInt(21000))
+	assert.Equal(t, new(big.Int).Sub(balance, burnt), statedbs[0].GetBalance(testUserAddress))
+
+	// Verifying nodes burn the same amount
+	verified := block.Header()
+	senate.Finalize(chain, verified, statedbs[1], nil, nil)
+	assert.Equal(t, block.Root(), verified.Root)
+
+	// The seal hash covers the base fee
+	legacy := types.CopyHeader(verified)
+	legacy.BaseFee = nil
+	assert.NotEqual(t, SealHash(verified, nil, nil), SealHash(legacy, nil, nil))
+}
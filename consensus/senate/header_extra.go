@@ -3,7 +3,11 @@ package senate
 import (
 	"bytes"
 	"compress/gzip"
+	"encoding/json"
+	"fmt"
 	"io"
+	"math/big"
+	"strings"
 
 	"github.com/SecretBlockChain/go-secret/common"
 	"github.com/SecretBlockChain/go-secret/core/types"
@@ -21,6 +25,124 @@ type Root struct {
 	ConfigHash    common.Hash
 	ProposalHash  common.Hash
 	DeclareHash   common.Hash
+	DepositHash   common.Hash
+	RefundHash    common.Hash
+	SignerHash    common.Hash
+	SlashHash     common.Hash
+	UnbondHash    common.Hash
+	StagedHash    common.Hash
+	RewardHash    common.Hash
+	DecayHash     common.Hash
+	NonceHash     common.Hash
+	StatsHash     common.Hash
+	PayoutHash    common.Hash
+	StakeHash     common.Hash
+	BondHash      common.Hash
+}
+
+// rootVersion is the version of the Root encoding, a list of the version and
+// the trie hashes in field order. Roots of version 0 are a plain list of the
+// trie hashes, which grew as tries were added, and are migrated on decoding.
+const rootVersion = 1
+
+// headerExtraVersion is the current encoding version of HeaderExtra. Headers
+// of a newer version may carry events the engine doesn't know and are rejected.
+const headerExtraVersion = 1
+
+// fields returns pointers to the trie hashes in the encoding order.
+func (root *Root) fields() []*common.Hash {
+	return []*common.Hash{
+		&root.EpochHash, &root.DelegateHash, &root.CandidateHash, &root.VoteHash,
+		&root.MintCntHash, &root.ConfigHash, &root.ProposalHash, &root.DeclareHash,
+		&root.DepositHash, &root.RefundHash, &root.SignerHash, &root.SlashHash,
+		&root.UnbondHash, &root.StagedHash, &root.RewardHash, &root.DecayHash,
+		&root.NonceHash, &root.StatsHash, &root.PayoutHash, &root.StakeHash,
+		&root.BondHash,
+	}
+}
+
+// EncodeRLP implements rlp.Encoder, writing the root with the current version.
+func (root Root) EncodeRLP(w io.Writer) error {
+	fields := root.fields()
+	list := make([]interface{}, 0, len(fields)+1)
+	list = append(list, uint64(rootVersion))
+	for _, hash := range fields {
+		list = append(list, *hash)
+	}
+	return rlp.Encode(w, list)
+}
+
+// DecodeRLP implements rlp.Decoder, migrating roots of older versions to the
+// current one. Tries missing from an older root are empty.
+func (root *Root) DecodeRLP(s *rlp.Stream) error {
+	if _, err := s.List(); err != nil {
+		return err
+	}
+
+	// Version 0 starts with the first trie hash instead of the version
+	kind, size, err := s.Kind()
+	if err != nil && err != rlp.EOL {
+		return err
+	}
+	if err == nil && !(kind == rlp.String && size == common.HashLength) {
+		version, err := s.Uint()
+		if err != nil {
+			return err
+		}
+		if version > rootVersion {
+			return fmt.Errorf("%w: version %d, supported %d", errRootVersion, version, rootVersion)
+		}
+	}
+
+	*root = Root{}
+	for _, field := range root.fields() {
+		if err := s.Decode(field); err != nil {
+			if err == rlp.EOL {
+				break
+			}
+			return err
+		}
+	}
+	return s.ListEnd()
+}
+
+// hashes returns the root hashes of all the tries of snapshot.
+func (root Root) hashes() []common.Hash {
+	return []common.Hash{
+		root.EpochHash, root.DelegateHash, root.VoteHash, root.CandidateHash,
+		root.MintCntHash, root.ConfigHash, root.ProposalHash, root.DeclareHash,
+		root.DepositHash, root.RefundHash, root.SignerHash, root.SlashHash,
+		root.UnbondHash, root.StagedHash, root.RewardHash, root.DecayHash,
+		root.NonceHash, root.StatsHash, root.PayoutHash, root.StakeHash,
+		root.BondHash,
+	}
+}
+
+// String returns the root hash of every trie as key=value pairs in field order.
+func (root Root) String() string {
+	return strings.Join([]string{
+		"EpochHash=" + root.EpochHash.Hex(),
+		"DelegateHash=" + root.DelegateHash.Hex(),
+		"CandidateHash=" + root.CandidateHash.Hex(),
+		"VoteHash=" + root.VoteHash.Hex(),
+		"MintCntHash=" + root.MintCntHash.Hex(),
+		"ConfigHash=" + root.ConfigHash.Hex(),
+		"ProposalHash=" + root.ProposalHash.Hex(),
+		"DeclareHash=" + root.DeclareHash.Hex(),
+		"DepositHash=" + root.DepositHash.Hex(),
+		"RefundHash=" + root.RefundHash.Hex(),
+		"SignerHash=" + root.SignerHash.Hex(),
+		"SlashHash=" + root.SlashHash.Hex(),
+		"UnbondHash=" + root.UnbondHash.Hex(),
+		"StagedHash=" + root.StagedHash.Hex(),
+		"RewardHash=" + root.RewardHash.Hex(),
+		"DecayHash=" + root.DecayHash.Hex(),
+		"NonceHash=" + root.NonceHash.Hex(),
+		"StatsHash=" + root.StatsHash.Hex(),
+		"PayoutHash=" + root.PayoutHash.Hex(),
+		"StakeHash=" + root.StakeHash.Hex(),
+		"BondHash=" + root.BondHash.Hex(),
+	}, ", ")
 }
 
 // Delegate come from custom tx which data like "senate:1:event:delegate".
@@ -30,6 +152,80 @@ type Delegate struct {
 	Candidate common.Address
 }
 
+// KeyRotation come from custom tx which data like "senate:1:event:rotate".
+// Sender of tx is Candidate, the tx.to is the new Signer.
+type KeyRotation struct {
+	Candidate common.Address
+	Signer    common.Address
+}
+
+// RewardAddress come from custom tx which data like "senate:1:event:payout".
+// Sender of tx is Candidate, the tx.to is the address credited with its rewards.
+type RewardAddress struct {
+	Candidate common.Address
+	Address   common.Address
+}
+
+// Slash is the stake debited from a validator kicked out for inactivity.
+type Slash struct {
+	Validator common.Address
+	Amount    *big.Int
+}
+
+// Deposit is the self-stake locked by a candidate at registration.
+type Deposit struct {
+	Candidate common.Address
+	Amount    *big.Int
+}
+
+// Reward is the share of block reward accrued to a delegator, or claimed by
+// custom tx which data like "senate:1:event:withdraw".
+type Reward struct {
+	Delegator common.Address
+	Amount    *big.Int
+}
+
+// Stake is the vote weight of a delegator of a validator fixed at the election
+// of an epoch, the delegator's share of the block rewards of the validator in
+// the epoch is in proportion to it.
+type Stake struct {
+	Candidate common.Address
+	Delegator common.Address
+	Amount    *big.Int
+}
+
+// Bond is an amount a delegator locks for a candidate with custom tx which data
+// like "senate:1:event:batchdelegate:...", or withdraws with custom tx which
+// data like "senate:1:event:unbond".
+type Bond struct {
+	Candidate common.Address
+	Delegator common.Address
+	Amount    *big.Int
+}
+
+// CandidateKey is the auxiliary public key registered by a candidate.
+type CandidateKey struct {
+	Candidate common.Address
+	Key       []byte
+}
+
+// CandidateDeclaration is the self-description published by a candidate with
+// custom tx which data like `senate:1:event:describe:{"name":"..."}`, and the
+// owner tag given at registration.
+type CandidateDeclaration struct {
+	Candidate common.Address `json:"candidate"`
+	Name      string         `json:"name"`
+	Website   string         `json:"website"`
+	Identity  string         `json:"identity"`
+	Owner     string         `json:"owner,omitempty"`
+}
+
+// CustomNonce is the nonce of a custom transaction applied by its sender.
+type CustomNonce struct {
+	Sender common.Address
+	Nonce  uint64
+}
+
 // HeaderExtra is the struct of info in header.Extra[extraVanity:len(header.extra)-extraSeal].
 // HeaderExtra is the current struct.
 type HeaderExtra struct {
@@ -43,14 +239,35 @@ type HeaderExtra struct {
 	CurrentBlockProposals         []Proposal
 	CurrentBlockDeclares          []Declare
 	CurrentEpochValidators        SortableAddresses
+
+	// Fields added after launch are optional in RLP, so the headers of older
+	// blocks still decode. Version is the encoding version of the HeaderExtra,
+	// 0 for headers encoded before it was added.
+	Version                      uint64                 `rlp:"optional"`
+	CurrentBlockCancelCandidates []common.Address       `rlp:"optional"`
+	CurrentBlockKeyRotations     []KeyRotation          `rlp:"optional"`
+	CurrentBlockSlashes          []Slash                `rlp:"optional"`
+	CurrentBlockDeposits         []Deposit              `rlp:"optional"`
+	CurrentBlockCandidateKeys    []CandidateKey         `rlp:"optional"`
+	CurrentBlockRewards          []Reward               `rlp:"optional"`
+	CurrentBlockWithdrawals      []Reward               `rlp:"optional"`
+	CurrentBlockDeclarations     []CandidateDeclaration `rlp:"optional"`
+	CurrentBlockRejects          []common.Hash          `rlp:"optional"`
+	CurrentBlockNonces           []CustomNonce          `rlp:"optional"`
+	CurrentBlockRewardAddresses  []RewardAddress        `rlp:"optional"`
+	CurrentBlockStakes           []Stake                `rlp:"optional"`
+	CurrentBlockBonds            []Bond                 `rlp:"optional"`
+	CurrentBlockUnbonds          []Bond                 `rlp:"optional"`
 }
 
 // NewHeaderExtra new HeaderExtra from rlp bytes.
 func NewHeaderExtra(data []byte) (HeaderExtra, error) {
-	r, err := gzip.NewReader(bytes.NewReader(data))
+	reader := bytes.NewReader(data)
+	r, err := gzip.NewReader(reader)
 	if err != nil {
 		return HeaderExtra{}, err
 	}
+	r.Multistream(false)
 
 	buffer := bytes.NewBuffer(nil)
 	for {
@@ -67,15 +284,38 @@ func NewHeaderExtra(data []byte) (HeaderExtra, error) {
 		}
 	}
 
+	// The encoded HeaderExtra must span the data exactly
+	if reader.Len() > 0 {
+		return HeaderExtra{}, errTrailingExtra
+	}
+
 	var headerExtra HeaderExtra
 	if err := rlp.DecodeBytes(buffer.Bytes(), &headerExtra); err != nil {
 		return HeaderExtra{}, err
 	}
+	if headerExtra.Version > headerExtraVersion {
+		return HeaderExtra{}, fmt.Errorf("%w: version %d, supported %d", errHeaderExtraVersion, headerExtra.Version, headerExtraVersion)
+	}
+	for _, config := range headerExtra.ChainConfig {
+		if config.Version > params.SenateConfigVersion {
+			return HeaderExtra{}, fmt.Errorf("%w: config version %d, supported %d", errHeaderExtraVersion, config.Version, params.SenateConfigVersion)
+		}
+	}
 	return headerExtra, nil
 }
 
-// Encode encode header extra as rlp bytes.
+// Encode encode header extra as rlp bytes, with the current versions of the
+// HeaderExtra and the configs it carries.
 func (headerExtra HeaderExtra) Encode() ([]byte, error) {
+	headerExtra.Version = headerExtraVersion
+	if len(headerExtra.ChainConfig) > 0 {
+		configs := make([]params.SenateConfig, len(headerExtra.ChainConfig))
+		for idx, config := range headerExtra.ChainConfig {
+			config.Version = params.SenateConfigVersion
+			configs[idx] = config
+		}
+		headerExtra.ChainConfig = configs
+	}
 	data, err := rlp.EncodeToBytes(headerExtra)
 	if err != nil {
 		return nil, err
@@ -88,6 +328,65 @@ func (headerExtra HeaderExtra) Encode() ([]byte, error) {
 	return buffer.Bytes(), nil
 }
 
+// headerExtraJSON is the JSON representation of HeaderExtra.
+type headerExtraJSON struct {
+	Root                   Root                   `json:"root"`
+	Epoch                  uint64                 `json:"epoch"`
+	EpochTime              uint64                 `json:"epoch_time"`
+	ChainConfig            []params.SenateConfig  `json:"chain_config,omitempty"`
+	Delegates              []Delegate             `json:"delegates,omitempty"`
+	Candidates             []common.Address       `json:"candidates,omitempty"`
+	CancelCandidates       []common.Address       `json:"cancel_candidates,omitempty"`
+	KickOutCandidates      []common.Address       `json:"kick_out_candidates,omitempty"`
+	Proposals              []Proposal             `json:"proposals,omitempty"`
+	Declares               []Declare              `json:"declares,omitempty"`
+	KeyRotations           []KeyRotation          `json:"key_rotations,omitempty"`
+	Slashes                []Slash                `json:"slashes,omitempty"`
+	Deposits               []Deposit              `json:"deposits,omitempty"`
+	CandidateKeys          []CandidateKey         `json:"candidate_keys,omitempty"`
+	Rewards                []Reward               `json:"rewards,omitempty"`
+	Withdrawals            []Reward               `json:"withdrawals,omitempty"`
+	Declarations           []CandidateDeclaration `json:"declarations,omitempty"`
+	Rejects                []common.Hash          `json:"rejects,omitempty"`
+	Nonces                 []CustomNonce          `json:"nonces,omitempty"`
+	RewardAddresses        []RewardAddress        `json:"reward_addresses,omitempty"`
+	Stakes                 []Stake                `json:"stakes,omitempty"`
+	Bonds                  []Bond                 `json:"bonds,omitempty"`
+	Unbonds                []Bond                 `json:"unbonds,omitempty"`
+	CurrentEpochValidators SortableAddresses      `json:"current_epoch_validators,omitempty"`
+}
+
+// MarshalJSON marshals the HeaderExtra as JSON, the events of the block are
+// keyed without the CurrentBlock prefix and omitted if empty.
+func (headerExtra HeaderExtra) MarshalJSON() ([]byte, error) {
+	return json.Marshal(headerExtraJSON{
+		Root:                   headerExtra.Root,
+		Epoch:                  headerExtra.Epoch,
+		EpochTime:              headerExtra.EpochTime,
+		ChainConfig:            headerExtra.ChainConfig,
+		Delegates:              headerExtra.CurrentBlockDelegates,
+		Candidates:             headerExtra.CurrentBlockCandidates,
+		CancelCandidates:       headerExtra.CurrentBlockCancelCandidates,
+		KickOutCandidates:      headerExtra.CurrentBlockKickOutCandidates,
+		Proposals:              headerExtra.CurrentBlockProposals,
+		Declares:               headerExtra.CurrentBlockDeclares,
+		KeyRotations:           headerExtra.CurrentBlockKeyRotations,
+		Slashes:                headerExtra.CurrentBlockSlashes,
+		Deposits:               headerExtra.CurrentBlockDeposits,
+		CandidateKeys:          headerExtra.CurrentBlockCandidateKeys,
+		Rewards:                headerExtra.CurrentBlockRewards,
+		Withdrawals:            headerExtra.CurrentBlockWithdrawals,
+		Declarations:           headerExtra.CurrentBlockDeclarations,
+		Rejects:                headerExtra.CurrentBlockRejects,
+		Nonces:                 headerExtra.CurrentBlockNonces,
+		RewardAddresses:        headerExtra.CurrentBlockRewardAddresses,
+		Stakes:                 headerExtra.CurrentBlockStakes,
+		Bonds:                  headerExtra.CurrentBlockBonds,
+		Unbonds:                headerExtra.CurrentBlockUnbonds,
+		CurrentEpochValidators: headerExtra.CurrentEpochValidators,
+	})
+}
+
 // Equal compares two HeaderExtras for equality.
 func (headerExtra HeaderExtra) Equal(other HeaderExtra) bool {
 	if headerExtra.Root != other.Root {
@@ -130,6 +429,15 @@ func (headerExtra HeaderExtra) Equal(other HeaderExtra) bool {
 		}
 	}
 
+	if len(headerExtra.CurrentBlockCancelCandidates) != len(other.CurrentBlockCancelCandidates) {
+		return false
+	}
+	for idx, candidate := range headerExtra.CurrentBlockCancelCandidates {
+		if candidate != other.CurrentBlockCancelCandidates[idx] {
+			return false
+		}
+	}
+
 	if len(headerExtra.CurrentBlockKickOutCandidates) != len(other.CurrentBlockKickOutCandidates) {
 		return false
 	}
@@ -157,6 +465,111 @@ func (headerExtra HeaderExtra) Equal(other HeaderExtra) bool {
 		}
 	}
 
+	if len(headerExtra.CurrentBlockKeyRotations) != len(other.CurrentBlockKeyRotations) {
+		return false
+	}
+	for idx, rotation := range headerExtra.CurrentBlockKeyRotations {
+		if rotation != other.CurrentBlockKeyRotations[idx] {
+			return false
+		}
+	}
+
+	if len(headerExtra.CurrentBlockSlashes) != len(other.CurrentBlockSlashes) {
+		return false
+	}
+	for idx, slash := range headerExtra.CurrentBlockSlashes {
+		if slash.Validator != other.CurrentBlockSlashes[idx].Validator {
+			return false
+		}
+		if slash.Amount.Cmp(other.CurrentBlockSlashes[idx].Amount) != 0 {
+			return false
+		}
+	}
+
+	if len(headerExtra.CurrentBlockDeposits) != len(other.CurrentBlockDeposits) {
+		return false
+	}
+	for idx, deposit := range headerExtra.CurrentBlockDeposits {
+		if deposit.Candidate != other.CurrentBlockDeposits[idx].Candidate {
+			return false
+		}
+		if deposit.Amount.Cmp(other.CurrentBlockDeposits[idx].Amount) != 0 {
+			return false
+		}
+	}
+
+	if len(headerExtra.CurrentBlockCandidateKeys) != len(other.CurrentBlockCandidateKeys) {
+		return false
+	}
+	for idx, candidateKey := range headerExtra.CurrentBlockCandidateKeys {
+		if candidateKey.Candidate != other.CurrentBlockCandidateKeys[idx].Candidate {
+			return false
+		}
+		if !bytes.Equal(candidateKey.Key, other.CurrentBlockCandidateKeys[idx].Key) {
+			return false
+		}
+	}
+
+	if !rewardsEqual(headerExtra.CurrentBlockRewards, other.CurrentBlockRewards) {
+		return false
+	}
+	if !rewardsEqual(headerExtra.CurrentBlockWithdrawals, other.CurrentBlockWithdrawals) {
+		return false
+	}
+	if len(headerExtra.CurrentBlockDeclarations) != len(other.CurrentBlockDeclarations) {
+		return false
+	}
+	for idx, declaration := range headerExtra.CurrentBlockDeclarations {
+		if declaration != other.CurrentBlockDeclarations[idx] {
+			return false
+		}
+	}
+
+	if len(headerExtra.CurrentBlockRejects) != len(other.CurrentBlockRejects) {
+		return false
+	}
+	for idx, hash := range headerExtra.CurrentBlockRejects {
+		if hash != other.CurrentBlockRejects[idx] {
+			return false
+		}
+	}
+	if len(headerExtra.CurrentBlockNonces) != len(other.CurrentBlockNonces) {
+		return false
+	}
+	for idx, nonce := range headerExtra.CurrentBlockNonces {
+		if nonce != other.CurrentBlockNonces[idx] {
+			return false
+		}
+	}
+	if len(headerExtra.CurrentBlockRewardAddresses) != len(other.CurrentBlockRewardAddresses) {
+		return false
+	}
+	for idx, payout := range headerExtra.CurrentBlockRewardAddresses {
+		if payout != other.CurrentBlockRewardAddresses[idx] {
+			return false
+		}
+	}
+	if len(headerExtra.CurrentBlockStakes) != len(other.CurrentBlockStakes) {
+		return false
+	}
+	for idx, stake := range headerExtra.CurrentBlockStakes {
+		if stake.Candidate != other.CurrentBlockStakes[idx].Candidate {
+			return false
+		}
+		if stake.Delegator != other.CurrentBlockStakes[idx].Delegator {
+			return false
+		}
+		if stake.Amount.Cmp(other.CurrentBlockStakes[idx].Amount) != 0 {
+			return false
+		}
+	}
+	if !bondsEqual(headerExtra.CurrentBlockBonds, other.CurrentBlockBonds) {
+		return false
+	}
+	if !bondsEqual(headerExtra.CurrentBlockUnbonds, other.CurrentBlockUnbonds) {
+		return false
+	}
+
 	if len(headerExtra.CurrentEpochValidators) != len(other.CurrentEpochValidators) {
 		return false
 	}
@@ -168,6 +581,49 @@ func (headerExtra HeaderExtra) Equal(other HeaderExtra) bool {
 	return true
 }
 
+func rewardsEqual(a, b []Reward) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx, reward := range a {
+		if reward.Delegator != b[idx].Delegator {
+			return false
+		}
+		if reward.Amount.Cmp(b[idx].Amount) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func bondsEqual(a, b []Bond) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx, bond := range a {
+		if bond.Candidate != b[idx].Candidate || bond.Delegator != b[idx].Delegator {
+			return false
+		}
+		if bond.Amount.Cmp(b[idx].Amount) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// DecodeHeaderExtra decodes the HeaderExtra of a senate header, checking the
+// extra-data holds both the signer vanity and the seal around it.
+func DecodeHeaderExtra(header *types.Header) (*HeaderExtra, error) {
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	headerExtra, err := decodeHeaderExtra(header)
+	if err != nil {
+		return nil, err
+	}
+	return &headerExtra, nil
+}
+
 func decodeHeaderExtra(header *types.Header) (HeaderExtra, error) {
 	headerExtra := header.Extra
 	if len(headerExtra) < extraVanity {
@@ -179,6 +635,23 @@ func decodeHeaderExtra(header *types.Header) (HeaderExtra, error) {
 	return NewHeaderExtra(headerExtra[extraVanity : len(headerExtra)-extraSeal])
 }
 
+// encodeHeaderExtra writes the HeaderExtra into header.Extra between the signer
+// vanity, padded to extraVanity bytes if shorter, and the reserved seal.
+func encodeHeaderExtra(header *types.Header, headerExtra HeaderExtra) error {
+	data, err := headerExtra.Encode()
+	if err != nil {
+		return err
+	}
+	if len(header.Extra) < extraVanity {
+		header.Extra = append(header.Extra, bytes.Repeat([]byte{0x00}, extraVanity-len(header.Extra))...)
+	}
+	extra := make([]byte, 0, extraVanity+len(data)+extraSeal)
+	extra = append(extra, header.Extra[:extraVanity]...)
+	extra = append(extra, data...)
+	header.Extra = append(extra, make([]byte, extraSeal)...)
+	return nil
+}
+
 // Ensure each element of an Delegate slice are not the same.
 func delegatesDistinct(slice []Delegate) []Delegate {
 	if len(slice) <= 1 {
@@ -212,3 +685,13 @@ func addressesDistinct(slice []common.Address) []common.Address {
 	}
 	return result
 }
+
+// Reports whether the common.Address slice contains the address.
+func containsAddress(slice []common.Address, address common.Address) bool {
+	for _, item := range slice {
+		if item == address {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,22 @@
 package senate
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
 	"math/big"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/SecretBlockChain/go-secret/common"
+	"github.com/SecretBlockChain/go-secret/common/hexutil"
+	"github.com/SecretBlockChain/go-secret/core/rawdb"
+	"github.com/SecretBlockChain/go-secret/core/types"
+	"github.com/SecretBlockChain/go-secret/params"
+	"github.com/SecretBlockChain/go-secret/rlp"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -93,3 +103,234 @@ func TestHeaderExtraEqual(t *testing.T) {
 	otherHeaderExtra.CurrentEpochValidators = append(otherHeaderExtra.CurrentEpochValidators, headerExtra.CurrentEpochValidators[0])
 	assert.True(t, headerExtra.Equal(otherHeaderExtra))
 }
+
+func TestEncodeHeaderExtraVanity(t *testing.T) {
+	headerExtra := HeaderExtra{Epoch: 1, EpochTime: 100}
+	for _, vanity := range [][]byte{nil, []byte("short"), bytes.Repeat([]byte{0x01}, extraVanity+10)} {
+		header := &types.Header{Extra: vanity}
+		assert.Nil(t, encodeHeaderExtra(header, headerExtra))
+		decoded, err := decodeHeaderExtra(header)
+		assert.Nil(t, err)
+		assert.True(t, headerExtra.Equal(decoded))
+		assert.Equal(t, make([]byte, extraSeal), header.Extra[len(header.Extra)-extraSeal:])
+	}
+}
+
+func TestDecodeHeaderExtraTrailing(t *testing.T) {
+	headerExtra := HeaderExtra{Epoch: 1, EpochTime: 100}
+	data, err := headerExtra.Encode()
+	assert.Nil(t, err)
+	empty, err := HeaderExtra{}.Encode()
+	assert.Nil(t, err)
+
+	// Garbage or another gzip stream after the encoded struct is rejected
+	for _, trailing := range [][]byte{{0x00}, []byte("garbage"), empty} {
+		header := &types.Header{Extra: make([]byte, extraVanity)}
+		header.Extra = append(header.Extra, data...)
+		header.Extra = append(header.Extra, trailing...)
+		header.Extra = append(header.Extra, make([]byte, extraSeal)...)
+		_, err := decodeHeaderExtra(header)
+		assert.Equal(t, errTrailingExtra, err)
+	}
+}
+
+func TestDecodeHeaderExtra(t *testing.T) {
+	headerExtra := HeaderExtra{
+		Root:                   Root{EpochHash: common.Hash{0x01}, ConfigHash: common.Hash{0x02}},
+		Epoch:                  3,
+		EpochTime:              100,
+		CurrentBlockCandidates: []common.Address{{0x03}},
+	}
+	header := &types.Header{Extra: []byte("vanity")}
+	assert.Nil(t, encodeHeaderExtra(header, headerExtra))
+	decoded, err := DecodeHeaderExtra(header)
+	assert.Nil(t, err)
+	assert.True(t, headerExtra.Equal(*decoded))
+
+	// The extra-data must frame the HeaderExtra with the vanity and seal
+	_, err = DecodeHeaderExtra(&types.Header{Extra: make([]byte, extraVanity-1)})
+	assert.Equal(t, errMissingVanity, err)
+	_, err = DecodeHeaderExtra(&types.Header{Extra: header.Extra[:len(header.Extra)-1]})
+	assert.NotNil(t, err)
+	_, err = DecodeHeaderExtra(&types.Header{Extra: make([]byte, extraVanity+extraSeal-1)})
+	assert.Equal(t, errMissingSignature, err)
+	_, err = DecodeHeaderExtra(nil)
+	assert.Equal(t, errUnknownBlock, err)
+}
+
+func TestHeaderExtraMarshalJSON(t *testing.T) {
+	headerExtra := HeaderExtra{
+		Root:                   Root{EpochHash: common.Hash{0x01}},
+		Epoch:                  3,
+		EpochTime:              100,
+		CurrentBlockCandidates: []common.Address{{0x03}},
+	}
+	data, err := json.Marshal(&headerExtra)
+	assert.Nil(t, err)
+
+	var decoded map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, float64(3), decoded["epoch"])
+	assert.Equal(t, float64(100), decoded["epoch_time"])
+	assert.Equal(t, common.Hash{0x01}.Hex(), decoded["root"].(map[string]interface{})["EpochHash"])
+	assert.Equal(t, []interface{}{common.Address{0x03}.Hex()}, decoded["candidates"])
+
+	// Empty events of the block are omitted
+	assert.NotContains(t, decoded, "delegates")
+	assert.NotContains(t, decoded, "CurrentBlockCandidates")
+}
+
+func TestRootMigration(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	candidate, delegator := common.Address{0x01}, common.Address{0x02}
+	assert.Nil(t, snap.BecomeCandidate(candidate))
+	assert.Nil(t, snap.Delegate(delegator, candidate))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	// A version 0 root is the plain list of the first eight trie hashes
+	blob, err := rlp.EncodeToBytes([]common.Hash{
+		root.EpochHash, root.DelegateHash, root.CandidateHash, root.VoteHash,
+		root.MintCntHash, root.ConfigHash, root.ProposalHash, root.DeclareHash,
+	})
+	assert.Nil(t, err)
+	var migrated Root
+	assert.Nil(t, rlp.DecodeBytes(blob, &migrated))
+	assert.Equal(t, root, migrated)
+
+	// The migrated root loads the same snapshot
+	loaded, err := loadSnapshot(db, migrated)
+	assert.Nil(t, err)
+	isCandidate, err := loaded.IsCandidate(candidate)
+	assert.Nil(t, err)
+	assert.True(t, isCandidate)
+	reloaded, err := loaded.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, root, reloaded)
+
+	// The current version round trips
+	blob, err = rlp.EncodeToBytes(root)
+	assert.Nil(t, err)
+	var decoded Root
+	assert.Nil(t, rlp.DecodeBytes(blob, &decoded))
+	assert.Equal(t, root, decoded)
+
+	// Roots of a newer version are rejected
+	blob, err = rlp.EncodeToBytes([]interface{}{uint64(rootVersion + 1), root.EpochHash})
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(rlp.DecodeBytes(blob, &decoded), errRootVersion))
+}
+
+// legacyHeaderExtra is a HeaderExtra encoded by releases before the
+// optional fields and the root version were added.
+const legacyHeaderExtra = "0x1f8b08000000000000fffac934f32723c70206fc8091900226420a9809296021a48095900236420ad80929e0606c898f1360f8e1f0c38ed946943105cc7b35e54c4db3f8263999578996053c366e87a47eceb8e833c5e5e239ee9acd11dda7a689ca3fdcc3badee2a774ce89e3ad4c21dc8fffb3be2641d355ac4aaf6255fb23f1477c5b416a51667e8a0921cf70623597902e961f513f220829e222a48013abeb19df5dc3eaa6946b589537000600dbac02f29c020000"
+
+func TestRootString(t *testing.T) {
+	root := Root{CandidateHash: common.HexToHash("0x01"), BondHash: common.HexToHash("0x02")}
+	pairs := strings.Split(root.String(), ", ")
+
+	// Every trie is printed once
+	assert.Equal(t, len(root.hashes()), len(pairs))
+	seen := make(map[string]bool)
+	for _, pair := range pairs {
+		key := strings.SplitN(pair, "=", 2)[0]
+		assert.False(t, seen[key], key)
+		seen[key] = true
+	}
+	assert.Contains(t, pairs, "CandidateHash="+common.HexToHash("0x01").Hex())
+	assert.Contains(t, pairs, "BondHash="+common.HexToHash("0x02").Hex())
+}
+
+func TestDecodeLegacyHeaderExtra(t *testing.T) {
+	headerExtra, err := NewHeaderExtra(hexutil.MustDecode(legacyHeaderExtra))
+	assert.Nil(t, err)
+
+	address1 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	address2 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	approved := common.HexToHash("0x04")
+	assert.Equal(t, common.HexToHash("0x01"), headerExtra.Root.EpochHash)
+	assert.Equal(t, common.HexToHash("0x08"), headerExtra.Root.DeclareHash)
+	assert.Equal(t, uint64(1), headerExtra.Epoch)
+	assert.Equal(t, uint64(1600000000), headerExtra.EpochTime)
+	assert.Equal(t, 1, len(headerExtra.ChainConfig))
+	assert.True(t, headerExtra.ChainConfig[0].Equal(params.SenateConfig{
+		Period:              3,
+		Epoch:               60,
+		MaxValidatorsCount:  21,
+		MinDelegatorBalance: big.NewInt(1),
+		MinCandidateBalance: big.NewInt(100),
+		GenesisTimestamp:    1600000000,
+		Validators:          []common.Address{address1, address2},
+		Rewards:             params.SenateRewards{{Height: 9999999999, Reward: big.NewInt(5)}},
+	}))
+	assert.Equal(t, []Delegate{{Delegator: address1, Candidate: address2}}, headerExtra.CurrentBlockDelegates)
+	assert.Equal(t, []common.Address{address1}, headerExtra.CurrentBlockCandidates)
+	assert.Equal(t, []common.Address{address2}, headerExtra.CurrentBlockKickOutCandidates)
+	assert.Equal(t, []Proposal{{Key: "period", Value: "4", Hash: common.HexToHash("0x09"),
+		Proposer: address1, ApprovedHash: &approved}}, headerExtra.CurrentBlockProposals)
+	assert.Equal(t, []Declare{{Hash: common.HexToHash("0x0a"), ProposalHash: common.HexToHash("0x09"),
+		Declarer: address2, Decision: true}}, headerExtra.CurrentBlockDeclares)
+	assert.Equal(t, 2, len(headerExtra.CurrentEpochValidators))
+	assert.Equal(t, address1, headerExtra.CurrentEpochValidators[0].Address)
+	assert.Equal(t, int64(100), headerExtra.CurrentEpochValidators[0].Weight.Int64())
+	assert.Equal(t, address2, headerExtra.CurrentEpochValidators[1].Address)
+
+	// Fields added since are empty
+	assert.Nil(t, headerExtra.CurrentBlockCancelCandidates)
+	assert.Nil(t, headerExtra.CurrentBlockRewardAddresses)
+
+	// The events of the block added since round trip after the legacy fields
+	headerExtra.CurrentBlockRewardAddresses = []RewardAddress{{Candidate: address1, Address: address2}}
+	data, err := headerExtra.Encode()
+	assert.Nil(t, err)
+	decoded, err := NewHeaderExtra(data)
+	assert.Nil(t, err)
+	assert.Equal(t, headerExtra.CurrentBlockProposals, decoded.CurrentBlockProposals)
+	assert.Equal(t, headerExtra.CurrentEpochValidators, decoded.CurrentEpochValidators)
+	assert.Equal(t, headerExtra.CurrentBlockRewardAddresses, decoded.CurrentBlockRewardAddresses)
+}
+
+func TestHeaderExtraVersion(t *testing.T) {
+	// Headers written before the version was added are version 0
+	legacy, err := NewHeaderExtra(hexutil.MustDecode(legacyHeaderExtra))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), legacy.Version)
+	assert.Equal(t, uint64(0), legacy.ChainConfig[0].Version)
+
+	// and are written with the current versions again
+	data, err := legacy.Encode()
+	assert.Nil(t, err)
+	headerExtra, err := NewHeaderExtra(data)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(headerExtraVersion), headerExtra.Version)
+	assert.Equal(t, uint64(params.SenateConfigVersion), headerExtra.ChainConfig[0].Version)
+	assert.Equal(t, legacy.Root, headerExtra.Root)
+	assert.True(t, legacy.ChainConfig[0].Equal(headerExtra.ChainConfig[0]))
+
+	// Encoding leaves the configs of the encoded HeaderExtra untouched
+	assert.Equal(t, uint64(0), legacy.ChainConfig[0].Version)
+
+	// Newer versions are rejected
+	encode := func(headerExtra HeaderExtra) []byte {
+		data, err := rlp.EncodeToBytes(headerExtra)
+		assert.Nil(t, err)
+		buffer := bytes.NewBuffer(nil)
+		w := gzip.NewWriter(buffer)
+		w.Write(data)
+		w.Close()
+		return buffer.Bytes()
+	}
+	newer := headerExtra
+	newer.Version = headerExtraVersion + 1
+	_, err = NewHeaderExtra(encode(newer))
+	assert.True(t, errors.Is(err, errHeaderExtraVersion))
+
+	newer = headerExtra
+	newer.ChainConfig = []params.SenateConfig{headerExtra.ChainConfig[0]}
+	newer.ChainConfig[0].Version = params.SenateConfigVersion + 1
+	_, err = NewHeaderExtra(encode(newer))
+	assert.True(t, errors.Is(err, errHeaderExtraVersion))
+}
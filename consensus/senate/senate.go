@@ -1,25 +1,35 @@
 package senate
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"math/big"
 	"math/rand"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/SecretBlockChain/go-secret/accounts"
 	"github.com/SecretBlockChain/go-secret/common"
 	"github.com/SecretBlockChain/go-secret/consensus"
+	"github.com/SecretBlockChain/go-secret/core/rawdb"
 	"github.com/SecretBlockChain/go-secret/core/state"
 	"github.com/SecretBlockChain/go-secret/core/types"
 	"github.com/SecretBlockChain/go-secret/crypto"
 	"github.com/SecretBlockChain/go-secret/ethdb"
+	"github.com/SecretBlockChain/go-secret/event"
 	"github.com/SecretBlockChain/go-secret/log"
+	"github.com/SecretBlockChain/go-secret/metrics"
 	"github.com/SecretBlockChain/go-secret/params"
 	"github.com/SecretBlockChain/go-secret/rpc"
 	"github.com/SecretBlockChain/go-secret/trie"
 	lru "github.com/hashicorp/golang-lru"
+	"github.com/steakknife/bloomfilter"
 )
 
 // Senate delegated-proof-of-stake protocol constants.
@@ -27,9 +37,46 @@ var (
 	extraVanity        = 32                       // Fixed number of extra-data prefix bytes reserved for signer vanity
 	extraSeal          = crypto.SignatureLength   // Fixed number of extra-data suffix bytes reserved for signer seal
 	defaultDifficulty  = int64(1)                 // Default difficulty
-	inmemorySnapshots  = 12                       // Number of recent vote snapshots to keep in memory
+	diffInTurn         = big.NewInt(2)            // Block difficulty for in-turn signatures
+	diffNoTurn         = big.NewInt(1)            // Block difficulty for out-of-turn signatures
+	wiggleTime         = 500 * time.Millisecond   // Random delay (per signer) to allow concurrent signers
+	inmemorySnapshots  = 12                       // Number of recent snapshots to keep in memory
+	snapshotCache      = 16                       // Megabytes of snapshot trie nodes to keep in memory
 	inMemorySignatures = 4096                     // Number of recent block signatures to keep in memory
+	inMemorySeals      = 4096                     // Number of recent sealed slots to keep in memory
+	inMemoryVerified   = 4096                     // Number of recent seal verification results to keep in memory
+	inMemoryConfigs    = 128                      // Number of recent chain configs to keep in memory
+	inMemoryRejected   = 1024                     // Number of recent blocks failing finalization to keep in memory
+	verifyAhead        = inMemorySignatures / 2   // Number of headers checked ahead of the in-order verification
+	maxBufferedHeaders = verifyAhead              // Number of headers of a batch held until their parent is verified
+	reorgSearchDepth   = 1024                     // Max number of headers walked back looking for the ancestor of a reorg
+	chainHeadChanSize  = 10                       // Size of the channel receiving the canonical heads
+	futureDrift        = 3 * time.Second          // Default time a header may be ahead of the local clock
+	readRetryDelay     = 100 * time.Millisecond   // Delay before the first retry of a transient snapshot read failure
+	retainBloomBits    = 64 << 20                 // Bits of the bloom filter of retained snapshot nodes (8 MB)
+	retainBloomFuncs   = 4                        // Number of hash functions of the bloom filter of retained snapshot nodes
+	retainWalkCache    = 65536                    // Number of retained snapshot nodes remembered to skip walking their children again
 	uncleHash          = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
+
+	snapshotPrunedKey = []byte("senate-snapshot-pruned") // Number of the first block whose snapshot isn't pruned
+	sealDomainTag     = []byte("\x19Senate Seal:\n")       // Prefix of the sealed data after activation, never valid RLP of a transaction or header
+)
+
+// StakingAddress holds the amounts bonded for candidates until they're released,
+// so the state keeps the supply locked by delegators. No key controls it, only
+// the engine credits and debits it.
+var StakingAddress = common.BytesToAddress([]byte("senate-staking"))
+
+// Metrics of the consensus engine, reported through the default registry.
+var (
+	sealDelayHistogram     = metrics.NewRegisteredHistogram("senate/seal/delay", nil, metrics.NewExpDecaySample(1028, 0.015))
+	staleSealMeter         = metrics.NewRegisteredMeter("senate/seal/stale", nil)
+	verifyHeaderTimer      = metrics.NewRegisteredTimer("senate/verify/header", nil)
+	verifyCascadingTimer   = metrics.NewRegisteredTimer("senate/verify/cascading", nil)
+	snapshotCacheHitMeter  = metrics.NewRegisteredMeter("senate/snapshot/cache/hit", nil)
+	snapshotCacheMissMeter = metrics.NewRegisteredMeter("senate/snapshot/cache/miss", nil)
+	epochGauge             = metrics.NewRegisteredGauge("senate/epoch", nil)
+	validatorsGauge        = metrics.NewRegisteredGauge("senate/validators", nil)
 )
 
 // Various error messages to mark blocks invalid. These should be private to
@@ -40,6 +87,10 @@ var (
 	// errUnauthorized is returned if a header is signed by a non-authorized entity.
 	errUnauthorized = errors.New("unauthorized")
 
+	// errNotAuthorizedToSeal is returned if a block is sealed by an engine no
+	// signing key was injected into, e.g. a non-validating node.
+	errNotAuthorizedToSeal = errors.New("no signing key authorized to seal")
+
 	// errUnknownBlock is returned when the list of signers is requested for a block
 	// that is not part of the local blockchain.
 	errUnknownBlock = errors.New("unknown block")
@@ -48,6 +99,74 @@ var (
 	// 32 bytes, which is required to store the signer vanity.
 	errMissingVanity = errors.New("extra-data 32 byte vanity prefix missing")
 
+	// errWrongDifficulty is returned if the difficulty of a block doesn't match the
+	// turn of the signer.
+	errWrongDifficulty = errors.New("wrong difficulty")
+
+	// errRecentlySigned is returned if a header is signed by an authorized entity
+	// that already signed a header recently, thus is temporarily not allowed to.
+	errRecentlySigned = errors.New("recently signed")
+
+	// errEmptyBlockHeld is returned if an empty block is sealed before the
+	// empty block period passed.
+	errEmptyBlockHeld = errors.New("empty block held back")
+
+	// errDoubleSign is returned if a validator signed two different blocks for
+	// the same slot.
+	errDoubleSign = errors.New("double sign in the same slot")
+
+	// errInvalidCoinbase is returned if the coinbase of a block isn't the signer
+	// of the block, which is credited with its rewards.
+	errInvalidCoinbase = errors.New("coinbase not the signer")
+
+	// errInvalidTrieRoot is returned if the snapshot root of a block doesn't
+	// match the root derived from its parent.
+	errInvalidTrieRoot = errors.New("invalid trie root")
+
+	// errSnapshotCommit is returned if the snapshot of a verified block can't be
+	// written to the database.
+	errSnapshotCommit = errors.New("failed to write snapshot")
+
+	// errSnapshotRead is returned if the snapshot needed to verify a block
+	// can't be read from the database, even after retrying.
+	errSnapshotRead = errors.New("failed to read snapshot")
+
+	// errSnapshotUnavailable is returned if the snapshot to repair from isn't
+	// stored in the database.
+	errSnapshotUnavailable = errors.New("snapshot unavailable")
+
+	// errInvalidRepairRange is returned if the block to repair the snapshot of
+	// isn't after the block to repair from.
+	errInvalidRepairRange = errors.New("invalid repair range")
+
+	// errInvalidCheckpoint is returned if the first block of an epoch doesn't
+	// carry the validators of the epoch.
+	errInvalidCheckpoint = errors.New("invalid epoch checkpoint")
+
+	// errInvalidEpoch is returned if the epoch of a block doesn't continue the
+	// epoch of its parent.
+	errInvalidEpoch = errors.New("invalid epoch")
+
+	// errInvalidNumber is returned if the number of a block isn't the number
+	// of its parent plus one.
+	errInvalidNumber = errors.New("invalid block number")
+
+	// errInvalidHeaderExtra is returned if the HeaderExtra of a block doesn't
+	// match the one replayed from its parent and transactions.
+	errInvalidHeaderExtra = errors.New("invalid header extra")
+
+	// errEngineClosed is returned if headers are verified or blocks are sealed
+	// after the engine is closed.
+	errEngineClosed = errors.New("consensus engine closed")
+
+	// errInvalidGasLimit is returned if the gas limit of a block is out of the
+	// bounds or changes too much from its parent.
+	errInvalidGasLimit = errors.New("invalid gas limit")
+
+	// errInvalidBaseFee is returned if the base fee of a block is not the one
+	// expected from its parent, or is set before the London fork.
+	errInvalidBaseFee = errors.New("invalid base fee")
+
 	// errUnclesNotAllowed is returned if uncles exists
 	errUnclesNotAllowed = errors.New("uncles not allowed")
 
@@ -55,6 +174,30 @@ var (
 	// to contain a 65 byte secp256k1 signature.
 	errMissingSignature = errors.New("extra-data 65 byte signature suffix missing")
 
+	// errExtraTooLong is returned if a block's extra-data section is longer than
+	// the configured max size.
+	errExtraTooLong = errors.New("extra-data too long")
+
+	// errRootVersion is returned if a snapshot root is encoded by a newer version
+	// of the engine, whose tries may not be understood.
+	errRootVersion = errors.New("unsupported snapshot root version")
+
+	// errHeaderExtraVersion is returned if a block's HeaderExtra or a config it
+	// carries is encoded by a newer version of the engine.
+	errHeaderExtraVersion = errors.New("unsupported header extra version")
+
+	// errTrailingExtra is returned if a block's extra-data section holds data
+	// after the encoded HeaderExtra.
+	errTrailingExtra = errors.New("trailing data after header extra")
+
+	// errInvalidSenateRLP is returned if the data to sign as a senate header
+	// isn't the signing RLP of a header.
+	errInvalidSenateRLP = errors.New("invalid senate signing rlp")
+
+	// errCheckpointMismatch is returned if the first block of an epoch isn't
+	// the one of the finalized checkpoint of the epoch.
+	errCheckpointMismatch = errors.New("epoch checkpoint mismatch")
+
 	// errInvalidMixDigest is returned if a block's mix digest is non-zero.
 	errInvalidMixDigest = errors.New("non-zero mix digest")
 
@@ -67,266 +210,1736 @@ var (
 
 	// ErrChainConfigMissing is returned if the chain config is missing
 	ErrChainConfigMissing = errors.New("chain config missing")
+
+	// ErrUnknownProposal is returned if the proposal doesn't exist in snapshot.
+	ErrUnknownProposal = errors.New("unknown proposal")
+
+	// ErrStakeTooLow is returned if a candidate registers with a self-stake
+	// lower than the minimum.
+	ErrStakeTooLow = errors.New("stake below minimum")
+
+	// ErrInsufficientStake is returned if the balance of a candidate can't
+	// cover the self-stake.
+	ErrInsufficientStake = errors.New("insufficient balance for stake")
+
+	// ErrRegistrationLimit is returned if the new candidates registered in
+	// the epoch reached config.MaxRegistrations.
+	ErrRegistrationLimit = errors.New("candidate registration limit of epoch reached")
 )
 
+// snapshotCommitError wraps the database error of writing a snapshot, it
+// matches errSnapshotCommit.
+type snapshotCommitError struct {
+	err error
+}
+
+func (e *snapshotCommitError) Error() string {
+	return errSnapshotCommit.Error() + ": " + e.err.Error()
+}
+
+func (e *snapshotCommitError) Unwrap() error {
+	return e.err
+}
+
+func (e *snapshotCommitError) Is(target error) bool {
+	return target == errSnapshotCommit
+}
+
+// snapshotReadError wraps the transient database error of reading a snapshot,
+// it matches errSnapshotRead.
+type snapshotReadError struct {
+	err error
+}
+
+func (e *snapshotReadError) Error() string {
+	return errSnapshotRead.Error() + ": " + e.err.Error()
+}
+
+func (e *snapshotReadError) Unwrap() error {
+	return e.err
+}
+
+func (e *snapshotReadError) Is(target error) bool {
+	return target == errSnapshotRead
+}
+
+// isTransientError reports whether the error is caused by reading the
+// database rather than an invalid block, so the read may succeed on retry.
+func isTransientError(err error) bool {
+	var missing *trie.MissingNodeError
+	return errors.As(err, &missing) || errors.Is(err, ErrChainConfigMissing)
+}
+
 type SignerFn func(accounts.Account, string, []byte) ([]byte, error)
 
 // Senate is the delegated-proof-of-stake consensus engine.
 type Senate struct {
 	db         ethdb.Database       // Database to store and retrieve snapshot checkpoints
 	signatures *lru.ARCCache        // Signatures of recent blocks to speed up mining
+	seals      *lru.ARCCache        // Sealed slots of recent blocks to detect double signing
+	verified   *lru.ARCCache        // Seal verification results of recent headers
+	recents    *lru.ARCCache        // Snapshots of recent blocks to speed up verification
+	configs    *lru.ARCCache        // Chain configs by root hash of the config trie
+	rejected   *lru.ARCCache        // Errors of recent blocks failing finalization by hash
+	triedb     *trie.Database       // Trie database caching the nodes of snapshots
 	config     *params.SenateConfig // Consensus engine configuration parameters
+	options    Options              // Node-local options of the engine
+	chainID    *big.Int             // Chain id bound into the seal hash after activation
 	signer     common.Address       // Ethereum address of the signing key
 	signFn     SignerFn             // Signer function to authorize hashes with
+	fallbacks  []authorizedSigner   // Additional signing keys sealing the slots they are in turn for
 	lock       sync.RWMutex         // Protects the signer fields
+
+	ctx       context.Context    // Parent context of verifications, cancelled on close
+	cancel    context.CancelFunc // Cancels the verifications and sealing in progress
+	closed    bool               // Whether the engine is closed
+	closeLock sync.Mutex         // Protects the closed flag
+	running   sync.WaitGroup     // Background goroutines of verifying and sealing
+
+	pruneLock   sync.RWMutex        // Held for writing while pruning snapshots, for reading while committing one
+	pruneEpoch  uint64              // Epoch the last background pruning was scheduled in (atomic access)
+	retainBloom *bloomfilter.Filter // Trie nodes of the retained first blocks of epochs, lazily allocated
+	retainedTo  uint64              // Number of the first block not yet added to the retain bloom
+
+	lastVerified uint64 // Number of the highest header verified (atomic access)
+
+	checkpoints    map[uint64]common.Hash // Hashes of the first blocks of finalized epochs
+	checkpointLock sync.RWMutex           // Protects the checkpoints
+
+	head      *types.Header // Canonical head of the chain watched
+	headLock  sync.Mutex    // Protects the head
+	reorgFeed event.Feed    // Reorgs of the canonical chain
+}
+
+// Options are the node-local settings of the engine. Unlike SenateConfig they
+// aren't part of consensus, so nodes of the same chain may differ in them.
+type Options struct {
+	VerifyWorkers      uint64 // Goroutines checking a batch of headers ahead of the in-order verification (0 = GOMAXPROCS)
+	SnapshotRetention  uint64 // Number of recent blocks whose snapshots are kept when pruning once per epoch (0 = never pruned)
+	ReadRetries        uint64 // Times a transient snapshot read failure is retried while verifying a header (0 = never retried)
+	StrictVerification bool   // Rebuild every snapshot trie of verified headers to localize a root mismatch, slow
+}
+
+// authorizedSigner is a signing key injected into the engine.
+type authorizedSigner struct {
+	address common.Address
+	signFn  SignerFn
 }
 
 // New creates a Senate delegated-proof-of-stake consensus engine with the initial
 // signers set to the ones provided by the user.
-func New(config *params.SenateConfig, db ethdb.Database) *Senate {
-	config.Rewards.Sort()
-	signatures, _ := lru.NewARC(inMemorySignatures)
-	return &Senate{db: db, signatures: signatures, config: config}
+func New(config *params.SenateConfig, chainID *big.Int, db ethdb.Database) *Senate {
+	return NewWithOptions(config, chainID, db, Options{})
 }
 
-// Close terminates any background threads maintained by the consensus engine.
-func (senate *Senate) Close() error {
-	return nil
+// NewWithOptions creates a Senate consensus engine with the node-local options.
+func NewWithOptions(config *params.SenateConfig, chainID *big.Int, db ethdb.Database, options Options) *Senate {
+	config.Rewards.Sort()
+	signatures, _ := lru.NewARC(inMemorySignatures)
+	seals, _ := lru.NewARC(inMemorySeals)
+	verified, _ := lru.NewARC(inMemoryVerified)
+	recents, _ := lru.NewARC(inmemorySnapshots)
+	configs, _ := lru.NewARC(inMemoryConfigs)
+	rejected, _ := lru.NewARC(inMemoryRejected)
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Senate{
+		db:         db,
+		signatures: signatures,
+		seals:      seals,
+		verified:   verified,
+		recents:    recents,
+		configs:    configs,
+		rejected:   rejected,
+		triedb:     trie.NewDatabaseWithCache(db, snapshotCache, ""),
+		config:     config,
+		options:    options,
+		chainID:    chainID,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
 }
 
-// APIs returns the RPC APIs this consensus engine provides.
-func (senate *Senate) APIs(chain consensus.ChainHeaderReader) []rpc.API {
-	return []rpc.API{{
-		Namespace: "dpos",
-		Version:   "1.0",
-		Service:   &API{chain: chain, senate: senate},
-		Public:    true,
-	}}
+// loadSnapshot loads the snapshot of root from the recent snapshots or the
+// database, the snapshot returned can be modified freely.
+func (senate *Senate) loadSnapshot(root Root) (*Snapshot, error) {
+	if snap, ok := senate.recents.Get(root); ok {
+		snapshotCacheHitMeter.Mark(1)
+		return snap.(*Snapshot).copy(), nil
+	}
+	snapshotCacheMissMeter.Mark(1)
+	return &Snapshot{root: root, db: senate.triedb}, nil
 }
 
-// Authorize injects a private key into the consensus engine to mint new blocks
-// with.
-func (senate *Senate) Authorize(signer common.Address, signFn SignerFn) {
-	senate.lock.Lock()
-	defer senate.lock.Unlock()
-
-	senate.signer = signer
-	senate.signFn = signFn
+// cacheSnapshot keeps a copy of the committed snapshot in the recent snapshots.
+func (senate *Senate) cacheSnapshot(snap *Snapshot) {
+	senate.recents.Add(snap.root, snap.copy())
 }
 
-// InTurn returns if a signer at a given block height is in-turn or not.
-func (senate *Senate) InTurn(lastBlockHeader *types.Header, now uint64) bool {
-	config, err := senate.chainConfig(lastBlockHeader)
+// DumpSnapshot encodes the full contents of the snapshot of root into JSON, two
+// dumps of the same root are byte for byte identical.
+func (senate *Senate) DumpSnapshot(root Root) ([]byte, error) {
+	snap, err := senate.loadSnapshot(root)
 	if err != nil {
-		return false
+		return nil, err
 	}
-	if now <= config.GenesisTimestamp-config.Period {
-		return false
+	dump, err := snap.Dump()
+	if err != nil {
+		return nil, err
 	}
+	return json.Marshal(dump)
+}
 
-	// Estimate the next block time
-	nexBlockTime := lastBlockHeader.Time + config.Period
-	if int64(nexBlockTime) < time.Now().Unix() {
-		nexBlockTime = uint64(time.Now().Unix())
+// DiffSnapshots compares the snapshots of two roots trie by trie, to localize
+// where the snapshots of two nodes split.
+func (senate *Senate) DiffSnapshots(a, b Root) (*SnapshotDiff, error) {
+	snapA, err := senate.loadSnapshot(a)
+	if err != nil {
+		return nil, err
 	}
-
-	senate.lock.Lock()
-	signer := senate.signer
-	senate.lock.Unlock()
-	return senate.inTurn(config, lastBlockHeader, nexBlockTime, signer)
+	snapB, err := senate.loadSnapshot(b)
+	if err != nil {
+		return nil, err
+	}
+	return snapA.Diff(snapB)
 }
 
-func (senate *Senate) inTurn(config params.SenateConfig,
-	lastBlockHeader *types.Header, nexBlockTime uint64, signer common.Address) bool {
-
-	validators := config.Validators
-	epochTime := config.GenesisTimestamp
-
-	if lastBlockHeader != nil && lastBlockHeader.Number.Int64() > 0 {
-		headerExtra, err := decodeHeaderExtra(lastBlockHeader)
-		if err != nil {
-			return false
+// snapshotAt retrieves the snapshot after the given block. If the snapshot is
+// missing in the database, e.g. the block was imported on a fork whose snapshots
+// were never written, it is rebuilt by replaying the headers since the nearest
+// ancestor with a stored snapshot.
+func (senate *Senate) snapshotAt(chain consensus.ChainHeaderReader, header *types.Header) (*Snapshot, error) {
+	var snap *Snapshot
+	var headers []*types.Header
+	for {
+		if header.Number.Uint64() == 0 {
+			snap, _ = senate.loadSnapshot(Root{})
+			break
 		}
-
-		epochTime = headerExtra.EpochTime
-		snap, err := loadSnapshot(senate.db, headerExtra.Root)
+		headerExtra, err := decodeHeaderExtra(header)
 		if err != nil {
-			return false
+			return nil, err
 		}
-
-		addresses, err := snap.GetValidators()
-		if err != nil {
-			return false
+		if cached, ok := senate.recents.Get(headerExtra.Root); ok {
+			snapshotCacheHitMeter.Mark(1)
+			snap = cached.(*Snapshot).copy()
+			break
 		}
-
-		validators = make([]common.Address, 0, len(addresses))
-		for _, address := range addresses {
-			validators = append(validators, address.Address)
+		if snap, _ = senate.loadSnapshot(headerExtra.Root); snap.available() {
+			break
+		}
+		headers = append(headers, header)
+		if header = chain.GetHeader(header.ParentHash, header.Number.Uint64()-1); header == nil {
+			return nil, consensus.ErrUnknownAncestor
 		}
 	}
 
-	count := len(validators)
-	if count == 0 {
-		return false
+	// Replay the headers without snapshot from the oldest one
+	for i := len(headers) - 1; i >= 0; i-- {
+		if err := senate.replaySnapshot(snap, headers[i]); err != nil {
+			return nil, err
+		}
 	}
-
-	idx := (nexBlockTime - epochTime) / config.Period % uint64(len(validators))
-	return validators[idx] == signer
-}
-
-// Gets the chain config for the specified block height.
-func (senate *Senate) chainConfig(header *types.Header) (params.SenateConfig, error) {
-	if header == nil || header.Number.Int64() == 0 {
-		return *senate.config, nil
+	if len(headers) > 0 {
+		log.Info("[DPOS] Rebuilt missing snapshots", "number", headers[0].Number, "replayed", len(headers))
 	}
+	return snap, nil
+}
 
+// replaySnapshot applies the header to the snapshot of its parent and commits
+// it once the root matches the one in the header.
+func (senate *Senate) replaySnapshot(snap *Snapshot, header *types.Header) error {
 	headerExtra, err := decodeHeaderExtra(header)
 	if err != nil {
-		return params.SenateConfig{}, err
+		return err
 	}
-	return senate.chainConfigByHash(headerExtra.Root.ConfigHash)
+	config := *senate.config
+	if snap.root.ConfigHash != (common.Hash{}) {
+		if config, err = snap.GetChainConfig(); err != nil {
+			return err
+		}
+	}
+	if err = snap.apply(config, header, headerExtra); err != nil {
+		return err
+	}
+	root, err := snap.Root()
+	if err != nil {
+		return err
+	}
+	if root != headerExtra.Root {
+		return errInvalidTrieRoot
+	}
+	if err = senate.commitSnapshot(snap, root); err != nil {
+		return &snapshotCommitError{err: err}
+	}
+	senate.cacheSnapshot(snap)
+	return nil
 }
 
-// Gets the chain config by tire node hash value.
-func (senate *Senate) chainConfigByHash(configHash common.Hash) (params.SenateConfig, error) {
-	zero := common.Hash{}
-	if configHash == zero {
-		return *senate.config, nil
+// RepairSnapshot rebuilds the snapshots of the blocks after from up to to by
+// replaying their headers on the snapshot of block from, which must be intact.
+// The root of every block is checked against its header and the trie nodes are
+// written again, overwriting the ones left corrupted by a partial write.
+func (senate *Senate) RepairSnapshot(chain consensus.ChainHeaderReader, from, to uint64) error {
+	if from >= to {
+		return errInvalidRepairRange
 	}
-
-	snap := Snapshot{
-		db:   trie.NewDatabase(senate.db),
-		root: Root{ConfigHash: configHash},
+	parent := chain.GetHeaderByNumber(from)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
 	}
-	config, err := snap.GetChainConfig()
-	if err != nil {
-		return params.SenateConfig{}, ErrChainConfigMissing
+	snap, _ := senate.loadSnapshot(Root{})
+	if from > 0 {
+		headerExtra, err := decodeHeaderExtra(parent)
+		if err != nil {
+			return err
+		}
+		if snap, _ = senate.loadSnapshot(headerExtra.Root); !snap.available() {
+			return errSnapshotUnavailable
+		}
 	}
-	return config, nil
+	for number := from + 1; number <= to; number++ {
+		header := chain.GetHeaderByNumber(number)
+		if header == nil || header.ParentHash != parent.Hash() {
+			return consensus.ErrUnknownAncestor
+		}
+		if err := senate.replaySnapshot(snap, header); err != nil {
+			return err
+		}
+		parent = header
+	}
+	log.Info("[DPOS] Repaired snapshots", "from", from, "to", to)
+	return nil
 }
 
-// Elect validators in first block for epoch.
-func (senate *Senate) tryElect(config params.SenateConfig, state *state.StateDB, header *types.Header,
-	snap *Snapshot, headerExtra *HeaderExtra) error {
+// PruneSnapshots deletes the snapshots of the canonical blocks below keepFromBlock
+// from the database. The snapshots of the first blocks of epochs are retained,
+// as are the trie nodes shared with any retained snapshot. Snapshots aren't
+// committed while pruning.
+func (senate *Senate) PruneSnapshots(chain consensus.ChainHeaderReader, keepFromBlock uint64) error {
+	senate.pruneLock.Lock()
+	defer senate.pruneLock.Unlock()
 
-	// Is come to new epoch?
-	if header.Time != headerExtra.EpochTime {
+	head := chain.CurrentHeader().Number.Uint64()
+	if keepFromBlock > head {
+		keepFromBlock = head
+	}
+	pruned := readPrunedSnapshots(senate.db)
+	if pruned >= keepFromBlock {
 		return nil
 	}
 
-	// Find not active validators
-	needKickOutValidators := make(SortableAddresses, 0)
-	if header.Number.Uint64() <= 1 {
-		for _, validator := range config.Validators {
-			if err := snap.BecomeCandidate(validator); err != nil {
-				return err
-			}
-			if err := snap.Delegate(validator, validator); err != nil {
-				return err
-			}
-			headerExtra.CurrentBlockDelegates = append(headerExtra.CurrentBlockDelegates, Delegate{
-				Delegator: validator,
-				Candidate: validator,
-			})
-			headerExtra.CurrentBlockCandidates = append(headerExtra.CurrentBlockCandidates, validator)
+	// The nodes of the first blocks of epochs are retained forever, they are
+	// added to a bloom filter once instead of being marked again every pruning.
+	// A false positive only keeps a node which could have been deleted
+	if senate.retainBloom == nil {
+		bloom, err := bloomfilter.New(uint64(retainBloomBits), uint64(retainBloomFuncs))
+		if err != nil {
+			return err
+		}
+		senate.retainBloom, senate.retainedTo = bloom, 1
+	}
+	from := senate.retainedTo
+	if pruned < from {
+		from = pruned
+	}
+	if from == 0 {
+		from = 1
+	}
+	var parentEpoch uint64
+	if from > 1 {
+		parent := chain.GetHeaderByNumber(from - 1)
+		if parent == nil {
+			return consensus.ErrUnknownAncestor
+		}
+		parentExtra, err := decodeHeaderExtra(parent)
+		if err != nil {
+			return err
 		}
+		parentEpoch = parentExtra.Epoch
+	}
 
-		headerExtra.CurrentBlockDelegates = delegatesDistinct(headerExtra.CurrentBlockDelegates)
-		headerExtra.CurrentBlockCandidates = addressesDistinct(headerExtra.CurrentBlockCandidates)
-	} else {
-		minMint := big.NewInt(int64(config.Epoch / config.Period / config.MaxValidatorsCount / 2))
-		validators, err := snap.CountMinted(headerExtra.Epoch - 1)
+	// Mark the trie nodes of the recent snapshots, the children of a node are
+	// walked once only as long as it's remembered
+	recent := make(map[common.Hash]struct{})
+	var stale, retained []Root
+	for number := from; number <= head; number++ {
+		header := chain.GetHeaderByNumber(number)
+		if header == nil {
+			return consensus.ErrUnknownAncestor
+		}
+		headerExtra, err := decodeHeaderExtra(header)
 		if err != nil {
 			return err
 		}
-		for _, validator := range validators {
-			if validator.Weight.Cmp(minMint) == -1 {
-				needKickOutValidators = append(needKickOutValidators, validator)
+		switch {
+		case number >= keepFromBlock:
+			err = senate.walkSnapshot(headerExtra.Root, func(hash common.Hash) bool {
+				if _, ok := recent[hash]; ok {
+					return false
+				}
+				recent[hash] = struct{}{}
+				return true
+			})
+			if err != nil {
+				return err
+			}
+		case number == 1 || headerExtra.Epoch != parentEpoch:
+			if number >= senate.retainedTo {
+				retained = append(retained, headerExtra.Root)
 			}
+		case number >= pruned:
+			stale = append(stale, headerExtra.Root)
 		}
+		parentEpoch = headerExtra.Epoch
 	}
-
-	// Kick out not active validators
-	if len(needKickOutValidators) > 0 {
-		safeSize := int(config.MaxValidatorsCount*2/3 + 1)
-		candidateCount, _ := snap.EnoughCandidates(safeSize + len(needKickOutValidators))
-		for i, validator := range needKickOutValidators {
-			// Ensure candidate count greater than or equal to safeSize
-			if candidateCount <= safeSize {
-				log.Info("[DPOS] No more candidate can be kick out",
-					"prevEpochID", headerExtra.Epoch-1,
-					"candidateCount", candidateCount, "needKickOutCount", len(needKickOutValidators)-i,"Epoch", config.Epoch,"Period", config.Period)
-				return nil
+	seen, _ := lru.New(retainWalkCache)
+	for _, root := range retained {
+		err := senate.walkSnapshot(root, func(hash common.Hash) bool {
+			if seen.Contains(hash) {
+				return false
 			}
+			seen.Add(hash, nil)
+			senate.retainBloom.Add(nodeBloomHasher(hash))
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	senate.retainedTo = keepFromBlock
 
-			if err := snap.KickOutCandidate(validator.Address); err != nil {
+	// Sweep the nodes only reachable from the stale snapshots, the ones left
+	// missing by an interrupted pruning are skipped
+	deleted := make(map[common.Hash]struct{})
+	for _, root := range stale {
+		err := senate.walkSnapshot(root, func(hash common.Hash) bool {
+			if _, ok := recent[hash]; ok {
+				return false
+			}
+			if senate.retainBloom.Contains(nodeBloomHasher(hash)) {
+				return false
+			}
+			if _, ok := deleted[hash]; ok {
+				return false
+			}
+			deleted[hash] = struct{}{}
+			return true
+		})
+		if _, missing := err.(*trie.MissingNodeError); err != nil && !missing {
+			return err
+		}
+	}
+	batch := senate.db.NewBatch()
+	for hash := range deleted {
+		rawdb.DeleteTrieNode(batch, hash)
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
 				return err
 			}
-
-			// If kick out success, candidateCount minus 1
-			candidateCount--
-			headerExtra.CurrentBlockKickOutCandidates = append(headerExtra.CurrentBlockKickOutCandidates, validator.Address)
-			log.Info("[DPOS] Kick out candidate",
-				"prevEpochID", headerExtra.Epoch-1, "candidate", validator, "mintCnt", validator.Weight.String())
+			batch.Reset()
 		}
 	}
-
-	// Elect next epoch validators by votes
-	//candidates, err := snap.TopCandidates(state, int(config.MaxValidatorsCount))
-
-
-	// Shuffle candidates of next epoch
-	seed := int64(binary.LittleEndian.Uint32(crypto.Keccak512(header.ParentHash.Bytes())))
-	candidates, err := snap.RandCandidates(seed, int(config.MaxValidatorsCount))
-	if err != nil {
+	if err := writePrunedSnapshots(batch, keepFromBlock); err != nil {
 		return err
 	}
-	r := rand.New(rand.NewSource(seed))
-	for i := len(candidates) - 1; i > 0; i-- {
-		j := int(r.Int31n(int32(i + 1)))
-		candidates[i], candidates[j] = candidates[j], candidates[i]
+	if err := batch.Write(); err != nil {
+		return err
 	}
-	headerExtra.CurrentEpochValidators = append(headerExtra.CurrentEpochValidators, candidates...)
-	return snap.SetValidators(headerExtra.CurrentEpochValidators)
+	log.Info("[DPOS] Pruned snapshots", "from", pruned, "to", keepFromBlock, "nodes", len(deleted))
+	return nil
 }
 
-// Credits the coinbase of the given block with the mining reward.
-func (senate *Senate) accumulateRewards(config params.SenateConfig, state *state.StateDB, header *types.Header) {
-	var blockReward *big.Int
-	number := header.Number.Uint64()
-	for _, reward := range config.Rewards {
-		blockReward = reward.Reward
-		if reward.Height > number {
-			break
-		}
-	}
+// commitSnapshot writes the snapshot with root to the database, waiting for
+// the pruning in progress to finish, which might otherwise delete the nodes
+// the snapshot shares with the pruned ones.
+func (senate *Senate) commitSnapshot(snap *Snapshot, root Root) error {
+	senate.pruneLock.RLock()
+	defer senate.pruneLock.RUnlock()
 
-	if blockReward == nil || blockReward.Cmp(big.NewInt(0)) <= 0 {
-		return
+	return snap.Commit(root)
+}
+
+// nodeBloomHasher converts a trie node hash into the 64 bit hash required by
+// the bloom filter.
+type nodeBloomHasher common.Hash
+
+func (h nodeBloomHasher) Write(p []byte) (n int, err error) { panic("not implemented") }
+func (h nodeBloomHasher) Sum(b []byte) []byte               { panic("not implemented") }
+func (h nodeBloomHasher) Reset()                            { panic("not implemented") }
+func (h nodeBloomHasher) BlockSize() int                    { panic("not implemented") }
+func (h nodeBloomHasher) Size() int                         { return 8 }
+func (h nodeBloomHasher) Sum64() uint64                     { return binary.BigEndian.Uint64(h[:8]) }
+
+// schedulePruning prunes the snapshots older than the configured retention depth
+// in background, once per epoch.
+func (senate *Senate) schedulePruning(chain consensus.ChainHeaderReader, header *types.Header, epoch uint64) {
+	retention := senate.options.SnapshotRetention
+	if retention == 0 || header.Number.Uint64() <= retention {
+		return
+	}
+	last := atomic.LoadUint64(&senate.pruneEpoch)
+	if epoch <= last || !atomic.CompareAndSwapUint64(&senate.pruneEpoch, last, epoch) {
+		return
+	}
+	if !senate.track(1) {
+		return
+	}
+	go func() {
+		defer senate.running.Done()
+		if err := senate.PruneSnapshots(chain, header.Number.Uint64()-retention); err != nil {
+			log.Warn("[DPOS] Failed to prune snapshots", "err", err)
+		}
+	}()
+}
+
+// walkSnapshot visits the hashes of the trie nodes of snapshot stored in the
+// database, the children of a node are skipped if visit returns false.
+func (senate *Senate) walkSnapshot(root Root, visit func(hash common.Hash) bool) error {
+	for _, hash := range root.hashes() {
+		if hash == (common.Hash{}) || hash == types.EmptyRootHash {
+			continue
+		}
+		t, err := trie.New(hash, senate.triedb)
+		if err != nil {
+			return err
+		}
+		it := t.NodeIterator(nil)
+		for descend := true; it.Next(descend); {
+			descend = true
+			if hash := it.Hash(); hash != (common.Hash{}) {
+				descend = visit(hash)
+			}
+		}
+		if err := it.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPrunedSnapshots retrieves the number of the first block whose snapshot
+// isn't pruned.
+func readPrunedSnapshots(db ethdb.KeyValueReader) uint64 {
+	data, _ := db.Get(snapshotPrunedKey)
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// writePrunedSnapshots stores the number of the first block whose snapshot
+// isn't pruned.
+func writePrunedSnapshots(db ethdb.KeyValueWriter, number uint64) error {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], number)
+	return db.Put(snapshotPrunedKey, data[:])
+}
+
+// Reports the epoch and the count of validators in snapshot of the latest block.
+func updateEpochMetrics(snap *Snapshot, headerExtra HeaderExtra) {
+	if !metrics.Enabled {
+		return
+	}
+	epochGauge.Update(int64(headerExtra.Epoch))
+	if validators, err := snap.GetValidators(); err == nil {
+		validatorsGauge.Update(int64(len(validators)))
+	}
+}
+
+// Close terminates any background threads maintained by the consensus engine.
+// In-flight verifications are cancelled and waited for, it's safe to call more
+// than once and concurrently. Snapshots are committed to the database once
+// created so there is nothing to flush, the database is closed by its owner.
+func (senate *Senate) Close() error {
+	senate.closeLock.Lock()
+	senate.closed = true
+	senate.cancel()
+	senate.closeLock.Unlock()
+
+	senate.running.Wait()
+	return nil
+}
+
+// track registers n background goroutines to be waited for by Close, false
+// if the engine is already closed.
+func (senate *Senate) track(n int) bool {
+	senate.closeLock.Lock()
+	defer senate.closeLock.Unlock()
+
+	if senate.closed {
+		return false
+	}
+	senate.running.Add(n)
+	return true
+}
+
+// APIs returns the RPC APIs this consensus engine provides.
+func (senate *Senate) APIs(chain consensus.ChainHeaderReader) []rpc.API {
+	api := &API{chain: chain, senate: senate}
+	return []rpc.API{{
+		Namespace: "dpos",
+		Version:   "1.0",
+		Service:   api,
+		Public:    true,
+	}, {
+		Namespace: "senate",
+		Version:   "1.0",
+		Service:   api,
+		Public:    true,
+	}}
+}
+
+// Authorize injects a private key into the consensus engine to mint new blocks
+// with. It may be called at runtime to swap the key, e.g. to rotate it without
+// restarting, the replaced key isn't kept.
+func (senate *Senate) Authorize(signer common.Address, signFn SignerFn) {
+	senate.lock.Lock()
+	defer senate.lock.Unlock()
+
+	senate.signer = signer
+	senate.signFn = signFn
+	senate.removeFallback(signer)
+}
+
+// AuthorizeWallet injects the key of signer held by the wallet, e.g. a clef
+// signer of accounts/external keeping the key out of process. Headers are sent
+// to the wallet for signing as accounts.MimetypeSenate.
+func (senate *Senate) AuthorizeWallet(signer common.Address, wallet accounts.Wallet) error {
+	if !wallet.Contains(accounts.Account{Address: signer}) {
+		return accounts.ErrUnknownAccount
+	}
+	senate.Authorize(signer, wallet.SignData)
+	return nil
+}
+
+// AuthorizeFallback injects an additional private key into the consensus engine,
+// which seals the slots it is in turn for while the primary key isn't.
+func (senate *Senate) AuthorizeFallback(signer common.Address, signFn SignerFn) {
+	senate.lock.Lock()
+	defer senate.lock.Unlock()
+
+	if signer == senate.signer {
+		senate.signFn = signFn
+		return
+	}
+	senate.removeFallback(signer)
+	senate.fallbacks = append(senate.fallbacks, authorizedSigner{address: signer, signFn: signFn})
+}
+
+// Deauthorize removes an additional private key from the consensus engine.
+func (senate *Senate) Deauthorize(signer common.Address) {
+	senate.lock.Lock()
+	defer senate.lock.Unlock()
+
+	senate.removeFallback(signer)
+}
+
+// removeFallback removes the additional key of signer, the caller must hold
+// the signer lock.
+func (senate *Senate) removeFallback(signer common.Address) {
+	for idx, fallback := range senate.fallbacks {
+		if fallback.address == signer {
+			senate.fallbacks = append(senate.fallbacks[:idx:idx], senate.fallbacks[idx+1:]...)
+			return
+		}
+	}
+}
+
+// AddCheckpoint finalizes the epoch at the block with hash, which must be the
+// first block of the epoch. Chains conflicting with a checkpoint are rejected
+// whatever their length, guarding against long-range attacks rewriting old
+// epochs. A previous checkpoint of the epoch is replaced.
+func (senate *Senate) AddCheckpoint(epoch uint64, hash common.Hash) {
+	senate.checkpointLock.Lock()
+	defer senate.checkpointLock.Unlock()
+
+	if senate.checkpoints == nil {
+		senate.checkpoints = make(map[uint64]common.Hash)
+	}
+	senate.checkpoints[epoch] = hash
+}
+
+// checkpoint returns the hash of the first block of the epoch if the epoch is
+// finalized by a checkpoint.
+func (senate *Senate) checkpoint(epoch uint64) (common.Hash, bool) {
+	senate.checkpointLock.RLock()
+	defer senate.checkpointLock.RUnlock()
+
+	hash, ok := senate.checkpoints[epoch]
+	return hash, ok
+}
+
+// ReorgEvent is posted once the canonical head moves to a chain diverging from
+// the previous head, the snapshots after the common ancestor are replaced.
+type ReorgEvent struct {
+	Ancestor *types.Header // Common ancestor of both chains
+	OldHead  *types.Header // Canonical head before the reorg
+	NewHead  *types.Header // Canonical head after the reorg
+}
+
+// SubscribeReorg registers a subscription of ReorgEvent, so indexers of the
+// snapshots can roll back to the common ancestor. Reorgs are only noticed from
+// the canonical heads followed by WatchChainHead, so nothing is posted until
+// it runs, whatever blocks the engine verifies or finalizes.
+func (senate *Senate) SubscribeReorg(ch chan<- ReorgEvent) event.Subscription {
+	return senate.reorgFeed.Subscribe(ch)
+}
+
+// ChainHeadSubscriber is the chain posting its canonical head changes.
+type ChainHeadSubscriber interface {
+	consensus.ChainHeaderReader
+
+	// SubscribeChainHead registers a subscription of the canonical heads.
+	SubscribeChainHead(ch chan<- *types.Header) event.Subscription
+}
+
+// WatchChainHead follows the canonical head of the chain in background to post
+// the reorgs, until the engine is closed.
+func (senate *Senate) WatchChainHead(chain ChainHeadSubscriber) {
+	if !senate.track(1) {
+		return
+	}
+	heads := make(chan *types.Header, chainHeadChanSize)
+	sub := chain.SubscribeChainHead(heads)
+	senate.trackHead(chain, chain.CurrentHeader())
+	go func() {
+		defer senate.running.Done()
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case head := <-heads:
+				senate.trackHead(chain, head)
+			case <-sub.Err():
+				return
+			case <-senate.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// trackHead moves the canonical head to the header, and posts a ReorgEvent if
+// the header doesn't descend from the previous head.
+func (senate *Senate) trackHead(chain consensus.ChainHeaderReader, header *types.Header) {
+	senate.headLock.Lock()
+	old := senate.head
+	senate.head = header
+	senate.headLock.Unlock()
+
+	if old == nil || old.Hash() == header.Hash() || header.ParentHash == old.Hash() {
+		return
+	}
+	parent := func(header *types.Header) *types.Header {
+		return chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	}
+	ancestor := commonAncestor(old, header, parent)
+	switch {
+	case ancestor == nil:
+		log.Debug("[DPOS] Unknown ancestor of canonical head", "number", header.Number, "hash", header.Hash())
+	case ancestor.Hash() != old.Hash():
+		log.Info("[DPOS] Chain reorg", "ancestor", ancestor.Number, "old", old.Number, "new", header.Number)
+		senate.reorgFeed.Send(ReorgEvent{Ancestor: ancestor, OldHead: old, NewHead: header})
+	}
+}
+
+// commonAncestor walks back both chains to the header they share, nil if it
+// isn't found within reorgSearchDepth headers.
+func commonAncestor(a, b *types.Header, parent func(*types.Header) *types.Header) *types.Header {
+	for depth := 0; a != nil && b != nil && depth < reorgSearchDepth; depth++ {
+		switch a.Number.Cmp(b.Number) {
+		case 1:
+			a = parent(a)
+		case -1:
+			b = parent(b)
+		default:
+			if a.Hash() == b.Hash() {
+				return a
+			}
+			a, b = parent(a), parent(b)
+		}
+	}
+	return nil
+}
+
+// authorized returns the signing keys injected into the engine, the primary
+// one first.
+func (senate *Senate) authorized() []authorizedSigner {
+	senate.lock.RLock()
+	defer senate.lock.RUnlock()
+
+	signers := make([]authorizedSigner, 0, len(senate.fallbacks)+1)
+	if senate.signer != (common.Address{}) {
+		signers = append(signers, authorizedSigner{address: senate.signer, signFn: senate.signFn})
+	}
+	return append(signers, senate.fallbacks...)
+}
+
+// canSeal returns if any key injected into the engine is able to sign, i.e.
+// has both an address and a signing function.
+func (senate *Senate) canSeal() bool {
+	for _, signer := range senate.authorized() {
+		if signer.address != (common.Address{}) && signer.signFn != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// sealer returns the authorized key to seal the block at time after the given
+// one, which is the key in turn, the fallback key once the validators in turn
+// missed enough slots, or any key of a validator if out-of-turn sealing is
+// enabled. The primary key is returned if none of the keys may seal.
+func (senate *Senate) sealer(config params.SenateConfig, lastBlockHeader *types.Header, time uint64) (authorizedSigner, bool) {
+	signers := senate.authorized()
+	for _, signer := range signers {
+		if senate.inTurn(config, lastBlockHeader, time, signer.address) {
+			return signer, true
+		}
+	}
+	for _, signer := range signers {
+		if senate.isFallback(config, lastBlockHeader, time, signer.address) {
+			return signer, true
+		}
+	}
+	if config.NoTurnDelay > 0 {
+		for _, signer := range signers {
+			if senate.isValidator(config, lastBlockHeader, signer.address) {
+				return signer, true
+			}
+		}
+	}
+	if len(signers) == 0 {
+		return authorizedSigner{}, false
+	}
+	return signers[0], false
+}
+
+// InTurn returns if any authorized signer is allowed to seal the block after the
+// given one, out-of-turn validators are allowed as well if out-of-turn sealing
+// is enabled.
+func (senate *Senate) InTurn(lastBlockHeader *types.Header, now uint64) bool {
+	config, err := senate.chainConfig(lastBlockHeader)
+	if err != nil {
+		return false
+	}
+	if now <= config.GenesisTimestamp-config.Period {
+		return false
+	}
+
+	// Estimate the next block time
+	nexBlockTime := lastBlockHeader.Time + config.Period
+	if int64(nexBlockTime) < time.Now().Unix() {
+		nexBlockTime = uint64(time.Now().Unix())
+	}
+
+	_, ok := senate.sealer(config, lastBlockHeader, nexBlockTime)
+	return ok
+}
+
+func (senate *Senate) inTurn(config params.SenateConfig,
+	lastBlockHeader *types.Header, nexBlockTime uint64, signer common.Address) bool {
+
+	validators, epochTime, err := senate.signers(config, lastBlockHeader)
+	if err != nil {
+		return false
+	}
+
+	count := len(validators)
+	if count == 0 {
+		return false
+	}
+
+	idx := (nexBlockTime - epochTime) / config.Period % uint64(len(validators))
+	return validators[idx] == signer
+}
+
+// isFallback returns if the signer is the fallback leader of the block at time
+// after the given one. Once config.FallbackSlots slots were missed since the
+// last block, the other validators than the one in turn take over the following
+// slots one by one in an order shuffled by the hash of the last block.
+func (senate *Senate) isFallback(config params.SenateConfig,
+	lastBlockHeader *types.Header, time uint64, signer common.Address) bool {
+
+	if config.FallbackSlots == 0 || lastBlockHeader == nil || time <= lastBlockHeader.Time {
+		return false
+	}
+	slots := (time - lastBlockHeader.Time) / config.Period
+	if slots <= config.FallbackSlots {
+		return false
+	}
+	missed := slots - 1
+
+	validators, epochTime, err := senate.signers(config, lastBlockHeader)
+	if err != nil || len(validators) < 2 {
+		return false
+	}
+	inturn := validators[(time-epochTime)/config.Period%uint64(len(validators))]
+	order := make([]common.Address, 0, len(validators)-1)
+	for _, validator := range fallbackOrder(validators, lastBlockHeader.Hash()) {
+		if validator != inturn {
+			order = append(order, validator)
+		}
+	}
+	return order[(missed-config.FallbackSlots)%uint64(len(order))] == signer
+}
+
+// fallbackOrder returns the validators shuffled by the hash of the last block,
+// every node derives the same order of fallback leaders from it.
+func fallbackOrder(validators []common.Address, hash common.Hash) []common.Address {
+	order := make([]common.Address, len(validators))
+	copy(order, validators)
+
+	seed := int64(binary.LittleEndian.Uint32(crypto.Keccak512(hash.Bytes())))
+	r := rand.New(rand.NewSource(seed))
+	for i := len(order) - 1; i > 0; i-- {
+		j := int(r.Int31n(int32(i + 1)))
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// isValidator returns if the signer is the signing key of any validator of the
+// block after the given one.
+func (senate *Senate) isValidator(config params.SenateConfig, lastBlockHeader *types.Header, signer common.Address) bool {
+	validators, _, err := senate.signers(config, lastBlockHeader)
+	if err != nil {
+		return false
+	}
+	for _, validator := range validators {
+		if validator == signer {
+			return true
+		}
+	}
+	return false
+}
+
+// signers returns the signing keys of validators for the block after the given
+// one, in turn order, and the start time of the epoch.
+func (senate *Senate) signers(config params.SenateConfig, lastBlockHeader *types.Header) ([]common.Address, uint64, error) {
+	if lastBlockHeader == nil || lastBlockHeader.Number.Int64() == 0 {
+		return config.Validators, config.GenesisTimestamp, nil
+	}
+
+	headerExtra, err := decodeHeaderExtra(lastBlockHeader)
+	if err != nil {
+		return nil, 0, err
+	}
+	snap, err := senate.loadSnapshot(headerExtra.Root)
+	if err != nil {
+		return nil, 0, err
+	}
+	validators, err := snap.GetSigners()
+	if err != nil {
+		return nil, 0, err
+	}
+	return validators, headerExtra.EpochTime, nil
+}
+
+// recentlySigned returns if the signer has minted any of the last
+// len(validators)/2 blocks of the epoch before the header.
+func (senate *Senate) recentlySigned(config params.SenateConfig,
+	header, parent *types.Header, signer common.Address) (bool, error) {
+
+	if !config.RecentSigners || parent == nil || parent.Number.Uint64() == 0 {
+		return false, nil
+	}
+	headerExtra, err := decodeHeaderExtra(header)
+	if err != nil {
+		return false, err
+	}
+	parentHeaderExtra, err := decodeHeaderExtra(parent)
+	if err != nil {
+		return false, err
+	}
+
+	// The window is reset when a new epoch begins
+	if headerExtra.Epoch != parentHeaderExtra.Epoch {
+		return false, nil
+	}
+
+	snap, err := senate.loadSnapshot(parentHeaderExtra.Root)
+	if err != nil {
+		return false, err
+	}
+	validators, err := snap.GetSigners()
+	if err != nil {
+		return false, err
+	}
+	validator, err := snap.ValidatorOf(signer)
+	if err != nil {
+		return false, err
+	}
+	recents, err := snap.LoadRecents(headerExtra.Epoch, parent.Number.Uint64(), len(validators)/2)
+	if err != nil {
+		return false, err
+	}
+	for _, recent := range recents {
+		if recent == validator {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// turnDifficulty returns the difficulty of the block signed by the signer.
+func (senate *Senate) turnDifficulty(config params.SenateConfig,
+	lastBlockHeader *types.Header, time uint64, signer common.Address) *big.Int {
+
+	if config.NoTurnDelay == 0 && config.FallbackSlots == 0 {
+		return big.NewInt(defaultDifficulty)
+	}
+	if senate.inTurn(config, lastBlockHeader, time, signer) {
+		return new(big.Int).Set(diffInTurn)
+	}
+	return new(big.Int).Set(diffNoTurn)
+}
+
+// isNewEpoch reports whether the block at the time starts a new epoch after
+// the epoch started at epochTime. Block-based epochs roll over every
+// EpochBlocks blocks since the first block instead.
+func isNewEpoch(config params.SenateConfig, epochTime, time, number uint64) bool {
+	if config.EpochBlocks > 0 {
+		return number > 1 && (number-1)%config.EpochBlocks == 0
+	}
+	duration := time - epochTime
+	return duration/config.Epoch >= 1 && duration%config.Epoch > 0
+}
+
+// Gets the chain config for the specified block height.
+func (senate *Senate) chainConfig(header *types.Header) (params.SenateConfig, error) {
+	if header == nil || header.Number.Int64() == 0 {
+		return *senate.config, nil
+	}
+
+	headerExtra, err := decodeHeaderExtra(header)
+	if err != nil {
+		return params.SenateConfig{}, err
+	}
+	return senate.chainConfigByHash(headerExtra.Root.ConfigHash)
+}
+
+// Gets the chain config by tire node hash value, the config of a hash never
+// changes so it's cached without invalidation.
+func (senate *Senate) chainConfigByHash(configHash common.Hash) (params.SenateConfig, error) {
+	zero := common.Hash{}
+	if configHash == zero {
+		return *senate.config, nil
+	}
+	if config, ok := senate.configs.Get(configHash); ok {
+		return config.(params.SenateConfig), nil
+	}
+
+	snap := Snapshot{
+		db:   trie.NewDatabase(senate.db),
+		root: Root{ConfigHash: configHash},
+	}
+	config, err := snap.GetChainConfig()
+	if err != nil {
+		return params.SenateConfig{}, ErrChainConfigMissing
+	}
+	senate.configs.Add(configHash, config)
+	return config, nil
+}
+
+// Elect validators in first block for epoch.
+func (senate *Senate) tryElect(config params.SenateConfig, state *state.StateDB, header *types.Header,
+	snap *Snapshot, headerExtra *HeaderExtra) error {
+
+	// Is come to new epoch?
+	if header.Time != headerExtra.EpochTime {
+		return nil
+	}
+	if err := snap.ResetRegistrations(headerExtra.Epoch); err != nil {
+		return err
+	}
+
+	// Find not active validators
+	needKickOutValidators := make(SortableAddresses, 0)
+	if header.Number.Uint64() <= 1 {
+		for idx, validator := range config.Validators {
+			if idx < len(config.InitialStakes) && config.InitialStakes[idx] != nil && config.InitialStakes[idx].Sign() > 0 {
+				if err := snap.SetDeposit(validator, config.InitialStakes[idx]); err != nil {
+					return err
+				}
+				headerExtra.CurrentBlockDeposits = append(headerExtra.CurrentBlockDeposits, Deposit{
+					Candidate: validator,
+					Amount:    new(big.Int).Set(config.InitialStakes[idx]),
+				})
+			}
+			if err := snap.BecomeCandidate(validator); err != nil {
+				return err
+			}
+			if err := snap.Delegate(validator, validator); err != nil {
+				return err
+			}
+			headerExtra.CurrentBlockDelegates = append(headerExtra.CurrentBlockDelegates, Delegate{
+				Delegator: validator,
+				Candidate: validator,
+			})
+			headerExtra.CurrentBlockCandidates = append(headerExtra.CurrentBlockCandidates, validator)
+		}
+
+		headerExtra.CurrentBlockDelegates = delegatesDistinct(headerExtra.CurrentBlockDelegates)
+		headerExtra.CurrentBlockCandidates = addressesDistinct(headerExtra.CurrentBlockCandidates)
+	} else {
+		// Votes not renewed in the last epoch lose weight
+		if err := snap.DecayVotes(config.VoteDecayPercent); err != nil {
+			return err
+		}
+
+		validators, err := snap.CountMinted(headerExtra.Epoch - 1)
+		if err != nil {
+			return err
+		}
+		minMint := minMintCount(config, len(validators))
+		for _, validator := range validators {
+			if validator.Weight.Cmp(minMint) == -1 {
+				needKickOutValidators = append(needKickOutValidators, validator)
+			}
+		}
+	}
+
+	// Kick out not active validators. Chains without MinMintPercent keep the
+	// legacy rule, which counts a single candidate and skips the election once
+	// no more candidate can be kicked out
+	if len(needKickOutValidators) > 0 {
+		safeSize := int(config.MaxValidatorsCount*2/3 + 1)
+		var candidateCount int
+		if config.MinMintPercent > 0 {
+			candidateCount, _ = snap.CountCandidates(safeSize + len(needKickOutValidators))
+		} else {
+			candidateCount, _ = snap.EnoughCandidates(safeSize + len(needKickOutValidators))
+		}
+		for i, validator := range needKickOutValidators {
+			// Ensure candidate count greater than or equal to safeSize
+			if candidateCount <= safeSize {
+				log.Info("[DPOS] No more candidate can be kick out",
+					"prevEpochID", headerExtra.Epoch-1,
+					"candidateCount", candidateCount, "needKickOutCount", len(needKickOutValidators)-i,"Epoch", config.Epoch,"Period", config.Period)
+				if config.MinMintPercent == 0 {
+					return nil
+				}
+				break
+			}
+
+			if err := snap.KickOutCandidate(validator.Address); err != nil {
+				return err
+			}
+
+			// If kick out success, candidateCount minus 1
+			candidateCount--
+			headerExtra.CurrentBlockKickOutCandidates = append(headerExtra.CurrentBlockKickOutCandidates, validator.Address)
+			log.Info("[DPOS] Kick out candidate",
+				"prevEpochID", headerExtra.Epoch-1, "candidate", validator, "mintCnt", validator.Weight.String())
+		}
+	}
+
+	// Elect next epoch validators by votes
+	//candidates, err := snap.TopCandidates(state, int(config.MaxValidatorsCount))
+
+
+	// The validators count approved in the last epoch applies to this election,
+	// candidates are ranked so the set only drops or promotes the tail
+	count, minSelfStake := config.MaxValidatorsCount, config.MinSelfStakePercent
+	if n := len(headerExtra.ChainConfig); n > 0 {
+		count = headerExtra.ChainConfig[n-1].MaxValidatorsCount
+		minSelfStake = headerExtra.ChainConfig[n-1].MinSelfStakePercent
+	}
+
+	// Candidates without enough self-stake are not eligible
+	var excluded []common.Address
+	if minSelfStake > 0 {
+		if state == nil {
+			return errors.New("state required by min self-stake")
+		}
+		var err error
+		excluded, err = snap.SelfStakeShortfalls(state, minSelfStake)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Shuffle candidates of next epoch
+	seed := int64(binary.LittleEndian.Uint32(crypto.Keccak512(header.ParentHash.Bytes())))
+	var candidates SortableAddresses
+	var err error
+
+	// Candidates of owners at the cap are skipped in the order of election,
+	// so all the candidates are ranked
+	n := int(count)
+	if config.MaxOwnerValidators > 0 {
+		all, err := snap.GetCandidates()
+		if err != nil {
+			return err
+		}
+		n = len(all)
+	}
+	if config.ReputationWeight > 0 {
+		if state == nil {
+			return errors.New("state required by reputation election")
+		}
+		reputations, err := snap.Reputations(headerExtra.Epoch, config.ReputationEpochs)
+		if err != nil {
+			return err
+		}
+		candidates, err = snap.ReputableCandidates(state, n, excluded, reputations, config.ReputationWeight)
+		if err != nil {
+			return err
+		}
+	} else if config.WeightedElection {
+		if state == nil {
+			return errors.New("state required by weighted election")
+		}
+		candidates, err = snap.WeightedCandidates(state, seed, n, excluded)
+	} else {
+		candidates, err = snap.RandCandidates(seed, n, excluded)
+	}
+	if err != nil {
+		return err
+	}
+	if config.MaxOwnerValidators > 0 {
+		if candidates, err = capOwners(snap, candidates, config.MaxOwnerValidators, int(count)); err != nil {
+			return err
+		}
+	}
+	r := rand.New(rand.NewSource(seed))
+	for i := len(candidates) - 1; i > 0; i-- {
+		j := int(r.Int31n(int32(i + 1)))
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	}
+
+	// Refuse to shrink the validators below the floor, a minority left by
+	// slashing or inactivity must not take over, the previous validators
+	// are elected again
+	if config.MinValidators > 0 && uint64(len(candidates)) < config.MinValidators {
+		validators, err := snap.GetValidators()
+		if err != nil {
+			return err
+		}
+		if len(candidates) < len(validators) {
+			log.Warn("[DPOS] Election below the validators floor, keeping the validators", "epoch", headerExtra.Epoch,
+				"elected", len(candidates), "validators", len(validators), "min", config.MinValidators)
+			candidates = validators
+		}
+	}
+
+	// Keep the previous validators trie if nobody joined or left the set,
+	// an empty election never replaces the current validators. The header
+	// still carries them as the checkpoint of the epoch
+	if config.ReuseValidators {
+		if len(candidates) == 0 {
+			return checkpointValidators(snap, headerExtra)
+		}
+		validators, err := snap.GetValidators()
+		if err == nil && sameValidators(validators, candidates) && !signersRotated(snap) {
+			log.Debug("[DPOS] Elected validators unchanged", "epoch", headerExtra.Epoch)
+			headerExtra.CurrentEpochValidators = validators
+			return nil
+		}
+	}
+
+	headerExtra.CurrentEpochValidators = append(headerExtra.CurrentEpochValidators, candidates...)
+	if err = snap.SetValidators(headerExtra.CurrentEpochValidators); err != nil {
+		return err
+	}
+	return snap.UpdateSigners()
+}
+
+// checkpointValidators embeds the current validators into the header extra of
+// the first block of epoch.
+func checkpointValidators(snap *Snapshot, headerExtra *HeaderExtra) error {
+	validators, err := snap.GetValidators()
+	if err != nil {
+		return err
+	}
+	headerExtra.CurrentEpochValidators = validators
+	return nil
+}
+
+// verifyCheckpoint checks that the validators carried by the first block of
+// epoch are the ones of the snapshot after the block, light clients track the
+// validator set by these checkpoints only.
+func verifyCheckpoint(snap *Snapshot, headerExtra HeaderExtra) error {
+	validators, err := snap.GetValidators()
+	if err != nil {
+		return err
+	}
+	if len(validators) != len(headerExtra.CurrentEpochValidators) {
+		return errInvalidCheckpoint
+	}
+	for idx, validator := range validators {
+		if validator.Address != headerExtra.CurrentEpochValidators[idx].Address {
+			return errInvalidCheckpoint
+		}
+	}
+	return nil
+}
+
+// signersRotated reports whether any current validator rotated its signing key
+// during the last epoch.
+func signersRotated(snap *Snapshot) bool {
+	validators, err := snap.GetValidators()
+	if err != nil {
+		return false
+	}
+	signers, err := snap.GetSigners()
+	if err != nil {
+		return true
+	}
+	for idx, validator := range validators {
+		signer, err := snap.GetSigner(validator.Address)
+		if err != nil || signer != signers[idx] {
+			return true
+		}
+	}
+	return false
+}
+
+// minMintCount returns the minimum count of blocks a validator has to mint in
+// an epoch to remain a candidate. It is the configured percent of the blocks
+// expected from each of the validators, or the legacy half of the blocks
+// expected from a full validator set if not configured.
+func minMintCount(config params.SenateConfig, validatorCount int) *big.Int {
+	if config.MinMintPercent == 0 {
+		return big.NewInt(int64(epochSlots(config) / config.MaxValidatorsCount / 2))
+	}
+	if validatorCount == 0 {
+		return big.NewInt(0)
+	}
+	expected := epochSlots(config) / uint64(validatorCount)
+	return new(big.Int).SetUint64(expected * config.MinMintPercent / 100)
+}
+
+// epochSlots returns the number of block slots in an epoch.
+func epochSlots(config params.SenateConfig) uint64 {
+	if config.EpochBlocks > 0 {
+		return config.EpochBlocks
+	}
+	return config.Epoch / config.Period
+}
+
+// futureDrift returns the time a header may be ahead of the local clock before
+// it's rejected as a future block.
+func (senate *Senate) futureDrift() time.Duration {
+	if senate.config.AllowedFutureDrift == 0 {
+		return futureDrift
+	}
+	return time.Duration(senate.config.AllowedFutureDrift) * time.Second
+}
+
+// verifyExtraSize checks the extra-data of header doesn't exceed the configured
+// max size.
+func (senate *Senate) verifyExtraSize(header *types.Header) error {
+	if max := senate.config.MaxExtraSize; max > 0 && uint64(len(header.Extra)) > max {
+		return fmt.Errorf("%w: %d > %d", errExtraTooLong, len(header.Extra), max)
+	}
+	return nil
+}
+
+// retryRead runs the snapshot reads of fn, transient failures are retried up
+// to the configured number of times with an exponential backoff.
+func (senate *Senate) retryRead(ctx context.Context, fn func() error) error {
+	err := fn()
+	delay := readRetryDelay
+	for retry := uint64(0); retry < senate.options.ReadRetries && isTransientError(err); retry++ {
+		log.Debug("[DPOS] Retrying snapshot read", "retry", retry+1, "delay", common.PrettyDuration(delay), "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		err = fn()
+	}
+	if isTransientError(err) {
+		return &snapshotReadError{err: err}
+	}
+	return err
+}
+
+// verifyWorkers returns the number of goroutines checking a batch of headers.
+func (senate *Senate) verifyWorkers() int {
+	if senate.options.VerifyWorkers == 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return int(senate.options.VerifyWorkers)
+}
+
+// blockPeriod returns the min seconds between the header and its parent, blocks
+// without transactions wait for the empty block period if it is longer.
+func blockPeriod(config params.SenateConfig, header *types.Header) uint64 {
+	if header.TxHash == types.EmptyRootHash && config.MinEmptyBlockPeriod > config.Period {
+		return config.MinEmptyBlockPeriod
+	}
+	return config.Period
+}
+
+// proposalExpireEpoch returns the epoch since which a proposal submitted in the
+// epoch expires, 0 means the proposal never expires.
+func proposalExpireEpoch(config params.SenateConfig, epoch uint64) uint64 {
+	if config.ProposalEpochs == 0 {
+		return 0
+	}
+	return epoch + config.ProposalEpochs
+}
+
+// sameValidators reports whether both lists contain the same set of addresses.
+func sameValidators(a, b SortableAddresses) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[common.Address]struct{}, len(a))
+	for _, validator := range a {
+		set[validator.Address] = struct{}{}
+	}
+	for _, validator := range b {
+		if _, ok := set[validator.Address]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Gets the reward of minting the block at the specified height, nil if none or
+// rewards are disabled. If an initial reward is configured, it halves every
+// halving interval down to the min reward, otherwise the reward rules apply.
+func blockReward(config params.SenateConfig, number uint64) *big.Int {
+	if config.NoRewards {
+		return nil
+	}
+	if config.InitialReward != nil && config.InitialReward.Sign() > 0 {
+		reward := new(big.Int).Set(config.InitialReward)
+		if config.HalvingInterval > 0 {
+			reward.Rsh(reward, uint(number/config.HalvingInterval))
+		}
+		if config.MinReward != nil && reward.Cmp(config.MinReward) < 0 {
+			reward.Set(config.MinReward)
+		}
+		if reward.Sign() <= 0 {
+			return nil
+		}
+		return reward
+	}
+
+	var blockReward *big.Int
+	for _, reward := range config.Rewards {
+		blockReward = reward.Reward
+		if reward.Height > number {
+			break
+		}
+	}
+
+	if blockReward == nil || blockReward.Cmp(big.NewInt(0)) <= 0 {
+		return nil
+	}
+	return new(big.Int).Set(blockReward)
+}
+
+// Burns the base fee of the gas consumed by the block. Transactions pay the
+// whole gas price to the coinbase, so the burnt part is taken back from it.
+func burnBaseFee(state *state.StateDB, header *types.Header) {
+	if header.BaseFee == nil || header.GasUsed == 0 {
+		return
+	}
+	burnt := new(big.Int).Mul(header.BaseFee, new(big.Int).SetUint64(header.GasUsed))
+	state.SubBalance(header.Coinbase, burnt)
+}
+
+// Credits the validator of the given block with the mining reward. If reward
+// sharing is enabled, the validator keeps the commission and the rest goes to
+// its delegators in proportion to their stakes fixed at the election, the
+// remainder of rounding down goes to the validator. With pending rewards the
+// shares of delegators accrue in the snapshot until withdrawn. The treasury
+// share is paid before all. The share of the validator is credited to its
+// reward address if redirected.
+func (senate *Senate) accumulateRewards(config params.SenateConfig, state *state.StateDB,
+	snap *Snapshot, header *types.Header, validator common.Address, headerExtra *HeaderExtra) error {
+
+	reward := blockReward(config, header.Number.Uint64())
+	if reward == nil {
+		return nil
+	}
+	if share := treasuryReward(config, reward); share.Sign() > 0 {
+		state.AddBalance(config.Treasury, share)
+		reward.Sub(reward, share)
+	}
+	if config.RewardSharing {
+		delegators, err := snap.GetDelegators(validator)
+		if err != nil {
+			return err
+		}
+		// Delegators who only locked amounts for the validator share too
+		bonds, err := snap.GetBonds(validator)
+		if err != nil {
+			return err
+		}
+		for _, bond := range bonds {
+			if !containsAddress(delegators, bond.Delegator) {
+				delegators = append(delegators, bond.Delegator)
+			}
+		}
+		stakes := make([]*big.Int, len(delegators))
+		for idx, delegator := range delegators {
+			if stakes[idx], err = snap.GetStake(validator, delegator); err != nil {
+				return err
+			}
+		}
+		shares := delegatorRewards(config, reward, stakes)
+		for idx, share := range shares {
+			reward.Sub(reward, share)
+			if !config.PendingRewards {
+				state.AddBalance(delegators[idx], share)
+				continue
+			}
+			if share.Sign() == 0 {
+				continue
+			}
+			if err = snap.AccrueReward(delegators[idx], share); err != nil {
+				return err
+			}
+			headerExtra.CurrentBlockRewards = append(headerExtra.CurrentBlockRewards, Reward{
+				Delegator: delegators[idx],
+				Amount:    share,
+			})
+		}
+	}
+	payout, err := snap.GetRewardAddress(validator)
+	if err != nil {
+		return err
+	}
+	state.AddBalance(payout, reward)
+	log.Info("[DPOS] Accumulate rewards", "address", validator, "payout", payout, "amount", reward)
+	return nil
+}
+
+// treasuryReward returns the share of the block reward paid to treasury, zero
+// if no treasury is configured. It's rounded down.
+func treasuryReward(config params.SenateConfig, reward *big.Int) *big.Int {
+	if config.Treasury == (common.Address{}) || config.TreasuryPercent == 0 {
+		return new(big.Int)
+	}
+	percent := config.TreasuryPercent
+	if percent > 100 {
+		percent = 100
+	}
+	share := new(big.Int).Mul(reward, new(big.Int).SetUint64(percent))
+	return share.Div(share, big.NewInt(100))
+}
+
+// payProposalRewards pays the proposal reward from treasury to each validator
+// declared on the approved proposal in the epoch, whatever its decision. The
+// declarations are paid in the order of the declare trie while the treasury
+// balance covers the reward.
+func payProposalRewards(config params.SenateConfig, state *state.StateDB, snap *Snapshot,
+	proposalHash common.Hash, epoch uint64) error {
+
+	reward := config.ProposalReward
+	if reward == nil || reward.Sign() <= 0 || config.Treasury == (common.Address{}) {
+		return nil
+	}
+	declarations, err := snap.GetDeclarations(proposalHash, epoch)
+	if err != nil {
+		return err
+	}
+	for _, declaration := range declarations {
+		if state.GetBalance(config.Treasury).Cmp(reward) < 0 {
+			log.Warn("[DPOS] Treasury can't cover proposal rewards", "hash", proposalHash)
+			break
+		}
+		state.SubBalance(config.Treasury, reward)
+		state.AddBalance(declaration.Declarer, reward)
+	}
+	return nil
+}
+
+// fixStakes fixes the vote weights of the delegators of the validators elected
+// in the first block of an epoch, the block rewards of the epoch are shared by
+// them. The amounts locked for a validator add to the weights, ordered by
+// delegator. Votes cast later in the epoch earn no share until the next election.
+func (senate *Senate) fixStakes(config params.SenateConfig, state *state.StateDB, header *types.Header,
+	snap *Snapshot, headerExtra *HeaderExtra) error {
+
+	if !config.RewardSharing || header.Time != headerExtra.EpochTime {
+		return nil
+	}
+	validators, err := snap.GetValidators()
+	if err != nil {
+		return err
+	}
+	var stakes []Stake
+	for _, validator := range validators {
+		delegators, err := snap.GetDelegators(validator.Address)
+		if err != nil {
+			return err
+		}
+		var own []Stake
+		for _, delegator := range delegators {
+			weight, err := snap.VoteWeight(state, delegator)
+			if err != nil {
+				return err
+			}
+			if weight.Sign() == 0 {
+				continue
+			}
+			own = append(own, Stake{Candidate: validator.Address, Delegator: delegator, Amount: weight})
+		}
+		bonds, err := snap.GetBonds(validator.Address)
+		if err != nil {
+			return err
+		}
+		stakes = append(stakes, mergeBonds(own, bonds)...)
+	}
+	if err = snap.SetStakes(stakes); err != nil {
+		return err
 	}
-	reward := new(big.Int).Set(blockReward)
-	state.AddBalance(header.Coinbase, reward)
-	log.Info("[DPOS] Accumulate rewards", "address", header.Coinbase, "amount", reward)
+	headerExtra.CurrentBlockStakes = stakes
+	return nil
+}
+
+// mergeBonds adds the bonds of a validator to its stakes, both ordered by
+// delegator. A delegator both voting and bonding holds one stake of the sum.
+func mergeBonds(stakes []Stake, bonds []Bond) []Stake {
+	if len(bonds) == 0 {
+		return stakes
+	}
+	merged := make([]Stake, 0, len(stakes)+len(bonds))
+	for len(stakes) > 0 || len(bonds) > 0 {
+		order := 1
+		if len(bonds) == 0 {
+			order = -1
+		} else if len(stakes) > 0 {
+			order = bytes.Compare(stakes[0].Delegator.Bytes(), bonds[0].Delegator.Bytes())
+		}
+		switch {
+		case order < 0:
+			merged = append(merged, stakes[0])
+			stakes = stakes[1:]
+		case order > 0:
+			merged = append(merged, Stake{Candidate: bonds[0].Candidate, Delegator: bonds[0].Delegator, Amount: bonds[0].Amount})
+			bonds = bonds[1:]
+		default:
+			stake := stakes[0]
+			stake.Amount = new(big.Int).Add(stake.Amount, bonds[0].Amount)
+			merged = append(merged, stake)
+			stakes, bonds = stakes[1:], bonds[1:]
+		}
+	}
+	return merged
+}
+
+// delegatorRewards splits the block reward without commission among the
+// delegators by their stakes, each share is rounded down.
+func delegatorRewards(config params.SenateConfig, reward *big.Int, stakes []*big.Int) []*big.Int {
+	total := big.NewInt(0)
+	for _, stake := range stakes {
+		total.Add(total, stake)
+	}
+	if total.Sign() == 0 || config.Commission >= 100 {
+		return nil
+	}
+
+	shared := new(big.Int).Mul(reward, new(big.Int).SetUint64(100-config.Commission))
+	shared.Div(shared, big.NewInt(100))
+	shares := make([]*big.Int, len(stakes))
+	for idx, stake := range stakes {
+		shares[idx] = new(big.Int).Mul(shared, stake)
+		shares[idx].Div(shares[idx], total)
+	}
+	return shares
+}
+
+// TotalEmitted returns the supply emitted from the genesis up to the specified
+// block, net of burns. Block rewards are recomputed from the reward rules in
+// effect at each block, including the treasury share. The base fee of the gas
+// consumed and the stake slashed without a slash fund are burnt. Proposal
+// rewards are paid from treasury, and deposits, bonds, refunds and pending
+// rewards are only locked, so none of them change the supply.
+func (senate *Senate) TotalEmitted(chain consensus.ChainHeaderReader, upToBlock uint64) (*big.Int, error) {
+	total := big.NewInt(0)
+	config := *senate.config
+	configHash := common.Hash{}
+	for number := uint64(1); number <= upToBlock; number++ {
+		header := chain.GetHeaderByNumber(number)
+		if header == nil {
+			return nil, errUnknownBlock
+		}
+		headerExtra, err := decodeHeaderExtra(header)
+		if err != nil {
+			return nil, err
+		}
+		if reward := blockReward(config, number); reward != nil {
+			total.Add(total, reward)
+		}
+		if header.BaseFee != nil {
+			total.Sub(total, new(big.Int).Mul(header.BaseFee, new(big.Int).SetUint64(header.GasUsed)))
+		}
+		if config.SlashFund == (common.Address{}) {
+			for _, slash := range headerExtra.CurrentBlockSlashes {
+				total.Sub(total, slash.Amount)
+			}
+		}
+		if number == upToBlock {
+			break
+		}
+
+		// The rules of next block come from the snapshot of this block
+		if headerExtra.Root.ConfigHash != configHash {
+			configHash = headerExtra.Root.ConfigHash
+			if config, err = senate.chainConfigByHash(configHash); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return total, nil
 }
 
-// Process custom transactions, write into header.Extra.
+// Process custom transactions, write into header.Extra. Invalid operations are
+// rejected, an error is returned only if the snapshot fails midway through an
+// accepted one, which the block can't be built on.
 func (senate *Senate) processTransactions(config params.SenateConfig, state *state.StateDB, header *types.Header,
-	snap *Snapshot, headerExtra *HeaderExtra, txs []*types.Transaction, receipts []*types.Receipt) {
+	snap *Snapshot, headerExtra *HeaderExtra, txs []*types.Transaction, receipts []*types.Receipt) error {
 
 	if header.Number.Int64() <= 1 {
 		if err := snap.SetChainConfig(config); err != nil {
-			panic(err)
+			return err
 		}
 		headerExtra.ChainConfig = []params.SenateConfig{config}
 	}
 
+	// Custom transactions are applied strictly in the order of the block, an
+	// operation repeating an accepted one of the same block is rejected
 	count := 0
+	rejects := rejectConflicts(config, header)
+	operations := make(map[string]struct{})
 	for _, tx := range txs {
 		ctx, err := NewTransaction(tx)
 		if err != nil {
 			continue
 		}
+		key := operationKey(ctx)
+		if _, ok := operations[key]; ok && rejects {
+			log.Debug("[DPOS] Reject duplicate operation", "tx", tx.Hash(), "operation", key)
+			headerExtra.CurrentBlockRejects = append(headerExtra.CurrentBlockRejects, tx.Hash())
+			continue
+		}
 
+		// A custom transaction replayed or older than the last applied one of
+		// its sender is rejected
+		var sender common.Address
+		if config.CustomNonces {
+			next := uint64(0)
+			if sender, err = types.Sender(types.NewEIP155Signer(tx.ChainId()), tx); err == nil {
+				next, err = snap.GetCustomNonce(sender)
+			}
+			if err != nil || tx.Nonce() < next {
+				log.Debug("[DPOS] Reject replayed operation", "tx", tx.Hash(), "nonce", tx.Nonce(), "next", next)
+				headerExtra.CurrentBlockRejects = append(headerExtra.CurrentBlockRejects, tx.Hash())
+				continue
+			}
+		}
+
+		accepted := false
 		switch ctx.Type() {
 		case EventTransactionType:
 			switch ctx.(type) {
@@ -335,28 +1948,620 @@ func (senate *Senate) processTransactions(config params.SenateConfig, state *sta
 				if state.GetBalance(event.Delegator).Cmp(config.MinDelegatorBalance) == -1 {
 					break
 				}
-				if err = snap.Delegate(event.Delegator, event.Candidate); err == nil {
+				// A locked delegation counts by the amount bonded instead of
+				// the balance, so the vote can't be transferred away
+				if config.LockDelegation {
+					amount := event.Amount
+					if amount == nil {
+						amount = new(big.Int).Set(state.GetBalance(event.Delegator))
+					}
+					if amount.Sign() == 0 {
+						break
+					}
+					batch := &EventBatchDelegate{
+						Delegator: event.Delegator,
+						Bonds:     []Bond{{Candidate: event.Candidate, Delegator: event.Delegator, Amount: amount}},
+					}
+					if err = checkBatchDelegate(config, state, snap, batch); err != nil {
+						log.Debug("[DPOS] Reject delegate", "tx", tx.Hash(), "delegator", event.Delegator, "reason", err)
+						break
+					}
+					if err = lockBonds(config, state, snap, headerExtra, batch); err != nil {
+						return err
+					}
+					accepted = true
+					break
+				}
+				delegate := snap.Delegate
+				if config.StagedDelegation {
+					delegate = snap.StageDelegate
+				}
+				if err = delegate(event.Delegator, event.Candidate); err == nil {
 					headerExtra.CurrentBlockDelegates = append(headerExtra.CurrentBlockDelegates, Delegate{
 						Delegator: event.Delegator,
 						Candidate: event.Candidate,
 					})
+					accepted = true
 				}
-				count++
+			case *EventBatchDelegate:
+				event := ctx.(*EventBatchDelegate)
+				if err = checkBatchDelegate(config, state, snap, event); err != nil {
+					log.Debug("[DPOS] Reject batch delegate", "tx", tx.Hash(), "delegator", event.Delegator, "reason", err)
+					break
+				}
+				if err = lockBonds(config, state, snap, headerExtra, event); err != nil {
+					return err
+				}
+				accepted = true
+			case *EventUnbond:
+				event := ctx.(*EventUnbond)
+				amount, err := snap.GetBond(event.Candidate, event.Delegator)
+				if err != nil || amount.Sign() == 0 {
+					break
+				}
+				if err = snap.DebitBond(event.Candidate, event.Delegator, amount); err != nil {
+					return fmt.Errorf("unbond of %s: %w", event.Delegator.Hex(), err)
+				}
+				if config.UnbondingPeriod == 0 {
+					state.SubBalance(StakingAddress, amount)
+					state.AddBalance(event.Delegator, amount)
+				} else if err = snap.UnbondBonded(event.Delegator, amount, header.Time+config.UnbondingPeriod); err != nil {
+					return fmt.Errorf("unbond of %s: %w", event.Delegator.Hex(), err)
+				}
+				headerExtra.CurrentBlockUnbonds = append(headerExtra.CurrentBlockUnbonds, Bond{
+					Candidate: event.Candidate,
+					Delegator: event.Delegator,
+					Amount:    amount,
+				})
+				accepted = true
 			case *EventBecomeCandidate:
 				event := ctx.(*EventBecomeCandidate)
 				if state.GetBalance(event.Candidate).Cmp(config.MinCandidateBalance) == -1 {
 					break
 				}
+				if containsAddress(headerExtra.CurrentBlockCancelCandidates, event.Candidate) {
+					break
+				}
+				isCandidate, err := snap.IsCandidate(event.Candidate)
+				if err != nil {
+					break
+				}
+				if !isCandidate {
+					if err = checkRegistrations(config, snap, headerExtra.Epoch); err != nil {
+						log.Debug("[DPOS] Reject candidate", "tx", tx.Hash(), "candidate", event.Candidate, "reason", err)
+						break
+					}
+				}
+				deposit, err := senate.candidateDeposit(config, state, snap, event.Candidate, event.Stake)
+				if err != nil {
+					log.Debug("[DPOS] Reject candidate", "tx", tx.Hash(), "candidate", event.Candidate, "reason", err)
+					break
+				}
 				if err = snap.BecomeCandidate(event.Candidate); err == nil {
+					if !isCandidate && config.MaxRegistrations > 0 {
+						if err = snap.AddRegistration(headerExtra.Epoch); err != nil {
+							log.Warn("[DPOS] Failed to count registration", "candidate", event.Candidate, "reason", err)
+						}
+					}
+					if deposit != nil && snap.SetDeposit(event.Candidate, deposit) == nil {
+						state.SubBalance(event.Candidate, deposit)
+						if config.MinCandidateStake != nil {
+							headerExtra.CurrentBlockDeposits = append(headerExtra.CurrentBlockDeposits, Deposit{
+								Candidate: event.Candidate,
+								Amount:    deposit,
+							})
+						}
+					}
 					headerExtra.CurrentBlockCandidates = append(headerExtra.CurrentBlockCandidates, event.Candidate)
+					if event.Key != nil && snap.SetCandidateKey(event.Candidate, event.Key) == nil {
+						headerExtra.CurrentBlockCandidateKeys = append(headerExtra.CurrentBlockCandidateKeys, CandidateKey{
+							Candidate: event.Candidate,
+							Key:       event.Key,
+						})
+					}
+					if event.Owner != "" {
+						if declaration, err := setCandidateOwner(snap, event.Candidate, event.Owner); err == nil {
+							headerExtra.CurrentBlockDeclarations = append(headerExtra.CurrentBlockDeclarations, declaration)
+						}
+					}
+					accepted = true
+				}
+			case *EventCancelCandidate:
+				event := ctx.(*EventCancelCandidate)
+				if containsAddress(headerExtra.CurrentBlockCandidates, event.Candidate) {
+					break
+				}
+				isCandidate, err := snap.IsCandidate(event.Candidate)
+				if err != nil {
+					break
+				}
+				deposit, err := snap.GetDeposit(event.Candidate)
+				if err != nil || (!isCandidate && deposit.Sign() == 0) {
+					break
+				}
+				refund, err := snap.CancelCandidate(headerExtra.Epoch, event.Candidate, config.RefundPercent, config.RefundEpochs)
+				if err != nil {
+					break
+				}
+				if refund.Sign() > 0 {
+					if config.UnbondingPeriod == 0 {
+						state.AddBalance(event.Candidate, refund)
+					} else if err = snap.Unbond(event.Candidate, refund, header.Time+config.UnbondingPeriod); err != nil {
+						return fmt.Errorf("unbond refund of %s: %w", event.Candidate.Hex(), err)
+					}
+				}
+				headerExtra.CurrentBlockCancelCandidates = append(headerExtra.CurrentBlockCancelCandidates, event.Candidate)
+				accepted = true
+			case *Proposal:
+				proposal := ctx.(*Proposal)
+				if !isElected(snap, proposal.Proposer) {
+					break
+				}
+				submitted := *proposal
+				submitted.ExpireEpoch = proposalExpireEpoch(config, headerExtra.Epoch)
+				if err = snap.SubmitProposal(submitted); err == nil {
+					headerExtra.CurrentBlockProposals = append(headerExtra.CurrentBlockProposals, *proposal)
+					accepted = true
+				}
+			case *Declare:
+				declare := ctx.(*Declare)
+				if !isElected(snap, declare.Declarer) {
+					break
+				}
+				proposal, err := snap.GetProposal(declare.ProposalHash)
+				if err != nil || proposal.ApprovedHash != nil {
+					break
+				}
+				if err = snap.Declare(headerExtra.Epoch, *declare); err != nil {
+					break
+				}
+				headerExtra.CurrentBlockDeclares = append(headerExtra.CurrentBlockDeclares, *declare)
+				if approved, err := snap.TallyProposal(config, headerExtra.Epoch, *declare); err == nil && approved {
+					log.Info("[DPOS] Proposal approved", "key", proposal.Key, "value", proposal.Value,
+						"hash", proposal.Hash)
+					if err = payProposalRewards(config, state, snap, proposal.Hash, headerExtra.Epoch); err != nil {
+						log.Warn("[DPOS] Failed to pay proposal rewards", "hash", proposal.Hash, "reason", err)
+					}
+				}
+				accepted = true
+			case *EventDeclareCandidate:
+				event := ctx.(*EventDeclareCandidate)
+				isCandidate, err := snap.IsCandidate(event.Declaration.Candidate)
+				if err != nil || !isCandidate {
+					break
+				}
+				declaration := event.Declaration
+				previous, err := snap.GetCandidateDeclaration(declaration.Candidate)
+				if err != nil {
+					break
+				}
+				if previous != nil {
+					declaration.Owner = previous.Owner
+				}
+				if err = snap.SetCandidateDeclaration(declaration); err != nil {
+					break
 				}
-				count++
+				headerExtra.CurrentBlockDeclarations = append(headerExtra.CurrentBlockDeclarations, declaration)
+				accepted = true
+			case *EventWithdrawReward:
+				event := ctx.(*EventWithdrawReward)
+				amount, err := snap.WithdrawReward(event.Delegator)
+				if err != nil || amount.Sign() == 0 {
+					break
+				}
+				state.AddBalance(event.Delegator, amount)
+				headerExtra.CurrentBlockWithdrawals = append(headerExtra.CurrentBlockWithdrawals, Reward{
+					Delegator: event.Delegator,
+					Amount:    amount,
+				})
+				accepted = true
+			case *EventRotateKey:
+				event := ctx.(*EventRotateKey)
+				if err = senate.checkKeyRotation(snap, event.Candidate, event.Signer); err != nil {
+					log.Debug("[DPOS] Reject key rotation", "candidate", event.Candidate, "reason", err)
+					break
+				}
+				if err = snap.RotateKey(event.Candidate, event.Signer); err == nil {
+					headerExtra.CurrentBlockKeyRotations = append(headerExtra.CurrentBlockKeyRotations, KeyRotation{
+						Candidate: event.Candidate,
+						Signer:    event.Signer,
+					})
+					accepted = true
+				}
+			case *EventSetRewardAddress:
+				event := ctx.(*EventSetRewardAddress)
+				isCandidate, err := snap.IsCandidate(event.Candidate)
+				if err != nil || !isCandidate {
+					log.Debug("[DPOS] Reject reward address", "tx", tx.Hash(), "candidate", event.Candidate)
+					break
+				}
+				if err = snap.SetRewardAddress(event.Candidate, event.Address); err == nil {
+					headerExtra.CurrentBlockRewardAddresses = append(headerExtra.CurrentBlockRewardAddresses, RewardAddress{
+						Candidate: event.Candidate,
+						Address:   event.Address,
+					})
+					accepted = true
+				}
+			}
+		}
+
+		// Rejected operations are recorded, so the validity of every custom
+		// transaction can be told from the block
+		if !accepted {
+			if rejects {
+				headerExtra.CurrentBlockRejects = append(headerExtra.CurrentBlockRejects, tx.Hash())
+			}
+			continue
+		}
+		if config.CustomNonces {
+			if err = snap.SetCustomNonce(sender, tx.Nonce()+1); err != nil {
+				log.Warn("[DPOS] Failed to track custom nonce", "sender", sender, "reason", err)
 			}
+			headerExtra.CurrentBlockNonces = append(headerExtra.CurrentBlockNonces, CustomNonce{Sender: sender, Nonce: tx.Nonce()})
 		}
+		operations[key] = struct{}{}
+		count++
 	}
 
 	headerExtra.CurrentBlockDelegates = delegatesDistinct(headerExtra.CurrentBlockDelegates)
 	headerExtra.CurrentBlockCandidates = addressesDistinct(headerExtra.CurrentBlockCandidates)
+	headerExtra.CurrentBlockCancelCandidates = addressesDistinct(headerExtra.CurrentBlockCancelCandidates)
 
 	log.Trace("[DPOS] Processing transactions done", "txs", count)
+	return nil
+}
+
+// checkBatchDelegate checks every entry of the batch before any is applied,
+// the candidates must be registered and the balance of the delegator must
+// cover the total. Like a single vote, the balance must reach the minimum of
+// delegators.
+func checkBatchDelegate(config params.SenateConfig, state *state.StateDB, snap *Snapshot, event *EventBatchDelegate) error {
+	if state.GetBalance(event.Delegator).Cmp(config.MinDelegatorBalance) == -1 {
+		return errors.New("balance below the minimum of delegators")
+	}
+	total := big.NewInt(0)
+	for _, bond := range event.Bonds {
+		isCandidate, err := snap.IsCandidate(bond.Candidate)
+		if err != nil {
+			return err
+		}
+		if !isCandidate {
+			return fmt.Errorf("%s is not a candidate", bond.Candidate.Hex())
+		}
+		total.Add(total, bond.Amount)
+	}
+	if state.GetBalance(event.Delegator).Cmp(total) < 0 {
+		return errors.New("insufficient balance")
+	}
+	return nil
+}
+
+// lockBonds moves the total of a checked batch from the balance of the delegator
+// to the staking account and bonds each amount, staged if delegations are.
+// Every entry was checked, so the batch is only cut short by a failing snapshot.
+func lockBonds(config params.SenateConfig, state *state.StateDB, snap *Snapshot,
+	headerExtra *HeaderExtra, event *EventBatchDelegate) error {
+
+	lock := snap.Bond
+	if config.StagedDelegation {
+		lock = snap.StageBond
+	}
+	total := big.NewInt(0)
+	for _, bond := range event.Bonds {
+		if err := lock(bond.Candidate, bond.Delegator, bond.Amount); err != nil {
+			return fmt.Errorf("bond of %s: %w", bond.Delegator.Hex(), err)
+		}
+		total.Add(total, bond.Amount)
+	}
+	state.SubBalance(event.Delegator, total)
+	state.AddBalance(StakingAddress, total)
+	headerExtra.CurrentBlockBonds = append(headerExtra.CurrentBlockBonds, event.Bonds...)
+	return nil
+}
+
+// setCandidateOwner tags the declaration of candidate with owner, keeping the
+// rest of a declaration published before.
+func setCandidateOwner(snap *Snapshot, candidate common.Address, owner string) (CandidateDeclaration, error) {
+	declaration := CandidateDeclaration{Candidate: candidate}
+	previous, err := snap.GetCandidateDeclaration(candidate)
+	if err != nil {
+		return CandidateDeclaration{}, err
+	}
+	if previous != nil {
+		declaration = *previous
+	}
+	declaration.Owner = owner
+	return declaration, snap.SetCandidateDeclaration(declaration)
+}
+
+// capOwners keeps the candidates in order, skipping those whose owner already
+// has limit candidates kept, until n are kept. Candidates without owner aren't
+// capped.
+func capOwners(snap *Snapshot, candidates SortableAddresses, limit uint64, n int) (SortableAddresses, error) {
+	owners := make(map[string]uint64)
+	capped := make(SortableAddresses, 0, n)
+	for _, candidate := range candidates {
+		if len(capped) >= n {
+			break
+		}
+		declaration, err := snap.GetCandidateDeclaration(candidate.Address)
+		if err != nil {
+			return nil, err
+		}
+		if declaration != nil && declaration.Owner != "" {
+			if owners[declaration.Owner] >= limit {
+				log.Debug("[DPOS] Skip candidate of capped owner", "candidate", candidate.Address, "owner", declaration.Owner)
+				continue
+			}
+			owners[declaration.Owner]++
+		}
+		capped = append(capped, candidate)
+	}
+	return capped, nil
+}
+
+// checkRegistrations returns ErrRegistrationLimit if no more new candidate may
+// register in the epoch.
+func checkRegistrations(config params.SenateConfig, snap *Snapshot, epoch uint64) error {
+	if config.MaxRegistrations == 0 {
+		return nil
+	}
+	count, err := snap.GetRegistrations(epoch)
+	if err != nil {
+		return err
+	}
+	if count >= config.MaxRegistrations {
+		return ErrRegistrationLimit
+	}
+	return nil
+}
+
+// rejectConflicts reports whether operations conflicting within the block of
+// header are rejected and rejects are recorded, chains before the activation
+// apply every operation of the block.
+func rejectConflicts(config params.SenateConfig, header *types.Header) bool {
+	if config.RejectConflictBlock == 0 || header.Number == nil {
+		return false
+	}
+	return header.Number.Uint64() >= config.RejectConflictBlock
+}
+
+// operationKey identifies the subject a custom transaction operates on, two
+// operations of the same key conflict within a block.
+func operationKey(ctx Transaction) string {
+	var subject string
+	switch ctx := ctx.(type) {
+	case *EventDelegate:
+		subject = ctx.Delegator.Hex()
+	case *EventBatchDelegate:
+		subject = ctx.Delegator.Hex()
+	case *EventUnbond:
+		subject = ctx.Delegator.Hex() + ":" + ctx.Candidate.Hex()
+	case *EventBecomeCandidate:
+		subject = ctx.Candidate.Hex()
+	case *EventCancelCandidate:
+		subject = ctx.Candidate.Hex()
+	case *Proposal:
+		subject = ctx.Proposer.Hex() + ":" + ctx.Key
+	case *Declare:
+		subject = ctx.Declarer.Hex() + ":" + ctx.ProposalHash.Hex()
+	case *EventDeclareCandidate:
+		subject = ctx.Declaration.Candidate.Hex()
+	case *EventWithdrawReward:
+		subject = ctx.Delegator.Hex()
+	case *EventRotateKey:
+		subject = ctx.Candidate.Hex()
+	case *EventSetRewardAddress:
+		subject = ctx.Candidate.Hex()
+	}
+	return ctx.Action() + ":" + subject
+}
+
+// Checks whether the address is one of the current epoch validators.
+func isElected(snap *Snapshot, address common.Address) bool {
+	validators, err := snap.GetValidators()
+	if err != nil {
+		return false
+	}
+	for _, validator := range validators {
+		if validator.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies the chain config approved in the last epoch at the first block of
+// the epoch, the config takes effect since the next block.
+func (senate *Senate) applyPendingConfig(header *types.Header, snap *Snapshot, headerExtra *HeaderExtra) error {
+	if header.Time != headerExtra.EpochTime {
+		return nil
+	}
+
+	config, err := snap.ApplyPendingChainConfig()
+	if err != nil || config == nil {
+		return err
+	}
+	headerExtra.ChainConfig = append(headerExtra.ChainConfig, *config)
+	log.Info("[DPOS] Apply chain config", "epoch", headerExtra.Epoch, "period", config.Period)
+	return nil
+}
+
+// Moves the votes and bonds staged in the last epoch into the delegate and bond
+// tries at the first block of the epoch, after the election so they count
+// since the next one.
+func (senate *Senate) applyStagedDelegates(header *types.Header, snap *Snapshot, headerExtra *HeaderExtra) error {
+	if header.Time != headerExtra.EpochTime {
+		return nil
+	}
+
+	delegates, err := snap.ApplyStagedDelegates()
+	if err != nil {
+		return err
+	}
+	if len(delegates) > 0 {
+		log.Debug("[DPOS] Apply staged delegates", "epoch", headerExtra.Epoch, "count", len(delegates))
+	}
+	bonds, err := snap.ApplyStagedBonds()
+	if err != nil {
+		return err
+	}
+	if len(bonds) > 0 {
+		log.Debug("[DPOS] Apply staged bonds", "epoch", headerExtra.Epoch, "count", len(bonds))
+	}
+	return nil
+}
+
+// Removes the proposals not approved in time at the first block of the epoch.
+func (senate *Senate) expireProposals(header *types.Header, snap *Snapshot, headerExtra *HeaderExtra) error {
+	if header.Time != headerExtra.EpochTime {
+		return nil
+	}
+
+	expired, err := snap.ExpireProposals(headerExtra.Epoch)
+	if err != nil {
+		return err
+	}
+	for _, hash := range expired {
+		log.Info("[DPOS] Proposal expired", "epoch", headerExtra.Epoch, "hash", hash)
+	}
+	return nil
+}
+
+// Checks whether the candidate is allowed to sign blocks with the new key.
+func (senate *Senate) checkKeyRotation(snap *Snapshot, candidate, signer common.Address) error {
+	isCandidate, err := snap.IsCandidate(candidate)
+	if err != nil {
+		return err
+	}
+	if !isCandidate {
+		return errors.New("sender is not a candidate")
+	}
+	if signer == candidate {
+		return nil
+	}
+	if isCandidate, err = snap.IsCandidate(signer); err != nil || isCandidate {
+		return errors.New("signer is a candidate")
+	}
+	owner, err := snap.ValidatorOf(signer)
+	if err != nil {
+		return err
+	}
+	if owner != signer && owner != candidate {
+		return errors.New("signer is used by another candidate")
+	}
+	return nil
+}
+
+// Debits the configured percent of the deposit from validators kicked out for
+// inactivity, or of their balance if they locked no deposit. The slashed
+// stake goes to the slash fund or is burned.
+func (senate *Senate) accumulateSlash(config params.SenateConfig, state *state.StateDB,
+	snap *Snapshot, headerExtra *HeaderExtra) error {
+
+	percent := config.SlashPercent
+	if percent == 0 {
+		return nil
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	for _, validator := range headerExtra.CurrentBlockKickOutCandidates {
+		stake, err := snap.GetDeposit(validator)
+		if err != nil {
+			return err
+		}
+		if stake.Sign() == 0 {
+			stake = state.GetBalance(validator)
+		}
+		amount := new(big.Int).Mul(stake, new(big.Int).SetUint64(percent))
+		amount.Div(amount, big.NewInt(100))
+		if amount.Sign() == 0 {
+			continue
+		}
+		deposited, err := snap.DebitDeposit(validator, amount)
+		if err != nil {
+			return err
+		}
+		if err = snap.Slash(headerExtra.Epoch, validator, amount); err != nil {
+			return err
+		}
+
+		if !deposited {
+			state.SubBalance(validator, amount)
+		}
+		if config.SlashFund != (common.Address{}) {
+			state.AddBalance(config.SlashFund, amount)
+		}
+		headerExtra.CurrentBlockSlashes = append(headerExtra.CurrentBlockSlashes, Slash{
+			Validator: validator,
+			Amount:    amount,
+		})
+		log.Info("[DPOS] Slash validator", "validator", validator, "amount", amount, "fund", config.SlashFund)
+	}
+	return nil
+}
+
+// Gets the deposit a new candidate has to lock, nil if nothing to lock.
+func (senate *Senate) candidateDeposit(config params.SenateConfig, state *state.StateDB,
+	snap *Snapshot, candidate common.Address, stake *big.Int) (*big.Int, error) {
+
+	amount := config.CandidateDeposit
+	if config.MinCandidateStake != nil {
+		if stake == nil {
+			stake = config.MinCandidateStake
+		}
+		if stake.Cmp(config.MinCandidateStake) < 0 {
+			return nil, ErrStakeTooLow
+		}
+		amount = stake
+	}
+	if amount == nil || amount.Sign() <= 0 {
+		return nil, nil
+	}
+	deposit, err := snap.GetDeposit(candidate)
+	if err != nil {
+		return nil, err
+	}
+	if deposit.Sign() > 0 {
+		return nil, nil
+	}
+	if state.GetBalance(candidate).Cmp(amount) == -1 {
+		return nil, ErrInsufficientStake
+	}
+	return new(big.Int).Set(amount), nil
+}
+
+// Credits the amounts unbonded by candidates and delegators unlocked since the block.
+func (senate *Senate) releaseUnbonded(state *state.StateDB, header *types.Header, snap *Snapshot) error {
+	unbondings, err := snap.ReleaseUnbonded(header.Time)
+	if err != nil {
+		return err
+	}
+	for _, unbonding := range unbondings {
+		if unbonding.Bonded {
+			state.SubBalance(StakingAddress, unbonding.Amount)
+		}
+		state.AddBalance(unbonding.Address, unbonding.Amount)
+		log.Debug("[DPOS] Release unbonded", "address", unbonding.Address, "amount", unbonding.Amount)
+	}
+	return nil
+}
+
+// Credits the vested refunds of deregistered candidates in first block for epoch.
+func (senate *Senate) releaseRefunds(state *state.StateDB, header *types.Header,
+	snap *Snapshot, headerExtra *HeaderExtra) error {
+
+	if header.Time != headerExtra.EpochTime {
+		return nil
+	}
+	refunds, err := snap.ReleaseRefunds(headerExtra.Epoch)
+	if err != nil {
+		return err
+	}
+	for _, refund := range refunds {
+		state.AddBalance(refund.Address, refund.Amount)
+		log.Debug("[DPOS] Release refund", "address", refund.Address, "amount", refund.Amount)
+	}
+	return nil
 }
@@ -1,15 +1,26 @@
 package senate
 
 import (
+	"context"
+	"fmt"
+	"math"
 	"math/big"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/SecretBlockChain/go-secret/accounts"
 	"github.com/SecretBlockChain/go-secret/common"
+	"github.com/SecretBlockChain/go-secret/common/hexutil"
+	"github.com/SecretBlockChain/go-secret/consensus"
+	"github.com/SecretBlockChain/go-secret/core"
 	"github.com/SecretBlockChain/go-secret/core/rawdb"
+	"github.com/SecretBlockChain/go-secret/core/state"
+	"github.com/SecretBlockChain/go-secret/core/types"
 	"github.com/SecretBlockChain/go-secret/crypto"
+	"github.com/SecretBlockChain/go-secret/ethdb"
 	"github.com/SecretBlockChain/go-secret/params"
+	"github.com/stretchr/testify/assert"
 )
 
 var (
@@ -32,8 +43,2991 @@ func TestNewSenate(t *testing.T) {
 			{Height: 100000, Reward: big.NewInt(1)},
 		},
 	}
-	senate := New(&config, db)
+	senate := New(&config, nil, db)
 	senate.Authorize(testUserAddress, func(account accounts.Account, s string, data []byte) ([]byte, error) {
 		return crypto.Sign(crypto.Keccak256(data), testUserKey)
 	})
 }
+
+func TestTryElectReuseValidators(t *testing.T) {
+	validator1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	validator2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	for _, reuse := range []bool{true, false} {
+		db := rawdb.NewMemoryDatabase()
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+		assert.Nil(t, err)
+
+		config := params.SenateConfig{
+			Period:              5,
+			Epoch:               10,
+			MaxValidatorsCount:  2,
+			MinDelegatorBalance: big.NewInt(0),
+			MinCandidateBalance: big.NewInt(0),
+			Validators:          []common.Address{validator1, validator2},
+			ReuseValidators:     reuse,
+		}
+		senate := New(&config, nil, db)
+
+		// First epoch elects the genesis validators
+		snap, err := newSnapshot(db)
+		assert.Nil(t, err)
+		header := &types.Header{Number: big.NewInt(1), Time: 100}
+		headerExtra := HeaderExtra{Epoch: 1, EpochTime: 100}
+		assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+		assert.Equal(t, 2, len(headerExtra.CurrentEpochValidators))
+		root1, err := snap.Root()
+		assert.Nil(t, err)
+		assert.Nil(t, snap.Commit(root1))
+
+		// Second epoch without any stake change
+		snap, err = loadSnapshot(db, root1)
+		assert.Nil(t, err)
+		header = &types.Header{Number: big.NewInt(3), Time: 110, ParentHash: common.HexToHash("0x01")}
+		headerExtra = HeaderExtra{Root: root1, Epoch: 2, EpochTime: 110}
+		assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+		root2, err := snap.Root()
+		assert.Nil(t, err)
+		assert.Equal(t, root1.CandidateHash, root2.CandidateHash)
+		if !reuse {
+			assert.Equal(t, 2, len(headerExtra.CurrentEpochValidators))
+			continue
+		}
+		assert.Equal(t, 2, len(headerExtra.CurrentEpochValidators))
+		assert.Equal(t, root1.EpochHash, root2.EpochHash)
+
+		// Replaying the header keeps the validators trie as well
+		replay, err := loadSnapshot(db, root1)
+		assert.Nil(t, err)
+		assert.Nil(t, replay.apply(config, header, headerExtra))
+		root3, err := replay.Root()
+		assert.Nil(t, err)
+		assert.Equal(t, root1.EpochHash, root3.EpochHash)
+	}
+}
+
+func TestTryElectEmptyElection(t *testing.T) {
+	validator := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	for _, reuse := range []bool{true, false} {
+		db := rawdb.NewMemoryDatabase()
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+		assert.Nil(t, err)
+		config := params.SenateConfig{
+			Period:              5,
+			Epoch:               10,
+			MaxValidatorsCount:  1,
+			MinDelegatorBalance: big.NewInt(0),
+			MinCandidateBalance: big.NewInt(0),
+			ReuseValidators:     reuse,
+		}
+		senate := New(&config, nil, db)
+
+		// Nobody is a candidate of the next epoch
+		snap, err := newSnapshot(db)
+		assert.Nil(t, err)
+		assert.Nil(t, snap.SetValidators(SortableAddresses{{Address: validator, Weight: big.NewInt(0)}}))
+		assert.Nil(t, snap.MintBlock(1, 2, validator))
+		root, err := snap.Root()
+		assert.Nil(t, err)
+		assert.Nil(t, snap.Commit(root))
+
+		header := &types.Header{Number: big.NewInt(3), Time: 110, ParentHash: common.HexToHash("0x01"), Coinbase: validator}
+		headerExtra := HeaderExtra{Root: root, Epoch: 2, EpochTime: 110}
+		assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+		validators, err := snap.GetValidators()
+		assert.Nil(t, err)
+		if reuse {
+			// The validators are kept and carried as the checkpoint
+			assert.Equal(t, 1, len(validators))
+			assert.Equal(t, validators, headerExtra.CurrentEpochValidators)
+		} else {
+			// The legacy election replaces them with nobody
+			assert.Empty(t, validators)
+			assert.Empty(t, headerExtra.CurrentEpochValidators)
+		}
+
+		// Replaying the header gets the same validators
+		assert.Nil(t, snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase))
+		expected, err := snap.Root()
+		assert.Nil(t, err)
+		replay, err := loadSnapshot(db, root)
+		assert.Nil(t, err)
+		assert.Nil(t, replay.apply(config, header, headerExtra))
+		replayRoot, err := replay.Root()
+		assert.Nil(t, err)
+		assert.Equal(t, expected.EpochHash, replayRoot.EpochHash)
+	}
+}
+
+func signTestTransaction(t *testing.T, nonce uint64, to common.Address, data string) *types.Transaction {
+	tx := types.NewTransaction(nonce, to, big.NewInt(0), 99999999, big.NewInt(1000), []byte(data))
+	tx, err := types.SignTx(tx, types.HomesteadSigner{}, testUserKey)
+	assert.Nil(t, err)
+	return tx
+}
+
+func TestCandidateDepositRefund(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+	statedb.AddBalance(testUserAddress, big.NewInt(1000))
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               10,
+		MaxValidatorsCount:  3,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		CandidateDeposit:    big.NewInt(100),
+		RefundPercent:       40,
+		RefundEpochs:        3,
+	}
+	senate := New(&config, nil, db)
+
+	// Become candidate and lock the deposit
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	header := &types.Header{Number: big.NewInt(2), Time: 105}
+	headerExtra := HeaderExtra{Epoch: 1, EpochTime: 100}
+	txs := []*types.Transaction{signTestTransaction(t, 0, testUserAddress, "senate:1:event:candidate")}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+	assert.Equal(t, []common.Address{testUserAddress}, headerExtra.CurrentBlockCandidates)
+	assert.Equal(t, big.NewInt(900), statedb.GetBalance(testUserAddress))
+	deposit, err := snap.GetDeposit(testUserAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(100), deposit)
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	// Deregister, the immediate fraction is returned at once
+	header = &types.Header{Number: big.NewInt(3), Time: 110}
+	headerExtra = HeaderExtra{Root: root, Epoch: 1, EpochTime: 100}
+	txs = []*types.Transaction{signTestTransaction(t, 1, testUserAddress, "senate:1:event:uncandidate")}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+	assert.Equal(t, []common.Address{testUserAddress}, headerExtra.CurrentBlockCancelCandidates)
+	assert.Equal(t, big.NewInt(940), statedb.GetBalance(testUserAddress))
+	isCandidate, err := snap.IsCandidate(testUserAddress)
+	assert.Nil(t, err)
+	assert.False(t, isCandidate)
+	assert.Nil(t, snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase))
+	expected, err := snap.Root()
+	assert.Nil(t, err)
+
+	// Replaying the header must result in the same snapshot
+	replay, err := loadSnapshot(db, root)
+	assert.Nil(t, err)
+	assert.Nil(t, replay.apply(config, header, headerExtra))
+	replayRoot, err := replay.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, expected, replayRoot)
+	assert.Nil(t, snap.Commit(expected))
+
+	// The remainder vests over the following epochs
+	for epoch, balance := range []int64{960, 980, 1000} {
+		epoch := uint64(epoch + 2)
+		header := &types.Header{Number: big.NewInt(int64(epoch * 2)), Time: epoch * 100}
+		headerExtra := HeaderExtra{Epoch: epoch, EpochTime: epoch * 100}
+		assert.Nil(t, senate.releaseRefunds(statedb, header, snap, &headerExtra))
+		assert.Equal(t, big.NewInt(balance), statedb.GetBalance(testUserAddress))
+
+		refunds, err := snap.GetRefunds()
+		assert.Nil(t, err)
+		assert.Equal(t, epoch < 4, len(refunds) == 1)
+	}
+}
+
+func TestProcessTransactionsConflicts(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+	statedb.AddBalance(testUserAddress, big.NewInt(1000))
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               10,
+		MaxValidatorsCount:  3,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		CandidateDeposit:    big.NewInt(100),
+		RejectConflictBlock: 3,
+	}
+	senate := New(&config, nil, db)
+
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	header := &types.Header{Number: big.NewInt(2), Time: 105}
+	headerExtra := HeaderExtra{Epoch: 1, EpochTime: 100}
+	txs := []*types.Transaction{signTestTransaction(t, 0, testUserAddress, "senate:1:event:candidate")}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+	assert.Empty(t, headerExtra.CurrentBlockRejects)
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	// Only the first of the conflicting operations takes effect, the others
+	// are rejected in the order of the block
+	cancel := signTestTransaction(t, 1, testUserAddress, "senate:1:event:uncandidate")
+	again := signTestTransaction(t, 2, testUserAddress, "senate:1:event:uncandidate")
+	register := signTestTransaction(t, 3, testUserAddress, "senate:1:event:candidate")
+	txs = []*types.Transaction{cancel, again, register}
+
+	process := func(statedb *state.StateDB) (HeaderExtra, Root) {
+		snap, err := loadSnapshot(db, root)
+		assert.Nil(t, err)
+		header := &types.Header{Number: big.NewInt(3), Time: 110}
+		headerExtra := HeaderExtra{Root: root, Epoch: 1, EpochTime: 100}
+		senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+		processed, err := snap.Root()
+		assert.Nil(t, err)
+		return headerExtra, processed
+	}
+	replica, legacy := statedb.Copy(), statedb.Copy()
+	headerExtra, processed := process(statedb)
+	assert.Equal(t, []common.Address{testUserAddress}, headerExtra.CurrentBlockCancelCandidates)
+	assert.Empty(t, headerExtra.CurrentBlockCandidates)
+	assert.Equal(t, []common.Hash{again.Hash(), register.Hash()}, headerExtra.CurrentBlockRejects)
+	assert.Equal(t, big.NewInt(1000), statedb.GetBalance(testUserAddress))
+
+	// Another node processing the same block reaches the same outcome
+	replayed, replayedRoot := process(replica)
+	assert.True(t, headerExtra.Equal(replayed))
+	assert.Equal(t, processed, replayedRoot)
+	assert.Equal(t, statedb.GetBalance(testUserAddress), replica.GetBalance(testUserAddress))
+
+	// Before the activation every operation applies and nothing is recorded
+	config.RejectConflictBlock = 4
+	headerExtra, _ = process(legacy)
+	assert.Equal(t, []common.Address{testUserAddress}, headerExtra.CurrentBlockCancelCandidates)
+	assert.Empty(t, headerExtra.CurrentBlockRejects)
+}
+
+func TestProcessTransactionsReplay(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+	statedb.AddBalance(testUserAddress, big.NewInt(1000))
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               10,
+		MaxValidatorsCount:  3,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		CustomNonces:        true,
+	}
+	senate := New(&config, nil, db)
+	candidate := common.Address{0x01}
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.BecomeCandidate(candidate))
+
+	vote := signTestTransaction(t, 0, candidate, "senate:1:event:delegate")
+	header := &types.Header{Number: big.NewInt(2), Time: 105}
+	headerExtra := HeaderExtra{Epoch: 1, EpochTime: 100}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, []*types.Transaction{vote}, nil)
+	assert.Empty(t, headerExtra.CurrentBlockRejects)
+	assert.Equal(t, []CustomNonce{{Sender: testUserAddress, Nonce: 0}}, headerExtra.CurrentBlockNonces)
+	next, err := snap.GetCustomNonce(testUserAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), next)
+
+	// The replayed vote is rejected, a later one is applied
+	renew := signTestTransaction(t, 1, candidate, "senate:1:event:delegate")
+	header = &types.Header{Number: big.NewInt(3), Time: 110}
+	headerExtra = HeaderExtra{Epoch: 1, EpochTime: 100}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, []*types.Transaction{vote, renew}, nil)
+	assert.Equal(t, []common.Hash{vote.Hash()}, headerExtra.CurrentBlockRejects)
+	assert.Equal(t, []CustomNonce{{Sender: testUserAddress, Nonce: 1}}, headerExtra.CurrentBlockNonces)
+	processed, err := snap.Root()
+	assert.Nil(t, err)
+
+	// Nodes replaying the header track the same nonces
+	replica, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, replica.apply(config, header, HeaderExtra{
+		Epoch:              1,
+		EpochTime:          100,
+		CurrentBlockNonces: []CustomNonce{{Sender: testUserAddress, Nonce: 1}},
+	}))
+	replayed, err := replica.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, processed.NonceHash, replayed.NonceHash)
+
+	// Without custom nonces the nonce trie isn't created
+	config.CustomNonces = false
+	snap, err = newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.BecomeCandidate(candidate))
+	headerExtra = HeaderExtra{Epoch: 1, EpochTime: 100}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, []*types.Transaction{vote}, nil)
+	assert.Empty(t, headerExtra.CurrentBlockNonces)
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, common.Hash{}, root.NonceHash)
+}
+
+func TestMaxOwnerValidators(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               10,
+		MaxValidatorsCount:  3,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		MaxOwnerValidators:  1,
+	}
+	senate := New(&config, nil, db)
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+
+	// The owner is tagged at registration and kept by later descriptions
+	header := &types.Header{Number: big.NewInt(2), Time: 105}
+	headerExtra := HeaderExtra{Epoch: 1, EpochTime: 100}
+	txs := []*types.Transaction{
+		signTestTransaction(t, 0, testUserAddress, "senate:1:event:candidate:::acme"),
+		signTestTransaction(t, 1, testUserAddress, `senate:1:event:describe:{"name":"node"}`),
+	}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+	assert.Empty(t, headerExtra.CurrentBlockRejects)
+	assert.Equal(t, []CandidateDeclaration{
+		{Candidate: testUserAddress, Owner: "acme"},
+		{Candidate: testUserAddress, Name: "node", Owner: "acme"},
+	}, headerExtra.CurrentBlockDeclarations)
+
+	// Only one of the candidates sharing an owner is elected
+	others := []common.Address{{0x01}, {0x02}, {0x03}}
+	for idx, candidate := range others {
+		assert.Nil(t, snap.BecomeCandidate(candidate))
+		if idx < 2 {
+			_, err = setCandidateOwner(snap, candidate, "acme")
+			assert.Nil(t, err)
+		}
+	}
+	header = &types.Header{Number: big.NewInt(1), Time: 200, ParentHash: common.HexToHash("0x01")}
+	headerExtra = HeaderExtra{Epoch: 2, EpochTime: 200}
+	assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+	owned, unowned := 0, 0
+	for _, validator := range headerExtra.CurrentEpochValidators {
+		if validator.Address == others[2] {
+			unowned++
+		} else {
+			owned++
+		}
+	}
+	assert.Equal(t, 1, owned)
+	assert.Equal(t, 1, unowned)
+
+	// Without a cap the validators are elected regardless of owner
+	config.MaxOwnerValidators = 0
+	headerExtra = HeaderExtra{Epoch: 2, EpochTime: 200}
+	assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+	assert.Len(t, headerExtra.CurrentEpochValidators, 3)
+}
+
+func TestMinValidators(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               10,
+		MaxValidatorsCount:  3,
+		MinValidators:       2,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+	}
+	senate := New(&config, nil, db)
+
+	// Two of the three validators left, a single candidate remains
+	validators := SortableAddresses{
+		{Address: common.Address{0x01}, Weight: big.NewInt(0)},
+		{Address: common.Address{0x02}, Weight: big.NewInt(0)},
+		{Address: common.Address{0x03}, Weight: big.NewInt(0)},
+	}
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.SetValidators(validators))
+	assert.Nil(t, snap.BecomeCandidate(validators[0].Address))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	// The floor keeps the previous validators
+	header := &types.Header{Number: big.NewInt(3), Time: 200, ParentHash: common.HexToHash("0x01")}
+	headerExtra := HeaderExtra{Epoch: 2, EpochTime: 200}
+	assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+	assert.Equal(t, validators, headerExtra.CurrentEpochValidators)
+	elected, err := snap.GetValidators()
+	assert.Nil(t, err)
+	assert.Equal(t, validators, elected)
+
+	// Without a floor the set collapses to the remaining candidate
+	config.MinValidators = 0
+	snap, err = loadSnapshot(db, root)
+	assert.Nil(t, err)
+	headerExtra = HeaderExtra{Epoch: 2, EpochTime: 200}
+	assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+	assert.Len(t, headerExtra.CurrentEpochValidators, 1)
+	assert.Equal(t, validators[0].Address, headerExtra.CurrentEpochValidators[0].Address)
+
+	// A floor above the max count is rejected
+	config = params.SenateConfig{Period: 5, Epoch: 10, MaxValidatorsCount: 1, MinValidators: 2,
+		MinDelegatorBalance: big.NewInt(0), MinCandidateBalance: big.NewInt(0), Validators: []common.Address{testUserAddress}}
+	assert.NotNil(t, config.Validate())
+}
+
+func TestCandidateStake(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+	statedb.AddBalance(testUserAddress, big.NewInt(1000))
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               10,
+		MaxValidatorsCount:  3,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		MinCandidateStake:   big.NewInt(100),
+	}
+	senate := New(&config, nil, db)
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	// Stake below the minimum or above the balance is rejected
+	_, err = senate.candidateDeposit(config, statedb, snap, testUserAddress, big.NewInt(50))
+	assert.Equal(t, ErrStakeTooLow, err)
+	_, err = senate.candidateDeposit(config, statedb, snap, testUserAddress, big.NewInt(2000))
+	assert.Equal(t, ErrInsufficientStake, err)
+
+	header := &types.Header{Number: big.NewInt(2), Time: 105}
+	headerExtra := HeaderExtra{Root: root, Epoch: 1, EpochTime: 100}
+	txs := []*types.Transaction{signTestTransaction(t, 0, testUserAddress, "senate:1:event:candidate:0x32")}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+	assert.Empty(t, headerExtra.CurrentBlockCandidates)
+	isCandidate, err := snap.IsCandidate(testUserAddress)
+	assert.Nil(t, err)
+	assert.False(t, isCandidate)
+
+	// Register with the self-stake
+	txs = []*types.Transaction{signTestTransaction(t, 1, testUserAddress, "senate:1:event:candidate:0x1f4")}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+	assert.Equal(t, []common.Address{testUserAddress}, headerExtra.CurrentBlockCandidates)
+	assert.Equal(t, []Deposit{{Candidate: testUserAddress, Amount: big.NewInt(500)}}, headerExtra.CurrentBlockDeposits)
+	assert.Equal(t, big.NewInt(500), statedb.GetBalance(testUserAddress))
+	isCandidate, err = snap.IsCandidate(testUserAddress)
+	assert.Nil(t, err)
+	assert.True(t, isCandidate)
+	deposit, err := snap.GetDeposit(testUserAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(500), deposit)
+	assert.Nil(t, snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase))
+	expected, err := snap.Root()
+	assert.Nil(t, err)
+
+	// Replaying the header must result in the same snapshot
+	replay, err := loadSnapshot(db, root)
+	assert.Nil(t, err)
+	assert.Nil(t, replay.apply(config, header, headerExtra))
+	replayRoot, err := replay.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, expected, replayRoot)
+}
+
+func TestCandidateRegistrationLimit(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               10,
+		MaxValidatorsCount:  3,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		MaxRegistrations:    2,
+		RejectConflictBlock: 1,
+	}
+	senate := New(&config, nil, db)
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+
+	candidates := make([]common.Address, 3)
+	register := make([]*types.Transaction, len(candidates))
+	for i := range candidates {
+		key, _ := crypto.GenerateKey()
+		candidates[i] = crypto.PubkeyToAddress(key.PublicKey)
+		tx := types.NewTransaction(0, candidates[i], big.NewInt(0), 99999999, big.NewInt(1000), []byte("senate:1:event:candidate"))
+		register[i], err = types.SignTx(tx, types.HomesteadSigner{}, key)
+		assert.Nil(t, err)
+	}
+
+	// Registrations beyond the cap of the epoch are rejected
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	header := &types.Header{Number: big.NewInt(2), Time: 105}
+	headerExtra := HeaderExtra{Root: root, Epoch: 1, EpochTime: 100}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, register, nil)
+	assert.Equal(t, candidates[:2], headerExtra.CurrentBlockCandidates)
+	assert.Equal(t, []common.Hash{register[2].Hash()}, headerExtra.CurrentBlockRejects)
+	count, err := snap.GetRegistrations(1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), count)
+	assert.Nil(t, snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase))
+	expected, err := snap.Root()
+	assert.Nil(t, err)
+
+	// Replaying the header must result in the same snapshot
+	replay, err := loadSnapshot(db, root)
+	assert.Nil(t, err)
+	assert.Nil(t, replay.apply(config, header, headerExtra))
+	replayRoot, err := replay.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, expected, replayRoot)
+
+	// The cap holds for the rest of the epoch, registered candidates aren't
+	// counted again
+	header = &types.Header{Number: big.NewInt(3), Time: 107}
+	headerExtra = HeaderExtra{Root: expected, Epoch: 1, EpochTime: 100}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, []*types.Transaction{register[2], register[0]}, nil)
+	assert.Equal(t, candidates[:1], headerExtra.CurrentBlockCandidates)
+	assert.Equal(t, []common.Hash{register[2].Hash()}, headerExtra.CurrentBlockRejects)
+	count, err = snap.GetRegistrations(1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), count)
+
+	// The counter resets at the next epoch
+	header = &types.Header{Number: big.NewInt(4), Time: 110}
+	headerExtra = HeaderExtra{Epoch: 2, EpochTime: 110}
+	assert.Nil(t, snap.ResetRegistrations(headerExtra.Epoch))
+	count, err = snap.GetRegistrations(1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), count)
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, register[2:], nil)
+	assert.Equal(t, candidates[2:], headerExtra.CurrentBlockCandidates)
+	assert.Empty(t, headerExtra.CurrentBlockRejects)
+	count, err = snap.GetRegistrations(2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), count)
+}
+
+func TestCandidateUnbonding(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+	statedb.AddBalance(testUserAddress, big.NewInt(1000))
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               100,
+		MaxValidatorsCount:  3,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		CandidateDeposit:    big.NewInt(100),
+		UnbondingPeriod:     100,
+	}
+	senate := New(&config, nil, db)
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	header := &types.Header{Number: big.NewInt(2), Time: 105}
+	headerExtra := HeaderExtra{Epoch: 1, EpochTime: 100}
+	txs := []*types.Transaction{signTestTransaction(t, 0, testUserAddress, "senate:1:event:candidate")}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	// Deregister, the deposit is locked for the unbonding period
+	header = &types.Header{Number: big.NewInt(3), Time: 110}
+	headerExtra = HeaderExtra{Root: root, Epoch: 1, EpochTime: 100}
+	txs = []*types.Transaction{signTestTransaction(t, 1, testUserAddress, "senate:1:event:uncandidate")}
+
+	// A refund failing to unbond fails the block, as replaying it does
+	broken, err := loadSnapshot(db, Root{DepositHash: root.DepositHash, CandidateHash: root.CandidateHash, UnbondHash: common.HexToHash("0x01")})
+	assert.Nil(t, err)
+	brokenExtra := headerExtra
+	assert.NotNil(t, senate.processTransactions(config, statedb.Copy(), header, broken, &brokenExtra, txs, nil))
+	broken, err = loadSnapshot(db, Root{DepositHash: root.DepositHash, CandidateHash: root.CandidateHash, UnbondHash: common.HexToHash("0x01")})
+	assert.Nil(t, err)
+	brokenExtra.CurrentBlockCancelCandidates = []common.Address{testUserAddress}
+	assert.NotNil(t, broken.apply(config, header, brokenExtra))
+
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+	assert.Equal(t, big.NewInt(900), statedb.GetBalance(testUserAddress))
+	unbondings, err := snap.GetUnbondings()
+	assert.Nil(t, err)
+	assert.Equal(t, []Unbonding{{Address: testUserAddress, Amount: big.NewInt(100), Release: 210}}, unbondings)
+	assert.Nil(t, snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase))
+	expected, err := snap.Root()
+	assert.Nil(t, err)
+
+	// Replaying the header must result in the same snapshot
+	replay, err := loadSnapshot(db, root)
+	assert.Nil(t, err)
+	assert.Nil(t, replay.apply(config, header, headerExtra))
+	replayRoot, err := replay.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, expected, replayRoot)
+
+	// Still locked in the last block of the epoch
+	assert.Nil(t, senate.releaseUnbonded(statedb, &types.Header{Number: big.NewInt(4), Time: 205}, snap))
+	assert.Equal(t, big.NewInt(900), statedb.GetBalance(testUserAddress))
+
+	// Unlocked exactly at the first block of the next epoch
+	header = &types.Header{Number: big.NewInt(5), Time: 210}
+	headerExtra = HeaderExtra{Epoch: 2, EpochTime: 210}
+	assert.Nil(t, senate.releaseRefunds(statedb, header, snap, &headerExtra))
+	assert.Nil(t, senate.releaseUnbonded(statedb, header, snap))
+	assert.Equal(t, big.NewInt(1000), statedb.GetBalance(testUserAddress))
+	unbondings, err = snap.GetUnbondings()
+	assert.Nil(t, err)
+	assert.Empty(t, unbondings)
+}
+
+func TestBatchDelegate(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+	statedb.AddBalance(testUserAddress, big.NewInt(1000))
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               100,
+		MaxValidatorsCount:  3,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		UnbondingPeriod:     100,
+		RejectConflictBlock: 1,
+	}
+	senate := New(&config, nil, db)
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	first := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	second := common.HexToAddress("0x1c1f1b2e6f0a4f7c8e8b3e7a9d2c5b4a3f2e9e52")
+	assert.Nil(t, snap.BecomeCandidate(first))
+	assert.Nil(t, snap.BecomeCandidate(second))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	batch := func(entries ...string) string {
+		return "senate:1:event:batchdelegate:" + strings.Join(entries, ",")
+	}
+
+	// A batch exceeding the balance, or naming a non-candidate, is rolled back
+	// as a whole
+	header := &types.Header{Number: big.NewInt(2), Time: 105}
+	headerExtra := HeaderExtra{Root: root, Epoch: 1, EpochTime: 100}
+	txs := []*types.Transaction{
+		signTestTransaction(t, 0, testUserAddress, batch(first.Hex()+":0x258", second.Hex()+":0x258")),
+		signTestTransaction(t, 1, testUserAddress, batch(first.Hex()+":0x64", testUserAddress.Hex()+":0x64")),
+	}
+	assert.Nil(t, senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil))
+	assert.Equal(t, big.NewInt(1000), statedb.GetBalance(testUserAddress))
+	assert.Empty(t, headerExtra.CurrentBlockBonds)
+	assert.Equal(t, []common.Hash{txs[0].Hash(), txs[1].Hash()}, headerExtra.CurrentBlockRejects)
+	unchanged, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, root, unchanged)
+
+	// A batch within the balance locks every amount
+	headerExtra = HeaderExtra{Root: root, Epoch: 1, EpochTime: 100}
+	txs = []*types.Transaction{signTestTransaction(t, 2, testUserAddress, batch(first.Hex()+":0x12c", second.Hex()+":0xc8"))}
+	assert.Nil(t, senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil))
+	assert.Equal(t, big.NewInt(500), statedb.GetBalance(testUserAddress))
+	assert.Equal(t, big.NewInt(500), statedb.GetBalance(StakingAddress))
+	assert.Empty(t, headerExtra.CurrentBlockRejects)
+	bond, err := snap.GetBond(first, testUserAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(300), bond)
+	bond, err = snap.GetBond(second, testUserAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(200), bond)
+	assert.Nil(t, snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase))
+	expected, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(expected))
+
+	replay, err := loadSnapshot(db, root)
+	assert.Nil(t, err)
+	assert.Nil(t, replay.apply(config, header, headerExtra))
+	replayRoot, err := replay.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, expected, replayRoot)
+	votes, err := replay.CountVotes(statedb, first)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(300), votes)
+
+	// Unbonding locks the amount for the unbonding period
+	header = &types.Header{Number: big.NewInt(3), Time: 110}
+	headerExtra = HeaderExtra{Root: expected, Epoch: 1, EpochTime: 100}
+	txs = []*types.Transaction{signTestTransaction(t, 3, first, "senate:1:event:unbond")}
+	assert.Nil(t, senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil))
+	assert.Equal(t, big.NewInt(500), statedb.GetBalance(testUserAddress))
+	bond, err = snap.GetBond(first, testUserAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, bond.Sign())
+	unbondings, err := snap.GetUnbondings()
+	assert.Nil(t, err)
+	assert.Equal(t, []Unbonding{{Address: testUserAddress, Amount: big.NewInt(300), Release: 210, Bonded: true}}, unbondings)
+	assert.Equal(t, big.NewInt(500), statedb.GetBalance(StakingAddress))
+	assert.Nil(t, snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase))
+	unbonded, err := snap.Root()
+	assert.Nil(t, err)
+
+	replay, err = loadSnapshot(db, expected)
+	assert.Nil(t, err)
+	assert.Nil(t, replay.apply(config, header, headerExtra))
+	replayRoot, err = replay.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, unbonded, replayRoot)
+
+	// The staking account pays the amount out once released
+	assert.Nil(t, senate.releaseUnbonded(statedb, &types.Header{Number: big.NewInt(4), Time: 210}, snap))
+	assert.Equal(t, big.NewInt(800), statedb.GetBalance(testUserAddress))
+	assert.Equal(t, big.NewInt(200), statedb.GetBalance(StakingAddress))
+}
+
+func TestBatchDelegateRules(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+	statedb.AddBalance(testUserAddress, big.NewInt(1000))
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               100,
+		MaxValidatorsCount:  3,
+		MinDelegatorBalance: big.NewInt(1001),
+		MinCandidateBalance: big.NewInt(0),
+		StagedDelegation:    true,
+	}
+	senate := New(&config, nil, db)
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	candidate := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	assert.Nil(t, snap.BecomeCandidate(candidate))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+	data := "senate:1:event:batchdelegate:" + candidate.Hex() + ":0x64"
+
+	// A balance below the minimum of delegators can't bond, as it can't vote
+	header := &types.Header{Number: big.NewInt(2), Time: 105}
+	headerExtra := HeaderExtra{Root: root, Epoch: 1, EpochTime: 100}
+	txs := []*types.Transaction{signTestTransaction(t, 0, candidate, data)}
+	assert.Nil(t, senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil))
+	assert.Empty(t, headerExtra.CurrentBlockBonds)
+	assert.Equal(t, big.NewInt(1000), statedb.GetBalance(testUserAddress))
+
+	// Staged bonds are locked at once but count since the next epoch
+	config.MinDelegatorBalance = big.NewInt(0)
+	headerExtra = HeaderExtra{Root: root, Epoch: 1, EpochTime: 100}
+	assert.Nil(t, senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil))
+	assert.Equal(t, big.NewInt(900), statedb.GetBalance(testUserAddress))
+	bond, err := snap.GetBond(candidate, testUserAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, bond.Sign())
+	dump, err := snap.Dump()
+	assert.Nil(t, err)
+	assert.Equal(t, "100", dump.StagedBonds[candidate][testUserAddress].String())
+	assert.Nil(t, snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase))
+	expected, err := snap.Root()
+	assert.Nil(t, err)
+
+	replay, err := loadSnapshot(db, root)
+	assert.Nil(t, err)
+	assert.Nil(t, replay.apply(config, header, headerExtra))
+	replayRoot, err := replay.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, expected, replayRoot)
+
+	header = &types.Header{Number: big.NewInt(3), Time: 200}
+	headerExtra = HeaderExtra{Epoch: 2, EpochTime: 200}
+	assert.Nil(t, senate.applyStagedDelegates(header, snap, &headerExtra))
+	bond, err = snap.GetBond(candidate, testUserAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(100), bond)
+	dump, err = snap.Dump()
+	assert.Nil(t, err)
+	assert.Empty(t, dump.StagedBonds)
+}
+
+func TestBondLocksBalance(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+	statedb.AddBalance(testUserAddress, big.NewInt(1000))
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               100,
+		MaxValidatorsCount:  3,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+	}
+	senate := New(&config, nil, db)
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	candidate := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	assert.Nil(t, snap.BecomeCandidate(candidate))
+
+	// The bonded amount leaves the balance, only the free balance transfers
+	header := &types.Header{Number: big.NewInt(2), Time: 105}
+	headerExtra := HeaderExtra{Epoch: 1, EpochTime: 100}
+	txs := []*types.Transaction{signTestTransaction(t, 0, testUserAddress, "senate:1:event:batchdelegate:"+candidate.Hex()+":0x258")}
+	assert.Nil(t, senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil))
+	assert.False(t, core.CanTransfer(statedb, testUserAddress, big.NewInt(401)))
+	assert.True(t, core.CanTransfer(statedb, testUserAddress, big.NewInt(400)))
+	assertStakingHoldsBonds(t, statedb, snap, candidate)
+
+	// Unbonding without an unbonding period frees the amount again
+	header = &types.Header{Number: big.NewInt(3), Time: 110}
+	headerExtra = HeaderExtra{Epoch: 1, EpochTime: 100}
+	txs = []*types.Transaction{signTestTransaction(t, 1, candidate, "senate:1:event:unbond")}
+	assert.Nil(t, senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil))
+	assert.True(t, core.CanTransfer(statedb, testUserAddress, big.NewInt(1000)))
+	assertStakingHoldsBonds(t, statedb, snap, candidate)
+}
+
+func TestLockDelegation(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+	statedb.AddBalance(testUserAddress, big.NewInt(1000))
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               100,
+		MaxValidatorsCount:  3,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		LockDelegation:      true,
+	}
+	senate := New(&config, nil, db)
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	candidate := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	assert.Nil(t, snap.BecomeCandidate(candidate))
+
+	// A plain delegate locks the amount, the rest of the balance is free but
+	// doesn't vote
+	header := &types.Header{Number: big.NewInt(2), Time: 105}
+	headerExtra := HeaderExtra{Epoch: 1, EpochTime: 100}
+	txs := []*types.Transaction{signTestTransaction(t, 0, candidate, "senate:1:event:delegate:0x258")}
+	assert.Nil(t, senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil))
+	assert.Empty(t, headerExtra.CurrentBlockDelegates)
+	assert.False(t, core.CanTransfer(statedb, testUserAddress, big.NewInt(401)))
+	assert.True(t, core.CanTransfer(statedb, testUserAddress, big.NewInt(400)))
+	votes, err := snap.CountVotes(statedb, candidate)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(600), votes)
+	assertStakingHoldsBonds(t, statedb, snap, candidate)
+
+	// Without an amount the whole balance is locked
+	headerExtra = HeaderExtra{Epoch: 1, EpochTime: 100}
+	txs = []*types.Transaction{signTestTransaction(t, 1, candidate, "senate:1:event:delegate")}
+	assert.Nil(t, senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil))
+	assert.False(t, core.CanTransfer(statedb, testUserAddress, big.NewInt(1)))
+	votes, err = snap.CountVotes(statedb, candidate)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(1000), votes)
+	assertStakingHoldsBonds(t, statedb, snap, candidate)
+
+	// Nothing left to lock
+	headerExtra = HeaderExtra{Epoch: 1, EpochTime: 100}
+	assert.Nil(t, senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil))
+	assert.Empty(t, headerExtra.CurrentBlockBonds)
+}
+
+// assertStakingHoldsBonds checks the staking account holds the sum of the bonds
+// for the candidates.
+func assertStakingHoldsBonds(t *testing.T, statedb *state.StateDB, snap *Snapshot, candidates ...common.Address) {
+	total := big.NewInt(0)
+	for _, candidate := range candidates {
+		bonds, err := snap.GetBonds(candidate)
+		assert.Nil(t, err)
+		for _, bond := range bonds {
+			total.Add(total, bond.Amount)
+		}
+	}
+	assert.Equal(t, total.String(), statedb.GetBalance(StakingAddress).String())
+}
+
+func TestMergeBonds(t *testing.T) {
+	validator := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	first, second, third := common.BigToAddress(big.NewInt(1)), common.BigToAddress(big.NewInt(2)), common.BigToAddress(big.NewInt(3))
+	stakes := []Stake{
+		{Candidate: validator, Delegator: first, Amount: big.NewInt(10)},
+		{Candidate: validator, Delegator: third, Amount: big.NewInt(30)},
+	}
+	bonds := []Bond{
+		{Candidate: validator, Delegator: second, Amount: big.NewInt(20)},
+		{Candidate: validator, Delegator: third, Amount: big.NewInt(5)},
+	}
+	assert.Equal(t, []Stake{
+		{Candidate: validator, Delegator: first, Amount: big.NewInt(10)},
+		{Candidate: validator, Delegator: second, Amount: big.NewInt(20)},
+		{Candidate: validator, Delegator: third, Amount: big.NewInt(35)},
+	}, mergeBonds(stakes, bonds))
+	assert.Equal(t, big.NewInt(30), stakes[1].Amount)
+	assert.Equal(t, stakes, mergeBonds(stakes, nil))
+}
+
+// countingDatabase counts the reads from the underlying database.
+type countingDatabase struct {
+	ethdb.Database
+	reads int
+}
+
+func (db *countingDatabase) Get(key []byte) ([]byte, error) {
+	db.reads++
+	return db.Database.Get(key)
+}
+
+func BenchmarkLoadSnapshot(b *testing.B) {
+	validators := make(SortableAddresses, 21)
+	for i := range validators {
+		validators[i] = SortableAddress{Address: common.BigToAddress(big.NewInt(int64(i + 1))), Weight: big.NewInt(0)}
+	}
+	run := func(b *testing.B, cached bool) {
+		db := &countingDatabase{Database: rawdb.NewMemoryDatabase()}
+		senate := New(&params.SenateConfig{}, nil, db)
+		snap, _ := newSnapshot(db)
+		snap.SetValidators(validators)
+		root, _ := snap.Root()
+		snap.Commit(root)
+		db.reads = 0
+
+		// Verify sequential headers, each one loads the snapshot of its parent
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if cached {
+				snap, _ = senate.loadSnapshot(root)
+			} else {
+				snap, _ = loadSnapshot(db, root)
+			}
+			snap.GetValidators()
+			snap.MintBlock(1, uint64(i+1), validators[i%len(validators)].Address)
+			root, _ = snap.Root()
+			snap.Commit(root)
+			if cached {
+				senate.cacheSnapshot(snap)
+			}
+		}
+		b.ReportMetric(float64(db.reads)/float64(b.N), "reads/op")
+	}
+	b.Run("db", func(b *testing.B) { run(b, false) })
+	b.Run("cache", func(b *testing.B) { run(b, true) })
+}
+
+func BenchmarkChainConfigByHash(b *testing.B) {
+	db := &countingDatabase{Database: rawdb.NewMemoryDatabase()}
+	senate := New(&params.SenateConfig{}, nil, db)
+
+	// A few config changes over a long chain
+	var hashes []common.Hash
+	for period := uint64(1); period <= 4; period++ {
+		snap, _ := newSnapshot(db)
+		snap.SetChainConfig(params.SenateConfig{Period: period, Epoch: 600})
+		root, _ := snap.Root()
+		snap.Commit(root)
+		hashes = append(hashes, root.ConfigHash)
+	}
+	db.reads = 0
+
+	b.ResetTimer()
+	reads := make(map[common.Hash]int)
+	for i := 0; i < b.N; i++ {
+		hash := hashes[i*len(hashes)/b.N]
+		before := db.reads
+		config, err := senate.chainConfigByHash(hash)
+		if err != nil || config.Epoch != 600 {
+			b.Fatal("unexpected chain config", err)
+		}
+		reads[hash] += db.reads - before
+	}
+	for hash, count := range reads {
+		if _, ok := senate.configs.Get(hash); !ok || count > 1 {
+			b.Fatalf("config %x read %d times from database", hash, count)
+		}
+	}
+	b.ReportMetric(float64(db.reads)/float64(b.N), "reads/op")
+}
+
+// testChainReader implements consensus.ChainHeaderReader over a slice of headers.
+type testChainReader struct {
+	headers []*types.Header
+	config  *params.ChainConfig
+}
+
+func (chain *testChainReader) Config() *params.ChainConfig {
+	if chain.config != nil {
+		return chain.config
+	}
+	return params.AllCliqueProtocolChanges
+}
+
+func (chain *testChainReader) CurrentHeader() *types.Header {
+	return chain.headers[len(chain.headers)-1]
+}
+
+func (chain *testChainReader) GetHeader(hash common.Hash, number uint64) *types.Header {
+	header := chain.GetHeaderByNumber(number)
+	if header == nil || header.Hash() != hash {
+		return nil
+	}
+	return header
+}
+
+func (chain *testChainReader) GetHeaderByNumber(number uint64) *types.Header {
+	if number >= uint64(len(chain.headers)) {
+		return nil
+	}
+	return chain.headers[number]
+}
+
+func (chain *testChainReader) GetHeaderByHash(hash common.Hash) *types.Header {
+	for _, header := range chain.headers {
+		if header.Hash() == hash {
+			return header
+		}
+	}
+	return nil
+}
+
+func newTestHeader(t *testing.T, number uint64, parent common.Hash, headerExtra HeaderExtra) *types.Header {
+	data, err := headerExtra.Encode()
+	assert.Nil(t, err)
+
+	extra := make([]byte, extraVanity, extraVanity+len(data)+extraSeal)
+	extra = append(extra, data...)
+	extra = append(extra, make([]byte, extraSeal)...)
+	return &types.Header{
+		ParentHash: parent,
+		Number:     new(big.Int).SetUint64(number),
+		Difficulty: big.NewInt(defaultDifficulty),
+		Extra:      extra,
+	}
+}
+
+// sealTestHeader seals the header by the test user as its coinbase.
+func sealTestHeader(t *testing.T, header *types.Header) {
+	header.Coinbase = testUserAddress
+	sig, err := crypto.Sign(SealHash(header, nil, nil).Bytes(), testUserKey)
+	assert.Nil(t, err)
+	copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+}
+
+func TestTotalEmitted(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               10,
+		MaxValidatorsCount:  3,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Rewards: []params.SenateReward{
+			{Height: 3, Reward: big.NewInt(5)},
+			{Height: 100, Reward: big.NewInt(2)},
+		},
+	}
+	senate := New(&config, nil, db)
+
+	// Rewards rules changed by proposal since block 4
+	changed := config
+	changed.Rewards = []params.SenateReward{{Height: 100, Reward: big.NewInt(7)}}
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.SetChainConfig(changed))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	chain := &testChainReader{headers: []*types.Header{{Number: big.NewInt(0)}}}
+	for number := uint64(1); number <= 6; number++ {
+		var headerExtra HeaderExtra
+		if number >= 4 {
+			headerExtra.Root.ConfigHash = root.ConfigHash
+		}
+		parent := chain.CurrentHeader()
+		header := newTestHeader(t, number, parent.Hash(), headerExtra)
+		header.Coinbase = testUserAddress
+		chain.headers = append(chain.headers, header)
+
+		config, err := senate.chainConfig(parent)
+		assert.Nil(t, err)
+		assert.Nil(t, senate.accumulateRewards(config, statedb, snap, header, header.Coinbase, new(HeaderExtra)))
+
+		total, err := senate.TotalEmitted(chain, number)
+		assert.Nil(t, err)
+		assert.Equal(t, statedb.GetBalance(testUserAddress), total)
+	}
+	assert.Equal(t, big.NewInt(28), statedb.GetBalance(testUserAddress))
+}
+
+func TestTotalEmittedNetOfBurns(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	validator1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	validator2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	validator3 := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	candidate := common.HexToAddress("0x90fcc640d56532c8d4f1255a44533b8d097149c6")
+	treasury := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	config := params.SenateConfig{
+		Period:             5,
+		Epoch:              60,
+		MaxValidatorsCount: 3,
+		MinMintPercent:     75,
+		SlashPercent:       10,
+		InitialReward:      big.NewInt(100),
+		Treasury:           treasury,
+		TreasuryPercent:    10,
+	}
+	senate := New(&config, nil, db)
+
+	// validator3 minted 1 of the 4 expected blocks in the last epoch
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	for _, address := range []common.Address{validator1, validator2, validator3, candidate} {
+		assert.Nil(t, snap.BecomeCandidate(address))
+	}
+	assert.Nil(t, snap.SetValidators(SortableAddresses{
+		{Address: validator1, Weight: big.NewInt(0)},
+		{Address: validator2, Weight: big.NewInt(0)},
+		{Address: validator3, Weight: big.NewInt(0)},
+	}))
+	number := uint64(1)
+	for validator, minted := range map[common.Address]int{validator1: 4, validator2: 4, validator3: 1} {
+		for i := 0; i < minted; i++ {
+			assert.Nil(t, snap.MintBlock(1, number, validator))
+			number++
+		}
+	}
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	parent := newTestHeader(t, 1, genesis.Hash(), HeaderExtra{Root: root, Epoch: 1, EpochTime: 100})
+	chain := &testChainReader{headers: []*types.Header{genesis, parent}}
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+	statedb.AddBalance(validator1, big.NewInt(1000))
+	statedb.AddBalance(validator3, big.NewInt(1000))
+	supply := func() *big.Int {
+		total := new(big.Int)
+		for _, address := range []common.Address{validator1, validator2, validator3, candidate, treasury} {
+			total.Add(total, statedb.GetBalance(address))
+		}
+		return total
+	}
+	before := supply()
+
+	// The block burns 50 of base fee and slashes 100 without a slash fund
+	header := newTestHeader(t, 2, parent.Hash(), HeaderExtra{Epoch: 2, EpochTime: 165})
+	header.Time = 165
+	header.Coinbase = validator1
+	header.BaseFee = big.NewInt(1)
+	header.GasUsed = 50
+	block, err := senate.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+	assert.Nil(t, err)
+	chain.headers = append(chain.headers, block.Header())
+	assert.Equal(t, big.NewInt(10), statedb.GetBalance(treasury))
+	assert.Equal(t, big.NewInt(900), statedb.GetBalance(validator3))
+
+	emitted, err := senate.TotalEmitted(chain, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(100), emitted)
+	total, err := senate.TotalEmitted(chain, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(50), total)
+	assert.Equal(t, new(big.Int).Sub(total, emitted), new(big.Int).Sub(supply(), before))
+}
+
+func TestBlockRewardHalving(t *testing.T) {
+	config := params.SenateConfig{
+		Rewards:         []params.SenateReward{{Height: 100, Reward: big.NewInt(7)}},
+		InitialReward:   big.NewInt(100),
+		HalvingInterval: 10,
+		MinReward:       big.NewInt(20),
+	}
+	tests := []struct {
+		number uint64
+		reward int64
+	}{
+		{1, 100},
+		{9, 100},
+		{10, 50},
+		{19, 50},
+		{20, 25},
+		{29, 25},
+		{30, 20}, // 12 is below the floor
+		{1000, 20},
+		{math.MaxUint64, 20},
+	}
+	for _, test := range tests {
+		assert.Equal(t, big.NewInt(test.reward), blockReward(config, test.number), fmt.Sprintf("block %d", test.number))
+	}
+
+	// Without floor the reward halves down to nothing
+	config.MinReward = nil
+	assert.Equal(t, big.NewInt(1), blockReward(config, 60))
+	assert.Nil(t, blockReward(config, 70))
+
+	// Without halving interval the initial reward stays
+	config.HalvingInterval = 0
+	assert.Equal(t, big.NewInt(100), blockReward(config, math.MaxUint64))
+
+	// The reward rules apply if no initial reward is configured
+	config.InitialReward = nil
+	assert.Equal(t, big.NewInt(7), blockReward(config, 30))
+}
+
+func TestNoRewards(t *testing.T) {
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               20,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+		Rewards:             []params.SenateReward{{Height: 9999999999, Reward: big.NewInt(5)}},
+		NoRewards:           true,
+	}
+	newState := func() *state.StateDB {
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		assert.Nil(t, err)
+		statedb.AddBalance(testUserAddress, big.NewInt(1000))
+		return statedb
+	}
+
+	// The miner and a verifying node finalize the same blocks on their own
+	miner := New(&config, nil, rawdb.NewMemoryDatabase())
+	verifier := New(&config, nil, rawdb.NewMemoryDatabase())
+	minerState, verifierState := newState(), newState()
+	genesis := &types.Header{Number: big.NewInt(0), Time: uint64(time.Now().Unix()) + 3600}
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	for number := uint64(1); number <= 5; number++ {
+		header := &types.Header{Number: new(big.Int).SetUint64(number), ParentHash: chain.CurrentHeader().Hash(), Coinbase: testUserAddress}
+		assert.Nil(t, miner.Prepare(chain, header))
+		block, err := miner.FinalizeAndAssemble(chain, header, minerState, nil, nil, nil)
+		assert.Nil(t, err)
+
+		verified := block.Header()
+		verified.Root = common.Hash{}
+		verifier.Finalize(chain, verified, verifierState, nil, nil)
+		assert.Equal(t, block.Root(), verified.Root)
+		chain.headers = append(chain.headers, block.Header())
+	}
+	assert.Equal(t, big.NewInt(1000), minerState.GetBalance(testUserAddress))
+	assert.Equal(t, big.NewInt(1000), verifierState.GetBalance(testUserAddress))
+
+	emitted, err := miner.TotalEmitted(chain, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, emitted.Sign())
+
+	// The same rules reward the validator unless disabled
+	config.NoRewards = false
+	assert.Equal(t, big.NewInt(5), blockReward(config, 1))
+}
+
+func TestAccumulateRewardsSharing(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	config := params.SenateConfig{
+		Rewards:       []params.SenateReward{{Height: 100, Reward: big.NewInt(101)}},
+		RewardSharing: true,
+		Commission:    10,
+	}
+	senate := New(&config, nil, db)
+
+	validator := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	delegator1 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	delegator2 := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.BecomeCandidate(validator))
+	assert.Nil(t, snap.Delegate(delegator1, validator))
+	assert.Nil(t, snap.Delegate(delegator2, validator))
+	statedb.SetBalance(delegator1, big.NewInt(1000))
+	statedb.SetBalance(delegator2, big.NewInt(3000))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	// Stakes are fixed at the election, a replay of the block fixes the same
+	stakes := fixTestStakes(t, senate, config, statedb, snap, validator)
+	assert.Equal(t, []Stake{
+		{Candidate: validator, Delegator: delegator2, Amount: big.NewInt(3000)},
+		{Candidate: validator, Delegator: delegator1, Amount: big.NewInt(1000)},
+	}, stakes)
+	expected, err := snap.Root()
+	assert.Nil(t, err)
+	replay, err := loadSnapshot(db, root)
+	assert.Nil(t, err)
+	assert.Nil(t, replay.SetValidators(SortableAddresses{{Address: validator, Weight: big.NewInt(0)}}))
+	assert.Nil(t, replay.SetStakes(stakes))
+	replayRoot, err := replay.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, expected, replayRoot)
+
+	// Coins moved between delegators after the election earn nothing more
+	statedb.SetBalance(delegator1, big.NewInt(4000))
+	statedb.SetBalance(delegator2, big.NewInt(0))
+	late := common.HexToAddress("0x90fcc640d56532c8d4f1255a44533b8d097149c6")
+	assert.Nil(t, snap.Delegate(late, validator))
+	statedb.SetBalance(late, big.NewInt(100000))
+
+	// 90 is shared by stake 1:3, the rounding remainder goes to validator
+	header := newTestHeader(t, 1, common.Hash{}, HeaderExtra{})
+	assert.Nil(t, senate.accumulateRewards(config, statedb, snap, header, validator, new(HeaderExtra)))
+	assert.Equal(t, big.NewInt(12), statedb.GetBalance(validator))
+	assert.Equal(t, big.NewInt(4022), statedb.GetBalance(delegator1))
+	assert.Equal(t, big.NewInt(67), statedb.GetBalance(delegator2))
+	assert.Equal(t, big.NewInt(100000), statedb.GetBalance(late))
+
+	distributed := new(big.Int).Add(statedb.GetBalance(validator), big.NewInt(22+67))
+	assert.Equal(t, big.NewInt(101), distributed)
+
+	// Validator without delegators keeps the whole reward
+	other := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	assert.Nil(t, snap.BecomeCandidate(other))
+	assert.Nil(t, senate.accumulateRewards(config, statedb, snap, header, other, new(HeaderExtra)))
+	assert.Equal(t, big.NewInt(101), statedb.GetBalance(other))
+
+	// Rewards are not shared by default
+	config.RewardSharing = false
+	assert.Nil(t, senate.accumulateRewards(config, statedb, snap, header, validator, new(HeaderExtra)))
+	assert.Equal(t, big.NewInt(113), statedb.GetBalance(validator))
+	assert.Equal(t, big.NewInt(4022), statedb.GetBalance(delegator1))
+}
+
+// fixTestStakes elects validator and fixes the stakes of its delegators like
+// the first block of an epoch.
+func fixTestStakes(t *testing.T, senate *Senate, config params.SenateConfig, statedb *state.StateDB,
+	snap *Snapshot, validator common.Address) []Stake {
+
+	assert.Nil(t, snap.SetValidators(SortableAddresses{{Address: validator, Weight: big.NewInt(0)}}))
+	header := &types.Header{Number: big.NewInt(1), Time: 100}
+	headerExtra := HeaderExtra{EpochTime: header.Time}
+	assert.Nil(t, senate.fixStakes(config, statedb, header, snap, &headerExtra))
+	return headerExtra.CurrentBlockStakes
+}
+
+func TestWithdrawReward(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	config := params.SenateConfig{
+		Rewards:        []params.SenateReward{{Height: 100, Reward: big.NewInt(101)}},
+		RewardSharing:  true,
+		PendingRewards: true,
+		Commission:     10,
+	}
+	senate := New(&config, nil, db)
+
+	validator := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	delegator := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.BecomeCandidate(validator))
+	assert.Nil(t, snap.Delegate(delegator, validator))
+	assert.Nil(t, snap.Delegate(testUserAddress, validator))
+	statedb.SetBalance(delegator, big.NewInt(1000))
+	statedb.SetBalance(testUserAddress, big.NewInt(3000))
+	fixTestStakes(t, senate, config, statedb, snap, validator)
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	// Shares accrue over blocks instead of being credited
+	for number := int64(1); number <= 2; number++ {
+		header := &types.Header{Number: big.NewInt(number), Time: 100 + uint64(number)*5, Coinbase: validator}
+		headerExtra := HeaderExtra{Root: root, Epoch: 10, EpochTime: 100}
+		assert.Nil(t, senate.accumulateRewards(config, statedb, snap, header, validator, &headerExtra))
+		assert.ElementsMatch(t, []Reward{
+			{Delegator: testUserAddress, Amount: big.NewInt(67)},
+			{Delegator: delegator, Amount: big.NewInt(22)},
+		}, headerExtra.CurrentBlockRewards)
+		assert.Nil(t, snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase))
+		expected, err := snap.Root()
+		assert.Nil(t, err)
+
+		replay, err := loadSnapshot(db, root)
+		assert.Nil(t, err)
+		assert.Nil(t, replay.apply(config, header, headerExtra))
+		replayRoot, err := replay.Root()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, replayRoot)
+		assert.Nil(t, snap.Commit(expected))
+		root = expected
+	}
+	assert.Equal(t, big.NewInt(24), statedb.GetBalance(validator))
+	assert.Equal(t, big.NewInt(3000), statedb.GetBalance(testUserAddress))
+	pending, err := snap.GetPendingReward(testUserAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(134), pending)
+
+	// Nothing to claim leaves the snapshot of a chain without pending rewards
+	empty, err := newSnapshot(db)
+	assert.Nil(t, err)
+	header := &types.Header{Number: big.NewInt(3), Time: 115, Coinbase: validator}
+	headerExtra := HeaderExtra{Epoch: 10, EpochTime: 100}
+	txs := []*types.Transaction{signTestTransaction(t, 0, testUserAddress, "senate:1:event:withdraw")}
+	senate.processTransactions(config, statedb, header, empty, &headerExtra, txs, nil)
+	assert.Empty(t, headerExtra.CurrentBlockWithdrawals)
+	emptyRoot, err := empty.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, common.Hash{}, emptyRoot.RewardHash)
+
+	// Claim credits the balance once, a second claim is a no-op
+	headerExtra = HeaderExtra{Root: root, Epoch: 10, EpochTime: 100}
+	txs = []*types.Transaction{
+		signTestTransaction(t, 0, testUserAddress, "senate:1:event:withdraw"),
+		signTestTransaction(t, 1, testUserAddress, "senate:1:event:withdraw"),
+	}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+	assert.Equal(t, []Reward{{Delegator: testUserAddress, Amount: big.NewInt(134)}}, headerExtra.CurrentBlockWithdrawals)
+	assert.Equal(t, big.NewInt(3134), statedb.GetBalance(testUserAddress))
+	pending, err = snap.GetPendingReward(testUserAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), pending.Int64())
+	pending, err = snap.GetPendingReward(delegator)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(44), pending)
+
+	assert.Nil(t, snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase))
+	expected, err := snap.Root()
+	assert.Nil(t, err)
+	replay, err := loadSnapshot(db, root)
+	assert.Nil(t, err)
+	assert.Nil(t, replay.apply(config, header, headerExtra))
+	replayRoot, err := replay.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, expected, replayRoot)
+}
+
+func TestAccumulateRewardsTreasury(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	treasury := common.HexToAddress("0x90fcc640d56532c8d4f1255a44533b8d097149c6")
+	config := params.SenateConfig{
+		Rewards:         []params.SenateReward{{Height: 100, Reward: big.NewInt(101)}},
+		RewardSharing:   true,
+		Commission:      10,
+		Treasury:        treasury,
+		TreasuryPercent: 15,
+	}
+	senate := New(&config, nil, db)
+
+	validator := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	delegator := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.BecomeCandidate(validator))
+	assert.Nil(t, snap.Delegate(delegator, validator))
+	statedb.SetBalance(delegator, big.NewInt(1000))
+	fixTestStakes(t, senate, config, statedb, snap, validator)
+
+	// 15 of 101 goes to treasury, 77 of the rest to the delegator
+	for number := int64(1); number <= 3; number++ {
+		header := newTestHeader(t, uint64(number), common.Hash{}, HeaderExtra{})
+		assert.Nil(t, senate.accumulateRewards(config, statedb, snap, header, validator, new(HeaderExtra)))
+		assert.Equal(t, big.NewInt(15*number), statedb.GetBalance(treasury))
+		assert.Equal(t, big.NewInt(9*number), statedb.GetBalance(validator))
+		assert.Equal(t, big.NewInt(1000+77*number), statedb.GetBalance(delegator))
+	}
+
+	// No treasury share without address
+	config.Treasury = common.Address{}
+	header := newTestHeader(t, 4, common.Hash{}, HeaderExtra{})
+	assert.Nil(t, senate.accumulateRewards(config, statedb, snap, header, validator, new(HeaderExtra)))
+	assert.Equal(t, big.NewInt(45), statedb.GetBalance(treasury))
+
+	// Treasury is replaced by proposal with a new config hash
+	config.Treasury = treasury
+	assert.Nil(t, snap.SetChainConfig(config))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	other := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	proposal := Proposal{Key: ProposalTreasuryChange, Value: other.Hex()}
+	assert.Nil(t, proposal.applyTo(&config))
+	assert.Nil(t, snap.SetChainConfig(config))
+	changed, err := snap.Root()
+	assert.Nil(t, err)
+	assert.NotEqual(t, root.ConfigHash, changed.ConfigHash)
+	current, err := snap.GetChainConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, other, current.Treasury)
+
+	proposal = Proposal{Key: ProposalTreasuryChange, Value: "0x01"}
+	assert.NotNil(t, proposal.applyTo(&config))
+	proposal = Proposal{Key: ProposalTreasuryPercentChange, Value: "101"}
+	assert.NotNil(t, proposal.applyTo(&config))
+}
+
+func TestRotateKey(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               10,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+	}
+	senate := New(&config, nil, db)
+	signerKey, _ := crypto.GenerateKey()
+	signerAddress := crypto.PubkeyToAddress(signerKey.PublicKey)
+
+	// First epoch elects the genesis validator
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	header := &types.Header{Number: big.NewInt(1), Time: 100}
+	headerExtra := HeaderExtra{Epoch: 1, EpochTime: 100}
+	assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	// Rotate the signing key of validator
+	header = &types.Header{Number: big.NewInt(2), Time: 105, Coinbase: testUserAddress}
+	headerExtra = HeaderExtra{Root: root, Epoch: 1, EpochTime: 100}
+	txs := []*types.Transaction{signTestTransaction(t, 0, signerAddress, "senate:1:event:rotate")}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+	assert.Equal(t, []KeyRotation{{Candidate: testUserAddress, Signer: signerAddress}}, headerExtra.CurrentBlockKeyRotations)
+	assert.Nil(t, snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase))
+	expected, err := snap.Root()
+	assert.Nil(t, err)
+
+	replay, err := loadSnapshot(db, root)
+	assert.Nil(t, err)
+	assert.Nil(t, replay.apply(config, header, headerExtra))
+	replayRoot, err := replay.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, expected, replayRoot)
+	assert.Nil(t, snap.Commit(expected))
+
+	// The old key keeps signing until the next epoch
+	parent := newTestHeader(t, 2, common.Hash{}, HeaderExtra{Root: expected, Epoch: 1, EpochTime: 100})
+	assert.True(t, senate.inTurn(config, parent, 110, testUserAddress))
+	assert.False(t, senate.inTurn(config, parent, 110, signerAddress))
+
+	// The new key takes over since the next epoch
+	header = &types.Header{Number: big.NewInt(3), Time: 110, ParentHash: parent.Hash()}
+	headerExtra = HeaderExtra{Root: expected, Epoch: 2, EpochTime: 110}
+	assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+	root, err = snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	parent = newTestHeader(t, 3, parent.Hash(), HeaderExtra{Root: root, Epoch: 2, EpochTime: 110})
+	assert.False(t, senate.inTurn(config, parent, 115, testUserAddress))
+	assert.True(t, senate.inTurn(config, parent, 115, signerAddress))
+
+	header = newTestHeader(t, 4, parent.Hash(), HeaderExtra{Root: root, Epoch: 2, EpochTime: 110})
+	header.Time = 115
+	header.Coinbase = signerAddress
+	sig, err := crypto.Sign(SealHash(header, nil, nil).Bytes(), signerKey)
+	assert.Nil(t, err)
+	copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+	assert.Nil(t, senate.verifySeal(config, header, parent))
+
+	// Blocks signed by the new key are attributed to the candidate
+	validator, err := snap.ValidatorOf(signerAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, testUserAddress, validator)
+}
+
+func TestRewardAddress(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               10,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+		Rewards:             []params.SenateReward{{Height: 100, Reward: big.NewInt(100)}},
+		RejectConflictBlock: 1,
+	}
+	senate := New(&config, nil, db)
+	cold := common.HexToAddress("0x90fcc640d56532c8d4f1255a44533b8d097149c6")
+	other := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	header := &types.Header{Number: big.NewInt(1), Time: 100}
+	headerExtra := HeaderExtra{Epoch: 1, EpochTime: 100}
+	assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	// Rewards go to the validator by default
+	assert.Nil(t, senate.accumulateRewards(config, statedb, snap, header, testUserAddress, new(HeaderExtra)))
+	assert.Equal(t, big.NewInt(100), statedb.GetBalance(testUserAddress))
+	assert.Equal(t, common.Hash{}, root.PayoutHash)
+
+	// setRewardAddress applies a payout tx and checks the replay of the block
+	// reaches the same root
+	setRewardAddress := func(number int64, nonce uint64, address common.Address) {
+		header := &types.Header{Number: big.NewInt(number), Time: uint64(100 + 5*number), Coinbase: testUserAddress}
+		headerExtra := HeaderExtra{Root: root, Epoch: 1, EpochTime: 100}
+		txs := []*types.Transaction{signTestTransaction(t, nonce, address, "senate:1:event:payout")}
+		senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+		assert.Equal(t, []RewardAddress{{Candidate: testUserAddress, Address: address}}, headerExtra.CurrentBlockRewardAddresses)
+		assert.Nil(t, snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase))
+		expected, err := snap.Root()
+		assert.Nil(t, err)
+
+		replay, err := loadSnapshot(db, root)
+		assert.Nil(t, err)
+		assert.Nil(t, replay.apply(config, header, headerExtra))
+		replayRoot, err := replay.Root()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, replayRoot)
+		assert.Nil(t, snap.Commit(expected))
+		root = expected
+	}
+
+	// Rewards are redirected once the reward address is set
+	setRewardAddress(2, 0, cold)
+	assert.NotEqual(t, common.Hash{}, root.PayoutHash)
+	assert.Nil(t, senate.accumulateRewards(config, statedb, snap, header, testUserAddress, new(HeaderExtra)))
+	assert.Equal(t, big.NewInt(100), statedb.GetBalance(testUserAddress))
+	assert.Equal(t, big.NewInt(100), statedb.GetBalance(cold))
+
+	// A later tx replaces the reward address
+	setRewardAddress(3, 1, other)
+	assert.Nil(t, senate.accumulateRewards(config, statedb, snap, header, testUserAddress, new(HeaderExtra)))
+	assert.Equal(t, big.NewInt(100), statedb.GetBalance(cold))
+	assert.Equal(t, big.NewInt(100), statedb.GetBalance(other))
+
+	// Setting the validator itself removes the redirection
+	setRewardAddress(4, 2, testUserAddress)
+	address, err := snap.GetRewardAddress(testUserAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, testUserAddress, address)
+	assert.Nil(t, senate.accumulateRewards(config, statedb, snap, header, testUserAddress, new(HeaderExtra)))
+	assert.Equal(t, big.NewInt(200), statedb.GetBalance(testUserAddress))
+
+	// Only candidates may redirect their rewards
+	snap, err = newSnapshot(db)
+	assert.Nil(t, err)
+	headerExtra = HeaderExtra{Epoch: 1, EpochTime: 100}
+	tx := signTestTransaction(t, 0, cold, "senate:1:event:payout")
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, []*types.Transaction{tx}, nil)
+	assert.Empty(t, headerExtra.CurrentBlockRewardAddresses)
+	assert.Equal(t, []common.Hash{tx.Hash()}, headerExtra.CurrentBlockRejects)
+}
+
+func TestCandidateKey(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               10,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+	}
+	senate := New(&config, nil, db)
+	auxKey, _ := crypto.GenerateKey()
+	key := crypto.CompressPubkey(&auxKey.PublicKey)
+
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	header := &types.Header{Number: big.NewInt(1), Time: 100}
+	headerExtra := HeaderExtra{Epoch: 1, EpochTime: 100}
+	assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	// Register without a key
+	header = &types.Header{Number: big.NewInt(2), Time: 105, Coinbase: testUserAddress}
+	headerExtra = HeaderExtra{Root: root, Epoch: 1, EpochTime: 100}
+	txs := []*types.Transaction{signTestTransaction(t, 0, testUserAddress, "senate:1:event:candidate")}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+	assert.Equal(t, []common.Address{testUserAddress}, headerExtra.CurrentBlockCandidates)
+	assert.Empty(t, headerExtra.CurrentBlockCandidateKeys)
+	candidateKey, err := snap.GetCandidateKey(testUserAddress)
+	assert.Nil(t, err)
+	assert.Nil(t, candidateKey)
+	candidates, err := snap.GetCandidates()
+	assert.Nil(t, err)
+	assert.Equal(t, []common.Address{testUserAddress}, candidates)
+	withoutKey, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(withoutKey))
+
+	// Register again with a key
+	header = &types.Header{Number: big.NewInt(3), Time: 110, Coinbase: testUserAddress}
+	headerExtra = HeaderExtra{Root: withoutKey, Epoch: 1, EpochTime: 100}
+	data := fmt.Sprintf("senate:1:event:candidate::%s", hexutil.Encode(key))
+	txs = []*types.Transaction{signTestTransaction(t, 1, testUserAddress, data)}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+	assert.Equal(t, []CandidateKey{{Candidate: testUserAddress, Key: key}}, headerExtra.CurrentBlockCandidateKeys)
+	candidateKey, err = snap.GetCandidateKey(testUserAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, key, candidateKey)
+	candidates, err = snap.GetCandidates()
+	assert.Nil(t, err)
+	assert.Equal(t, []common.Address{testUserAddress}, candidates)
+
+	// The key is covered by the candidate root
+	assert.Nil(t, snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase))
+	expected, err := snap.Root()
+	assert.Nil(t, err)
+	assert.NotEqual(t, withoutKey.CandidateHash, expected.CandidateHash)
+
+	replay, err := loadSnapshot(db, withoutKey)
+	assert.Nil(t, err)
+	assert.Nil(t, replay.apply(config, header, headerExtra))
+	replayRoot, err := replay.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, expected, replayRoot)
+	candidateKey, err = replay.GetCandidateKey(testUserAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, key, candidateKey)
+}
+
+func TestCandidateDeclaration(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               10,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+	}
+	senate := New(&config, nil, db)
+
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	header := &types.Header{Number: big.NewInt(1), Time: 100}
+	headerExtra := HeaderExtra{Epoch: 1, EpochTime: 100}
+	assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+	assert.Nil(t, snap.Declare(1, Declare{ProposalHash: common.HexToHash("0x01"), Declarer: testUserAddress, Decision: true}))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	// describe processes the block at number and checks the replayed snapshot
+	describe := func(parent Root, number int64, txs ...*types.Transaction) (HeaderExtra, Root) {
+		header := &types.Header{Number: big.NewInt(number), Time: 100 + uint64(number)*5, Coinbase: testUserAddress}
+		headerExtra := HeaderExtra{Root: parent, Epoch: 1, EpochTime: 100}
+		senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+		assert.Nil(t, snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase))
+		expected, err := snap.Root()
+		assert.Nil(t, err)
+
+		replay, err := loadSnapshot(db, parent)
+		assert.Nil(t, err)
+		assert.Nil(t, replay.apply(config, header, headerExtra))
+		replayRoot, err := replay.Root()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, replayRoot)
+		assert.Nil(t, snap.Commit(expected))
+		return headerExtra, expected
+	}
+
+	// Only candidates publish a description
+	other := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	stranger := types.NewTransaction(0, other, big.NewInt(0), 99999999, big.NewInt(1000), []byte(`senate:1:event:describe:{"name":"stranger"}`))
+	stranger, err = types.SignTx(stranger, types.HomesteadSigner{}, testKey)
+	assert.Nil(t, err)
+	headerExtra, described := describe(root, 2,
+		signTestTransaction(t, 0, testUserAddress, `senate:1:event:describe:{"name":"node","website":"https://example.org"}`), stranger)
+	expected := CandidateDeclaration{Candidate: testUserAddress, Name: "node", Website: "https://example.org"}
+	assert.Equal(t, []CandidateDeclaration{expected}, headerExtra.CurrentBlockDeclarations)
+	assert.NotEqual(t, root.DeclareHash, described.DeclareHash)
+	declaration, err := snap.GetCandidateDeclaration(testUserAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, &expected, declaration)
+	declaration, err = snap.GetCandidateDeclaration(crypto.PubkeyToAddress(testKey.PublicKey))
+	assert.Nil(t, err)
+	assert.Nil(t, declaration)
+
+	// A later description replaces the previous one
+	_, overwritten := describe(described, 3, signTestTransaction(t, 1, testUserAddress, `senate:1:event:describe:{"name":"renamed"}`))
+	declaration, err = snap.GetCandidateDeclaration(testUserAddress)
+	assert.Nil(t, err)
+	assert.Equal(t, &CandidateDeclaration{Candidate: testUserAddress, Name: "renamed"}, declaration)
+	assert.NotEqual(t, described.DeclareHash, overwritten.DeclareHash)
+
+	// Declarations on proposals are kept apart
+	dump, err := snap.Dump()
+	assert.Nil(t, err)
+	assert.Equal(t, map[common.Address]CandidateDeclaration{testUserAddress: *declaration}, dump.Declarations)
+	assert.Len(t, dump.Declares[common.HexToHash("0x01")][1], 1)
+	declarations, err := snap.GetDeclarations(common.HexToHash("0x01"), 1)
+	assert.Nil(t, err)
+	assert.Len(t, declarations, 1)
+}
+
+func TestStagedDelegation(t *testing.T) {
+	candidate1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	candidate2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+
+	// elect runs the first block of an epoch and returns the elected validator
+	elect := func(senate *Senate, config params.SenateConfig, statedb *state.StateDB, snap *Snapshot, number, time uint64) common.Address {
+		header := &types.Header{Number: new(big.Int).SetUint64(number), Time: time, Coinbase: candidate1}
+		headerExtra := HeaderExtra{Epoch: time / 10, EpochTime: time}
+		assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+		assert.Nil(t, senate.applyStagedDelegates(header, snap, &headerExtra))
+		assert.Len(t, headerExtra.CurrentEpochValidators, 1)
+		// Reading stakes mustn't create the deposit trie, replay never does
+		root, err := snap.Root()
+		assert.Nil(t, err)
+		assert.Equal(t, common.Hash{}, root.DepositHash)
+		return headerExtra.CurrentEpochValidators[0].Address
+	}
+
+	for _, staged := range []bool{false, true} {
+		db := rawdb.NewMemoryDatabase()
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+		assert.Nil(t, err)
+		statedb.AddBalance(testUserAddress, big.NewInt(1000))
+
+		config := params.SenateConfig{
+			Period:              5,
+			Epoch:               10,
+			MaxValidatorsCount:  1,
+			MinDelegatorBalance: big.NewInt(0),
+			MinCandidateBalance: big.NewInt(0),
+			MinMintPercent:      50,
+			WeightedElection:    true,
+			StagedDelegation:    staged,
+		}
+		senate := New(&config, nil, db)
+
+		// Only the candidate with stake is elected
+		snap, err := newSnapshot(db)
+		assert.Nil(t, err)
+		assert.Nil(t, snap.BecomeCandidate(candidate1))
+		assert.Nil(t, snap.BecomeCandidate(candidate2))
+		assert.Nil(t, snap.Delegate(testUserAddress, candidate1))
+		assert.Nil(t, snap.SetValidators(SortableAddresses{{Address: candidate1, Weight: big.NewInt(0)}}))
+		root, err := snap.Root()
+		assert.Nil(t, err)
+		assert.Nil(t, snap.Commit(root))
+
+		// Move the vote in the last block of the epoch
+		header := &types.Header{Number: big.NewInt(2), Time: 105, Coinbase: candidate1}
+		headerExtra := HeaderExtra{Root: root, Epoch: 10, EpochTime: 100}
+		txs := []*types.Transaction{signTestTransaction(t, 0, candidate2, "senate:1:event:delegate")}
+		senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+		assert.Equal(t, []Delegate{{Delegator: testUserAddress, Candidate: candidate2}}, headerExtra.CurrentBlockDelegates)
+		assert.Nil(t, snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase))
+		expected, err := snap.Root()
+		assert.Nil(t, err)
+
+		replay, err := loadSnapshot(db, root)
+		assert.Nil(t, err)
+		assert.Nil(t, replay.apply(config, header, headerExtra))
+		replayRoot, err := replay.Root()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, replayRoot)
+		assert.Nil(t, snap.Commit(expected))
+
+		if !staged {
+			assert.Equal(t, candidate2, elect(senate, config, statedb, snap, 3, 110))
+			continue
+		}
+
+		// The staged vote doesn't count in this election but in the next one
+		dump, err := snap.Dump()
+		assert.Nil(t, err)
+		assert.Equal(t, map[common.Address]common.Address{testUserAddress: candidate2}, dump.Staged)
+		votes, err := snap.CountVotes(statedb, candidate2)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), votes.Int64())
+		assert.Equal(t, candidate1, elect(senate, config, statedb, snap, 3, 110))
+		votes, err = snap.CountVotes(statedb, candidate2)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1000), votes.Int64())
+		dump, err = snap.Dump()
+		assert.Nil(t, err)
+		assert.Empty(t, dump.Staged)
+		assert.Equal(t, candidate2, elect(senate, config, statedb, snap, 4, 120))
+	}
+}
+
+func TestVoteDecay(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	candidate1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	candidate2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	delegator := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               10,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		MinMintPercent:      1,
+		WeightedElection:    true,
+		VoteDecayPercent:    50,
+	}
+	senate := New(&config, nil, db)
+
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.BecomeCandidate(candidate1))
+	assert.Nil(t, snap.BecomeCandidate(candidate2))
+	assert.Nil(t, snap.Delegate(testUserAddress, candidate1))
+	assert.Nil(t, snap.Delegate(delegator, candidate2))
+	assert.Nil(t, snap.SetValidators(SortableAddresses{{Address: candidate1, Weight: big.NewInt(0)}}))
+	statedb.SetBalance(testUserAddress, big.NewInt(1000))
+	statedb.SetBalance(delegator, big.NewInt(1001))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+	assert.Equal(t, common.Hash{}, root.DecayHash)
+
+	// mint runs the block at time like the miner and checks the replayed snapshot
+	mint := func(number, time uint64, txs ...*types.Transaction) {
+		header := &types.Header{Number: new(big.Int).SetUint64(number), Time: time, Coinbase: candidate1}
+		headerExtra := HeaderExtra{Root: root, Epoch: time / 10, EpochTime: time / 10 * 10}
+		assert.Nil(t, senate.releaseRefunds(statedb, header, snap, &headerExtra))
+		assert.Nil(t, senate.releaseUnbonded(statedb, header, snap))
+		assert.Nil(t, senate.applyPendingConfig(header, snap, &headerExtra))
+		assert.Nil(t, senate.expireProposals(header, snap, &headerExtra))
+		senate.processTransactions(config, statedb, header, snap, &headerExtra, txs, nil)
+		assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+		assert.Nil(t, snap.MintBlock(headerExtra.Epoch, number, header.Coinbase))
+		expected, err := snap.Root()
+		assert.Nil(t, err)
+
+		replay, err := loadSnapshot(db, root)
+		assert.Nil(t, err)
+		assert.Nil(t, replay.apply(config, header, headerExtra))
+		replayRoot, err := replay.Root()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, replayRoot)
+		assert.Nil(t, snap.Commit(expected))
+		root = expected
+	}
+	votes := func(candidate common.Address) int64 {
+		votes, err := snap.CountVotes(statedb, candidate)
+		assert.Nil(t, err)
+		return votes.Int64()
+	}
+
+	// Votes lose half of their weight at every epoch boundary
+	mint(2, 105)
+	assert.Equal(t, int64(1000), votes(candidate1))
+	mint(3, 110)
+	assert.Equal(t, int64(500), votes(candidate1))
+	assert.Equal(t, int64(500), votes(candidate2))
+
+	// A fresh vote restores the full weight until the next boundary
+	mint(4, 115, signTestTransaction(t, 0, candidate1, "senate:1:event:delegate"))
+	assert.Equal(t, int64(1000), votes(candidate1))
+	assert.Equal(t, int64(500), votes(candidate2))
+	mint(5, 120)
+	assert.Equal(t, int64(500), votes(candidate1))
+	assert.Equal(t, int64(250), votes(candidate2))
+	mint(6, 130)
+	assert.Equal(t, int64(250), votes(candidate1))
+	assert.Equal(t, int64(125), votes(candidate2))
+
+	dump, err := snap.Dump()
+	assert.Nil(t, err)
+	assert.Equal(t, map[common.Address]uint64{
+		testUserAddress: voteDecayUnit / 4,
+		delegator:       voteDecayUnit / 8,
+	}, dump.Decays)
+
+	// Undelegated votes leave no decay behind
+	assert.Nil(t, snap.UnDelegate(delegator, candidate2))
+	dump, err = snap.Dump()
+	assert.Nil(t, err)
+	assert.NotContains(t, dump.Decays, delegator)
+}
+
+func TestMinSelfStake(t *testing.T) {
+	address := func(n int64) common.Address { return common.BigToAddress(big.NewInt(n)) }
+	candidate1, candidate2, candidate3 := address(1), address(2), address(3)
+	build := func() (*Snapshot, *state.StateDB) {
+		db := rawdb.NewMemoryDatabase()
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+		assert.Nil(t, err)
+		snap, err := newSnapshot(db)
+		assert.Nil(t, err)
+		for i, candidate := range []common.Address{candidate1, candidate2, candidate3} {
+			delegator := address(int64(i + 100))
+			assert.Nil(t, snap.BecomeCandidate(candidate))
+			assert.Nil(t, snap.Delegate(delegator, candidate))
+			statedb.SetBalance(delegator, big.NewInt(1000))
+		}
+
+		// 10% and 30% deposited, 50% voted by the candidate itself
+		assert.Nil(t, snap.SetDeposit(candidate1, big.NewInt(100)))
+		assert.Nil(t, snap.SetDeposit(candidate2, big.NewInt(300)))
+		assert.Nil(t, snap.Delegate(candidate3, candidate3))
+		statedb.SetBalance(candidate3, big.NewInt(500))
+		return snap, statedb
+	}
+
+	snap, statedb := build()
+	shortfalls, err := snap.SelfStakeShortfalls(statedb, 20)
+	assert.Nil(t, err)
+	assert.Equal(t, []common.Address{candidate1}, shortfalls)
+	shortfalls, err = snap.SelfStakeShortfalls(statedb, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, shortfalls)
+
+	// The heavily delegated candidate isn't elected with either election
+	header := &types.Header{Number: big.NewInt(1), Time: 200, ParentHash: common.HexToHash("0x01")}
+	for _, weighted := range []bool{false, true} {
+		config := params.SenateConfig{Period: 5, Epoch: 10, MaxValidatorsCount: 3, WeightedElection: weighted, MinSelfStakePercent: 20}
+		senate := New(&config, nil, rawdb.NewMemoryDatabase())
+		snap, statedb := build()
+		headerExtra := HeaderExtra{Epoch: 2, EpochTime: 200}
+		assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+		var elected []common.Address
+		for _, validator := range headerExtra.CurrentEpochValidators {
+			elected = append(elected, validator.Address)
+		}
+		assert.ElementsMatch(t, []common.Address{candidate2, candidate3}, elected)
+
+		// Lowered by proposal in the last epoch
+		pending := config
+		proposal := Proposal{Key: ProposalMinSelfStakePercentChange, Value: "10"}
+		assert.Nil(t, proposal.applyTo(&pending))
+		snap, statedb = build()
+		headerExtra = HeaderExtra{Epoch: 2, EpochTime: 200, ChainConfig: []params.SenateConfig{pending}}
+		assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+		assert.Len(t, headerExtra.CurrentEpochValidators, 3)
+	}
+
+	proposal := Proposal{Key: ProposalMinSelfStakePercentChange, Value: "101"}
+	assert.NotNil(t, proposal.applyTo(new(params.SenateConfig)))
+}
+
+func TestTryElectKickOutInactive(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	validator1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	validator2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	validator3 := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	candidate := common.HexToAddress("0x90fcc640d56532c8d4f1255a44533b8d097149c6")
+	config := params.SenateConfig{
+		Period:             5,
+		Epoch:              60,
+		MaxValidatorsCount: 3,
+		MinMintPercent:     75,
+	}
+	senate := New(&config, nil, db)
+
+	// Each validator is expected to mint 4 blocks, validator3 missed 3 of them
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	for _, address := range []common.Address{validator1, validator2, validator3, candidate} {
+		assert.Nil(t, snap.BecomeCandidate(address))
+	}
+	assert.Nil(t, snap.SetValidators(SortableAddresses{
+		{Address: validator1, Weight: big.NewInt(0)},
+		{Address: validator2, Weight: big.NewInt(0)},
+		{Address: validator3, Weight: big.NewInt(0)},
+	}))
+	number := uint64(1)
+	for validator, minted := range map[common.Address]int{validator1: 4, validator2: 3, validator3: 1} {
+		for i := 0; i < minted; i++ {
+			assert.Nil(t, snap.MintBlock(1, number, validator))
+			number++
+		}
+	}
+	assert.Equal(t, big.NewInt(3), minMintCount(config, 3))
+
+	header := &types.Header{Number: big.NewInt(int64(number)), Time: 165, ParentHash: common.HexToHash("0x01")}
+	headerExtra := HeaderExtra{Epoch: 2, EpochTime: 165}
+	assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+	assert.Equal(t, []common.Address{validator3}, headerExtra.CurrentBlockKickOutCandidates)
+	isCandidate, err := snap.IsCandidate(validator3)
+	assert.Nil(t, err)
+	assert.False(t, isCandidate)
+
+	// The inactive validator is excluded from the next epoch
+	assert.Equal(t, 3, len(headerExtra.CurrentEpochValidators))
+	for _, validator := range headerExtra.CurrentEpochValidators {
+		assert.NotEqual(t, validator3, validator.Address)
+	}
+}
+
+func TestTryElectLegacyKickOut(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	validator1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	validator2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	validator3 := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	candidate := common.HexToAddress("0x90fcc640d56532c8d4f1255a44533b8d097149c6")
+	config := params.SenateConfig{
+		Period:             5,
+		Epoch:              60,
+		MaxValidatorsCount: 3,
+	}
+	senate := New(&config, nil, db)
+
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	for _, address := range []common.Address{validator1, validator2, validator3, candidate} {
+		assert.Nil(t, snap.BecomeCandidate(address))
+	}
+	validators := SortableAddresses{
+		{Address: validator1, Weight: big.NewInt(0)},
+		{Address: validator2, Weight: big.NewInt(0)},
+		{Address: validator3, Weight: big.NewInt(0)},
+	}
+	assert.Nil(t, snap.SetValidators(validators))
+	assert.Nil(t, snap.MintBlock(1, 1, validator1))
+
+	// Without MinMintPercent a single candidate is counted, so no validator
+	// can be kicked out and the election is skipped
+	count, _ := snap.EnoughCandidates(4)
+	assert.Equal(t, 1, count)
+	count, enough := snap.CountCandidates(4)
+	assert.Equal(t, 4, count)
+	assert.True(t, enough)
+
+	header := &types.Header{Number: big.NewInt(2), Time: 165, ParentHash: common.HexToHash("0x01")}
+	headerExtra := HeaderExtra{Epoch: 2, EpochTime: 165}
+	assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+	assert.Empty(t, headerExtra.CurrentBlockKickOutCandidates)
+	assert.Empty(t, headerExtra.CurrentEpochValidators)
+	current, err := snap.GetValidators()
+	assert.Nil(t, err)
+	assert.Equal(t, validators, current)
+}
+
+func TestSlashInactiveValidator(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	validator1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	validator2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	validator3 := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	candidate := common.HexToAddress("0x90fcc640d56532c8d4f1255a44533b8d097149c6")
+	fund := common.HexToAddress("0x0000000000000000000000000000000000000fee")
+	config := params.SenateConfig{
+		Period:             5,
+		Epoch:              60,
+		MaxValidatorsCount: 3,
+		MinMintPercent:     75,
+		SlashPercent:       10,
+		SlashFund:          fund,
+	}
+	senate := New(&config, nil, db)
+
+	// validator3 minted 1 of the 4 expected blocks in the last epoch
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	for _, address := range []common.Address{validator1, validator2, validator3, candidate} {
+		assert.Nil(t, snap.BecomeCandidate(address))
+	}
+	assert.Nil(t, snap.SetValidators(SortableAddresses{
+		{Address: validator1, Weight: big.NewInt(0)},
+		{Address: validator2, Weight: big.NewInt(0)},
+		{Address: validator3, Weight: big.NewInt(0)},
+	}))
+	number := uint64(1)
+	for validator, minted := range map[common.Address]int{validator1: 4, validator2: 4, validator3: 1} {
+		for i := 0; i < minted; i++ {
+			assert.Nil(t, snap.MintBlock(1, number, validator))
+			number++
+		}
+	}
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	parent := newTestHeader(t, 1, genesis.Hash(), HeaderExtra{Root: root, Epoch: 1, EpochTime: 100})
+	chain := &testChainReader{headers: []*types.Header{genesis, parent}}
+	newState := func() *state.StateDB {
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+		assert.Nil(t, err)
+		statedb.AddBalance(validator3, big.NewInt(1000))
+		return statedb
+	}
+
+	header := newTestHeader(t, 2, parent.Hash(), HeaderExtra{Epoch: 2, EpochTime: 165})
+	header.Time = 165
+	header.Coinbase = validator1
+	statedb := newState()
+	block, err := senate.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(900), statedb.GetBalance(validator3))
+	assert.Equal(t, big.NewInt(100), statedb.GetBalance(fund))
+
+	headerExtra, err := decodeHeaderExtra(block.Header())
+	assert.Nil(t, err)
+	assert.Equal(t, []Slash{{Validator: validator3, Amount: big.NewInt(100)}}, headerExtra.CurrentBlockSlashes)
+	snap, err = loadSnapshot(db, headerExtra.Root)
+	assert.Nil(t, err)
+	slashes, err := snap.GetSlashes(2)
+	assert.Nil(t, err)
+	assert.Equal(t, headerExtra.CurrentBlockSlashes, slashes)
+
+	// Verifying nodes reach the same state and snapshot
+	verified := block.Header()
+	statedb = newState()
+	senate.Finalize(chain, verified, statedb, nil, nil)
+	assert.Equal(t, block.Root(), verified.Root)
+
+	replay, err := loadSnapshot(db, root)
+	assert.Nil(t, err)
+	assert.Nil(t, replay.apply(config, block.Header(), headerExtra))
+	replayRoot, err := replay.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, headerExtra.Root, replayRoot)
+}
+
+func TestSlashInactiveValidatorDeposit(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	validator1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	validator2 := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	candidate := common.HexToAddress("0x90fcc640d56532c8d4f1255a44533b8d097149c6")
+	fund := common.HexToAddress("0x0000000000000000000000000000000000000fee")
+	config := params.SenateConfig{
+		Period:             5,
+		Epoch:              60,
+		MaxValidatorsCount: 2,
+		MinMintPercent:     75,
+		SlashPercent:       10,
+		SlashFund:          fund,
+	}
+	senate := New(&config, nil, db)
+
+	// validator2 locked 500 and minted 1 of the 6 expected blocks
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	for _, address := range []common.Address{validator1, validator2, candidate} {
+		assert.Nil(t, snap.BecomeCandidate(address))
+	}
+	assert.Nil(t, snap.SetDeposit(validator2, big.NewInt(500)))
+	assert.Nil(t, snap.SetValidators(SortableAddresses{
+		{Address: validator1, Weight: big.NewInt(0)},
+		{Address: validator2, Weight: big.NewInt(0)},
+	}))
+	number := uint64(1)
+	for validator, minted := range map[common.Address]int{validator1: 6, validator2: 1} {
+		for i := 0; i < minted; i++ {
+			assert.Nil(t, snap.MintBlock(1, number, validator))
+			number++
+		}
+	}
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	genesis := newTestHeader(t, 0, common.Hash{}, HeaderExtra{})
+	parent := newTestHeader(t, 1, genesis.Hash(), HeaderExtra{Root: root, Epoch: 1, EpochTime: 100})
+	chain := &testChainReader{headers: []*types.Header{genesis, parent}}
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+	statedb.AddBalance(validator2, big.NewInt(1000))
+
+	header := newTestHeader(t, 2, parent.Hash(), HeaderExtra{Epoch: 2, EpochTime: 165})
+	header.Time = 165
+	header.Coinbase = validator1
+	block, err := senate.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+	assert.Nil(t, err)
+
+	// The deposit is slashed, the liquid balance is left alone
+	assert.Equal(t, big.NewInt(1000), statedb.GetBalance(validator2))
+	assert.Equal(t, big.NewInt(50), statedb.GetBalance(fund))
+	headerExtra, err := decodeHeaderExtra(block.Header())
+	assert.Nil(t, err)
+	assert.Equal(t, []Slash{{Validator: validator2, Amount: big.NewInt(50)}}, headerExtra.CurrentBlockSlashes)
+	snap, err = loadSnapshot(db, headerExtra.Root)
+	assert.Nil(t, err)
+	deposit, err := snap.GetDeposit(validator2)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(450), deposit)
+
+	replay, err := loadSnapshot(db, root)
+	assert.Nil(t, err)
+	assert.Nil(t, replay.apply(config, block.Header(), headerExtra))
+	deposit, err = replay.GetDeposit(validator2)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(450), deposit)
+	replayRoot, err := replay.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, headerExtra.Root, replayRoot)
+}
+
+func TestProposalRewards(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	treasury := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	validator := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	statedb.AddBalance(treasury, big.NewInt(1000))
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               20,
+		MaxValidatorsCount:  2,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		ProposalQuorum:      50,
+		Treasury:            treasury,
+		ProposalReward:      big.NewInt(10),
+	}
+	senate := New(&config, nil, db)
+
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.SetChainConfig(config))
+	assert.Nil(t, snap.SetValidators(SortableAddresses{
+		{Address: testUserAddress, Weight: big.NewInt(0)},
+		{Address: validator, Weight: big.NewInt(0)},
+	}))
+
+	// The declaration of one validator reaches the quorum
+	proposal := signTestTransaction(t, 0, testUserAddress, "senate:1:event:proposal:period:10")
+	declare := signTestTransaction(t, 1, testUserAddress, fmt.Sprintf("senate:1:event:declare:%s:yes", proposal.Hash().Hex()))
+	header := &types.Header{Number: big.NewInt(2), Time: 105}
+	headerExtra := HeaderExtra{Epoch: 1, EpochTime: 100}
+	senate.processTransactions(config, statedb, header, snap, &headerExtra, []*types.Transaction{proposal, declare}, nil)
+	approved, err := snap.GetProposal(proposal.Hash())
+	assert.Nil(t, err)
+	assert.NotNil(t, approved.ApprovedHash)
+
+	// Only the validator declared on it is paid, by treasury
+	assert.Equal(t, big.NewInt(10), statedb.GetBalance(testUserAddress))
+	assert.Equal(t, big.NewInt(0), statedb.GetBalance(validator))
+	assert.Equal(t, big.NewInt(990), statedb.GetBalance(treasury))
+
+	// Nothing is paid once treasury can't cover the reward
+	statedb.SetBalance(treasury, big.NewInt(5))
+	assert.Nil(t, payProposalRewards(config, statedb, snap, proposal.Hash(), headerExtra.Epoch))
+	assert.Equal(t, big.NewInt(10), statedb.GetBalance(testUserAddress))
+	assert.Equal(t, big.NewInt(5), statedb.GetBalance(treasury))
+}
+
+func TestProposalPeriodChange(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               20,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+	}
+	senate := New(&config, nil, db)
+
+	// Blocks in the future are prepared with the exact period
+	genesis := &types.Header{Number: big.NewInt(0), Time: uint64(time.Now().Unix()) + 3600}
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	proposal := signTestTransaction(t, 0, testUserAddress, "senate:1:event:proposal:period:10")
+	declare := signTestTransaction(t, 1, testUserAddress, fmt.Sprintf("senate:1:event:declare:%s:yes", proposal.Hash().Hex()))
+	txs := map[uint64][]*types.Transaction{2: {proposal}, 3: {declare}}
+	for number := uint64(1); number <= 7; number++ {
+		parent := chain.CurrentHeader()
+		header := &types.Header{Number: new(big.Int).SetUint64(number), ParentHash: parent.Hash(), Coinbase: testUserAddress}
+		assert.Nil(t, senate.Prepare(chain, header))
+		block, err := senate.FinalizeAndAssemble(chain, header, statedb, txs[number], nil, nil)
+		assert.Nil(t, err)
+
+		// Verifying nodes reach the same snapshot
+		headerExtra, err := decodeHeaderExtra(block.Header())
+		assert.Nil(t, err)
+		if number > 1 {
+			parentHeaderExtra, err := decodeHeaderExtra(parent)
+			assert.Nil(t, err)
+			parentConfig, err := senate.chainConfig(parent)
+			assert.Nil(t, err)
+			snap, err := loadSnapshot(db, parentHeaderExtra.Root)
+			assert.Nil(t, err)
+			assert.Nil(t, snap.apply(parentConfig, block.Header(), headerExtra))
+			root, err := snap.Root()
+			assert.Nil(t, err)
+			assert.Equal(t, headerExtra.Root, root)
+		}
+		chain.headers = append(chain.headers, block.Header())
+
+		snap, err := loadSnapshot(db, headerExtra.Root)
+		assert.Nil(t, err)
+		pending, err := snap.GetPendingChainConfig()
+		assert.Nil(t, err)
+		current, err := snap.GetChainConfig()
+		assert.Nil(t, err)
+		switch {
+		case number < 3:
+			assert.Nil(t, pending)
+			assert.Equal(t, uint64(5), current.Period)
+		case number < 6:
+			assert.Equal(t, uint64(10), pending.Period)
+			assert.Equal(t, uint64(5), current.Period)
+		default:
+			assert.Nil(t, pending)
+			assert.Equal(t, uint64(10), current.Period)
+		}
+	}
+
+	// The new period takes effect after the first block of next epoch
+	headers := chain.headers
+	assert.Equal(t, uint64(5), headers[6].Time-headers[5].Time)
+	assert.Equal(t, headers[6].Time, mustDecodeHeaderExtra(t, headers[6]).EpochTime)
+	assert.Equal(t, uint64(10), headers[7].Time-headers[6].Time)
+}
+
+func TestTryElectValidatorsCountChange(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               10,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+	}
+	senate := New(&config, nil, db)
+
+	// Last epoch has one active validator out of four candidates
+	candidates := make([]common.Address, 4)
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	for i := range candidates {
+		candidates[i] = common.BigToAddress(big.NewInt(int64(i + 1)))
+		assert.Nil(t, snap.BecomeCandidate(candidates[i]))
+	}
+	assert.Nil(t, snap.SetValidators(SortableAddresses{{Address: candidates[0], Weight: big.NewInt(0)}}))
+	assert.Nil(t, snap.MintBlock(1, 1, candidates[0]))
+	assert.Nil(t, snap.SetChainConfig(config))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	// The count approved by proposal applies to the election at the boundary
+	var last map[common.Address]bool
+	header := &types.Header{Number: big.NewInt(3), Time: 110, ParentHash: common.HexToHash("0x01")}
+	for _, count := range []string{"1", "2", "3", "4"} {
+		pending := config
+		proposal := Proposal{Key: ProposalMaxValidatorsCountChange, Value: count}
+		assert.Nil(t, proposal.applyTo(&pending))
+		snap, err := loadSnapshot(db, root)
+		assert.Nil(t, err)
+		assert.Nil(t, snap.SetPendingChainConfig(pending))
+		base, err := snap.Root()
+		assert.Nil(t, err)
+		assert.Nil(t, snap.Commit(base))
+
+		headerExtra := HeaderExtra{Root: base, Epoch: 2, EpochTime: 110}
+		assert.Nil(t, senate.applyPendingConfig(header, snap, &headerExtra))
+		assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+		assert.Equal(t, int(pending.MaxValidatorsCount), len(headerExtra.CurrentEpochValidators))
+
+		// Growing the set promotes the next candidate only
+		elected := make(map[common.Address]bool)
+		for _, validator := range headerExtra.CurrentEpochValidators {
+			elected[validator.Address] = true
+		}
+		for address := range last {
+			assert.True(t, elected[address])
+		}
+		last = elected
+	}
+}
+
+func TestFinalizeRebuildsSnapshots(t *testing.T) {
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               20,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+	}
+	newState := func() *state.StateDB {
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		assert.Nil(t, err)
+		return statedb
+	}
+
+	// A fork crossing epoch boundaries is assembled on another node
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	genesis := &types.Header{Number: big.NewInt(0), Time: uint64(time.Now().Unix()) + 3600}
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	var block *types.Block
+	for number := uint64(1); number <= 10; number++ {
+		header := &types.Header{Number: new(big.Int).SetUint64(number), ParentHash: chain.CurrentHeader().Hash(), Coinbase: testUserAddress}
+		assert.Nil(t, senate.Prepare(chain, header))
+		var err error
+		block, err = senate.FinalizeAndAssemble(chain, header, newState(), nil, nil, nil)
+		assert.Nil(t, err)
+		if number < 10 {
+			chain.headers = append(chain.headers, block.Header())
+		}
+	}
+	assert.True(t, mustDecodeHeaderExtra(t, chain.CurrentHeader()).Epoch > 1)
+
+	// Snapshots of the fork are missing locally, they are rebuilt from genesis
+	local := New(&config, nil, rawdb.NewMemoryDatabase())
+	header := block.Header()
+	assert.NotPanics(t, func() { local.Finalize(chain, header, newState(), nil, nil) })
+	assert.Equal(t, block.Root(), header.Root)
+	for _, header := range chain.headers[1:] {
+		snap, err := local.loadSnapshot(mustDecodeHeaderExtra(t, header).Root)
+		assert.Nil(t, err)
+		assert.True(t, snap.available())
+	}
+
+	// Without the ancestors the state is left unchanged
+	header = block.Header()
+	header.Root = common.Hash{}
+	orphan := &testChainReader{headers: []*types.Header{genesis}}
+	assert.NotPanics(t, func() { New(&config, nil, rawdb.NewMemoryDatabase()).Finalize(orphan, header, newState(), nil, nil) })
+	assert.Equal(t, common.Hash{}, header.Root)
+}
+
+func TestSnapshotCompetingBranches(t *testing.T) {
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               20,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+	}
+	newState := func() *state.StateDB {
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		assert.Nil(t, err)
+		return statedb
+	}
+
+	// Two branches fork after block 2, only the second one sets a candidate key
+	miner := New(&config, nil, rawdb.NewMemoryDatabase())
+	genesis := &types.Header{Number: big.NewInt(0), Time: uint64(time.Now().Unix()) + 3600}
+	key := crypto.CompressPubkey(&testUserKey.PublicKey)
+	extend := func(headers []*types.Header, txs []*types.Transaction) []*types.Header {
+		chain := &testChainReader{headers: headers}
+		header := &types.Header{Number: big.NewInt(int64(len(headers))), ParentHash: chain.CurrentHeader().Hash(), Coinbase: testUserAddress}
+		assert.Nil(t, miner.Prepare(chain, header))
+		block, err := miner.FinalizeAndAssemble(chain, header, newState(), txs, nil, nil)
+		assert.Nil(t, err)
+		return append(headers[:len(headers):len(headers)], block.Header())
+	}
+	ancestors := extend(extend([]*types.Header{genesis}, nil), nil)
+	branch1 := extend(extend(ancestors, nil), nil)
+	branch2 := extend(extend(ancestors, []*types.Transaction{signTestTransaction(t, 0, testUserAddress, "senate:1:event:candidate::"+hexutil.Encode(key))}), nil)
+	root1 := mustDecodeHeaderExtra(t, branch1[4]).Root
+	root2 := mustDecodeHeaderExtra(t, branch2[4]).Root
+	assert.NotEqual(t, root1, root2)
+
+	// Import both branches into one node, the second import is a reorg
+	db := rawdb.NewMemoryDatabase()
+	local := New(&config, nil, db)
+	for _, headers := range [][]*types.Header{branch1, branch2, branch1} {
+		snap, err := local.snapshotAt(&testChainReader{headers: headers}, headers[4])
+		assert.Nil(t, err)
+		assert.Equal(t, mustDecodeHeaderExtra(t, headers[4]).Root, snap.root)
+	}
+
+	// Both snapshots are found on disk without the recent snapshots
+	restarted := New(&config, nil, db)
+	for root, expected := range map[Root][]byte{root1: nil, root2: key} {
+		snap, err := restarted.loadSnapshot(root)
+		assert.Nil(t, err)
+		assert.True(t, snap.available())
+		actual, err := snap.Root()
+		assert.Nil(t, err)
+		assert.Equal(t, root, actual)
+		candidateKey, err := snap.GetCandidateKey(testUserAddress)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, candidateKey)
+	}
+}
+
+func TestRepairSnapshot(t *testing.T) {
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               20,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+	}
+	miner := New(&config, nil, rawdb.NewMemoryDatabase())
+	genesis := &types.Header{Number: big.NewInt(0), Time: uint64(time.Now().Unix()) + 3600}
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	for number := uint64(1); number <= 6; number++ {
+		header := &types.Header{Number: new(big.Int).SetUint64(number), ParentHash: chain.CurrentHeader().Hash(), Coinbase: testUserAddress}
+		assert.Nil(t, miner.Prepare(chain, header))
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		assert.Nil(t, err)
+		block, err := miner.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+		assert.Nil(t, err)
+		chain.headers = append(chain.headers, block.Header())
+	}
+	db := rawdb.NewMemoryDatabase()
+	_, err := New(&config, nil, db).snapshotAt(chain, chain.CurrentHeader())
+	assert.Nil(t, err)
+
+	// A partial write left the latest snapshot corrupted across restarts
+	root := mustDecodeHeaderExtra(t, chain.CurrentHeader()).Root
+	assert.Nil(t, db.Delete(root.MintCntHash.Bytes()))
+	snap, err := New(&config, nil, db).loadSnapshot(root)
+	assert.Nil(t, err)
+	_, err = snap.Dump()
+	assert.NotNil(t, err)
+
+	// Replaying from an intact ancestor writes the snapshot again
+	senate := New(&config, nil, db)
+	assert.Equal(t, errInvalidRepairRange, senate.RepairSnapshot(chain, 6, 6))
+	assert.Equal(t, consensus.ErrUnknownAncestor, senate.RepairSnapshot(chain, 7, 8))
+	assert.Nil(t, senate.RepairSnapshot(chain, 3, 6))
+
+	snap, err = New(&config, nil, db).loadSnapshot(root)
+	assert.Nil(t, err)
+	_, err = snap.Dump()
+	assert.Nil(t, err)
+	repaired, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, root, repaired)
+}
+
+func TestEpochCheckpoint(t *testing.T) {
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               20,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+		ReuseValidators:     true,
+		MinMintPercent:      50,
+	}
+	db := rawdb.NewMemoryDatabase()
+	senate := New(&config, nil, db)
+	genesis := &types.Header{Number: big.NewInt(0), Time: uint64(time.Now().Unix()) + 3600, GasLimit: params.GenesisGasLimit}
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	for number := uint64(1); number <= 10; number++ {
+		parent := chain.CurrentHeader()
+		header := &types.Header{Number: new(big.Int).SetUint64(number), ParentHash: parent.Hash(), GasLimit: parent.GasLimit,
+			Coinbase: testUserAddress}
+		assert.Nil(t, senate.Prepare(chain, header))
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		assert.Nil(t, err)
+		block, err := senate.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+		assert.Nil(t, err)
+		chain.headers = append(chain.headers, block.Header())
+	}
+
+	// The first block of every epoch carries the validators of the snapshot
+	var checkpoints []*types.Header
+	for _, header := range chain.headers[1:] {
+		headerExtra := mustDecodeHeaderExtra(t, header)
+		if header.Time != headerExtra.EpochTime {
+			assert.Empty(t, headerExtra.CurrentEpochValidators)
+			continue
+		}
+		checkpoints = append(checkpoints, header)
+		assert.Equal(t, 1, len(headerExtra.CurrentEpochValidators))
+		assert.Equal(t, testUserAddress, headerExtra.CurrentEpochValidators[0].Address)
+		snap, err := senate.loadSnapshot(headerExtra.Root)
+		assert.Nil(t, err)
+		assert.Nil(t, verifyCheckpoint(snap, headerExtra))
+	}
+	assert.True(t, len(checkpoints) > 1)
+
+	// A tampered validator list changes the snapshot root
+	header := types.CopyHeader(checkpoints[1])
+	headerExtra := mustDecodeHeaderExtra(t, header)
+	headerExtra.CurrentEpochValidators[0].Address = common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	assert.Nil(t, encodeHeaderExtra(header, headerExtra))
+	// An unsigned header is rejected before its snapshot is replayed into the
+	// shared trie database, no trie node is left behind
+	err := senate.verifyCascadingFields(context.Background(), chain, header, nil)
+	assert.NotNil(t, err)
+	assert.NotEqual(t, errInvalidTrieRoot, err)
+	dirty, _ := senate.triedb.Size()
+	assert.Equal(t, common.StorageSize(0), dirty)
+
+	sealTestHeader(t, header)
+	err = senate.verifyCascadingFields(context.Background(), chain, header, nil)
+	assert.Equal(t, errInvalidTrieRoot, err)
+	dirty, _ = senate.triedb.Size()
+	assert.NotEqual(t, common.StorageSize(0), dirty)
+
+	// The checkpoint can't be left out even if the validators are unchanged,
+	// the seals of both tampered headers in the slot aren't a double sign here
+	headerExtra.CurrentEpochValidators = nil
+	assert.Nil(t, encodeHeaderExtra(header, headerExtra))
+	sealTestHeader(t, header)
+	senate.seals.Purge()
+	err = senate.verifyCascadingFields(context.Background(), chain, header, nil)
+	assert.Equal(t, errInvalidCheckpoint, err)
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               20,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+	}
+	db := rawdb.NewMemoryDatabase()
+	senate := New(&config, nil, db)
+	genesis := &types.Header{Number: big.NewInt(0), Time: uint64(time.Now().Unix()) + 3600}
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	for number := uint64(1); number <= 12; number++ {
+		header := &types.Header{Number: new(big.Int).SetUint64(number), ParentHash: chain.CurrentHeader().Hash(), Coinbase: testUserAddress}
+		assert.Nil(t, senate.Prepare(chain, header))
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		assert.Nil(t, err)
+		block, err := senate.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+		assert.Nil(t, err)
+		chain.headers = append(chain.headers, block.Header())
+	}
+	assert.Nil(t, senate.PruneSnapshots(chain, 9))
+
+	// The recent snapshots and the ones of the first blocks of epochs still load
+	check := func(keepFromBlock uint64) {
+		restarted := New(&config, nil, db)
+		var parentEpoch uint64
+		for number := uint64(1); number <= 12; number++ {
+			headerExtra := mustDecodeHeaderExtra(t, chain.GetHeaderByNumber(number))
+			snap, err := restarted.loadSnapshot(headerExtra.Root)
+			assert.Nil(t, err)
+			_, err = snap.Dump()
+			if number == 1 || number >= keepFromBlock || headerExtra.Epoch != parentEpoch {
+				assert.Nil(t, err, "block %d", number)
+			} else {
+				assert.NotNil(t, err, "block %d", number)
+			}
+			parentEpoch = headerExtra.Epoch
+		}
+	}
+	check(9)
+
+	// Pruning continues from the blocks already pruned
+	assert.Nil(t, senate.PruneSnapshots(chain, 5))
+	check(9)
+	assert.Nil(t, senate.PruneSnapshots(chain, 10))
+	check(10)
+	assert.Equal(t, uint64(10), senate.retainedTo)
+
+	// A restarted engine marks the retained snapshots again
+	restarted := New(&config, nil, db)
+	assert.Nil(t, restarted.PruneSnapshots(chain, 12))
+	check(12)
+
+	// Snapshots aren't committed while pruning
+	snap, err := restarted.loadSnapshot(mustDecodeHeaderExtra(t, chain.CurrentHeader()).Root)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.BecomeCandidate(common.Address{0x01}))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	restarted.pruneLock.Lock()
+	committed := make(chan error, 1)
+	go func() { committed <- restarted.commitSnapshot(snap, root) }()
+	select {
+	case <-committed:
+		t.Fatal("snapshot committed while pruning")
+	case <-time.After(50 * time.Millisecond):
+	}
+	restarted.pruneLock.Unlock()
+	assert.Nil(t, <-committed)
+}
+
+func TestFinalizeCorruptedHeaderExtra(t *testing.T) {
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               20,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress},
+	}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	genesis := &types.Header{Number: big.NewInt(0), Time: uint64(time.Now().Unix()) + 3600}
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	header := &types.Header{Number: big.NewInt(1), ParentHash: genesis.Hash(), Coinbase: testUserAddress}
+	assert.Nil(t, senate.Prepare(chain, header))
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	assert.Nil(t, err)
+	block, err := senate.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+	assert.Nil(t, err)
+
+	withExtra := func(data []byte) *types.Header {
+		header := block.Header()
+		header.Root = common.Hash{}
+		header.Extra = append(append(header.Extra[:extraVanity:extraVanity], data...), make([]byte, extraSeal)...)
+		return header
+	}
+	headerExtra := mustDecodeHeaderExtra(t, block.Header())
+	headerExtra.CurrentEpochValidators = nil
+	mismatch, err := headerExtra.Encode()
+	assert.Nil(t, err)
+
+	corrupted := withExtra([]byte{0x01, 0x02, 0x03})
+	statedb, err = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	assert.Nil(t, err)
+	finalizeErr := senate.finalize(chain, corrupted, statedb, nil)
+	assert.NotNil(t, finalizeErr)
+
+	// The error is recorded, the header and state are left unchanged
+	statedb, err = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, senate.VerifyFinalize(corrupted))
+	assert.NotPanics(t, func() { senate.Finalize(chain, corrupted, statedb, nil, nil) })
+	assert.Equal(t, common.Hash{}, corrupted.Root)
+	assert.Equal(t, types.EmptyRootHash, statedb.IntermediateRoot(true))
+
+	// The block is rejected whatever its state root
+	assert.Equal(t, finalizeErr, senate.VerifyFinalize(corrupted))
+	assert.NotNil(t, senate.VerifyHeader(chain, corrupted, true))
+	assert.Nil(t, senate.VerifyFinalize(block.Header()))
+
+	// A HeaderExtra differing from the replayed one is rejected the same way
+	forged := withExtra(mismatch)
+	statedb, err = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, errInvalidHeaderExtra, senate.finalize(chain, forged, statedb, nil))
+	statedb, err = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	assert.Nil(t, err)
+	assert.NotPanics(t, func() { senate.Finalize(chain, forged, statedb, nil, nil) })
+	assert.Equal(t, types.EmptyRootHash, statedb.IntermediateRoot(true))
+	assert.Equal(t, errInvalidHeaderExtra, senate.VerifyFinalize(forged))
+}
+
+func mustDecodeHeaderExtra(t *testing.T, header *types.Header) HeaderExtra {
+	headerExtra, err := decodeHeaderExtra(header)
+	assert.Nil(t, err)
+	return headerExtra
+}
+
+func TestGenesisValidators(t *testing.T) {
+	validator := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	config := params.SenateConfig{
+		Period:              5,
+		Epoch:               60,
+		MaxValidatorsCount:  2,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		Validators:          []common.Address{testUserAddress, validator},
+		InitialStakes:       []*big.Int{big.NewInt(100), big.NewInt(200)},
+	}
+	assert.Nil(t, config.Validate())
+
+	db := rawdb.NewMemoryDatabase()
+	senate := New(&config, nil, db)
+	genesis := &types.Header{Number: big.NewInt(0), Time: uint64(time.Now().Unix()) + 3600}
+	chain := &testChainReader{headers: []*types.Header{genesis}}
+	minted := make(map[common.Address]int)
+	for number := uint64(1); number <= 5; number++ {
+		parent := chain.CurrentHeader()
+		header := &types.Header{Number: new(big.Int).SetUint64(number), ParentHash: parent.Hash()}
+		assert.Nil(t, senate.Prepare(chain, header))
+
+		// Each slot belongs to exactly one of the genesis validators
+		var turns int
+		for _, address := range config.Validators {
+			if senate.inTurn(config, parent, header.Time, address) {
+				header.Coinbase = address
+				if number > 1 {
+					minted[address]++
+				}
+				turns++
+			}
+		}
+		assert.Equal(t, 1, turns)
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		assert.Nil(t, err)
+		block, err := senate.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+		assert.Nil(t, err)
+		chain.headers = append(chain.headers, block.Header())
+	}
+
+	// The genesis validators are seeded as candidates with their stakes
+	headerExtra := mustDecodeHeaderExtra(t, chain.headers[1])
+	assert.Equal(t, uint64(1), headerExtra.Epoch)
+	assert.Equal(t, 2, len(headerExtra.CurrentBlockDeposits))
+	snap, err := senate.loadSnapshot(headerExtra.Root)
+	assert.Nil(t, err)
+	for idx, address := range config.Validators {
+		deposit, err := snap.GetDeposit(address)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, config.InitialStakes[idx].Cmp(deposit))
+	}
+	validators, err := snap.GetValidators()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(validators))
+
+	// Both take turns minting the rest of epoch 1
+	for _, header := range chain.headers[2:] {
+		assert.Equal(t, uint64(1), mustDecodeHeaderExtra(t, header).Epoch)
+	}
+	assert.Equal(t, 2, len(minted))
+}
+
+func TestIsNewEpoch(t *testing.T) {
+	timeBased := params.SenateConfig{Period: 1, Epoch: 3}
+	blockBased := params.SenateConfig{Period: 1, Epoch: 3, EpochBlocks: 2}
+
+	// Epoch numbers of blocks 1 to 7, one second apart
+	for _, test := range []struct {
+		config params.SenateConfig
+		epochs []uint64
+	}{
+		{timeBased, []uint64{1, 1, 1, 1, 2, 2, 2}},
+		{blockBased, []uint64{1, 1, 2, 2, 3, 3, 4}},
+	} {
+		epoch, epochTime := uint64(1), uint64(100)
+		for number := uint64(1); number <= uint64(len(test.epochs)); number++ {
+			time := 100 + number - 1
+			if number > 1 && isNewEpoch(test.config, epochTime, time, number) {
+				epoch, epochTime = epoch+1, time
+			}
+			assert.Equal(t, test.epochs[number-1], epoch, "block %d", number)
+		}
+	}
+
+	// Block-based epochs ignore gaps in the block times
+	assert.False(t, isNewEpoch(blockBased, 100, 200, 4))
+	assert.True(t, isNewEpoch(blockBased, 100, 101, 5))
+}
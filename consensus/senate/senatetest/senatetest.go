@@ -0,0 +1,232 @@
+// Package senatetest provides a harness mining in-memory chains with the senate
+// consensus engine, for the tests of code built on top of it.
+package senatetest
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/SecretBlockChain/go-secret/accounts"
+	"github.com/SecretBlockChain/go-secret/common"
+	"github.com/SecretBlockChain/go-secret/consensus/senate"
+	"github.com/SecretBlockChain/go-secret/core/rawdb"
+	"github.com/SecretBlockChain/go-secret/core/state"
+	"github.com/SecretBlockChain/go-secret/core/types"
+	"github.com/SecretBlockChain/go-secret/crypto"
+	"github.com/SecretBlockChain/go-secret/params"
+)
+
+const (
+	extraVanity = 32                     // Fixed number of extra-data prefix bytes reserved for signer vanity
+	extraSeal   = crypto.SignatureLength // Fixed number of extra-data suffix bytes reserved for signer seal
+
+	sealSlack = 5 * time.Second // Time allowed to a block on top of its slot before mining gives up
+)
+
+var (
+	// errNoValidators is returned if the harness is created without validator keys.
+	errNoValidators = errors.New("no validator keys")
+
+	// errSealHeld is returned if the engine didn't seal a block in time, e.g.
+	// an empty block held back or a validator that signed recently.
+	errSealHeld = errors.New("block not sealed")
+)
+
+// Chain is an in-memory chain mined by a senate engine holding the keys of all
+// the validators. It implements consensus.ChainHeaderReader.
+type Chain struct {
+	Engine *senate.Senate
+	Keys   map[common.Address]*ecdsa.PrivateKey
+
+	config  *params.ChainConfig
+	db      state.Database
+	headers []*types.Header
+	blocks  map[common.Hash]*types.Block
+}
+
+// NewChain creates a chain with a genesis block, sealed by the validators
+// holding keys. The validators of config are set to the addresses of keys.
+func NewChain(config params.SenateConfig, keys ...*ecdsa.PrivateKey) (*Chain, error) {
+	if len(keys) == 0 {
+		return nil, errNoValidators
+	}
+	config.Validators = make([]common.Address, 0, len(keys))
+	for _, key := range keys {
+		config.Validators = append(config.Validators, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	chainConfig := *params.AllCliqueProtocolChanges
+	chainConfig.Clique = nil
+	chainConfig.Senate = &config
+
+	genesis := types.NewBlockWithHeader(&types.Header{
+		Number:     big.NewInt(0),
+		Time:       uint64(time.Now().Unix()),
+		GasLimit:   params.GenesisGasLimit,
+		BaseFee:    big.NewInt(params.InitialBaseFee),
+		Difficulty: big.NewInt(1),
+		Root:       types.EmptyRootHash,
+		Extra:      make([]byte, extraVanity+extraSeal),
+	})
+	chain := &Chain{
+		Engine:  senate.New(&config, chainConfig.ChainID, rawdb.NewMemoryDatabase()),
+		Keys:    make(map[common.Address]*ecdsa.PrivateKey, len(keys)),
+		config:  &chainConfig,
+		db:      state.NewDatabase(rawdb.NewMemoryDatabase()),
+		headers: []*types.Header{genesis.Header()},
+		blocks:  map[common.Hash]*types.Block{genesis.Hash(): genesis},
+	}
+
+	// Authorize every validator, the one in turn seals each block
+	for idx, key := range keys {
+		address := crypto.PubkeyToAddress(key.PublicKey)
+		chain.Keys[address] = key
+		if idx == 0 {
+			chain.Engine.Authorize(address, signFn(key))
+		} else {
+			chain.Engine.AuthorizeFallback(address, signFn(key))
+		}
+	}
+	return chain, nil
+}
+
+// signFn returns a SignerFn signing with key.
+func signFn(key *ecdsa.PrivateKey) senate.SignerFn {
+	return func(_ accounts.Account, _ string, data []byte) ([]byte, error) {
+		return crypto.Sign(crypto.Keccak256(data), key)
+	}
+}
+
+// Mine mines n empty blocks on top of the current head.
+func (chain *Chain) Mine(n int) ([]*types.Block, error) {
+	blocks := make([]*types.Block, 0, n)
+	for i := 0; i < n; i++ {
+		block, err := chain.MineBlock(nil)
+		if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// MineBlock mines a block holding txs on top of the current head, going
+// through Prepare, FinalizeAndAssemble and Seal like the miner. It blocks until
+// the slot of the block.
+func (chain *Chain) MineBlock(txs []*types.Transaction) (*types.Block, error) {
+	parent := chain.CurrentHeader()
+	header := &types.Header{
+		Number:     new(big.Int).Add(parent.Number, common.Big1),
+		ParentHash: parent.Hash(),
+		GasLimit:   parent.GasLimit,
+		UncleHash:  types.EmptyUncleHash,
+	}
+	if err := chain.Engine.Prepare(chain, header); err != nil {
+		return nil, err
+	}
+
+	statedb, err := state.New(parent.Root, chain.db, nil)
+	if err != nil {
+		return nil, err
+	}
+	block, err := chain.Engine.FinalizeAndAssemble(chain, header, statedb, txs, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := statedb.Commit(chain.config.IsEIP158(header.Number)); err != nil {
+		return nil, err
+	}
+	if err := statedb.Database().TrieDB().Commit(block.Root(), false, nil); err != nil {
+		return nil, err
+	}
+
+	results := make(chan *types.Block, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	if err := chain.Engine.Seal(chain, block, results, stop); err != nil {
+		return nil, err
+	}
+	timeout := time.Until(time.Unix(int64(header.Time), 0)) + sealSlack
+	if config := chain.config.Senate; config.NoTurnDelay > 0 {
+		timeout += time.Duration(config.NoTurnDelay) * time.Second
+	}
+	select {
+	case block = <-results:
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("%w: number %d", errSealHeld, header.Number)
+	}
+
+	chain.headers = append(chain.headers, block.Header())
+	chain.blocks[block.Hash()] = block
+	return block, nil
+}
+
+// HeaderExtra decodes the HeaderExtra of the block with number.
+func (chain *Chain) HeaderExtra(number uint64) (*senate.HeaderExtra, error) {
+	return senate.DecodeHeaderExtra(chain.GetHeaderByNumber(number))
+}
+
+// Headers returns the headers of the chain, starting at the genesis.
+func (chain *Chain) Headers() []*types.Header {
+	return append([]*types.Header(nil), chain.headers...)
+}
+
+// GetBlock returns the block with hash and number, nil if unknown.
+func (chain *Chain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	block := chain.blocks[hash]
+	if block == nil || block.NumberU64() != number {
+		return nil
+	}
+	return block
+}
+
+// StateAt returns the state of the chain at root.
+func (chain *Chain) StateAt(root common.Hash) (*state.StateDB, error) {
+	return state.New(root, chain.db, nil)
+}
+
+// Close stops the engine.
+func (chain *Chain) Close() error {
+	return chain.Engine.Close()
+}
+
+// Config retrieves the chain configuration, holding the senate configuration.
+func (chain *Chain) Config() *params.ChainConfig {
+	return chain.config
+}
+
+// CurrentHeader retrieves the head of the chain.
+func (chain *Chain) CurrentHeader() *types.Header {
+	return chain.headers[len(chain.headers)-1]
+}
+
+// GetHeader retrieves a header by hash and number.
+func (chain *Chain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	header := chain.GetHeaderByNumber(number)
+	if header == nil || header.Hash() != hash {
+		return nil
+	}
+	return header
+}
+
+// GetHeaderByNumber retrieves a header by number.
+func (chain *Chain) GetHeaderByNumber(number uint64) *types.Header {
+	if number >= uint64(len(chain.headers)) {
+		return nil
+	}
+	return chain.headers[number]
+}
+
+// GetHeaderByHash retrieves a header by hash.
+func (chain *Chain) GetHeaderByHash(hash common.Hash) *types.Header {
+	if block := chain.blocks[hash]; block != nil {
+		return block.Header()
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package senatetest
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SecretBlockChain/go-secret/accounts"
+	"github.com/SecretBlockChain/go-secret/accounts/external"
+	"github.com/SecretBlockChain/go-secret/common"
+	"github.com/SecretBlockChain/go-secret/common/hexutil"
+	"github.com/SecretBlockChain/go-secret/consensus/senate"
+	"github.com/SecretBlockChain/go-secret/core/rawdb"
+	"github.com/SecretBlockChain/go-secret/crypto"
+	"github.com/SecretBlockChain/go-secret/params"
+	"github.com/SecretBlockChain/go-secret/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMineAcrossEpoch(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	for idx := range keys {
+		keys[idx], _ = crypto.GenerateKey()
+	}
+	config := params.SenateConfig{
+		Period:              1,
+		Epoch:               2,
+		MaxValidatorsCount:  3,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		AllowedFutureDrift:  3600,
+	}
+	chain, err := NewChain(config, keys...)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer chain.Close()
+
+	blocks, err := chain.Mine(4)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Len(t, blocks, 4)
+
+	// Blocks are sealed by the validator in turn
+	for _, block := range blocks {
+		signer, err := chain.Engine.Author(block.Header())
+		assert.Nil(t, err)
+		assert.Equal(t, block.Coinbase(), signer)
+		assert.Contains(t, chain.Keys, signer)
+	}
+
+	// The chain crossed an epoch boundary
+	first, err := chain.HeaderExtra(1)
+	assert.Nil(t, err)
+	last, err := chain.HeaderExtra(4)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), first.Epoch)
+	assert.True(t, last.Epoch > first.Epoch)
+
+	// Another node accepts the mined headers
+	verifier := senate.New(chain.Config().Senate, chain.Config().ChainID, rawdb.NewMemoryDatabase())
+	defer verifier.Close()
+	headers := chain.Headers()
+	_, results := verifier.VerifyHeaders(chain, headers[1:], nil)
+	for range headers[1:] {
+		assert.Nil(t, <-results)
+	}
+}
+
+func TestMineBlockBasedEpochs(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	config := params.SenateConfig{
+		Period:              1,
+		Epoch:               1,
+		EpochBlocks:         3,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		AllowedFutureDrift:  3600,
+	}
+	chain, err := NewChain(config, key)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer chain.Close()
+
+	_, err = chain.Mine(7)
+	if !assert.Nil(t, err) {
+		return
+	}
+	for number, epoch := range []uint64{1, 1, 1, 2, 2, 2, 3} {
+		headerExtra, err := chain.HeaderExtra(uint64(number + 1))
+		assert.Nil(t, err)
+		assert.Equal(t, epoch, headerExtra.Epoch, "block %d", number+1)
+	}
+
+	verifier := senate.New(chain.Config().Senate, chain.Config().ChainID, rawdb.NewMemoryDatabase())
+	defer verifier.Close()
+	headers := chain.Headers()
+	_, results := verifier.VerifyHeaders(chain, headers[1:], nil)
+	for range headers[1:] {
+		assert.Nil(t, <-results)
+	}
+}
+
+func TestMineRewardSharing(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	config := params.SenateConfig{
+		Period:              1,
+		Epoch:               1,
+		EpochBlocks:         2,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		AllowedFutureDrift:  3600,
+		InitialReward:       big.NewInt(100),
+		RewardSharing:       true,
+	}
+	chain, err := NewChain(config, key)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer chain.Close()
+
+	if _, err = chain.Mine(3); !assert.Nil(t, err) {
+		return
+	}
+
+	// The self-delegated validator holds the rewards of the first epoch and of
+	// the block electing the second one, which are paid before the election
+	validator := crypto.PubkeyToAddress(key.PublicKey)
+	headerExtra, err := chain.HeaderExtra(3)
+	assert.Nil(t, err)
+	assert.Equal(t, []senate.Stake{{Candidate: validator, Delegator: validator, Amount: big.NewInt(300)}}, headerExtra.CurrentBlockStakes)
+
+	verifier := senate.New(chain.Config().Senate, chain.Config().ChainID, rawdb.NewMemoryDatabase())
+	defer verifier.Close()
+	headers := chain.Headers()
+	_, results := verifier.VerifyHeaders(chain, headers[1:], nil)
+	for range headers[1:] {
+		assert.Nil(t, <-results)
+	}
+}
+
+// mockClef serves the account API of clef, signing with the key held in
+// its process like a remote or hardware signer does.
+type mockClef struct {
+	key       *ecdsa.PrivateKey
+	mimeTypes []string
+}
+
+func (clef *mockClef) Version() string {
+	return "6.0.0"
+}
+
+func (clef *mockClef) List() []common.Address {
+	return []common.Address{crypto.PubkeyToAddress(clef.key.PublicKey)}
+}
+
+func (clef *mockClef) SignData(contentType string, addr common.MixedcaseAddress, data hexutil.Bytes) (hexutil.Bytes, error) {
+	clef.mimeTypes = append(clef.mimeTypes, contentType)
+	header, chainID, domain, err := senate.DecodeSenateRLP(data)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.Sign(senate.SealHash(header, chainID, domain).Bytes(), clef.key)
+	if err != nil {
+		return nil, err
+	}
+	sig[crypto.RecoveryIDOffset] += 27 // Clef returns V on the Ethereum 27/28 form
+	return sig, nil
+}
+
+func TestMineRemoteSigner(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	signer := crypto.PubkeyToAddress(key.PublicKey)
+	clef := &mockClef{key: key}
+	server := rpc.NewServer()
+	assert.Nil(t, server.RegisterName("account", clef))
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	defer server.Stop()
+
+	wallet, err := external.NewExternalSigner(httpServer.URL)
+	if !assert.Nil(t, err) {
+		return
+	}
+	config := params.SenateConfig{
+		Period:              1,
+		Epoch:               100,
+		MaxValidatorsCount:  1,
+		MinDelegatorBalance: big.NewInt(0),
+		MinCandidateBalance: big.NewInt(0),
+		AllowedFutureDrift:  3600,
+		ChainIDBlock:        1,
+		SealDomainBlock:     1,
+	}
+	chain, err := NewChain(config, key)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer chain.Close()
+	unknown, _ := crypto.GenerateKey()
+	assert.Equal(t, accounts.ErrUnknownAccount, chain.Engine.AuthorizeWallet(crypto.PubkeyToAddress(unknown.PublicKey), wallet))
+	assert.Nil(t, chain.Engine.AuthorizeWallet(signer, wallet))
+
+	// The wallet decodes the chain id and domain bound header it signs
+	blocks, err := chain.Mine(1)
+	if !assert.Nil(t, err) {
+		return
+	}
+	author, err := chain.Engine.Author(blocks[0].Header())
+	assert.Nil(t, err)
+	assert.Equal(t, signer, author)
+	assert.Equal(t, []string{accounts.MimetypeSenate}, clef.mimeTypes)
+}
@@ -6,15 +6,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/SecretBlockChain/go-secret/log"
 	"math/big"
 	"math/rand"
 	"sort"
+	"strings"
 
 	"github.com/SecretBlockChain/go-secret/common"
+	"github.com/SecretBlockChain/go-secret/common/hexutil"
+	"github.com/SecretBlockChain/go-secret/common/math"
 	"github.com/SecretBlockChain/go-secret/core/state"
 	"github.com/SecretBlockChain/go-secret/core/types"
 	"github.com/SecretBlockChain/go-secret/ethdb"
+	"github.com/SecretBlockChain/go-secret/ethdb/memorydb"
 	"github.com/SecretBlockChain/go-secret/params"
 	"github.com/SecretBlockChain/go-secret/rlp"
 	"github.com/SecretBlockChain/go-secret/trie"
@@ -24,32 +27,93 @@ var (
 	epochPrefix     = []byte("epoch-")     // epoch-validator:{validators}
 	delegatePrefix  = []byte("delegate-")  // delegate-{candidateAddr}..{delegatorAddr}:{delegatorAddr}
 	votePrefix      = []byte("vote-")      // vote-{delegatorAddr}:{candidateAddr}
-	candidatePrefix = []byte("candidate-") // candidate-{candidateAddr}:
+	candidatePrefix = []byte("candidate-") // candidate-{candidateAddr}:{candidateAddr}{key}
 	mintCntPrefix   = []byte("mintCnt-")   // mintCnt-{epoch}..{validator}:{count}
 	configPrefix    = []byte("config")     // config:{params.SenateConfig}
 	proposalPrefix  = []byte("proposal-")  // proposal-{hash}:{Proposal}
-	declarePrefix   = []byte("declare-")   // declare-{hash}-{epoch}-{declarer}:{Declare}
+	declarePrefix   = []byte("declare-")   // declare-{hash}-{epoch}-{declarer}:{Declare}, declare-{declarer}-{hash}:{epoch}, declare-{candidateAddr}:{CandidateDeclaration}
+	depositPrefix   = []byte("deposit-")   // deposit-{candidateAddr}:{amount}
+	refundPrefix    = []byte("refund-")    // refund-{address}-{epoch}:{Refund}
+	signerPrefix    = []byte("signer-")    // signer-{candidateAddr}:{signerAddr}
+	slashPrefix     = []byte("slash-")     // slash-{epoch}-{validator}:{amount}
+	unbondPrefix    = []byte("unbond-")    // unbond-{release}-{address}:{amount}, unbond-{release}-{address}-{bonded}:{amount}
+	stagedPrefix    = []byte("staged-")    // staged-{delegatorAddr}:{candidateAddr}, staged-{candidateAddr}{delegatorAddr}:{amount}
+	rewardPrefix    = []byte("reward-")    // reward-{delegatorAddr}:{amount}
+	decayPrefix     = []byte("decay-")     // decay-{delegatorAddr}:{retained}
+	noncePrefix     = []byte("nonce-")     // nonce-{senderAddr}:{next nonce}
+	statsPrefix     = []byte("stats-")     // stats-{epoch}:{EpochStats}
+	payoutPrefix    = []byte("payout-")    // payout-{candidateAddr}:{rewardAddr}
+	stakePrefix     = []byte("stake-")     // stake-{candidateAddr}{delegatorAddr}:{amount}
+	bondPrefix      = []byte("bond-")      // bond-{candidateAddr}{delegatorAddr}:{amount}
+
+	// triePrefixes are the prefixes of all the tries of snapshot, in the
+	// order of their hashes in Root.fields.
+	triePrefixes = [][]byte{
+		epochPrefix, delegatePrefix, candidatePrefix, votePrefix, mintCntPrefix,
+		configPrefix, proposalPrefix, declarePrefix, depositPrefix, refundPrefix,
+		signerPrefix, slashPrefix, unbondPrefix, stagedPrefix, rewardPrefix, decayPrefix,
+		noncePrefix, statsPrefix, payoutPrefix, stakePrefix, bondPrefix,
+	}
 )
 
+// voteDecayUnit is the retained weight of a vote which never decayed.
+const voteDecayUnit = 1000000000000
+
 // SortableAddress sorted by votes.
 type SortableAddress struct {
 	Address common.Address `json:"address"`
 	Weight  *big.Int       `json:"weight"`
 }
 
-// SortableAddresses sorting in descending order by weight.
+// SortableAddresses sorting in descending order by weight, addresses of equal
+// weight in ascending byte order so every node gets the same order.
 type SortableAddresses []SortableAddress
 
 func (p SortableAddresses) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
 func (p SortableAddresses) Len() int      { return len(p) }
 func (p SortableAddresses) Less(i, j int) bool {
-	if p[i].Weight.Cmp(p[j].Weight) < 0 {
-		return false
-	} else if p[i].Weight.Cmp(p[j].Weight) > 0 {
-		return true
-	} else {
-		return p[i].Address.String() < p[j].Address.String()
+	if cmp := p[i].Weight.Cmp(p[j].Weight); cmp != 0 {
+		return cmp > 0
+	}
+	return bytes.Compare(p[i].Address[:], p[j].Address[:]) < 0
+}
+
+// Refund is the deposit of a deregistered candidate which vests linearly.
+type Refund struct {
+	Address common.Address `json:"address"`
+	Amount  *big.Int       `json:"amount"` // Amount still to be refunded
+	Epoch   uint64         `json:"epoch"`  // Epoch of the deregistration
+	Epochs  uint64         `json:"epochs"` // Number of epochs to vest over
+}
+
+// Installment returns the amount of refund vested in the epoch.
+func (refund Refund) Installment(epoch uint64) *big.Int {
+	if refund.Epochs == 0 || epoch <= refund.Epoch || epoch > refund.Epoch+refund.Epochs {
+		return big.NewInt(0)
 	}
+
+	installment := new(big.Int).Div(refund.Amount, new(big.Int).SetUint64(refund.Epochs))
+	if epoch == refund.Epoch+refund.Epochs {
+		paid := new(big.Int).Mul(installment, new(big.Int).SetUint64(refund.Epochs-1))
+		installment.Sub(refund.Amount, paid)
+	}
+	return installment
+}
+
+func refundKey(address common.Address, epoch uint64) []byte {
+	key := make([]byte, common.AddressLength+8)
+	copy(key, address.Bytes())
+	binary.BigEndian.PutUint64(key[common.AddressLength:], epoch)
+	return key
+}
+
+// Unbonding is the refund of a deregistered candidate locked until the release
+// time.
+type Unbonding struct {
+	Address common.Address `json:"address"`
+	Amount  *big.Int       `json:"amount"`
+	Release uint64         `json:"release"`          // Time since which the refund is unlocked
+	Bonded  bool           `json:"bonded,omitempty"` // Whether the amount is held by the staking account
 }
 
 // Snapshot is the state of the authorization voting at a given block number.
@@ -63,7 +127,22 @@ type Snapshot struct {
 	configTrie    *Trie
 	proposalTrie  *Trie
 	declareTrie   *Trie
+	depositTrie   *Trie
+	refundTrie    *Trie
+	signerTrie    *Trie
+	slashTrie     *Trie
+	unbondTrie    *Trie
+	stagedTrie    *Trie
+	rewardTrie    *Trie
+	decayTrie     *Trie
+	nonceTrie     *Trie
+	statsTrie     *Trie
+	payoutTrie    *Trie
+	stakeTrie     *Trie
+	bondTrie      *Trie
 	db            *trie.Database
+
+	Recents map[uint64]common.Address // Set of recent validators for spam protections
 }
 
 // newSnapshot creates a new empty snapshot
@@ -75,7 +154,8 @@ func newSnapshot(diskdb ethdb.Database) (*Snapshot, error) {
 	return &snap, nil
 }
 
-// loadSnapshot loads an existing snapshot from the database.
+// loadSnapshot loads an existing snapshot from the database. Roots of older
+// versions are already migrated by Root.DecodeRLP, the tries they lack are empty.
 func loadSnapshot(diskdb ethdb.Database, root Root) (*Snapshot, error) {
 	snap := Snapshot{
 		root: root,
@@ -84,6 +164,62 @@ func loadSnapshot(diskdb ethdb.Database, root Root) (*Snapshot, error) {
 	return &snap, nil
 }
 
+// available reports whether the tries of snapshot are all in the database.
+func (snap *Snapshot) available() bool {
+	for _, hash := range snap.root.hashes() {
+		if hash == (common.Hash{}) {
+			continue
+		}
+		if _, err := trie.New(hash, snap.db); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// copy creates a deep copy of the snapshot, changes to the copy don't affect
+// the original one.
+func (snap *Snapshot) copy() *Snapshot {
+	copyTrie := func(t *Trie) *Trie {
+		if t == nil {
+			return nil
+		}
+		return t.Copy()
+	}
+	cpy := &Snapshot{
+		root:          snap.root,
+		epochTrie:     copyTrie(snap.epochTrie),
+		delegateTrie:  copyTrie(snap.delegateTrie),
+		voteTrie:      copyTrie(snap.voteTrie),
+		candidateTrie: copyTrie(snap.candidateTrie),
+		mintCntTrie:   copyTrie(snap.mintCntTrie),
+		configTrie:    copyTrie(snap.configTrie),
+		proposalTrie:  copyTrie(snap.proposalTrie),
+		declareTrie:   copyTrie(snap.declareTrie),
+		depositTrie:   copyTrie(snap.depositTrie),
+		refundTrie:    copyTrie(snap.refundTrie),
+		signerTrie:    copyTrie(snap.signerTrie),
+		slashTrie:     copyTrie(snap.slashTrie),
+		unbondTrie:    copyTrie(snap.unbondTrie),
+		stagedTrie:    copyTrie(snap.stagedTrie),
+		rewardTrie:    copyTrie(snap.rewardTrie),
+		decayTrie:     copyTrie(snap.decayTrie),
+		nonceTrie:     copyTrie(snap.nonceTrie),
+		statsTrie:     copyTrie(snap.statsTrie),
+		payoutTrie:    copyTrie(snap.payoutTrie),
+		stakeTrie:     copyTrie(snap.stakeTrie),
+		bondTrie:      copyTrie(snap.bondTrie),
+		db:            snap.db,
+	}
+	if snap.Recents != nil {
+		cpy.Recents = make(map[uint64]common.Address, len(snap.Recents))
+		for number, validator := range snap.Recents {
+			cpy.Recents[number] = validator
+		}
+	}
+	return cpy
+}
+
 // ensureTrie ensure the trie has been created, trie is not nil
 // the purpose is to create tire as needed.
 func (snap *Snapshot) ensureTrie(prefix []byte) (*Trie, error) {
@@ -137,6 +273,84 @@ func (snap *Snapshot) ensureTrie(prefix []byte) (*Trie, error) {
 		}
 		snap.declareTrie, err = NewTrieWithPrefix(snap.root.DeclareHash, prefix, snap.db)
 		return snap.declareTrie, err
+	case string(depositPrefix):
+		if snap.depositTrie != nil {
+			return snap.depositTrie, nil
+		}
+		snap.depositTrie, err = NewTrieWithPrefix(snap.root.DepositHash, prefix, snap.db)
+		return snap.depositTrie, err
+	case string(refundPrefix):
+		if snap.refundTrie != nil {
+			return snap.refundTrie, nil
+		}
+		snap.refundTrie, err = NewTrieWithPrefix(snap.root.RefundHash, prefix, snap.db)
+		return snap.refundTrie, err
+	case string(signerPrefix):
+		if snap.signerTrie != nil {
+			return snap.signerTrie, nil
+		}
+		snap.signerTrie, err = NewTrieWithPrefix(snap.root.SignerHash, prefix, snap.db)
+		return snap.signerTrie, err
+	case string(slashPrefix):
+		if snap.slashTrie != nil {
+			return snap.slashTrie, nil
+		}
+		snap.slashTrie, err = NewTrieWithPrefix(snap.root.SlashHash, prefix, snap.db)
+		return snap.slashTrie, err
+	case string(unbondPrefix):
+		if snap.unbondTrie != nil {
+			return snap.unbondTrie, nil
+		}
+		snap.unbondTrie, err = NewTrieWithPrefix(snap.root.UnbondHash, prefix, snap.db)
+		return snap.unbondTrie, err
+	case string(stagedPrefix):
+		if snap.stagedTrie != nil {
+			return snap.stagedTrie, nil
+		}
+		snap.stagedTrie, err = NewTrieWithPrefix(snap.root.StagedHash, prefix, snap.db)
+		return snap.stagedTrie, err
+	case string(rewardPrefix):
+		if snap.rewardTrie != nil {
+			return snap.rewardTrie, nil
+		}
+		snap.rewardTrie, err = NewTrieWithPrefix(snap.root.RewardHash, prefix, snap.db)
+		return snap.rewardTrie, err
+	case string(decayPrefix):
+		if snap.decayTrie != nil {
+			return snap.decayTrie, nil
+		}
+		snap.decayTrie, err = NewTrieWithPrefix(snap.root.DecayHash, prefix, snap.db)
+		return snap.decayTrie, err
+	case string(noncePrefix):
+		if snap.nonceTrie != nil {
+			return snap.nonceTrie, nil
+		}
+		snap.nonceTrie, err = NewTrieWithPrefix(snap.root.NonceHash, prefix, snap.db)
+		return snap.nonceTrie, err
+	case string(statsPrefix):
+		if snap.statsTrie != nil {
+			return snap.statsTrie, nil
+		}
+		snap.statsTrie, err = NewTrieWithPrefix(snap.root.StatsHash, prefix, snap.db)
+		return snap.statsTrie, err
+	case string(payoutPrefix):
+		if snap.payoutTrie != nil {
+			return snap.payoutTrie, nil
+		}
+		snap.payoutTrie, err = NewTrieWithPrefix(snap.root.PayoutHash, prefix, snap.db)
+		return snap.payoutTrie, err
+	case string(stakePrefix):
+		if snap.stakeTrie != nil {
+			return snap.stakeTrie, nil
+		}
+		snap.stakeTrie, err = NewTrieWithPrefix(snap.root.StakeHash, prefix, snap.db)
+		return snap.stakeTrie, err
+	case string(bondPrefix):
+		if snap.bondTrie != nil {
+			return snap.bondTrie, nil
+		}
+		snap.bondTrie, err = NewTrieWithPrefix(snap.root.BondHash, prefix, snap.db)
+		return snap.bondTrie, err
 	default:
 		return nil, errors.New("unknown prefix")
 	}
@@ -144,14 +358,106 @@ func (snap *Snapshot) ensureTrie(prefix []byte) (*Trie, error) {
 
 // apply creates a new authorization snapshot by applying the given headers to
 // the original one.
-func (snap *Snapshot) apply(header *types.Header, headerExtra HeaderExtra) error {
+func (snap *Snapshot) apply(config params.SenateConfig, header *types.Header, headerExtra HeaderExtra) error {
+	validator, err := snap.ValidatorOf(header.Coinbase)
+	if err != nil {
+		return err
+	}
+	if header.Time == headerExtra.EpochTime {
+		if _, err := snap.ApplyPendingChainConfig(); err != nil {
+			return err
+		}
+	}
+	for _, reward := range headerExtra.CurrentBlockRewards {
+		if err := snap.AccrueReward(reward.Delegator, reward.Amount); err != nil {
+			return err
+		}
+	}
+	for _, deposit := range headerExtra.CurrentBlockDeposits {
+		if err := snap.SetDeposit(deposit.Candidate, deposit.Amount); err != nil {
+			return err
+		}
+	}
+	if header.Time == headerExtra.EpochTime {
+		if err := snap.ResetRegistrations(headerExtra.Epoch); err != nil {
+			return err
+		}
+	}
 	for _, candidate := range headerExtra.CurrentBlockCandidates {
+		isCandidate, err := snap.IsCandidate(candidate)
+		if err != nil {
+			return err
+		}
 		if err := snap.BecomeCandidate(candidate); err != nil {
 			return err
 		}
+		if !isCandidate && config.MaxRegistrations > 0 {
+			if err := snap.AddRegistration(headerExtra.Epoch); err != nil {
+				return err
+			}
+		}
+		if config.CandidateDeposit != nil && config.CandidateDeposit.Sign() > 0 {
+			deposit, err := snap.GetDeposit(candidate)
+			if err != nil {
+				return err
+			}
+			if deposit.Sign() == 0 {
+				if err = snap.SetDeposit(candidate, config.CandidateDeposit); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, candidateKey := range headerExtra.CurrentBlockCandidateKeys {
+		if err := snap.SetCandidateKey(candidateKey.Candidate, candidateKey.Key); err != nil {
+			return err
+		}
+	}
+	for _, declaration := range headerExtra.CurrentBlockDeclarations {
+		if err := snap.SetCandidateDeclaration(declaration); err != nil {
+			return err
+		}
+	}
+	delegate := snap.Delegate
+	if config.StagedDelegation {
+		delegate = snap.StageDelegate
+	}
+	for _, vote := range headerExtra.CurrentBlockDelegates {
+		if err := delegate(vote.Delegator, vote.Candidate); err != nil {
+			return err
+		}
+	}
+	bond := snap.Bond
+	if config.StagedDelegation {
+		bond = snap.StageBond
+	}
+	for _, locked := range headerExtra.CurrentBlockBonds {
+		if err := bond(locked.Candidate, locked.Delegator, locked.Amount); err != nil {
+			return err
+		}
+	}
+	for _, unbond := range headerExtra.CurrentBlockUnbonds {
+		if err := snap.DebitBond(unbond.Candidate, unbond.Delegator, unbond.Amount); err != nil {
+			return err
+		}
+		if config.UnbondingPeriod > 0 {
+			if err := snap.UnbondBonded(unbond.Delegator, unbond.Amount, header.Time+config.UnbondingPeriod); err != nil {
+				return err
+			}
+		}
+	}
+	for _, withdrawal := range headerExtra.CurrentBlockWithdrawals {
+		if _, err := snap.WithdrawReward(withdrawal.Delegator); err != nil {
+			return err
+		}
+	}
+	for _, nonce := range headerExtra.CurrentBlockNonces {
+		if err := snap.SetCustomNonce(nonce.Sender, nonce.Nonce+1); err != nil {
+			return err
+		}
 	}
-	for _, delegate := range headerExtra.CurrentBlockDelegates {
-		if err := snap.Delegate(delegate.Delegator, delegate.Candidate); err != nil {
+	if header.Time == headerExtra.EpochTime && header.Number.Uint64() > 1 {
+		if err := snap.DecayVotes(config.VoteDecayPercent); err != nil {
 			return err
 		}
 	}
@@ -160,7 +466,27 @@ func (snap *Snapshot) apply(header *types.Header, headerExtra HeaderExtra) error
 			return err
 		}
 	}
+	for _, candidate := range headerExtra.CurrentBlockCancelCandidates {
+		refund, err := snap.CancelCandidate(headerExtra.Epoch, candidate, config.RefundPercent, config.RefundEpochs)
+		if err != nil {
+			return err
+		}
+		if config.UnbondingPeriod > 0 && refund.Sign() > 0 {
+			if err = snap.Unbond(candidate, refund, header.Time+config.UnbondingPeriod); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := snap.ReleaseUnbonded(header.Time); err != nil {
+		return err
+	}
+	if header.Time == headerExtra.EpochTime {
+		if _, err := snap.ExpireProposals(headerExtra.Epoch); err != nil {
+			return err
+		}
+	}
 	for _, proposal := range headerExtra.CurrentBlockProposals {
+		proposal.ExpireEpoch = proposalExpireEpoch(config, headerExtra.Epoch)
 		if err := snap.SubmitProposal(proposal); err != nil {
 			return err
 		}
@@ -169,11 +495,52 @@ func (snap *Snapshot) apply(header *types.Header, headerExtra HeaderExtra) error
 		if err := snap.Declare(headerExtra.Epoch, declare); err != nil {
 			return err
 		}
+		if _, err := snap.TallyProposal(config, headerExtra.Epoch, declare); err != nil {
+			return err
+		}
 	}
-	if header.Time == headerExtra.EpochTime {
+	for _, slash := range headerExtra.CurrentBlockSlashes {
+		if _, err := snap.DebitDeposit(slash.Validator, slash.Amount); err != nil {
+			return err
+		}
+		if err := snap.Slash(headerExtra.Epoch, slash.Validator, slash.Amount); err != nil {
+			return err
+		}
+	}
+	for _, rotation := range headerExtra.CurrentBlockKeyRotations {
+		if err := snap.RotateKey(rotation.Candidate, rotation.Signer); err != nil {
+			return err
+		}
+	}
+	for _, payout := range headerExtra.CurrentBlockRewardAddresses {
+		if err := snap.SetRewardAddress(payout.Candidate, payout.Address); err != nil {
+			return err
+		}
+	}
+	// An empty election keeps the validators if they are reused
+	if header.Time == headerExtra.EpochTime && (len(headerExtra.CurrentEpochValidators) > 0 || !config.ReuseValidators) {
 		if err := snap.SetValidators(headerExtra.CurrentEpochValidators); err != nil {
 			return err
 		}
+		if err := snap.UpdateSigners(); err != nil {
+			return err
+		}
+	}
+	if config.RewardSharing && header.Time == headerExtra.EpochTime {
+		if err := snap.SetStakes(headerExtra.CurrentBlockStakes); err != nil {
+			return err
+		}
+	}
+	if header.Time == headerExtra.EpochTime {
+		if _, err := snap.ApplyStagedDelegates(); err != nil {
+			return err
+		}
+		if _, err := snap.ApplyStagedBonds(); err != nil {
+			return err
+		}
+		if _, err := snap.ReleaseRefunds(headerExtra.Epoch); err != nil {
+			return err
+		}
 	}
 	if len(headerExtra.ChainConfig) > 0 {
 		last := len(headerExtra.ChainConfig) - 1
@@ -181,10 +548,10 @@ func (snap *Snapshot) apply(header *types.Header, headerExtra HeaderExtra) error
 			return err
 		}
 	}
-	if err := snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), header.Coinbase); err != nil {
+	if err := snap.MintBlock(headerExtra.Epoch, header.Number.Uint64(), validator); err != nil {
 		return err
 	}
-	return nil
+	return snap.AccumulateEpochStats(config, header, headerExtra.Epoch)
 }
 
 // Root returns root of snapshot trie.
@@ -245,10 +612,103 @@ func (snap *Snapshot) Root() (root Root, err error) {
 			return Root{}, err
 		}
 	}
+
+	if snap.depositTrie != nil {
+		root.DepositHash, err = snap.depositTrie.Commit(nil)
+		if err != nil {
+			return Root{}, err
+		}
+	}
+
+	if snap.refundTrie != nil {
+		root.RefundHash, err = snap.refundTrie.Commit(nil)
+		if err != nil {
+			return Root{}, err
+		}
+	}
+
+	if snap.signerTrie != nil {
+		root.SignerHash, err = snap.signerTrie.Commit(nil)
+		if err != nil {
+			return Root{}, err
+		}
+	}
+
+	if snap.slashTrie != nil {
+		root.SlashHash, err = snap.slashTrie.Commit(nil)
+		if err != nil {
+			return Root{}, err
+		}
+	}
+
+	if snap.unbondTrie != nil {
+		root.UnbondHash, err = snap.unbondTrie.Commit(nil)
+		if err != nil {
+			return Root{}, err
+		}
+	}
+
+	if snap.stagedTrie != nil {
+		root.StagedHash, err = snap.stagedTrie.Commit(nil)
+		if err != nil {
+			return Root{}, err
+		}
+	}
+
+	if snap.rewardTrie != nil {
+		root.RewardHash, err = snap.rewardTrie.Commit(nil)
+		if err != nil {
+			return Root{}, err
+		}
+	}
+
+	if snap.decayTrie != nil {
+		root.DecayHash, err = snap.decayTrie.Commit(nil)
+		if err != nil {
+			return Root{}, err
+		}
+	}
+
+	if snap.nonceTrie != nil {
+		root.NonceHash, err = snap.nonceTrie.Commit(nil)
+		if err != nil {
+			return Root{}, err
+		}
+	}
+
+	if snap.statsTrie != nil {
+		root.StatsHash, err = snap.statsTrie.Commit(nil)
+		if err != nil {
+			return Root{}, err
+		}
+	}
+
+	if snap.payoutTrie != nil {
+		root.PayoutHash, err = snap.payoutTrie.Commit(nil)
+		if err != nil {
+			return Root{}, err
+		}
+	}
+
+	if snap.stakeTrie != nil {
+		root.StakeHash, err = snap.stakeTrie.Commit(nil)
+		if err != nil {
+			return Root{}, err
+		}
+	}
+
+	if snap.bondTrie != nil {
+		root.BondHash, err = snap.bondTrie.Commit(nil)
+		if err != nil {
+			return Root{}, err
+		}
+	}
 	return root, err
 }
 
-// Commit commit snapshot changes to database.
+// Commit commit snapshot changes to database. Trie nodes are stored by their
+// hashes and no head pointer is written, so committing the snapshot of a side
+// chain never overwrites the snapshots of other branches.
 func (snap *Snapshot) Commit(root Root) error {
 	if snap.root.EpochHash != root.EpochHash {
 		if err := snap.db.Commit(root.EpochHash, false, nil); err != nil {
@@ -290,348 +750,2273 @@ func (snap *Snapshot) Commit(root Root) error {
 			return err
 		}
 	}
+	if snap.root.DepositHash != root.DepositHash {
+		if err := snap.db.Commit(root.DepositHash, false, nil); err != nil {
+			return err
+		}
+	}
+	if snap.root.RefundHash != root.RefundHash {
+		if err := snap.db.Commit(root.RefundHash, false, nil); err != nil {
+			return err
+		}
+	}
+	if snap.root.SignerHash != root.SignerHash {
+		if err := snap.db.Commit(root.SignerHash, false, nil); err != nil {
+			return err
+		}
+	}
+	if snap.root.SlashHash != root.SlashHash {
+		if err := snap.db.Commit(root.SlashHash, false, nil); err != nil {
+			return err
+		}
+	}
+	if snap.root.UnbondHash != root.UnbondHash {
+		if err := snap.db.Commit(root.UnbondHash, false, nil); err != nil {
+			return err
+		}
+	}
+	if snap.root.StagedHash != root.StagedHash {
+		if err := snap.db.Commit(root.StagedHash, false, nil); err != nil {
+			return err
+		}
+	}
+	if snap.root.RewardHash != root.RewardHash {
+		if err := snap.db.Commit(root.RewardHash, false, nil); err != nil {
+			return err
+		}
+	}
+	if snap.root.DecayHash != root.DecayHash {
+		if err := snap.db.Commit(root.DecayHash, false, nil); err != nil {
+			return err
+		}
+	}
+	if snap.root.NonceHash != root.NonceHash {
+		if err := snap.db.Commit(root.NonceHash, false, nil); err != nil {
+			return err
+		}
+	}
+	if snap.root.StatsHash != root.StatsHash {
+		if err := snap.db.Commit(root.StatsHash, false, nil); err != nil {
+			return err
+		}
+	}
+	if snap.root.PayoutHash != root.PayoutHash {
+		if err := snap.db.Commit(root.PayoutHash, false, nil); err != nil {
+			return err
+		}
+	}
+	if snap.root.StakeHash != root.StakeHash {
+		if err := snap.db.Commit(root.StakeHash, false, nil); err != nil {
+			return err
+		}
+	}
+	if snap.root.BondHash != root.BondHash {
+		if err := snap.db.Commit(root.BondHash, false, nil); err != nil {
+			return err
+		}
+	}
 	snap.root = root
 	return nil
 }
 
-// GetChainConfig returns chain config from snapshot.
-func (snap *Snapshot) GetChainConfig() (params.SenateConfig, error) {
-	configTrie, err := snap.ensureTrie(configPrefix)
+// snapshotJSON is the JSON representation of snapshot.
+type snapshotJSON struct {
+	Root       Root                                `json:"root"`
+	Validators SortableAddresses                   `json:"validators"`
+	Candidates []common.Address                    `json:"candidates"`
+	Votes      map[common.Address]common.Address   `json:"votes"`     // delegator -> candidate
+	Delegates  map[common.Address][]common.Address `json:"delegates"` // candidate -> delegators
+}
+
+// MarshalJSON encodes the validators, candidates, votes and delegates of
+// snapshot into JSON.
+func (snap *Snapshot) MarshalJSON() ([]byte, error) {
+	enc := snapshotJSON{
+		Root:       snap.root,
+		Validators: SortableAddresses{},
+		Candidates: []common.Address{},
+		Votes:      make(map[common.Address]common.Address),
+		Delegates:  make(map[common.Address][]common.Address),
+	}
+	epochTrie, err := snap.ensureTrie(epochPrefix)
 	if err != nil {
-		return params.SenateConfig{}, err
+		return nil, err
+	}
+	if epochTrie.Get([]byte("validator")) != nil {
+		validators, err := snap.GetValidators()
+		if err != nil {
+			return nil, err
+		}
+		enc.Validators = validators
 	}
 
-	key := []byte("config")
-	data := configTrie.Get(key)
+	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+	if err != nil {
+		return nil, err
+	}
+	iter := trie.NewIterator(candidateTrie.NodeIterator(nil))
+	for iter.Next() {
+		enc.Candidates = append(enc.Candidates, common.BytesToAddress(iter.Value[:common.AddressLength]))
+	}
+	if iter.Err != nil {
+		return nil, iter.Err
+	}
+
+	voteTrie, err := snap.ensureTrie(votePrefix)
+	if err != nil {
+		return nil, err
+	}
+	iter = trie.NewIterator(voteTrie.NodeIterator(nil))
+	for iter.Next() {
+		delegator := common.BytesToAddress(iter.Key[len(votePrefix):])
+		enc.Votes[delegator] = common.BytesToAddress(iter.Value)
+	}
+	if iter.Err != nil {
+		return nil, iter.Err
+	}
+
+	delegateTrie, err := snap.ensureTrie(delegatePrefix)
+	if err != nil {
+		return nil, err
+	}
+	iter = trie.NewIterator(delegateTrie.NodeIterator(nil))
+	for iter.Next() {
+		key := iter.Key[len(delegatePrefix):]
+		candidate := common.BytesToAddress(key[:common.AddressLength])
+		enc.Delegates[candidate] = append(enc.Delegates[candidate], common.BytesToAddress(iter.Value))
+	}
+	if iter.Err != nil {
+		return nil, iter.Err
+	}
+	return json.Marshal(&enc)
+}
+
+// SnapshotDump is the full contents of snapshot tries keyed by address, hash
+// or epoch, big integers are encoded as decimal strings. Chain configs are kept
+// as stored in the config trie.
+
+type SnapshotDump struct {
+	Root          Root                                                   `json:"root"`
+	Epoch         epochDump                                              `json:"epoch"`
+	Candidates    []common.Address                                       `json:"candidates"`
+	CandidateKeys map[common.Address]hexutil.Bytes                       `json:"candidate_keys"`
+	Votes         map[common.Address]common.Address                      `json:"votes"`     // delegator -> candidate
+	Delegates     map[common.Address][]common.Address                    `json:"delegates"` // candidate -> delegators
+	MintCnt       map[uint64]map[uint64]common.Address                   `json:"mintcnt"`   // epoch -> number -> validator
+	Config        map[string]json.RawMessage                             `json:"config"`
+	Proposals     map[common.Hash]Proposal                               `json:"proposals"`
+	Declares      map[common.Hash]map[uint64][]Declare                   `json:"declares"` // proposal -> epoch -> declarations
+	Declarations  map[common.Address]CandidateDeclaration                `json:"declarations"`
+	Deposits      map[common.Address]*math.Decimal256                    `json:"deposits"`
+	Refunds       map[common.Address]map[uint64]refundDump               `json:"refunds"`     // address -> epoch -> refund
+	Signers       map[common.Address]common.Address                      `json:"signers"`     // candidate -> signer
+	Slashes       map[uint64]map[common.Address]*math.Decimal256         `json:"slashes"`     // epoch -> validator -> amount
+	Unbonds       map[uint64]map[common.Address]*math.Decimal256         `json:"unbonds"`     // release -> address -> amount
+	Staged        map[common.Address]common.Address                      `json:"staged"`      // delegator -> candidate
+	StagedBonds   map[common.Address]map[common.Address]*math.Decimal256 `json:"stagedBonds"` // candidate -> delegator -> amount locked since the next epoch
+	Rewards       map[common.Address]*math.Decimal256                    `json:"rewards"`     // delegator -> pending reward
+	Decays        map[common.Address]uint64                              `json:"decays"`      // delegator -> retained weight of voteDecayUnit
+	Nonces        map[common.Address]uint64                              `json:"nonces"`      // sender -> next custom transaction nonce
+	Stats         map[uint64]EpochStats                                  `json:"stats"`       // epoch -> aggregates
+	Payouts       map[common.Address]common.Address                      `json:"payouts"`     // candidate -> reward address
+	Stakes        map[common.Address]map[common.Address]*math.Decimal256 `json:"stakes"`      // candidate -> delegator -> vote weight fixed at election
+	Bonds         map[common.Address]map[common.Address]*math.Decimal256 `json:"bonds"`       // candidate -> delegator -> amount locked
+}
+
+type epochDump struct {
+	Validators    []validatorDump  `json:"validators"`
+	Signers       []common.Address `json:"signers,omitempty"`
+	Registrations *Registrations   `json:"registrations,omitempty"`
+}
+
+type validatorDump struct {
+	Address common.Address   `json:"address"`
+	Weight  *math.Decimal256 `json:"weight"`
+}
+
+type refundDump struct {
+	Amount *math.Decimal256 `json:"amount"`
+	Epochs uint64           `json:"epochs"`
+}
+
+// Dump reads every trie of snapshot into a SnapshotDump, the snapshot itself
+// is left untouched.
+func (snap *Snapshot) Dump() (*SnapshotDump, error) {
+	cpy := snap.copy()
+	root, err := cpy.Root()
+	if err != nil {
+		return nil, err
+	}
+	dump := &SnapshotDump{
+		Root:          root,
+		Epoch:         epochDump{Validators: []validatorDump{}},
+		Candidates:    []common.Address{},
+		CandidateKeys: make(map[common.Address]hexutil.Bytes),
+		Votes:         make(map[common.Address]common.Address),
+		Delegates:     make(map[common.Address][]common.Address),
+		MintCnt:       make(map[uint64]map[uint64]common.Address),
+		Config:        make(map[string]json.RawMessage),
+		Proposals:     make(map[common.Hash]Proposal),
+		Declares:      make(map[common.Hash]map[uint64][]Declare),
+		Declarations:  make(map[common.Address]CandidateDeclaration),
+		Deposits:      make(map[common.Address]*math.Decimal256),
+		Refunds:       make(map[common.Address]map[uint64]refundDump),
+		Signers:       make(map[common.Address]common.Address),
+		Slashes:       make(map[uint64]map[common.Address]*math.Decimal256),
+		Unbonds:       make(map[uint64]map[common.Address]*math.Decimal256),
+		Staged:        make(map[common.Address]common.Address),
+		Rewards:       make(map[common.Address]*math.Decimal256),
+		Decays:        make(map[common.Address]uint64),
+		Nonces:        make(map[common.Address]uint64),
+		Stats:         make(map[uint64]EpochStats),
+		Payouts:       make(map[common.Address]common.Address),
+		Stakes:        make(map[common.Address]map[common.Address]*math.Decimal256),
+		Bonds:         make(map[common.Address]map[common.Address]*math.Decimal256),
+		StagedBonds:   make(map[common.Address]map[common.Address]*math.Decimal256),
+	}
+
+	err = cpy.iterate(epochPrefix, func(key, value []byte) error {
+		switch string(key) {
+		case "validator":
+			var validators SortableAddresses
+			if err := rlp.DecodeBytes(value, &validators); err != nil {
+				return err
+			}
+			for _, validator := range validators {
+				dump.Epoch.Validators = append(dump.Epoch.Validators, validatorDump{
+					Address: validator.Address,
+					Weight:  (*math.Decimal256)(validator.Weight),
+				})
+			}
+		case "signer":
+			return rlp.DecodeBytes(value, &dump.Epoch.Signers)
+		case "registrations":
+			dump.Epoch.Registrations = new(Registrations)
+			return rlp.DecodeBytes(value, dump.Epoch.Registrations)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(candidatePrefix, func(key, value []byte) error {
+		candidate := common.BytesToAddress(value[:common.AddressLength])
+		dump.Candidates = append(dump.Candidates, candidate)
+		if len(value) > common.AddressLength {
+			dump.CandidateKeys[candidate] = common.CopyBytes(value[common.AddressLength:])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(votePrefix, func(key, value []byte) error {
+		dump.Votes[common.BytesToAddress(key)] = common.BytesToAddress(value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(delegatePrefix, func(key, value []byte) error {
+		candidate := common.BytesToAddress(key[:common.AddressLength])
+		dump.Delegates[candidate] = append(dump.Delegates[candidate], common.BytesToAddress(value))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(mintCntPrefix, func(key, value []byte) error {
+		epoch := binary.BigEndian.Uint64(key[:8])
+		if dump.MintCnt[epoch] == nil {
+			dump.MintCnt[epoch] = make(map[uint64]common.Address)
+		}
+		dump.MintCnt[epoch][binary.BigEndian.Uint64(key[8:])] = common.BytesToAddress(value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(configPrefix, func(key, value []byte) error {
+		dump.Config[string(key)] = common.CopyBytes(value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(proposalPrefix, func(key, value []byte) error {
+		var proposal Proposal
+		if err := json.Unmarshal(value, &proposal); err != nil {
+			return err
+		}
+		dump.Proposals[common.BytesToHash(key)] = proposal
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(declarePrefix, func(key, value []byte) error {
+		if len(key) == common.AddressLength+common.HashLength {
+			return nil
+		}
+		var declare Declare
+		if err := json.Unmarshal(value, &declare); err != nil {
+			return err
+		}
+		if len(key) == common.AddressLength {
+			var declaration CandidateDeclaration
+			if err := json.Unmarshal(value, &declaration); err != nil {
+				return err
+			}
+			dump.Declarations[common.BytesToAddress(key)] = declaration
+			return nil
+		}
+
+		hash := common.BytesToHash(key[:common.HashLength])
+		epoch := binary.BigEndian.Uint64(key[common.HashLength : common.HashLength+8])
+		if dump.Declares[hash] == nil {
+			dump.Declares[hash] = make(map[uint64][]Declare)
+		}
+		dump.Declares[hash][epoch] = append(dump.Declares[hash][epoch], declare)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(depositPrefix, func(key, value []byte) error {
+		dump.Deposits[common.BytesToAddress(key)] = (*math.Decimal256)(new(big.Int).SetBytes(value))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(refundPrefix, func(key, value []byte) error {
+		var refund Refund
+		if err := rlp.DecodeBytes(value, &refund); err != nil {
+			return err
+		}
+		if dump.Refunds[refund.Address] == nil {
+			dump.Refunds[refund.Address] = make(map[uint64]refundDump)
+		}
+		dump.Refunds[refund.Address][refund.Epoch] = refundDump{
+			Amount: (*math.Decimal256)(refund.Amount),
+			Epochs: refund.Epochs,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(signerPrefix, func(key, value []byte) error {
+		dump.Signers[common.BytesToAddress(key)] = common.BytesToAddress(value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(slashPrefix, func(key, value []byte) error {
+		epoch := binary.BigEndian.Uint64(key[:8])
+		if dump.Slashes[epoch] == nil {
+			dump.Slashes[epoch] = make(map[common.Address]*math.Decimal256)
+		}
+		dump.Slashes[epoch][common.BytesToAddress(key[8:])] = (*math.Decimal256)(new(big.Int).SetBytes(value))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(unbondPrefix, func(key, value []byte) error {
+		release := binary.BigEndian.Uint64(key[:8])
+		if dump.Unbonds[release] == nil {
+			dump.Unbonds[release] = make(map[common.Address]*math.Decimal256)
+		}
+		address := common.BytesToAddress(key[8 : 8+common.AddressLength])
+		amount := new(big.Int).SetBytes(value)
+		if unbonded := dump.Unbonds[release][address]; unbonded != nil {
+			amount.Add(amount, (*big.Int)(unbonded))
+		}
+		dump.Unbonds[release][address] = (*math.Decimal256)(amount)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(stagedPrefix, func(key, value []byte) error {
+		if len(key) == common.AddressLength {
+			dump.Staged[common.BytesToAddress(key)] = common.BytesToAddress(value)
+			return nil
+		}
+		candidate := common.BytesToAddress(key[:common.AddressLength])
+		if dump.StagedBonds[candidate] == nil {
+			dump.StagedBonds[candidate] = make(map[common.Address]*math.Decimal256)
+		}
+		dump.StagedBonds[candidate][common.BytesToAddress(key[common.AddressLength:])] = (*math.Decimal256)(new(big.Int).SetBytes(value))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(rewardPrefix, func(key, value []byte) error {
+		dump.Rewards[common.BytesToAddress(key)] = (*math.Decimal256)(new(big.Int).SetBytes(value))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(decayPrefix, func(key, value []byte) error {
+		dump.Decays[common.BytesToAddress(key)] = binary.BigEndian.Uint64(value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(noncePrefix, func(key, value []byte) error {
+		dump.Nonces[common.BytesToAddress(key)] = binary.BigEndian.Uint64(value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(statsPrefix, func(key, value []byte) error {
+		var stats EpochStats
+		if err := json.Unmarshal(value, &stats); err != nil {
+			return err
+		}
+		dump.Stats[binary.BigEndian.Uint64(key)] = stats
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(payoutPrefix, func(key, value []byte) error {
+		dump.Payouts[common.BytesToAddress(key)] = common.BytesToAddress(value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(stakePrefix, func(key, value []byte) error {
+		candidate := common.BytesToAddress(key[:common.AddressLength])
+		if dump.Stakes[candidate] == nil {
+			dump.Stakes[candidate] = make(map[common.Address]*math.Decimal256)
+		}
+		dump.Stakes[candidate][common.BytesToAddress(key[common.AddressLength:])] = (*math.Decimal256)(new(big.Int).SetBytes(value))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = cpy.iterate(bondPrefix, func(key, value []byte) error {
+		candidate := common.BytesToAddress(key[:common.AddressLength])
+		if dump.Bonds[candidate] == nil {
+			dump.Bonds[candidate] = make(map[common.Address]*math.Decimal256)
+		}
+		dump.Bonds[candidate][common.BytesToAddress(key[common.AddressLength:])] = (*math.Decimal256)(new(big.Int).SetBytes(value))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dump, nil
+}
+
+// iterate calls fn with every key, without the prefix, and value in the trie
+// of prefix in key order.
+func (snap *Snapshot) iterate(prefix []byte, fn func(key, value []byte) error) error {
+	t, err := snap.ensureTrie(prefix)
+	if err != nil {
+		return err
+	}
+	iter := trie.NewIterator(t.NodeIterator(nil))
+	for iter.Next() {
+		if err = fn(iter.Key[len(prefix):], iter.Value); err != nil {
+			return err
+		}
+	}
+	return iter.Err
+}
+
+// verifyRoot rebuilds every trie of snapshot from its contents and compares the
+// hash to the one in root, the first trie which doesn't match is named in the
+// error. The snapshot is left untouched.
+func (snap *Snapshot) verifyRoot(root Root) error {
+	cpy := snap.copy()
+	db := trie.NewDatabase(memorydb.New())
+	for idx, hash := range root.fields() {
+		prefix := triePrefixes[idx]
+		rebuilt, err := NewTrieWithPrefix(common.Hash{}, prefix, db)
+		if err != nil {
+			return err
+		}
+		count := 0
+		err = cpy.iterate(prefix, func(key, value []byte) error {
+			count++
+			return rebuilt.TryUpdate(common.CopyBytes(key), common.CopyBytes(value))
+		})
+		if err != nil {
+			return err
+		}
+
+		// A trie never created has no hash in root
+		if count == 0 && *hash == (common.Hash{}) {
+			continue
+		}
+		if rebuilt.Hash() != *hash {
+			return fmt.Errorf("%w: %s trie has %s, header %s", errInvalidTrieRoot,
+				strings.TrimSuffix(string(prefix), "-"), rebuilt.Hash().Hex(), hash.Hex())
+		}
+	}
+	return nil
+}
+
+// SnapshotDiff is the difference of the tries of snapshot B against snapshot
+// A, keyed by the name of trie. Tries with the same root are left out.
+type SnapshotDiff struct {
+	A     Root                 `json:"a"`
+	B     Root                 `json:"b"`
+	Tries map[string]*TrieDiff `json:"tries"`
+}
+
+// Empty reports whether both snapshots have the same contents.
+func (diff *SnapshotDiff) Empty() bool {
+	return len(diff.Tries) == 0
+}
+
+// TrieDiff is the entries of a trie added, removed or changed in snapshot B,
+// in key order. Keys are without the prefix of trie.
+type TrieDiff struct {
+	Added   []DiffEntry `json:"added"`
+	Removed []DiffEntry `json:"removed"`
+	Changed []DiffEntry `json:"changed"`
+}
+
+// DiffEntry is an entry of trie and its raw values in both snapshots, the
+// value is empty if the entry is missing in the snapshot.
+type DiffEntry struct {
+	Key hexutil.Bytes `json:"key"`
+	A   hexutil.Bytes `json:"a,omitempty"`
+	B   hexutil.Bytes `json:"b,omitempty"`
+}
+
+// Diff compares every trie of snapshot with the other one, both snapshots are
+// left untouched.
+func (snap *Snapshot) Diff(other *Snapshot) (*SnapshotDiff, error) {
+	a, b := snap.copy(), other.copy()
+	diff := &SnapshotDiff{A: a.root, B: b.root, Tries: make(map[string]*TrieDiff)}
+
+	for _, prefix := range triePrefixes {
+		ta, err := a.ensureTrie(prefix)
+		if err != nil {
+			return nil, err
+		}
+		tb, err := b.ensureTrie(prefix)
+		if err != nil {
+			return nil, err
+		}
+		if ta.Hash() == tb.Hash() {
+			continue
+		}
+
+		var keys []string
+		values := make(map[string][]byte)
+		err = a.iterate(prefix, func(key, value []byte) error {
+			keys = append(keys, string(key))
+			values[string(key)] = common.CopyBytes(value)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		trieDiff := &TrieDiff{Added: []DiffEntry{}, Removed: []DiffEntry{}, Changed: []DiffEntry{}}
+		err = b.iterate(prefix, func(key, value []byte) error {
+			old, ok := values[string(key)]
+			switch {
+			case !ok:
+				trieDiff.Added = append(trieDiff.Added, DiffEntry{Key: common.CopyBytes(key), B: common.CopyBytes(value)})
+			case !bytes.Equal(old, value):
+				trieDiff.Changed = append(trieDiff.Changed, DiffEntry{Key: common.CopyBytes(key), A: old, B: common.CopyBytes(value)})
+			}
+			delete(values, string(key))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if value, ok := values[key]; ok {
+				trieDiff.Removed = append(trieDiff.Removed, DiffEntry{Key: []byte(key), A: value})
+			}
+		}
+		diff.Tries[strings.TrimSuffix(string(prefix), "-")] = trieDiff
+	}
+	return diff, nil
+}
+
+// GetChainConfig returns chain config from snapshot.
+func (snap *Snapshot) GetChainConfig() (params.SenateConfig, error) {
+	configTrie, err := snap.ensureTrie(configPrefix)
+	if err != nil {
+		return params.SenateConfig{}, err
+	}
+
+	key := []byte("config")
+	data := configTrie.Get(key)
 	var config params.SenateConfig
 	if err := json.Unmarshal(data, &config); err != nil {
 		return params.SenateConfig{}, err
 	}
-	return config, nil
+	return config, nil
+}
+
+// SetChainConfig write chain config to snapshot.
+func (snap *Snapshot) SetChainConfig(config params.SenateConfig) error {
+	return snap.setChainConfig([]byte("config"), config)
+}
+
+// GetPendingChainConfig returns chain config approved by proposals which takes
+// effect since the next epoch, nil if nothing approved.
+func (snap *Snapshot) GetPendingChainConfig() (*params.SenateConfig, error) {
+	configTrie, err := snap.ensureTrie(configPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := configTrie.TryGet([]byte("pending"))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var config params.SenateConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// SetPendingChainConfig write chain config which takes effect since the next
+// epoch to snapshot.
+func (snap *Snapshot) SetPendingChainConfig(config params.SenateConfig) error {
+	return snap.setChainConfig([]byte("pending"), config)
+}
+
+// ApplyPendingChainConfig replace chain config with the pending one, returns
+// the applied chain config or nil if nothing is pending.
+func (snap *Snapshot) ApplyPendingChainConfig() (*params.SenateConfig, error) {
+	config, err := snap.GetPendingChainConfig()
+	if err != nil || config == nil {
+		return nil, err
+	}
+	if err = snap.SetChainConfig(*config); err != nil {
+		return nil, err
+	}
+
+	configTrie, err := snap.ensureTrie(configPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return config, configTrie.TryDelete([]byte("pending"))
+}
+
+func (snap *Snapshot) setChainConfig(key []byte, config params.SenateConfig) error {
+	if len(config.Rewards) == 0 {
+		config.Rewards = nil
+	}
+	if len(config.Validators) == 0 {
+		config.Validators = nil
+	}
+	if len(config.InitialStakes) == 0 {
+		config.InitialStakes = nil
+	}
+	if config.CandidateDeposit != nil && config.CandidateDeposit.Sign() == 0 {
+		config.CandidateDeposit = nil
+	}
+	if config.MinCandidateStake != nil && config.MinCandidateStake.Sign() == 0 {
+		config.MinCandidateStake = nil
+	}
+	if config.InitialReward != nil && config.InitialReward.Sign() == 0 {
+		config.InitialReward = nil
+	}
+	if config.MinReward != nil && config.MinReward.Sign() == 0 {
+		config.MinReward = nil
+	}
+	if config.ProposalReward != nil && config.ProposalReward.Sign() == 0 {
+		config.ProposalReward = nil
+	}
+
+	configTrie, err := snap.ensureTrie(configPrefix)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return configTrie.TryUpdate(key, data)
+}
+
+// GetValidators returns validators of current epoch.
+func (snap *Snapshot) GetValidators() (SortableAddresses, error) {
+	epochTrie, err := snap.ensureTrie(epochPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	key := []byte("validator")
+	var validators SortableAddresses
+	validatorsRLP := epochTrie.Get(key)
+	if validatorsRLP == nil {
+		// Nobody elected before the first election
+		return validators, nil
+	}
+	if err := rlp.DecodeBytes(validatorsRLP, &validators); err != nil {
+		return nil, fmt.Errorf("failed to decode validators: %s", err)
+	}
+	return validators, nil
+}
+
+// SetValidators write validators of current epoch to snapshot.
+func (snap *Snapshot) SetValidators(validators SortableAddresses) error {
+	key := []byte("validator")
+	validatorsRLP, err := rlp.EncodeToBytes(validators)
+	if err != nil {
+		return fmt.Errorf("failed to encode validators to rlp bytes: %s", err)
+	}
+
+	epochTrie, err := snap.ensureTrie(epochPrefix)
+	if err != nil {
+		return err
+	}
+	return epochTrie.TryUpdate(key, validatorsRLP)
+}
+
+// GetSigners returns signing keys of current epoch validators, in the same
+// order as validators.
+func (snap *Snapshot) GetSigners() ([]common.Address, error) {
+	validators, err := snap.GetValidators()
+	if err != nil {
+		return nil, err
+	}
+
+	epochTrie, err := snap.ensureTrie(epochPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	signers := make([]common.Address, 0, len(validators))
+	signersRLP := epochTrie.Get([]byte("signer"))
+	if signersRLP == nil {
+		for _, validator := range validators {
+			signers = append(signers, validator.Address)
+		}
+		return signers, nil
+	}
+	if err := rlp.DecodeBytes(signersRLP, &signers); err != nil {
+		return nil, fmt.Errorf("failed to decode signers: %s", err)
+	}
+	if len(signers) != len(validators) {
+		return nil, errors.New("mismatch signers of validators")
+	}
+	return signers, nil
+}
+
+// UpdateSigners resolve the signing keys of current epoch validators, the
+// rotated keys take effect since now.
+func (snap *Snapshot) UpdateSigners() error {
+	validators, err := snap.GetValidators()
+	if err != nil {
+		return err
+	}
+
+	rotated := false
+	signers := make([]common.Address, 0, len(validators))
+	for _, validator := range validators {
+		signer, err := snap.GetSigner(validator.Address)
+		if err != nil {
+			return err
+		}
+		rotated = rotated || signer != validator.Address
+		signers = append(signers, signer)
+	}
+
+	epochTrie, err := snap.ensureTrie(epochPrefix)
+	if err != nil {
+		return err
+	}
+	key := []byte("signer")
+	if !rotated {
+		return epochTrie.TryDelete(key)
+	}
+	signersRLP, err := rlp.EncodeToBytes(signers)
+	if err != nil {
+		return fmt.Errorf("failed to encode signers to rlp bytes: %s", err)
+	}
+	return epochTrie.TryUpdate(key, signersRLP)
+}
+
+// Registrations is the count of new candidates registered in an epoch.
+type Registrations struct {
+	Epoch uint64 `json:"epoch"`
+	Count uint64 `json:"count"`
+}
+
+// getRegistrations returns the stored count of registrations, nil if none.
+func (snap *Snapshot) getRegistrations() (*Registrations, error) {
+	epochTrie, err := snap.ensureTrie(epochPrefix)
+	if err != nil {
+		return nil, err
+	}
+	data, err := epochTrie.TryGet([]byte("registrations"))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	registrations := new(Registrations)
+	if err := rlp.DecodeBytes(data, registrations); err != nil {
+		return nil, err
+	}
+	return registrations, nil
+}
+
+// GetRegistrations returns the count of new candidates registered in epoch.
+func (snap *Snapshot) GetRegistrations(epoch uint64) (uint64, error) {
+	registrations, err := snap.getRegistrations()
+	if err != nil || registrations == nil || registrations.Epoch != epoch {
+		return 0, err
+	}
+	return registrations.Count, nil
+}
+
+// AddRegistration counts a new candidate registered in epoch.
+func (snap *Snapshot) AddRegistration(epoch uint64) error {
+	count, err := snap.GetRegistrations(epoch)
+	if err != nil {
+		return err
+	}
+	data, err := rlp.EncodeToBytes(Registrations{Epoch: epoch, Count: count + 1})
+	if err != nil {
+		return err
+	}
+	epochTrie, err := snap.ensureTrie(epochPrefix)
+	if err != nil {
+		return err
+	}
+	return epochTrie.TryUpdate([]byte("registrations"), data)
+}
+
+// ResetRegistrations drops the count of registrations of any epoch before the
+// given one.
+func (snap *Snapshot) ResetRegistrations(epoch uint64) error {
+	registrations, err := snap.getRegistrations()
+	if err != nil || registrations == nil || registrations.Epoch >= epoch {
+		return err
+	}
+	epochTrie, err := snap.ensureTrie(epochPrefix)
+	if err != nil {
+		return err
+	}
+	return epochTrie.TryDelete([]byte("registrations"))
+}
+
+// GetSigner returns the signing key of candidate, which is the candidate
+// itself unless rotated.
+func (snap *Snapshot) GetSigner(candidateAddr common.Address) (common.Address, error) {
+	signerTrie, err := snap.ensureTrie(signerPrefix)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	signer, err := signerTrie.TryGet(candidateAddr.Bytes())
+	if err != nil {
+		return common.Address{}, err
+	}
+	if signer == nil {
+		return candidateAddr, nil
+	}
+	return common.BytesToAddress(signer), nil
+}
+
+// RotateKey associate a new signing key with the candidate, the key is used
+// to sign blocks since the next epoch.
+func (snap *Snapshot) RotateKey(candidateAddr, signerAddr common.Address) error {
+	signerTrie, err := snap.ensureTrie(signerPrefix)
+	if err != nil {
+		return err
+	}
+	if candidateAddr == signerAddr {
+		return signerTrie.TryDelete(candidateAddr.Bytes())
+	}
+	return signerTrie.TryUpdate(candidateAddr.Bytes(), signerAddr.Bytes())
+}
+
+// GetRewardAddress returns the address credited with the rewards of candidate,
+// which is the candidate itself unless redirected.
+func (snap *Snapshot) GetRewardAddress(candidateAddr common.Address) (common.Address, error) {
+	if snap.payoutTrie == nil && snap.root.PayoutHash == (common.Hash{}) {
+		return candidateAddr, nil
+	}
+	payoutTrie, err := snap.ensureTrie(payoutPrefix)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	address, err := payoutTrie.TryGet(candidateAddr.Bytes())
+	if err != nil {
+		return common.Address{}, err
+	}
+	if address == nil {
+		return candidateAddr, nil
+	}
+	return common.BytesToAddress(address), nil
+}
+
+// SetRewardAddress redirects the rewards of candidate to address, setting the
+// candidate itself removes the redirection.
+func (snap *Snapshot) SetRewardAddress(candidateAddr, address common.Address) error {
+	payoutTrie, err := snap.ensureTrie(payoutPrefix)
+	if err != nil {
+		return err
+	}
+	if candidateAddr == address {
+		return payoutTrie.TryDelete(candidateAddr.Bytes())
+	}
+	return payoutTrie.TryUpdate(candidateAddr.Bytes(), address.Bytes())
+}
+
+// ValidatorOf returns the candidate which the signing key belongs to, the
+// signer itself is returned if no candidate rotated to it.
+func (snap *Snapshot) ValidatorOf(signerAddr common.Address) (common.Address, error) {
+	if snap.signerTrie == nil && snap.root.SignerHash == (common.Hash{}) {
+		return signerAddr, nil
+	}
+	if validators, err := snap.GetValidators(); err == nil {
+		signers, err := snap.GetSigners()
+		if err != nil {
+			return common.Address{}, err
+		}
+		for idx, signer := range signers {
+			if signer == signerAddr {
+				return validators[idx].Address, nil
+			}
+		}
+	}
+
+	signerTrie, err := snap.ensureTrie(signerPrefix)
+	if err != nil {
+		return common.Address{}, err
+	}
+	iter := trie.NewIterator(signerTrie.NodeIterator(nil))
+	for iter.Next() {
+		if common.BytesToAddress(iter.Value) == signerAddr {
+			return common.BytesToAddress(iter.Key[len(signerPrefix):]), nil
+		}
+	}
+	return signerAddr, iter.Err
+}
+
+// CountMinted count the minted of each validator.
+func (snap *Snapshot) CountMinted(epoch uint64) (SortableAddresses, error) {
+	validators, err := snap.GetValidators()
+	if err != nil {
+		return nil, err
+	}
+
+	mintCntTrie, err := snap.ensureTrie(mintCntPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, 8)
+	binary.BigEndian.PutUint64(prefix, epoch)
+	iter := trie.NewIterator(mintCntTrie.PrefixIterator(prefix))
+
+	mapper := make(map[common.Address]int64)
+	for iter.Next() {
+		validator := common.BytesToAddress(iter.Value)
+		count, _ := mapper[validator]
+		mapper[validator] = count + 1
+	}
+
+	for idx := range validators {
+		validator := &validators[idx]
+		count, ok := mapper[validator.Address]
+		if !ok {
+			validator.Weight = big.NewInt(0)
+		} else {
+			validator.Weight = big.NewInt(count)
+		}
+	}
+	sort.Sort(sort.Reverse(validators))
+	return validators, nil
+}
+
+// MintCounts counts the blocks minted by each validator in the epoch.
+func (snap *Snapshot) MintCounts(epoch uint64) (map[common.Address]uint64, error) {
+	mintCntTrie, err := snap.ensureTrie(mintCntPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, 8)
+	binary.BigEndian.PutUint64(prefix, epoch)
+	iter := trie.NewIterator(mintCntTrie.PrefixIterator(prefix))
+
+	counts := make(map[common.Address]uint64)
+	for iter.Next() {
+		counts[common.BytesToAddress(iter.Value)]++
+	}
+	return counts, iter.Err
+}
+
+// ForgeBlock write validator of block to snapshot.
+func (snap *Snapshot) MintBlock(epoch, number uint64, validator common.Address) error {
+	mintCntTrie, err := snap.ensureTrie(mintCntPrefix)
+	if err != nil {
+		return err
+	}
+
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], epoch)
+	binary.BigEndian.PutUint64(key[8:], number)
+	return mintCntTrie.TryUpdate(key, validator.Bytes())
+}
+
+// LoadRecents loads the validators minted the last count blocks up to number
+// in the epoch into Recents, blocks of the previous epochs are not included.
+func (snap *Snapshot) LoadRecents(epoch, number uint64, count int) (map[uint64]common.Address, error) {
+	mintCntTrie, err := snap.ensureTrie(mintCntPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	recents := make(map[uint64]common.Address)
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], epoch)
+	for n := number; n > 0 && number-n < uint64(count); n-- {
+		binary.BigEndian.PutUint64(key[8:], n)
+		validator, err := mintCntTrie.TryGet(key)
+		if err != nil {
+			return nil, err
+		}
+		if validator == nil {
+			break
+		}
+		recents[n] = common.BytesToAddress(validator)
+	}
+	snap.Recents = recents
+	return recents, nil
+}
+
+// CountVotes count the votes of candidate, the amounts locked for it included.
+func (snap *Snapshot) CountVotes(state *state.StateDB, candidateAddr common.Address) (*big.Int, error) {
+	delegateTrie, err := snap.ensureTrie(delegatePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	candidate, err := candidateTrie.TryGet(candidateAddr.Bytes())
+	if err != nil || candidate == nil {
+		return nil, errors.New("no candidate")
+	}
+	candidate = candidate[:common.AddressLength]
+
+	votes := big.NewInt(0)
+	delegateIterator := trie.NewIterator(delegateTrie.PrefixIterator(candidate))
+	for delegateIterator.Next() {
+		delegator := delegateIterator.Value
+		delegatorAddr := common.BytesToAddress(delegator)
+		weight, err := snap.VoteWeight(state, delegatorAddr)
+		if err != nil {
+			return nil, err
+		}
+		votes.Add(votes, weight)
+	}
+	bonded, err := snap.bondedVotes(candidateAddr)
+	if err != nil {
+		return nil, err
+	}
+	return votes.Add(votes, bonded), nil
+}
+
+// GetCandidates returns all candidates ordered by address.
+func (snap *Snapshot) GetCandidates() ([]common.Address, error) {
+	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []common.Address
+	iter := trie.NewIterator(candidateTrie.NodeIterator(nil))
+	for iter.Next() {
+		candidates = append(candidates, common.BytesToAddress(iter.Value[:common.AddressLength]))
+	}
+	return candidates, iter.Err
+}
+
+// GetDelegators returns the delegators of candidate ordered by address.
+func (snap *Snapshot) GetDelegators(candidateAddr common.Address) ([]common.Address, error) {
+	delegateTrie, err := snap.ensureTrie(delegatePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var delegators []common.Address
+	iter := trie.NewIterator(delegateTrie.PrefixIterator(candidateAddr.Bytes()))
+	for iter.Next() {
+		delegators = append(delegators, common.BytesToAddress(iter.Value))
+	}
+	return delegators, iter.Err
+}
+
+// GetDelegatedCandidate returns the candidate delegator delegated to, false if
+// the delegator hasn't delegated to any.
+func (snap *Snapshot) GetDelegatedCandidate(delegatorAddr common.Address) (common.Address, bool, error) {
+	if snap.voteTrie == nil && snap.root.VoteHash == (common.Hash{}) {
+		return common.Address{}, false, nil
+	}
+	voteTrie, err := snap.ensureTrie(votePrefix)
+	if err != nil {
+		return common.Address{}, false, err
+	}
+
+	candidate, err := voteTrie.TryGet(delegatorAddr.Bytes())
+	if err != nil || candidate == nil {
+		return common.Address{}, false, err
+	}
+	return common.BytesToAddress(candidate), true, nil
+}
+
+// EnoughCandidates count of candidates is greater than or equal to n.
+// It counts the first candidate only, the legacy kick-out rule depends on it,
+// use CountCandidates to count all of them.
+func (snap *Snapshot) EnoughCandidates(n int) (int, bool) {
+	candidateCount := 0
+	if n <= 0 {
+		return 0, true
+	}
+
+	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+	if err != nil {
+		return 0, false
+	}
+
+	iterCandidate := trie.NewIterator(candidateTrie.NodeIterator(nil))
+	if iterCandidate.Next() {
+		candidateCount++
+		if candidateCount >= n {
+			return candidateCount, true
+		}
+	}
+	return candidateCount, false
+}
+
+// CountCandidates counts the candidates up to n, reporting whether there are
+// at least n of them.
+func (snap *Snapshot) CountCandidates(n int) (int, bool) {
+	candidateCount := 0
+	if n <= 0 {
+		return 0, true
+	}
+
+	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+	if err != nil {
+		return 0, false
+	}
+
+	iterCandidate := trie.NewIterator(candidateTrie.NodeIterator(nil))
+	for iterCandidate.Next() {
+		candidateCount++
+		if candidateCount >= n {
+			return candidateCount, true
+		}
+	}
+	return candidateCount, false
+}
+
+// TopCandidates candidates with the top N votes.
+func (snap *Snapshot) TopCandidates(state *state.StateDB, n int) (SortableAddresses, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	delegateTrie, err := snap.ensureTrie(delegatePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	iterCandidate := trie.NewIterator(candidateTrie.NodeIterator(nil))
+	existCandidate := iterCandidate.Next()
+	if !existCandidate {
+		return nil, nil
+	}
+
+	// Count of votes in election, in the order of the candidate trie
+	candidates := make(SortableAddresses, 0, n)
+	for existCandidate {
+		candidate := iterCandidate.Value[:common.AddressLength]
+		score := big.NewInt(0)
+		delegateIterator := trie.NewIterator(delegateTrie.PrefixIterator(candidate))
+		for delegateIterator.Next() {
+			delegatorAddr := common.BytesToAddress(delegateIterator.Value)
+			weight, err := snap.VoteWeight(state, delegatorAddr)
+			if err != nil {
+				return nil, err
+			}
+			score.Add(score, weight)
+		}
+		bonded, err := snap.bondedVotes(common.BytesToAddress(candidate))
+		if err != nil {
+			return nil, err
+		}
+		score.Add(score, bonded)
+		candidates = append(candidates, SortableAddress{common.BytesToAddress(candidate), score})
+		existCandidate = iterCandidate.Next()
+	}
+
+	// Sort candidates by votes, ties are broken by address
+	sort.Sort(candidates)
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates, nil
+}
+
+// RandCandidates selects n candidates other than the excluded ones uniformly at
+// random.
+func (snap *Snapshot) RandCandidates(seed int64, n int, excluded []common.Address) (SortableAddresses, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	iterCandidate := trie.NewIterator(candidateTrie.NodeIterator(nil))
+	existCandidate := iterCandidate.Next()
+	if !existCandidate {
+		return nil, nil
+	}
+
+	// All candidate
+	candidates := make(SortableAddresses, 0)
+	for existCandidate {
+		candidate := iterCandidate.Value[:common.AddressLength]
+		candidateAddr := common.BytesToAddress(candidate)
+		if !containsAddress(excluded, candidateAddr) {
+			candidates = append(candidates, SortableAddress{candidateAddr, big.NewInt(0)})
+		}
+		existCandidate = iterCandidate.Next()
+	}
+
+	// Shuffle candidates
+	r := rand.New(rand.NewSource(seed))
+	for i := len(candidates) -1 ; i >0; i-- {
+		j := int(r.Int31n(int32(i + 1)))
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	}
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates, nil
+}
+
+// WeightedCandidates selects n candidates other than the excluded ones randomly,
+// the chance of a candidate is proportional to its stake, which is the deposit
+// plus the balance of its delegators. Candidates without stake are only
+// selected after all the others.
+func (snap *Snapshot) WeightedCandidates(state *state.StateDB, seed int64, n int, excluded []common.Address) (SortableAddresses, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	addresses, err := snap.GetCandidates()
+	if err != nil {
+		return nil, err
+	}
+
+	total := big.NewInt(0)
+	candidates := make(SortableAddresses, 0, len(addresses))
+	for _, address := range addresses {
+		if containsAddress(excluded, address) {
+			continue
+		}
+		votes, err := snap.CountVotes(state, address)
+		if err != nil {
+			return nil, err
+		}
+		deposit, err := snap.GetDeposit(address)
+		if err != nil {
+			return nil, err
+		}
+		stake := votes.Add(votes, deposit)
+		total.Add(total, stake)
+		candidates = append(candidates, SortableAddress{Address: address, Weight: stake})
+	}
+
+	r := rand.New(rand.NewSource(seed))
+	elected := make(SortableAddresses, 0, n)
+	for len(elected) < n && len(candidates) > 0 {
+		idx := 0
+		if total.Sign() == 0 {
+			idx = r.Intn(len(candidates))
+		} else {
+			point := new(big.Int).Rand(r, total)
+			for ; idx < len(candidates)-1; idx++ {
+				if point.Cmp(candidates[idx].Weight) < 0 {
+					break
+				}
+				point.Sub(point, candidates[idx].Weight)
+			}
+		}
+		elected = append(elected, candidates[idx])
+		total.Sub(total, candidates[idx].Weight)
+		candidates = append(candidates[:idx], candidates[idx+1:]...)
+	}
+	return elected, nil
+}
+
+// Reputations returns the minting reliability in percent of the validators which
+// minted blocks in the count epochs before epoch. The reliability in an epoch is
+// the blocks minted relative to an even share of the blocks among the validators
+// which minted, capped at 100, and it is averaged over the epochs minted in.
+func (snap *Snapshot) Reputations(epoch, count uint64) (map[common.Address]uint64, error) {
+	if count == 0 {
+		count = 1
+	}
+	sums := make(map[common.Address]uint64)
+	epochs := make(map[common.Address]uint64)
+	for past := epoch - 1; past > 0 && epoch-past <= count; past-- {
+		counts, err := snap.MintCounts(past)
+		if err != nil {
+			return nil, err
+		}
+		var blocks uint64
+		for _, minted := range counts {
+			blocks += minted
+		}
+		for validator, minted := range counts {
+			reliability := minted * uint64(len(counts)) * 100 / blocks
+			if reliability > 100 {
+				reliability = 100
+			}
+			sums[validator] += reliability
+			epochs[validator]++
+		}
+	}
+
+	reputations := make(map[common.Address]uint64, len(sums))
+	for validator, sum := range sums {
+		reputations[validator] = sum / epochs[validator]
+	}
+	return reputations, nil
+}
+
+// ReputableCandidates ranks the candidates other than the excluded ones by
+// stake, the deposit plus the balance of its delegators, with weight percent
+// of it scaled by the reputation of the candidate, and returns the top n.
+// Candidates without reputation count as fully reliable.
+func (snap *Snapshot) ReputableCandidates(state *state.StateDB, n int, excluded []common.Address,
+	reputations map[common.Address]uint64, weight uint64) (SortableAddresses, error) {
+
+	if n <= 0 {
+		return nil, nil
+	}
+	addresses, err := snap.GetCandidates()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make(SortableAddresses, 0, len(addresses))
+	for _, address := range addresses {
+		if containsAddress(excluded, address) {
+			continue
+		}
+		votes, err := snap.CountVotes(state, address)
+		if err != nil {
+			return nil, err
+		}
+		deposit, err := snap.GetDeposit(address)
+		if err != nil {
+			return nil, err
+		}
+		reputation, ok := reputations[address]
+		if !ok {
+			reputation = 100
+		}
+
+		// score = stake * ((100-weight)*100 + weight*reputation) / 10000
+		factor := (100-weight)*100 + weight*reputation
+		score := votes.Add(votes, deposit)
+		score.Mul(score, new(big.Int).SetUint64(factor))
+		score.Div(score, big.NewInt(10000))
+		candidates = append(candidates, SortableAddress{Address: address, Weight: score})
+	}
+
+	// Sort candidates by score, ties are broken by address
+	sort.Sort(candidates)
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates, nil
+}
+
+// SelfStakeShortfalls returns the candidates in address order whose self-stake,
+// the deposit plus its own balance if it votes for itself and its own bond, is
+// below percent of the stake delegated or bonded by others.
+func (snap *Snapshot) SelfStakeShortfalls(state *state.StateDB, percent uint64) ([]common.Address, error) {
+	if percent == 0 {
+		return nil, nil
+	}
+	candidates, err := snap.GetCandidates()
+	if err != nil {
+		return nil, err
+	}
+
+	var shortfalls []common.Address
+	for _, candidate := range candidates {
+		self, err := snap.GetDeposit(candidate)
+		if err != nil {
+			return nil, err
+		}
+		delegators, err := snap.GetDelegators(candidate)
+		if err != nil {
+			return nil, err
+		}
+		delegated := big.NewInt(0)
+		for _, delegator := range delegators {
+			weight, err := snap.VoteWeight(state, delegator)
+			if err != nil {
+				return nil, err
+			}
+			if delegator == candidate {
+				self.Add(self, weight)
+			} else {
+				delegated.Add(delegated, weight)
+			}
+		}
+		bonds, err := snap.GetBonds(candidate)
+		if err != nil {
+			return nil, err
+		}
+		for _, bond := range bonds {
+			if bond.Delegator == candidate {
+				self.Add(self, bond.Amount)
+			} else {
+				delegated.Add(delegated, bond.Amount)
+			}
+		}
+
+		required := new(big.Int).Mul(delegated, new(big.Int).SetUint64(percent))
+		if new(big.Int).Mul(self, big.NewInt(100)).Cmp(required) < 0 {
+			shortfalls = append(shortfalls, candidate)
+		}
+	}
+	return shortfalls, nil
+}
+
+// BecomeCandidate add a new candidate, the key of an existing candidate is kept.
+func (snap *Snapshot) BecomeCandidate(candidateAddr common.Address) error {
+	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+	if err != nil {
+		return err
+	}
+	candidate := candidateAddr.Bytes()
+	if exist, err := candidateTrie.TryGet(candidate); err != nil || exist != nil {
+		return err
+	}
+	return candidateTrie.TryUpdate(candidate, candidate)
+}
+
+// GetCandidateKey returns the auxiliary public key registered by candidate, nil
+// if the candidate registered without a key.
+func (snap *Snapshot) GetCandidateKey(candidateAddr common.Address) ([]byte, error) {
+	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	candidate, err := candidateTrie.TryGet(candidateAddr.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if candidate == nil {
+		return nil, errors.New("no candidate")
+	}
+	if len(candidate) == common.AddressLength {
+		return nil, nil
+	}
+	return candidate[common.AddressLength:], nil
+}
+
+// SetCandidateKey write the auxiliary public key of candidate to snapshot, the
+// key is stored after the address in the candidate trie.
+func (snap *Snapshot) SetCandidateKey(candidateAddr common.Address, key []byte) error {
+	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+	if err != nil {
+		return err
+	}
+
+	candidate := candidateAddr.Bytes()
+	exist, err := candidateTrie.TryGet(candidate)
+	if err != nil {
+		return err
+	}
+	if exist == nil {
+		return errors.New("no candidate")
+	}
+	return candidateTrie.TryUpdate(candidate, append(candidate, key...))
+}
+
+// IsCandidate returns whether the address is a candidate.
+func (snap *Snapshot) IsCandidate(candidateAddr common.Address) (bool, error) {
+	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+	if err != nil {
+		return false, err
+	}
+	candidate, err := candidateTrie.TryGet(candidateAddr.Bytes())
+	if err != nil {
+		return false, err
+	}
+	return candidate != nil, nil
+}
+
+// KickOutCandidate kick out existing candidate.
+func (snap *Snapshot) KickOutCandidate(candidateAddr common.Address) error {
+	voteTrie, err := snap.ensureTrie(votePrefix)
+	if err != nil {
+		return err
+	}
+
+	delegateTrie, err := snap.ensureTrie(delegatePrefix)
+	if err != nil {
+		return err
+	}
+
+	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+	if err != nil {
+		return err
+	}
+
+	candidate := candidateAddr.Bytes()
+	err = candidateTrie.TryDelete(candidate)
+	if err != nil {
+		if _, ok := err.(*trie.MissingNodeError); !ok {
+			return err
+		}
+	}
+	iter := trie.NewIterator(delegateTrie.PrefixIterator(candidate))
+	for iter.Next() {
+		delegator := iter.Value
+		key := append(candidate, delegator...)
+		err = delegateTrie.TryDelete(key)
+		if err != nil {
+			if _, ok := err.(*trie.MissingNodeError); !ok {
+				return err
+			}
+		}
+		v, err := voteTrie.TryGet(delegator)
+		if err != nil {
+			if _, ok := err.(*trie.MissingNodeError); !ok {
+				return err
+			}
+		}
+		if err == nil && bytes.Equal(v, candidate) {
+			err = voteTrie.TryDelete(delegator)
+			if err != nil {
+				if _, ok := err.(*trie.MissingNodeError); !ok {
+					return err
+				}
+			}
+			if err = snap.renewVote(common.BytesToAddress(delegator)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetDeposit returns the deposit locked by the candidate.
+func (snap *Snapshot) GetDeposit(candidateAddr common.Address) (*big.Int, error) {
+	if snap.depositTrie == nil && snap.root.DepositHash == (common.Hash{}) {
+		return new(big.Int), nil
+	}
+	depositTrie, err := snap.ensureTrie(depositPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := depositTrie.TryGet(candidateAddr.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// SetDeposit write the deposit locked by the candidate to snapshot.
+func (snap *Snapshot) SetDeposit(candidateAddr common.Address, amount *big.Int) error {
+	depositTrie, err := snap.ensureTrie(depositPrefix)
+	if err != nil {
+		return err
+	}
+	if amount == nil || amount.Sign() <= 0 {
+		return depositTrie.TryDelete(candidateAddr.Bytes())
+	}
+	return depositTrie.TryUpdate(candidateAddr.Bytes(), amount.Bytes())
+}
+
+// DebitDeposit debits the amount from the deposit locked by the candidate,
+// false is returned if the candidate has no deposit.
+func (snap *Snapshot) DebitDeposit(candidateAddr common.Address, amount *big.Int) (bool, error) {
+	deposit, err := snap.GetDeposit(candidateAddr)
+	if err != nil || deposit.Sign() == 0 {
+		return false, err
+	}
+	if deposit.Cmp(amount) < 0 {
+		return false, errors.New("debit exceeds the deposit")
+	}
+	return true, snap.SetDeposit(candidateAddr, deposit.Sub(deposit, amount))
+}
+
+// CancelCandidate remove the candidate and unlock its deposit, percent of the
+// deposit is returned to be refunded at once, the rest vests linearly over the
+// following epochs.
+func (snap *Snapshot) CancelCandidate(epoch uint64, candidateAddr common.Address, percent, epochs uint64) (*big.Int, error) {
+	if err := snap.KickOutCandidate(candidateAddr); err != nil {
+		return nil, err
+	}
+
+	deposit, err := snap.GetDeposit(candidateAddr)
+	if err != nil {
+		return nil, err
+	}
+	if deposit.Sign() == 0 {
+		return deposit, nil
+	}
+	if err = snap.SetDeposit(candidateAddr, nil); err != nil {
+		return nil, err
+	}
+
+	immediate := new(big.Int).Set(deposit)
+	if epochs > 0 && percent < 100 {
+		immediate.Mul(deposit, new(big.Int).SetUint64(percent))
+		immediate.Div(immediate, big.NewInt(100))
+	}
+	rest := new(big.Int).Sub(deposit, immediate)
+	if rest.Sign() == 0 {
+		return immediate, nil
+	}
+
+	refundTrie, err := snap.ensureTrie(refundPrefix)
+	if err != nil {
+		return nil, err
+	}
+	data, err := rlp.EncodeToBytes(Refund{Address: candidateAddr, Amount: rest, Epoch: epoch, Epochs: epochs})
+	if err != nil {
+		return nil, err
+	}
+	return immediate, refundTrie.TryUpdate(refundKey(candidateAddr, epoch), data)
 }
 
-// SetChainConfig write chain config to snapshot.
-func (snap *Snapshot) SetChainConfig(config params.SenateConfig) error {
-	if len(config.Rewards) == 0 {
-		config.Rewards = nil
+// GetRefunds returns the refunds which are still vesting.
+func (snap *Snapshot) GetRefunds() ([]Refund, error) {
+	refundTrie, err := snap.ensureTrie(refundPrefix)
+	if err != nil {
+		return nil, err
 	}
-	if len(config.Validators) == 0 {
-		config.Validators = nil
+
+	var refunds []Refund
+	iter := trie.NewIterator(refundTrie.NodeIterator(nil))
+	for iter.Next() {
+		var refund Refund
+		if err = rlp.DecodeBytes(iter.Value, &refund); err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, refund)
+	}
+	return refunds, iter.Err
+}
+
+// ReleaseRefunds returns the installments vested in the epoch, the refunds
+// which are fully paid are removed from snapshot.
+func (snap *Snapshot) ReleaseRefunds(epoch uint64) ([]Refund, error) {
+	refunds, err := snap.GetRefunds()
+	if err != nil || len(refunds) == 0 {
+		return nil, err
 	}
 
-	configTrie, err := snap.ensureTrie(configPrefix)
+	refundTrie, err := snap.ensureTrie(refundPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	installments := make([]Refund, 0, len(refunds))
+	for _, refund := range refunds {
+		amount := refund.Installment(epoch)
+		if amount.Sign() > 0 {
+			installments = append(installments, Refund{Address: refund.Address, Amount: amount, Epoch: epoch})
+		}
+		if epoch >= refund.Epoch+refund.Epochs {
+			if err = refundTrie.TryDelete(refundKey(refund.Address, refund.Epoch)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return installments, nil
+}
+
+// Unbond lock the refund of address until the release time.
+func (snap *Snapshot) Unbond(address common.Address, amount *big.Int, release uint64) error {
+	return snap.unbond(Unbonding{Address: address, Amount: amount, Release: release})
+}
+
+// UnbondBonded lock the amount address unbonded until the release time, the
+// amount is held by the staking account until released.
+func (snap *Snapshot) UnbondBonded(address common.Address, amount *big.Int, release uint64) error {
+	return snap.unbond(Unbonding{Address: address, Amount: amount, Release: release, Bonded: true})
+}
+
+// unbondKey returns the key of the unbonding in the unbond trie, the amounts
+// held by the staking account are kept apart from the refunds.
+func unbondKey(unbonding Unbonding) []byte {
+	key := make([]byte, 8+common.AddressLength, 8+common.AddressLength+1)
+	binary.BigEndian.PutUint64(key[:8], unbonding.Release)
+	copy(key[8:], unbonding.Address.Bytes())
+	if unbonding.Bonded {
+		key = append(key, 1)
+	}
+	return key
+}
+
+// unbond adds the amount to the unbonding of the same address, release time
+// and kind.
+func (snap *Snapshot) unbond(unbonding Unbonding) error {
+	unbondTrie, err := snap.ensureTrie(unbondPrefix)
 	if err != nil {
 		return err
 	}
 
-	key := []byte("config")
-	data, err := json.Marshal(config)
+	key := unbondKey(unbonding)
+	amount := unbonding.Amount
+	data, err := unbondTrie.TryGet(key)
 	if err != nil {
 		return err
 	}
-	return configTrie.TryUpdate(key, data)
+	total := new(big.Int).Add(new(big.Int).SetBytes(data), amount)
+	return unbondTrie.TryUpdate(key, total.Bytes())
 }
 
-// GetValidators returns validators of current epoch.
-func (snap *Snapshot) GetValidators() (SortableAddresses, error) {
-	epochTrie, err := snap.ensureTrie(epochPrefix)
+// GetUnbondings returns the locked refunds ordered by release time.
+func (snap *Snapshot) GetUnbondings() ([]Unbonding, error) {
+	if snap.unbondTrie == nil && snap.root.UnbondHash == (common.Hash{}) {
+		return nil, nil
+	}
+	unbondTrie, err := snap.ensureTrie(unbondPrefix)
 	if err != nil {
 		return nil, err
 	}
 
-	key := []byte("validator")
-	var validators SortableAddresses
-	validatorsRLP := epochTrie.Get(key)
-	if err := rlp.DecodeBytes(validatorsRLP, &validators); err != nil {
-		return nil, fmt.Errorf("failed to decode validators: %s", err)
-	}
-	return validators, nil
+	var unbondings []Unbonding
+	iter := trie.NewIterator(unbondTrie.NodeIterator(nil))
+	for iter.Next() {
+		key := iter.Key[len(unbondPrefix):]
+		unbondings = append(unbondings, Unbonding{
+			Address: common.BytesToAddress(key[8 : 8+common.AddressLength]),
+			Amount:  new(big.Int).SetBytes(iter.Value),
+			Release: binary.BigEndian.Uint64(key[:8]),
+			Bonded:  len(key) > 8+common.AddressLength,
+		})
+	}
+	return unbondings, iter.Err
 }
 
-// SetValidators write validators of current epoch to snapshot.
-func (snap *Snapshot) SetValidators(validators SortableAddresses) error {
-	key := []byte("validator")
-	validatorsRLP, err := rlp.EncodeToBytes(validators)
+// ReleaseUnbonded returns the refunds unlocked at the time, which are removed
+// from snapshot.
+func (snap *Snapshot) ReleaseUnbonded(time uint64) ([]Unbonding, error) {
+	unbondings, err := snap.GetUnbondings()
 	if err != nil {
-		return fmt.Errorf("failed to encode validators to rlp bytes: %s", err)
+		return nil, err
 	}
 
-	epochTrie, err := snap.ensureTrie(epochPrefix)
+	var released []Unbonding
+	for _, unbonding := range unbondings {
+		if unbonding.Release > time {
+			break
+		}
+		if err = snap.unbondTrie.TryDelete(unbondKey(unbonding)); err != nil {
+			return nil, err
+		}
+		released = append(released, unbonding)
+	}
+	return released, nil
+}
+
+// Slash record the stake debited from the validator in the epoch.
+func (snap *Snapshot) Slash(epoch uint64, validator common.Address, amount *big.Int) error {
+	slashTrie, err := snap.ensureTrie(slashPrefix)
 	if err != nil {
 		return err
 	}
-	return epochTrie.TryUpdate(key, validatorsRLP)
-}
 
-// CountMinted count the minted of each validator.
-func (snap *Snapshot) CountMinted(epoch uint64) (SortableAddresses, error) {
-	validators, err := snap.GetValidators()
+	key := make([]byte, 8+common.AddressLength)
+	binary.BigEndian.PutUint64(key[:8], epoch)
+	copy(key[8:], validator.Bytes())
+	data, err := slashTrie.TryGet(key)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	total := new(big.Int).Add(new(big.Int).SetBytes(data), amount)
+	return slashTrie.TryUpdate(key, total.Bytes())
+}
 
-	mintCntTrie, err := snap.ensureTrie(mintCntPrefix)
+// GetSlashes returns the stake debited from validators in the epoch.
+func (snap *Snapshot) GetSlashes(epoch uint64) ([]Slash, error) {
+	slashTrie, err := snap.ensureTrie(slashPrefix)
 	if err != nil {
 		return nil, err
 	}
 
 	prefix := make([]byte, 8)
 	binary.BigEndian.PutUint64(prefix, epoch)
-	iter := trie.NewIterator(mintCntTrie.PrefixIterator(prefix))
+	iter := trie.NewIterator(slashTrie.PrefixIterator(prefix))
 
-	mapper := make(map[common.Address]int64)
+	var slashes []Slash
 	for iter.Next() {
-		validator := common.BytesToAddress(iter.Value)
-		count, _ := mapper[validator]
-		mapper[validator] = count + 1
+		key := iter.Key[len(slashPrefix)+len(prefix):]
+		slashes = append(slashes, Slash{
+			Validator: common.BytesToAddress(key),
+			Amount:    new(big.Int).SetBytes(iter.Value),
+		})
 	}
-
-	for idx := range validators {
-		validator := &validators[idx]
-		count, ok := mapper[validator.Address]
-		if !ok {
-			validator.Weight = big.NewInt(0)
-		} else {
-			validator.Weight = big.NewInt(count)
-		}
-	}
-	sort.Sort(sort.Reverse(validators))
-	return validators, nil
+	return slashes, iter.Err
 }
 
-// ForgeBlock write validator of block to snapshot.
-func (snap *Snapshot) MintBlock(epoch, number uint64, validator common.Address) error {
-	mintCntTrie, err := snap.ensureTrie(mintCntPrefix)
+// AccrueReward adds amount to the pending reward of delegator.
+func (snap *Snapshot) AccrueReward(delegatorAddr common.Address, amount *big.Int) error {
+	rewardTrie, err := snap.ensureTrie(rewardPrefix)
 	if err != nil {
 		return err
 	}
 
-	key := make([]byte, 16)
-	binary.BigEndian.PutUint64(key[:8], epoch)
-	binary.BigEndian.PutUint64(key[8:], number)
-	return mintCntTrie.TryUpdate(key, validator.Bytes())
+	pending, err := snap.GetPendingReward(delegatorAddr)
+	if err != nil {
+		return err
+	}
+	pending.Add(pending, amount)
+	if pending.Sign() <= 0 {
+		return rewardTrie.TryDelete(delegatorAddr.Bytes())
+	}
+	return rewardTrie.TryUpdate(delegatorAddr.Bytes(), pending.Bytes())
 }
 
-// CountVotes count the votes of candidate.
-func (snap *Snapshot) CountVotes(state *state.StateDB, candidateAddr common.Address) (*big.Int, error) {
-	delegateTrie, err := snap.ensureTrie(delegatePrefix)
+// GetPendingReward returns the reward accrued to delegator and not withdrawn yet.
+func (snap *Snapshot) GetPendingReward(delegatorAddr common.Address) (*big.Int, error) {
+	if snap.rewardTrie == nil && snap.root.RewardHash == (common.Hash{}) {
+		return new(big.Int), nil
+	}
+	rewardTrie, err := snap.ensureTrie(rewardPrefix)
 	if err != nil {
 		return nil, err
 	}
 
-	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+	data, err := rewardTrie.TryGet(delegatorAddr.Bytes())
 	if err != nil {
 		return nil, err
 	}
+	return new(big.Int).SetBytes(data), nil
+}
 
-	candidate, err := candidateTrie.TryGet(candidateAddr.Bytes())
-	if err != nil || candidate == nil {
-		return nil, errors.New("no candidate")
+// WithdrawReward removes the pending reward of delegator and returns it, zero
+// if nothing is pending.
+func (snap *Snapshot) WithdrawReward(delegatorAddr common.Address) (*big.Int, error) {
+	pending, err := snap.GetPendingReward(delegatorAddr)
+	if err != nil || pending.Sign() == 0 {
+		return pending, err
 	}
 
-	votes := big.NewInt(0)
-	delegateIterator := trie.NewIterator(delegateTrie.PrefixIterator(candidate))
-	for delegateIterator.Next() {
-		delegator := delegateIterator.Value
-		delegatorAddr := common.BytesToAddress(delegator)
-		weight := state.GetBalance(delegatorAddr)
-		votes.Add(votes, weight)
+	rewardTrie, err := snap.ensureTrie(rewardPrefix)
+	if err != nil {
+		return nil, err
 	}
-	return votes, nil
+	if err = rewardTrie.TryDelete(delegatorAddr.Bytes()); err != nil {
+		return nil, err
+	}
+	return pending, nil
 }
 
-// EnoughCandidates count of candidates is greater than or equal to n.
-func (snap *Snapshot) EnoughCandidates(n int) (int, bool) {
-	candidateCount := 0
-	if n <= 0 {
-		return 0, true
+// SetStakes replaces the stakes fixed at the last election. The stake trie isn't
+// created unless stakes were fixed.
+func (snap *Snapshot) SetStakes(stakes []Stake) error {
+	if len(stakes) == 0 && snap.stakeTrie == nil && snap.root.StakeHash == (common.Hash{}) {
+		return nil
 	}
-
-	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+	stakeTrie, err := NewTrieWithPrefix(common.Hash{}, stakePrefix, snap.db)
 	if err != nil {
-		return 0, false
+		return err
 	}
-
-	iterCandidate := trie.NewIterator(candidateTrie.NodeIterator(nil))
-	if iterCandidate.Next() {
-		candidateCount++
-		if candidateCount >= n {
-			return candidateCount, true
+	for _, stake := range stakes {
+		key := append(stake.Candidate.Bytes(), stake.Delegator.Bytes()...)
+		if err = stakeTrie.TryUpdate(key, stake.Amount.Bytes()); err != nil {
+			return err
 		}
 	}
-	return candidateCount, false
+	snap.stakeTrie = stakeTrie
+	return nil
 }
 
-// TopCandidates candidates with the top N votes.
-func (snap *Snapshot) TopCandidates(state *state.StateDB, n int) (SortableAddresses, error) {
-	if n <= 0 {
-		return nil, nil
+// GetStake returns the vote weight of delegator for candidate fixed at the last
+// election, zero if none was.
+func (snap *Snapshot) GetStake(candidateAddr, delegatorAddr common.Address) (*big.Int, error) {
+	if snap.stakeTrie == nil && snap.root.StakeHash == (common.Hash{}) {
+		return big.NewInt(0), nil
 	}
-
-	delegateTrie, err := snap.ensureTrie(delegatePrefix)
+	stakeTrie, err := snap.ensureTrie(stakePrefix)
+	if err != nil {
+		return nil, err
+	}
+	data, err := stakeTrie.TryGet(append(candidateAddr.Bytes(), delegatorAddr.Bytes()...))
 	if err != nil {
 		return nil, err
 	}
+	return new(big.Int).SetBytes(data), nil
+}
 
-	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+// GetStakes returns the stakes of the delegators of candidate fixed at the last
+// election, ordered by delegator.
+func (snap *Snapshot) GetStakes(candidateAddr common.Address) ([]Stake, error) {
+	if snap.stakeTrie == nil && snap.root.StakeHash == (common.Hash{}) {
+		return nil, nil
+	}
+	stakeTrie, err := snap.ensureTrie(stakePrefix)
 	if err != nil {
 		return nil, err
 	}
 
-	iterCandidate := trie.NewIterator(candidateTrie.NodeIterator(nil))
-	existCandidate := iterCandidate.Next()
-	if !existCandidate {
+	var stakes []Stake
+	iter := trie.NewIterator(stakeTrie.PrefixIterator(candidateAddr.Bytes()))
+	for iter.Next() {
+		stakes = append(stakes, Stake{
+			Candidate: candidateAddr,
+			Delegator: common.BytesToAddress(iter.Key[len(stakePrefix)+common.AddressLength:]),
+			Amount:    new(big.Int).SetBytes(iter.Value),
+		})
+	}
+	return stakes, iter.Err
+}
+
+// Bond locks the amount of delegator for candidate on top of its bond, the
+// candidateAddr must be candidate.
+func (snap *Snapshot) Bond(candidateAddr, delegatorAddr common.Address, amount *big.Int) error {
+	isCandidate, err := snap.IsCandidate(candidateAddr)
+	if err != nil {
+		return err
+	}
+	if !isCandidate {
+		return errors.New("invalid candidate to bond")
+	}
+	return snap.addBond(candidateAddr, delegatorAddr, amount)
+}
+
+// addBond adds the amount to the bond of delegator for candidate.
+func (snap *Snapshot) addBond(candidateAddr, delegatorAddr common.Address, amount *big.Int) error {
+	bond, err := snap.GetBond(candidateAddr, delegatorAddr)
+	if err != nil {
+		return err
+	}
+	bondTrie, err := snap.ensureTrie(bondPrefix)
+	if err != nil {
+		return err
+	}
+	return bondTrie.TryUpdate(append(candidateAddr.Bytes(), delegatorAddr.Bytes()...), bond.Add(bond, amount).Bytes())
+}
+
+// StageBond stage the amount of delegator for candidate until the next epoch,
+// the candidateAddr must be candidate. Later amounts add to the staged one.
+func (snap *Snapshot) StageBond(candidateAddr, delegatorAddr common.Address, amount *big.Int) error {
+	isCandidate, err := snap.IsCandidate(candidateAddr)
+	if err != nil {
+		return err
+	}
+	if !isCandidate {
+		return errors.New("invalid candidate to bond")
+	}
+	stagedTrie, err := snap.ensureTrie(stagedPrefix)
+	if err != nil {
+		return err
+	}
+	key := append(candidateAddr.Bytes(), delegatorAddr.Bytes()...)
+	data, err := stagedTrie.TryGet(key)
+	if err != nil {
+		return err
+	}
+	return stagedTrie.TryUpdate(key, new(big.Int).Add(new(big.Int).SetBytes(data), amount).Bytes())
+}
+
+// ApplyStagedBonds moves the staged amounts into the bond trie in candidate
+// order. Amounts for addresses no longer candidate are still bonded, so the
+// delegators can unbond them.
+func (snap *Snapshot) ApplyStagedBonds() ([]Bond, error) {
+	if snap.stagedTrie == nil && snap.root.StagedHash == (common.Hash{}) {
 		return nil, nil
 	}
+	stagedTrie, err := snap.ensureTrie(stagedPrefix)
+	if err != nil {
+		return nil, err
+	}
 
-	// Count of votes in election
-	votes := make(map[common.Address]*big.Int)
-	for existCandidate {
-		candidate := iterCandidate.Value
-		candidateAddr := common.BytesToAddress(candidate)
-		delegateIterator := trie.NewIterator(delegateTrie.PrefixIterator(candidate))
-		existDelegator := delegateIterator.Next()
-		if !existDelegator {
-			votes[candidateAddr] = big.NewInt(0)
-			existCandidate = iterCandidate.Next()
+	var staged []Bond
+	iter := trie.NewIterator(stagedTrie.NodeIterator(nil))
+	for iter.Next() {
+		key := iter.Key[len(stagedPrefix):]
+		if len(key) != 2*common.AddressLength {
 			continue
 		}
+		staged = append(staged, Bond{
+			Candidate: common.BytesToAddress(key[:common.AddressLength]),
+			Delegator: common.BytesToAddress(key[common.AddressLength:]),
+			Amount:    new(big.Int).SetBytes(iter.Value),
+		})
+	}
+	if iter.Err != nil {
+		return nil, iter.Err
+	}
 
-		for existDelegator {
-			delegator := delegateIterator.Value
-			score, ok := votes[candidateAddr]
-			if !ok {
-				score = big.NewInt(0)
-				votes[candidateAddr] = score
-			}
-			delegatorAddr := common.BytesToAddress(delegator)
-			weight := state.GetBalance(delegatorAddr)
-			score.Add(score, weight)
-			existDelegator = delegateIterator.Next()
+	for _, bond := range staged {
+		if err = stagedTrie.TryDelete(append(bond.Candidate.Bytes(), bond.Delegator.Bytes()...)); err != nil {
+			return nil, err
+		}
+		if err = snap.addBond(bond.Candidate, bond.Delegator, bond.Amount); err != nil {
+			return nil, err
 		}
-		existCandidate = iterCandidate.Next()
 	}
+	return staged, nil
+}
 
-	// Sort candidates by votes
-	candidates := make(SortableAddresses, 0, n)
-	for candidate, cnt := range votes {
-		candidates = append(candidates, SortableAddress{candidate, cnt})
+// DebitBond unlocks the amount from the bond of delegator for candidate.
+func (snap *Snapshot) DebitBond(candidateAddr, delegatorAddr common.Address, amount *big.Int) error {
+	bond, err := snap.GetBond(candidateAddr, delegatorAddr)
+	if err != nil {
+		return err
 	}
-	sort.Sort(candidates)
-	if len(candidates) > n {
-		candidates = candidates[:n]
+	if bond.Cmp(amount) < 0 {
+		return errors.New("debit exceeds the bond")
 	}
-	return candidates, nil
+	bondTrie, err := snap.ensureTrie(bondPrefix)
+	if err != nil {
+		return err
+	}
+	key := append(candidateAddr.Bytes(), delegatorAddr.Bytes()...)
+	if bond.Sub(bond, amount).Sign() == 0 {
+		return bondTrie.TryDelete(key)
+	}
+	return bondTrie.TryUpdate(key, bond.Bytes())
 }
 
-func (snap *Snapshot) RandCandidates(seed int64, n int) (SortableAddresses, error) {
-	if n <= 0 {
+// GetBond returns the amount delegator locked for candidate, zero if none.
+func (snap *Snapshot) GetBond(candidateAddr, delegatorAddr common.Address) (*big.Int, error) {
+	if snap.bondTrie == nil && snap.root.BondHash == (common.Hash{}) {
+		return big.NewInt(0), nil
+	}
+	bondTrie, err := snap.ensureTrie(bondPrefix)
+	if err != nil {
+		return nil, err
+	}
+	data, err := bondTrie.TryGet(append(candidateAddr.Bytes(), delegatorAddr.Bytes()...))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// GetBonds returns the amounts locked for candidate ordered by delegator.
+func (snap *Snapshot) GetBonds(candidateAddr common.Address) ([]Bond, error) {
+	if snap.bondTrie == nil && snap.root.BondHash == (common.Hash{}) {
 		return nil, nil
 	}
+	bondTrie, err := snap.ensureTrie(bondPrefix)
+	if err != nil {
+		return nil, err
+	}
 
-	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+	var bonds []Bond
+	iter := trie.NewIterator(bondTrie.PrefixIterator(candidateAddr.Bytes()))
+	for iter.Next() {
+		bonds = append(bonds, Bond{
+			Candidate: candidateAddr,
+			Delegator: common.BytesToAddress(iter.Key[len(bondPrefix)+common.AddressLength:]),
+			Amount:    new(big.Int).SetBytes(iter.Value),
+		})
+	}
+	return bonds, iter.Err
+}
+
+// bondedVotes returns the total amount locked for candidate.
+func (snap *Snapshot) bondedVotes(candidateAddr common.Address) (*big.Int, error) {
+	bonds, err := snap.GetBonds(candidateAddr)
+	if err != nil {
+		return nil, err
+	}
+	votes := big.NewInt(0)
+	for _, bond := range bonds {
+		votes.Add(votes, bond.Amount)
+	}
+	return votes, nil
+}
+
+// DecayVotes multiplies the weight of every vote by 100-percent percent, the
+// weight is restored once the delegator votes again.
+func (snap *Snapshot) DecayVotes(percent uint64) error {
+	if percent == 0 {
+		return nil
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	decayTrie, err := snap.ensureTrie(decayPrefix)
+	if err != nil {
+		return err
+	}
+
+	var delegators []common.Address
+	err = snap.iterate(votePrefix, func(key, value []byte) error {
+		delegators = append(delegators, common.BytesToAddress(key))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, delegator := range delegators {
+		retained, err := snap.retainedWeight(delegator)
+		if err != nil {
+			return err
+		}
+		retained = retained/100*(100-percent) + retained%100*(100-percent)/100
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, retained)
+		if err = decayTrie.TryUpdate(delegator.Bytes(), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VoteWeight returns the balance of delegator scaled by the weight its vote
+// retained since it was cast.
+func (snap *Snapshot) VoteWeight(state *state.StateDB, delegatorAddr common.Address) (*big.Int, error) {
+	retained, err := snap.retainedWeight(delegatorAddr)
 	if err != nil {
 		return nil, err
 	}
+	weight := new(big.Int).Set(state.GetBalance(delegatorAddr))
+	if retained == voteDecayUnit {
+		return weight, nil
+	}
+	weight.Mul(weight, new(big.Int).SetUint64(retained))
+	return weight.Div(weight, big.NewInt(voteDecayUnit)), nil
+}
 
-	iterCandidate := trie.NewIterator(candidateTrie.NodeIterator(nil))
-	existCandidate := iterCandidate.Next()
-	if !existCandidate {
-		return nil, nil
+// retainedWeight returns the weight of voteDecayUnit kept by the vote of
+// delegator. The decay trie isn't created unless votes decayed.
+func (snap *Snapshot) retainedWeight(delegatorAddr common.Address) (uint64, error) {
+	if snap.decayTrie == nil && snap.root.DecayHash == (common.Hash{}) {
+		return voteDecayUnit, nil
 	}
-
-	// All candidate
-	candidates := make(SortableAddresses, 0)
-	for existCandidate {
-		candidate := iterCandidate.Value
-		candidateAddr := common.BytesToAddress(candidate)
-		candidates = append(candidates, SortableAddress{candidateAddr, big.NewInt(0)})
-		existCandidate = iterCandidate.Next()
+	decayTrie, err := snap.ensureTrie(decayPrefix)
+	if err != nil {
+		return 0, err
 	}
-
-	// Shuffle candidates
-	r := rand.New(rand.NewSource(seed))
-	for i := len(candidates) -1 ; i >0; i-- {
-		j := int(r.Int31n(int32(i + 1)))
-		candidates[i], candidates[j] = candidates[j], candidates[i]
+	data, err := decayTrie.TryGet(delegatorAddr.Bytes())
+	if err != nil {
+		return 0, err
 	}
-	if len(candidates) > n {
-		candidates = candidates[:n]
+	if len(data) != 8 {
+		return voteDecayUnit, nil
 	}
-
-	//TODO test print log
-	printLog(candidates,n)
-	return candidates, nil
+	return binary.BigEndian.Uint64(data), nil
 }
 
-func printLog(candidates SortableAddresses , count int)  {
-
-	addrS := fmt.Sprintf("\n count %d | \n",count)
-	for _,addr := range candidates {
-		addrS += addr.Address.String() + "\n"
+// renewVote restores the full weight of the vote of delegator.
+func (snap *Snapshot) renewVote(delegatorAddr common.Address) error {
+	if snap.decayTrie == nil && snap.root.DecayHash == (common.Hash{}) {
+		return nil
 	}
-	log.Info("rand candidates ",addrS)
-}
-// BecomeCandidate add a new candidate.
-func (snap *Snapshot) BecomeCandidate(candidateAddr common.Address) error {
-	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+	decayTrie, err := snap.ensureTrie(decayPrefix)
 	if err != nil {
 		return err
 	}
-	candidate := candidateAddr.Bytes()
-	return candidateTrie.TryUpdate(candidate, candidate)
+	return decayTrie.TryDelete(delegatorAddr.Bytes())
 }
 
-// KickOutCandidate kick out existing candidate.
-func (snap *Snapshot) KickOutCandidate(candidateAddr common.Address) error {
-	voteTrie, err := snap.ensureTrie(votePrefix)
+// StageDelegate stage a vote for a candidate until the next epoch, the
+// candidateAddr must be candidate. A later vote of the delegator replaces it.
+func (snap *Snapshot) StageDelegate(delegatorAddr, candidateAddr common.Address) error {
+	stagedTrie, err := snap.ensureTrie(stagedPrefix)
 	if err != nil {
 		return err
 	}
 
-	delegateTrie, err := snap.ensureTrie(delegatePrefix)
+	isCandidate, err := snap.IsCandidate(candidateAddr)
 	if err != nil {
 		return err
 	}
+	if !isCandidate {
+		return errors.New("invalid candidate to delegate")
+	}
+	return stagedTrie.TryUpdate(delegatorAddr.Bytes(), candidateAddr.Bytes())
+}
 
-	candidateTrie, err := snap.ensureTrie(candidatePrefix)
+// ApplyStagedDelegates moves the staged votes into the delegate trie in
+// delegator order, votes for addresses no longer candidate are dropped.
+func (snap *Snapshot) ApplyStagedDelegates() ([]Delegate, error) {
+	if snap.stagedTrie == nil && snap.root.StagedHash == (common.Hash{}) {
+		return nil, nil
+	}
+	stagedTrie, err := snap.ensureTrie(stagedPrefix)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	candidate := candidateAddr.Bytes()
-	err = candidateTrie.TryDelete(candidate)
-	if err != nil {
-		if _, ok := err.(*trie.MissingNodeError); !ok {
-			return err
+	var staged []Delegate
+	iter := trie.NewIterator(stagedTrie.NodeIterator(nil))
+	for iter.Next() {
+		if len(iter.Key) != len(stagedPrefix)+common.AddressLength {
+			continue
 		}
+		staged = append(staged, Delegate{
+			Delegator: common.BytesToAddress(iter.Key[len(stagedPrefix):]),
+			Candidate: common.BytesToAddress(iter.Value),
+		})
 	}
-	iter := trie.NewIterator(delegateTrie.PrefixIterator(candidate))
-	for iter.Next() {
-		delegator := iter.Value
-		key := append(candidate, delegator...)
-		err = delegateTrie.TryDelete(key)
-		if err != nil {
-			if _, ok := err.(*trie.MissingNodeError); !ok {
-				return err
-			}
+	if iter.Err != nil {
+		return nil, iter.Err
+	}
+
+	applied := make([]Delegate, 0, len(staged))
+	for _, delegate := range staged {
+		if err = stagedTrie.TryDelete(delegate.Delegator.Bytes()); err != nil {
+			return nil, err
 		}
-		v, err := voteTrie.TryGet(delegator)
+		isCandidate, err := snap.IsCandidate(delegate.Candidate)
 		if err != nil {
-			if _, ok := err.(*trie.MissingNodeError); !ok {
-				return err
-			}
+			return nil, err
 		}
-		if err == nil && bytes.Equal(v, candidate) {
-			err = voteTrie.TryDelete(delegator)
-			if err != nil {
-				if _, ok := err.(*trie.MissingNodeError); !ok {
-					return err
-				}
-			}
+		if !isCandidate {
+			continue
+		}
+		if err = snap.Delegate(delegate.Delegator, delegate.Candidate); err != nil {
+			return nil, err
 		}
+		applied = append(applied, delegate)
 	}
-	return nil
+	return applied, nil
 }
 
 // Delegate vote for a candidate, the candidateAddr must be candidate.
@@ -672,6 +3057,9 @@ func (snap *Snapshot) Delegate(delegatorAddr, candidateAddr common.Address) erro
 	if err = delegateTrie.TryUpdate(append(candidate, delegator...), delegator); err != nil {
 		return err
 	}
+	if err = snap.renewVote(delegatorAddr); err != nil {
+		return err
+	}
 	return voteTrie.TryUpdate(delegator, candidate)
 }
 
@@ -712,6 +3100,9 @@ func (snap *Snapshot) UnDelegate(delegatorAddr, candidateAddr common.Address) er
 	if err = delegateTrie.TryDelete(append(candidate, delegator...)); err != nil {
 		return err
 	}
+	if err = snap.renewVote(delegatorAddr); err != nil {
+		return err
+	}
 	return voteTrie.TryDelete(delegator)
 }
 
@@ -733,7 +3124,48 @@ func (snap *Snapshot) Declare(epoch uint64, declare Declare) error {
 	if err != nil {
 		return err
 	}
-	return declareTrie.TryUpdate(key, jsb)
+	if err = declareTrie.TryUpdate(key, jsb); err != nil {
+		return err
+	}
+
+	// Index the latest epoch the declarer declared on the proposal
+	index := append(append(make([]byte, 0, len(declarer)+len(hash)), declarer...), hash...)
+	return declareTrie.TryUpdate(index, key[len(hash):len(hash)+8])
+}
+
+// SetCandidateDeclaration stores the self-description of candidate, replacing
+// the previous one. It's keyed by the candidate address only, so it never
+// collides with the declarations on proposals.
+func (snap *Snapshot) SetCandidateDeclaration(declaration CandidateDeclaration) error {
+	declareTrie, err := snap.ensureTrie(declarePrefix)
+	if err != nil {
+		return err
+	}
+
+	jsb, err := json.Marshal(declaration)
+	if err != nil {
+		return err
+	}
+	return declareTrie.TryUpdate(declaration.Candidate.Bytes(), jsb)
+}
+
+// GetCandidateDeclaration returns the self-description of candidate, nil if
+// the candidate never published one.
+func (snap *Snapshot) GetCandidateDeclaration(candidateAddr common.Address) (*CandidateDeclaration, error) {
+	declareTrie, err := snap.ensureTrie(declarePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := declareTrie.TryGet(candidateAddr.Bytes())
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var declaration CandidateDeclaration
+	if err = json.Unmarshal(data, &declaration); err != nil {
+		return nil, err
+	}
+	return &declaration, nil
 }
 
 // GetDeclarations returns all declarations in the epoch.
@@ -760,6 +3192,188 @@ func (snap *Snapshot) GetDeclarations(proposalHash common.Hash, epoch uint64) ([
 	return declarations, nil
 }
 
+// TallyProposal approve the proposal once the yes declarations of the current
+// validators in the epoch reach the quorum, the approved change is pending
+// until the next epoch. Returns whether the proposal was approved by the
+// declaration.
+func (snap *Snapshot) TallyProposal(config params.SenateConfig, epoch uint64, declare Declare) (bool, error) {
+	proposal, err := snap.GetProposal(declare.ProposalHash)
+	if err != nil || proposal.ApprovedHash != nil {
+		return false, err
+	}
+
+	validators, err := snap.GetValidators()
+	if err != nil {
+		return false, err
+	}
+	reached, err := snap.quorumReached(config, declare.ProposalHash, epoch, validators)
+	if err != nil || !reached {
+		return false, err
+	}
+
+	// Apply on top of the changes approved in the epoch
+	pending, err := snap.GetPendingChainConfig()
+	if err != nil {
+		return false, err
+	}
+	if pending == nil {
+		current, err := snap.GetChainConfig()
+		if err != nil {
+			return false, err
+		}
+		pending = &current
+	}
+	if err = proposal.applyTo(pending); err != nil {
+		return false, err
+	}
+	if _, err = snap.ApproveProposal(proposal.Hash, declare.Hash); err != nil {
+		return false, err
+	}
+	return true, snap.SetPendingChainConfig(*pending)
+}
+
+// CountDeclarations count the decisions declared by validators on the proposal
+// in the epoch.
+func (snap *Snapshot) CountDeclarations(proposalHash common.Hash, epoch uint64,
+	validators SortableAddresses) (yes int, no int, err error) {
+
+	declarations, err := snap.GetDeclarations(proposalHash, epoch)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, declaration := range declarations {
+		for _, validator := range validators {
+			if validator.Address != declaration.Declarer {
+				continue
+			}
+			if declaration.Decision {
+				yes++
+			} else {
+				no++
+			}
+			break
+		}
+	}
+	return yes, no, nil
+}
+
+// quorumReached reports whether the validators declared yes on the proposal in
+// the epoch reach the quorum, counted by head or weighed by self-stake.
+func (snap *Snapshot) quorumReached(config params.SenateConfig, proposalHash common.Hash,
+	epoch uint64, validators SortableAddresses) (bool, error) {
+
+	if !config.StakeWeightedQuorum {
+		yes, _, err := snap.CountDeclarations(proposalHash, epoch, validators)
+		if err != nil {
+			return false, err
+		}
+		return yes >= approvalQuorum(config, len(validators)), nil
+	}
+
+	declarations, err := snap.GetDeclarations(proposalHash, epoch)
+	if err != nil {
+		return false, err
+	}
+	total, yes := new(big.Int), new(big.Int)
+	for _, validator := range validators {
+		stake, err := snap.GetDeposit(validator.Address)
+		if err != nil {
+			return false, err
+		}
+		total.Add(total, stake)
+		for _, declaration := range declarations {
+			if declaration.Declarer == validator.Address && declaration.Decision {
+				yes.Add(yes, stake)
+				break
+			}
+		}
+	}
+	if yes.Sign() == 0 {
+		return false, nil
+	}
+
+	// More than 2/3 of the stake unless configured
+	if config.ProposalQuorum == 0 {
+		return new(big.Int).Mul(yes, big.NewInt(3)).Cmp(new(big.Int).Mul(total, big.NewInt(2))) > 0, nil
+	}
+	required := new(big.Int).Mul(total, new(big.Int).SetUint64(config.ProposalQuorum))
+	return new(big.Int).Mul(yes, big.NewInt(100)).Cmp(required) >= 0, nil
+}
+
+// approvalQuorum returns count of validators required to approve a proposal,
+// which is the configured percent of the validators or more than 2/3 of them.
+func approvalQuorum(config params.SenateConfig, validators int) int {
+	if config.ProposalQuorum == 0 {
+		return validators*2/3 + 1
+	}
+	quorum := (validators*int(config.ProposalQuorum) + 99) / 100
+	if quorum == 0 {
+		return 1
+	}
+	return quorum
+}
+
+// ProposalVote is a decision an address declared on a pending proposal.
+type ProposalVote struct {
+	Proposal Proposal `json:"proposal"`
+	Epoch    uint64   `json:"epoch"`
+	Decision bool     `json:"decision"`
+	Weight   *big.Int `json:"weight"` // Stake of the declarer, its deposit plus the votes for it
+}
+
+// VotesByAddress returns the latest decision of the address on every proposal
+// which has not been approved yet, ordered by proposal hash.
+func (snap *Snapshot) VotesByAddress(state *state.StateDB, addr common.Address) ([]ProposalVote, error) {
+	declareTrie, err := snap.ensureTrie(declarePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	weight, err := snap.GetDeposit(addr)
+	if err != nil {
+		return nil, err
+	}
+	if isCandidate, err := snap.IsCandidate(addr); err != nil {
+		return nil, err
+	} else if isCandidate {
+		votes, err := snap.CountVotes(state, addr)
+		if err != nil {
+			return nil, err
+		}
+		weight.Add(weight, votes)
+	}
+
+	var votes []ProposalVote
+	offset := len(declarePrefix) + common.AddressLength
+	iter := trie.NewIterator(declareTrie.PrefixIterator(addr.Bytes()))
+	for iter.Next() {
+		if len(iter.Key) != offset+common.HashLength {
+			continue
+		}
+		proposal, err := snap.GetProposal(common.BytesToHash(iter.Key[offset:]))
+		if err != nil || proposal.ApprovedHash != nil {
+			continue
+		}
+
+		key := append(append(append([]byte{}, iter.Key[offset:]...), iter.Value...), addr.Bytes()...)
+		data, err := declareTrie.TryGet(key)
+		if err != nil {
+			return nil, err
+		}
+		var declare Declare
+		if err = json.Unmarshal(data, &declare); err != nil {
+			continue
+		}
+		votes = append(votes, ProposalVote{
+			Proposal: proposal,
+			Epoch:    binary.BigEndian.Uint64(iter.Value),
+			Decision: declare.Decision,
+			Weight:   new(big.Int).Set(weight),
+		})
+	}
+	return votes, iter.Err
+}
+
 // GetProposal returns the specified proposal
 // the hash is transaction hash of proposal.
 func (snap *Snapshot) GetProposal(hash common.Hash) (Proposal, error) {
@@ -770,7 +3384,7 @@ func (snap *Snapshot) GetProposal(hash common.Hash) (Proposal, error) {
 
 	data := proposalTrie.Get(hash.Bytes())
 	if data == nil {
-		return Proposal{}, errors.New("proposal not found, hash: " + hash.String())
+		return Proposal{}, ErrUnknownProposal
 	}
 
 	var proposal Proposal
@@ -780,6 +3394,25 @@ func (snap *Snapshot) GetProposal(hash common.Hash) (Proposal, error) {
 	return proposal, nil
 }
 
+// GetProposals returns all proposals in snapshot.
+func (snap *Snapshot) GetProposals() ([]Proposal, error) {
+	proposalTrie, err := snap.ensureTrie(proposalPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var proposals []Proposal
+	iter := trie.NewIterator(proposalTrie.NodeIterator(nil))
+	for iter.Next() {
+		var proposal Proposal
+		if err = json.Unmarshal(iter.Value, &proposal); err != nil {
+			return nil, err
+		}
+		proposals = append(proposals, proposal)
+	}
+	return proposals, iter.Err
+}
+
 // SubmitProposal submit a new proposal.
 func (snap *Snapshot) SubmitProposal(proposal Proposal) error {
 	proposalTrie, err := snap.ensureTrie(proposalPrefix)
@@ -794,6 +3427,27 @@ func (snap *Snapshot) SubmitProposal(proposal Proposal) error {
 	return proposalTrie.TryUpdate(proposal.Hash.Bytes(), value)
 }
 
+// ExpireProposals removes the proposals which are not approved before the
+// epoch they expire, returns hashes of the removed proposals.
+func (snap *Snapshot) ExpireProposals(epoch uint64) ([]common.Hash, error) {
+	proposals, err := snap.GetProposals()
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []common.Hash
+	for _, proposal := range proposals {
+		if proposal.ApprovedHash != nil || proposal.ExpireEpoch == 0 || proposal.ExpireEpoch > epoch {
+			continue
+		}
+		if err = snap.proposalTrie.TryDelete(proposal.Hash.Bytes()); err != nil {
+			return nil, err
+		}
+		expired = append(expired, proposal.Hash)
+	}
+	return expired, nil
+}
+
 // ApproveProposal approve the proposal
 // the hash is transaction hash of proposal, txHash is transaction hash of declare.
 func (snap *Snapshot) ApproveProposal(hash, txHash common.Hash) (Proposal, error) {
@@ -808,3 +3462,145 @@ func (snap *Snapshot) ApproveProposal(hash, txHash common.Hash) (Proposal, error
 	}
 	return proposal, nil
 }
+
+// GetCustomNonce returns the lowest nonce a custom transaction of sender may
+// have to be applied. The nonce trie isn't created unless custom nonces are
+// tracked.
+func (snap *Snapshot) GetCustomNonce(sender common.Address) (uint64, error) {
+	if snap.nonceTrie == nil && snap.root.NonceHash == (common.Hash{}) {
+		return 0, nil
+	}
+	nonceTrie, err := snap.ensureTrie(noncePrefix)
+	if err != nil {
+		return 0, err
+	}
+	data, err := nonceTrie.TryGet(sender.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 8 {
+		return 0, nil
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// SetCustomNonce sets the lowest nonce a later custom transaction of sender
+// may have to be applied.
+func (snap *Snapshot) SetCustomNonce(sender common.Address, nonce uint64) error {
+	nonceTrie, err := snap.ensureTrie(noncePrefix)
+	if err != nil {
+		return err
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, nonce)
+	return nonceTrie.TryUpdate(sender.Bytes(), value)
+}
+
+// EpochStats are the aggregates of an epoch, accumulated block by block.
+type EpochStats struct {
+	Epoch         uint64   `json:"epoch"`
+	Validators    uint64   `json:"validators"`    // Count of validators elected for the epoch
+	TotalStake    *big.Int `json:"total_stake"`   // Self-stake deposited by the validators at the election
+	Blocks        uint64   `json:"blocks"`        // Count of blocks minted in the epoch
+	Rewards       *big.Int `json:"rewards"`       // Rewards of the blocks minted in the epoch
+	Participation uint64   `json:"participation"` // Percent of validators which minted a block, set once the epoch ended
+}
+
+// GetEpochStats returns the aggregates of epoch, nil if they aren't recorded.
+// The stats trie isn't created unless epoch stats are enabled.
+func (snap *Snapshot) GetEpochStats(epoch uint64) (*EpochStats, error) {
+	if snap.statsTrie == nil && snap.root.StatsHash == (common.Hash{}) {
+		return nil, nil
+	}
+	statsTrie, err := snap.ensureTrie(statsPrefix)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, epoch)
+	data, err := statsTrie.TryGet(key)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var stats EpochStats
+	if err = json.Unmarshal(data, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// setEpochStats stores the aggregates of an epoch.
+func (snap *Snapshot) setEpochStats(stats EpochStats) error {
+	statsTrie, err := snap.ensureTrie(statsPrefix)
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, stats.Epoch)
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return statsTrie.TryUpdate(key, data)
+}
+
+// AccumulateEpochStats adds the block to the aggregates of its epoch, it must
+// be called once the snapshot holds all the changes of the block. The first
+// block of an epoch records the elected validators and their stake, and sets
+// the participation of the last epoch.
+func (snap *Snapshot) AccumulateEpochStats(config params.SenateConfig, header *types.Header, epoch uint64) error {
+	if !config.EpochStats {
+		return nil
+	}
+	stats, err := snap.GetEpochStats(epoch)
+	if err != nil {
+		return err
+	}
+	if stats == nil {
+		if epoch > 0 {
+			if err = snap.closeEpochStats(epoch - 1); err != nil {
+				return err
+			}
+		}
+		validators, err := snap.GetValidators()
+		if err != nil {
+			return err
+		}
+		stake := new(big.Int)
+		for _, validator := range validators {
+			deposit, err := snap.GetDeposit(validator.Address)
+			if err != nil {
+				return err
+			}
+			stake.Add(stake, deposit)
+		}
+		stats = &EpochStats{
+			Epoch:      epoch,
+			Validators: uint64(len(validators)),
+			TotalStake: stake,
+			Rewards:    new(big.Int),
+		}
+	}
+	stats.Blocks++
+	if reward := blockReward(config, header.Number.Uint64()); reward != nil {
+		stats.Rewards.Add(stats.Rewards, reward)
+	}
+	return snap.setEpochStats(*stats)
+}
+
+// closeEpochStats sets the participation of the ended epoch, if recorded.
+func (snap *Snapshot) closeEpochStats(epoch uint64) error {
+	stats, err := snap.GetEpochStats(epoch)
+	if err != nil || stats == nil || stats.Validators == 0 {
+		return err
+	}
+	minted, err := snap.MintCounts(epoch)
+	if err != nil {
+		return err
+	}
+	stats.Participation = uint64(len(minted)) * 100 / stats.Validators
+	if stats.Participation > 100 {
+		stats.Participation = 100
+	}
+	return snap.setEpochStats(*stats)
+}
@@ -1,12 +1,15 @@
 package senate
 
 import (
+	"encoding/json"
+	"errors"
 	"math/big"
 	"testing"
 
 	"github.com/SecretBlockChain/go-secret/common"
 	"github.com/SecretBlockChain/go-secret/core/rawdb"
 	"github.com/SecretBlockChain/go-secret/core/state"
+	"github.com/SecretBlockChain/go-secret/core/types"
 	"github.com/SecretBlockChain/go-secret/params"
 	"github.com/stretchr/testify/assert"
 )
@@ -124,6 +127,183 @@ func TestTopCandidates(t *testing.T) {
 	assert.Equal(t, addresses[2].Address, candidate1)
 }
 
+func TestWeightedCandidates(t *testing.T) {
+	build := func() (*Snapshot, *state.StateDB) {
+		db := rawdb.NewMemoryDatabase()
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+		assert.Nil(t, err)
+		snap, err := newSnapshot(db)
+		assert.Nil(t, err)
+		for i, stake := range []int64{1000, 10, 500, 0, 200, 50} {
+			candidate := common.BigToAddress(big.NewInt(int64(i + 1)))
+			delegator := common.BigToAddress(big.NewInt(int64(i + 100)))
+			assert.Nil(t, snap.BecomeCandidate(candidate))
+			assert.Nil(t, snap.Delegate(delegator, candidate))
+			statedb.SetBalance(delegator, big.NewInt(stake))
+		}
+		assert.Nil(t, snap.SetDeposit(common.BigToAddress(big.NewInt(2)), big.NewInt(90)))
+		return snap, statedb
+	}
+
+	address := func(n int64) common.Address { return common.BigToAddress(big.NewInt(n)) }
+	snap, statedb := build()
+	elected, err := snap.WeightedCandidates(statedb, 42, 3, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, SortableAddresses{
+		{Address: address(1), Weight: big.NewInt(1000)},
+		{Address: address(3), Weight: big.NewInt(500)},
+		{Address: address(6), Weight: big.NewInt(50)},
+	}, elected)
+
+	// Candidates without stake are elected last
+	elected, err = snap.WeightedCandidates(statedb, 42, 10, nil)
+	assert.Nil(t, err)
+	assert.Len(t, elected, 6)
+	assert.Equal(t, address(4), elected[5].Address)
+
+	// Two nodes elect the same validators and get the same root
+	config := params.SenateConfig{Period: 5, Epoch: 10, MaxValidatorsCount: 3, WeightedElection: true}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	header := &types.Header{Number: big.NewInt(1), Time: 200, ParentHash: common.HexToHash("0x01")}
+	var roots []Root
+	var validators []SortableAddresses
+	for i := 0; i < 2; i++ {
+		snap, statedb := build()
+		headerExtra := HeaderExtra{Epoch: 2, EpochTime: 200}
+		assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+		root, err := snap.Root()
+		assert.Nil(t, err)
+		roots = append(roots, root)
+		validators = append(validators, headerExtra.CurrentEpochValidators)
+	}
+	assert.Len(t, validators[0], 3)
+	assert.Equal(t, validators[0], validators[1])
+	assert.Equal(t, roots[0], roots[1])
+
+	// Weighted election needs the state
+	snap, _ = build()
+	assert.NotNil(t, senate.tryElect(config, nil, header, snap, &HeaderExtra{Epoch: 2, EpochTime: 200}))
+}
+
+func TestReputableCandidates(t *testing.T) {
+	reliable, unreliable := common.Address{0x01}, common.Address{0x02}
+	build := func() (*Snapshot, *state.StateDB) {
+		db := rawdb.NewMemoryDatabase()
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+		assert.Nil(t, err)
+		snap, err := newSnapshot(db)
+		assert.Nil(t, err)
+		for _, candidate := range []common.Address{reliable, unreliable} {
+			assert.Nil(t, snap.BecomeCandidate(candidate))
+			assert.Nil(t, snap.Delegate(candidate, candidate))
+		}
+		statedb.SetBalance(reliable, big.NewInt(100))
+		statedb.SetBalance(unreliable, big.NewInt(110))
+
+		// The unreliable validator minted 2 of 8 blocks of epoch 1
+		for number := uint64(1); number <= 8; number++ {
+			validator := reliable
+			if number%4 == 0 {
+				validator = unreliable
+			}
+			assert.Nil(t, snap.MintBlock(1, number, validator))
+		}
+		return snap, statedb
+	}
+
+	snap, statedb := build()
+	reputations, err := snap.Reputations(2, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, map[common.Address]uint64{reliable: 100, unreliable: 50}, reputations)
+
+	// A slightly lower stake outranks an unreliable validator
+	elected, err := snap.ReputableCandidates(statedb, 1, nil, reputations, 50)
+	assert.Nil(t, err)
+	assert.Equal(t, SortableAddresses{{Address: reliable, Weight: big.NewInt(100)}}, elected)
+
+	// The stake alone ranks candidates without weight
+	elected, err = snap.ReputableCandidates(statedb, 2, nil, reputations, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, SortableAddresses{
+		{Address: unreliable, Weight: big.NewInt(110)},
+		{Address: reliable, Weight: big.NewInt(100)},
+	}, elected)
+
+	// Epochs out of the window don't count
+	reputations, err = snap.Reputations(3, 1)
+	assert.Nil(t, err)
+	assert.Empty(t, reputations)
+
+	// Two nodes elect the same validators and get the same root
+	config := params.SenateConfig{Period: 5, Epoch: 10, MaxValidatorsCount: 1, ReputationWeight: 50}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	header := &types.Header{Number: big.NewInt(1), Time: 200, ParentHash: common.HexToHash("0x01")}
+	var roots []Root
+	for i := 0; i < 2; i++ {
+		snap, statedb := build()
+		headerExtra := HeaderExtra{Epoch: 2, EpochTime: 200}
+		assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+		if assert.Len(t, headerExtra.CurrentEpochValidators, 1) {
+			assert.Equal(t, reliable, headerExtra.CurrentEpochValidators[0].Address)
+		}
+		root, err := snap.Root()
+		assert.Nil(t, err)
+		roots = append(roots, root)
+	}
+	assert.Equal(t, roots[0], roots[1])
+}
+
+func TestElectEqualStakes(t *testing.T) {
+	address := func(n int64) common.Address { return common.BigToAddress(big.NewInt(n)) }
+	build := func(order []int64) (*Snapshot, *state.StateDB) {
+		db := rawdb.NewMemoryDatabase()
+		statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+		assert.Nil(t, err)
+		snap, err := newSnapshot(db)
+		assert.Nil(t, err)
+		for _, n := range order {
+			delegator := address(n + 100)
+			assert.Nil(t, snap.BecomeCandidate(address(n)))
+			assert.Nil(t, snap.Delegate(delegator, address(n)))
+			statedb.SetBalance(delegator, big.NewInt(500))
+		}
+		return snap, statedb
+	}
+
+	// Equal votes are ranked by address whatever the registration order
+	orders := [][]int64{{1, 2, 3, 4, 5}, {5, 4, 3, 2, 1}, {3, 1, 5, 2, 4}}
+	for _, order := range orders {
+		snap, statedb := build(order)
+		for i := 0; i < 3; i++ {
+			top, err := snap.TopCandidates(statedb, 3)
+			assert.Nil(t, err)
+			assert.Equal(t, SortableAddresses{
+				{Address: address(1), Weight: big.NewInt(500)},
+				{Address: address(2), Weight: big.NewInt(500)},
+				{Address: address(3), Weight: big.NewInt(500)},
+			}, top)
+		}
+	}
+
+	// The elected set of equal stakes is reproducible
+	config := params.SenateConfig{Period: 5, Epoch: 10, MaxValidatorsCount: 3, WeightedElection: true}
+	senate := New(&config, nil, rawdb.NewMemoryDatabase())
+	header := &types.Header{Number: big.NewInt(1), Time: 200, ParentHash: common.HexToHash("0x01")}
+	var expected SortableAddresses
+	for i := 0; i < 3; i++ {
+		for _, order := range orders {
+			snap, statedb := build(order)
+			headerExtra := HeaderExtra{Epoch: 2, EpochTime: 200}
+			assert.Nil(t, senate.tryElect(config, statedb, header, snap, &headerExtra))
+			assert.Len(t, headerExtra.CurrentEpochValidators, 3)
+			if expected == nil {
+				expected = headerExtra.CurrentEpochValidators
+			}
+			assert.Equal(t, expected, headerExtra.CurrentEpochValidators)
+		}
+	}
+}
+
 func TestKickOutCandidate(t *testing.T) {
 	db := rawdb.NewMemoryDatabase()
 	snap, err := newSnapshot(db)
@@ -251,3 +431,397 @@ func TestDeclare(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, len(declarations), 3)
 }
+
+func TestTallyProposalQuorum(t *testing.T) {
+	validators := make(SortableAddresses, 5)
+	for i := range validators {
+		validators[i] = SortableAddress{Address: common.BigToAddress(big.NewInt(int64(i + 1))), Weight: big.NewInt(0)}
+	}
+	proposal := Proposal{Key: ProposalPeriodChange, Value: "10", Hash: common.HexToHash("0x01")}
+
+	// Returns whether the proposal is approved once the first yes validators
+	// declared yes and the rest no
+	tally := func(config params.SenateConfig, yes int, stakes ...int64) bool {
+		snap, err := newSnapshot(rawdb.NewMemoryDatabase())
+		assert.Nil(t, err)
+		assert.Nil(t, snap.SetChainConfig(params.SenateConfig{Period: 5, Epoch: 20}))
+		assert.Nil(t, snap.SetValidators(validators))
+		assert.Nil(t, snap.SubmitProposal(proposal))
+		for i, stake := range stakes {
+			assert.Nil(t, snap.SetDeposit(validators[i].Address, big.NewInt(stake)))
+		}
+
+		var approved bool
+		for i, validator := range validators {
+			declare := Declare{
+				Hash:         common.BigToHash(big.NewInt(int64(i + 100))),
+				ProposalHash: proposal.Hash,
+				Declarer:     validator.Address,
+				Decision:     i < yes,
+			}
+			assert.Nil(t, snap.Declare(1, declare))
+			ok, err := snap.TallyProposal(config, 1, declare)
+			assert.Nil(t, err)
+			approved = approved || ok
+		}
+		return approved
+	}
+
+	// A simple majority isn't more than 2/3 of the validators
+	assert.False(t, tally(params.SenateConfig{}, 3))
+	assert.True(t, tally(params.SenateConfig{}, 4))
+	assert.True(t, tally(params.SenateConfig{ProposalQuorum: 50}, 3))
+
+	// A higher quorum rejects a majority approving by default
+	assert.False(t, tally(params.SenateConfig{ProposalQuorum: 90}, 4))
+	assert.True(t, tally(params.SenateConfig{ProposalQuorum: 90}, 5))
+
+	// Weighed by stake, the majority of validators may hold too little stake
+	stakes := []int64{10, 10, 10, 60, 10}
+	assert.False(t, tally(params.SenateConfig{StakeWeightedQuorum: true}, 3, stakes...))
+	assert.True(t, tally(params.SenateConfig{StakeWeightedQuorum: true}, 4, stakes...))
+	assert.True(t, tally(params.SenateConfig{StakeWeightedQuorum: true, ProposalQuorum: 90}, 4, stakes...))
+	assert.False(t, tally(params.SenateConfig{StakeWeightedQuorum: true, ProposalQuorum: 95}, 4, stakes...))
+
+	// Nothing is approved without any stake
+	assert.False(t, tally(params.SenateConfig{StakeWeightedQuorum: true}, 5))
+}
+
+func TestExpireProposals(t *testing.T) {
+	build := func() *Snapshot {
+		snap, err := newSnapshot(rawdb.NewMemoryDatabase())
+		assert.Nil(t, err)
+		proposals := []Proposal{
+			{Key: ProposalPeriodChange, Value: "8", Hash: common.HexToHash("0x01"), ExpireEpoch: 2},
+			{Key: ProposalPeriodChange, Value: "9", Hash: common.HexToHash("0x02")},
+			{Key: ProposalPeriodChange, Value: "10", Hash: common.HexToHash("0x03"), ExpireEpoch: 2},
+		}
+		for _, proposal := range proposals {
+			assert.Nil(t, snap.SubmitProposal(proposal))
+		}
+		_, err = snap.ApproveProposal(common.HexToHash("0x03"), common.HexToHash("0x04"))
+		assert.Nil(t, err)
+		return snap
+	}
+
+	snap := build()
+	root, err := snap.Root()
+	assert.Nil(t, err)
+
+	// Nothing expires before the deadline
+	expired, err := snap.ExpireProposals(1)
+	assert.Nil(t, err)
+	assert.Empty(t, expired)
+	unchanged, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, root, unchanged)
+
+	// Only the unapproved proposal with a deadline is removed
+	expired, err = snap.ExpireProposals(2)
+	assert.Nil(t, err)
+	assert.Equal(t, []common.Hash{common.HexToHash("0x01")}, expired)
+	_, err = snap.GetProposal(common.HexToHash("0x01"))
+	assert.Equal(t, ErrUnknownProposal, err)
+	proposals, err := snap.GetProposals()
+	assert.Nil(t, err)
+	assert.Len(t, proposals, 2)
+
+	changed, err := snap.Root()
+	assert.Nil(t, err)
+	assert.NotEqual(t, root.ProposalHash, changed.ProposalHash)
+
+	// The resulting root is the same on every node
+	other := build()
+	_, err = other.ExpireProposals(3)
+	assert.Nil(t, err)
+	otherRoot, err := other.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, changed, otherRoot)
+}
+
+func TestVotesByAddress(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	assert.Nil(t, err)
+
+	// Validators elected at random carry no weight, the stake of the voter
+	// is its deposit plus the votes for it
+	voter := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	other := common.HexToAddress("0x10702d5b794d97fb720e02506ecfdb1186a804b1")
+	delegator := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	assert.Nil(t, snap.SetValidators(SortableAddresses{
+		SortableAddress{Address: voter, Weight: big.NewInt(0)},
+		SortableAddress{Address: other, Weight: big.NewInt(0)},
+	}))
+	assert.Nil(t, snap.BecomeCandidate(voter))
+	assert.Nil(t, snap.SetDeposit(voter, big.NewInt(30)))
+	assert.Nil(t, snap.Delegate(delegator, voter))
+	statedb.AddBalance(delegator, big.NewInt(12))
+
+	proposal1 := Proposal{Key: "period", Value: "8", Hash: common.HexToHash("0x01"), Proposer: other}
+	proposal2 := Proposal{Key: "epoch", Value: "86400", Hash: common.HexToHash("0x02"), Proposer: other}
+	proposal3 := Proposal{Key: "maxValidatorsCount", Value: "21", Hash: common.HexToHash("0x03"), Proposer: other}
+	approved := Proposal{Key: "period", Value: "6", Hash: common.HexToHash("0x04"), Proposer: other}
+	for _, proposal := range []Proposal{proposal1, proposal2, proposal3, approved} {
+		assert.Nil(t, snap.SubmitProposal(proposal))
+	}
+	_, err = snap.ApproveProposal(approved.Hash, common.HexToHash("0xff"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, snap.Declare(1, Declare{ProposalHash: proposal1.Hash, Declarer: voter, Decision: true}))
+	assert.Nil(t, snap.Declare(1, Declare{ProposalHash: proposal2.Hash, Declarer: voter, Decision: true}))
+	assert.Nil(t, snap.Declare(2, Declare{ProposalHash: proposal2.Hash, Declarer: voter, Decision: false}))
+	assert.Nil(t, snap.Declare(1, Declare{ProposalHash: proposal3.Hash, Declarer: other, Decision: true}))
+	assert.Nil(t, snap.Declare(1, Declare{ProposalHash: approved.Hash, Declarer: voter, Decision: true}))
+
+	votes, err := snap.VotesByAddress(statedb, voter)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(votes))
+
+	decisions := make(map[common.Hash]ProposalVote)
+	for _, vote := range votes {
+		decisions[vote.Proposal.Hash] = vote
+	}
+	assert.True(t, decisions[proposal1.Hash].Decision)
+	assert.Equal(t, uint64(1), decisions[proposal1.Hash].Epoch)
+	assert.False(t, decisions[proposal2.Hash].Decision)
+	assert.Equal(t, uint64(2), decisions[proposal2.Hash].Epoch)
+	assert.Equal(t, big.NewInt(42), decisions[proposal1.Hash].Weight)
+
+	votes, err = snap.VotesByAddress(statedb, other)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(votes))
+	assert.Equal(t, proposal3.Hash, votes[0].Proposal.Hash)
+	assert.Equal(t, 0, votes[0].Weight.Sign())
+
+	// The index survives a commit, and the declarations still dump by proposal
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+	snap, err = loadSnapshot(db, root)
+	assert.Nil(t, err)
+	votes, err = snap.VotesByAddress(statedb, voter)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(votes))
+	dump, err := snap.Dump()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(dump.Declares[proposal2.Hash]))
+}
+
+func TestSnapshotCopy(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	validator := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	assert.Nil(t, snap.BecomeCandidate(validator))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+
+	// Changes to the copy are invisible to the original
+	cpy := snap.copy()
+	assert.Nil(t, cpy.KickOutCandidate(validator))
+	assert.Nil(t, cpy.MintBlock(1, 1, validator))
+	isCandidate, err := snap.IsCandidate(validator)
+	assert.Nil(t, err)
+	assert.True(t, isCandidate)
+	unchanged, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, root, unchanged)
+
+	changed, err := cpy.Root()
+	assert.Nil(t, err)
+	assert.NotEqual(t, root, changed)
+}
+
+func TestSnapshotMarshalJSON(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+
+	data, err := json.Marshal(snap)
+	assert.Nil(t, err)
+	var empty map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &empty))
+	assert.Equal(t, []interface{}{}, empty["validators"])
+	assert.Equal(t, []interface{}{}, empty["candidates"])
+
+	candidate := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	delegator := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	assert.Nil(t, snap.BecomeCandidate(candidate))
+	assert.Nil(t, snap.Delegate(delegator, candidate))
+	assert.Nil(t, snap.SetValidators(SortableAddresses{{Address: candidate, Weight: big.NewInt(1)}}))
+
+	data, err = json.Marshal(snap)
+	assert.Nil(t, err)
+	var result struct {
+		Validators SortableAddresses                   `json:"validators"`
+		Candidates []common.Address                    `json:"candidates"`
+		Votes      map[common.Address]common.Address   `json:"votes"`
+		Delegates  map[common.Address][]common.Address `json:"delegates"`
+	}
+	assert.Nil(t, json.Unmarshal(data, &result))
+	assert.Equal(t, SortableAddresses{{Address: candidate, Weight: big.NewInt(1)}}, result.Validators)
+	assert.Equal(t, []common.Address{candidate}, result.Candidates)
+	assert.Equal(t, map[common.Address]common.Address{delegator: candidate}, result.Votes)
+	assert.Equal(t, map[common.Address][]common.Address{candidate: {delegator}}, result.Delegates)
+}
+
+func TestSnapshotDump(t *testing.T) {
+	candidate := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	delegator := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+	signer := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	deposit, _ := new(big.Int).SetString("1000000000000000000000000000001", 10)
+	proposal := Proposal{Key: ProposalPeriodChange, Value: "10", Hash: common.HexToHash("0x01"), Proposer: candidate}
+
+	// Build the same snapshot on two nodes
+	dumps := make([][]byte, 0, 2)
+	for i := 0; i < 2; i++ {
+		db := rawdb.NewMemoryDatabase()
+		snap, err := newSnapshot(db)
+		assert.Nil(t, err)
+		assert.Nil(t, snap.BecomeCandidate(candidate))
+		assert.Nil(t, snap.Delegate(delegator, candidate))
+		assert.Nil(t, snap.SetValidators(SortableAddresses{{Address: candidate, Weight: big.NewInt(1)}}))
+		assert.Nil(t, snap.SetDeposit(candidate, deposit))
+		assert.Nil(t, snap.RotateKey(candidate, signer))
+		assert.Nil(t, snap.MintBlock(1, 2, candidate))
+		assert.Nil(t, snap.Slash(1, candidate, deposit))
+		assert.Nil(t, snap.Unbond(delegator, big.NewInt(5), 100))
+		assert.Nil(t, snap.SubmitProposal(proposal))
+		assert.Nil(t, snap.Declare(1, Declare{Hash: common.HexToHash("0x02"), ProposalHash: proposal.Hash, Declarer: candidate, Decision: true}))
+		root, err := snap.Root()
+		assert.Nil(t, err)
+		assert.Nil(t, snap.Commit(root))
+
+		config := params.SenateConfig{Period: 5, Epoch: 10}
+		data, err := New(&config, nil, db).DumpSnapshot(root)
+		assert.Nil(t, err)
+		dumps = append(dumps, data)
+
+		// Dumping doesn't change the snapshot
+		dump, err := snap.Dump()
+		assert.Nil(t, err)
+		assert.Equal(t, root, dump.Root)
+		unchanged, err := snap.Root()
+		assert.Nil(t, err)
+		assert.Equal(t, root, unchanged)
+	}
+	assert.Equal(t, string(dumps[0]), string(dumps[1]))
+
+	var result struct {
+		Epoch struct {
+			Validators []struct {
+				Address common.Address `json:"address"`
+				Weight  string         `json:"weight"`
+			} `json:"validators"`
+		} `json:"epoch"`
+		Votes    map[common.Address]common.Address    `json:"votes"`
+		MintCnt  map[string]map[string]common.Address `json:"mintcnt"`
+		Deposits map[common.Address]string            `json:"deposits"`
+		Signers  map[common.Address]common.Address    `json:"signers"`
+		Slashes  map[string]map[common.Address]string `json:"slashes"`
+		Unbonds  map[string]map[common.Address]string `json:"unbonds"`
+		Declares map[common.Hash]map[string][]Declare `json:"declares"`
+	}
+	assert.Nil(t, json.Unmarshal(dumps[0], &result))
+	assert.Equal(t, 1, len(result.Epoch.Validators))
+	assert.Equal(t, "1", result.Epoch.Validators[0].Weight)
+	assert.Equal(t, map[common.Address]common.Address{delegator: candidate}, result.Votes)
+	assert.Equal(t, candidate, result.MintCnt["1"]["2"])
+	assert.Equal(t, deposit.String(), result.Deposits[candidate])
+	assert.Equal(t, map[common.Address]common.Address{candidate: signer}, result.Signers)
+	assert.Equal(t, deposit.String(), result.Slashes["1"][candidate])
+	assert.Equal(t, "5", result.Unbonds["100"][delegator])
+	assert.Equal(t, 1, len(result.Declares[proposal.Hash]["1"]))
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	candidate1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	candidate2 := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	delegator := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+
+	// Two nodes agree on everything but the candidate voted by the delegator
+	db := rawdb.NewMemoryDatabase()
+	roots := make([]Root, 0, 2)
+	for _, candidate := range []common.Address{candidate1, candidate2} {
+		snap, err := newSnapshot(db)
+		assert.Nil(t, err)
+		assert.Nil(t, snap.BecomeCandidate(candidate1))
+		assert.Nil(t, snap.BecomeCandidate(candidate2))
+		assert.Nil(t, snap.Delegate(delegator, candidate))
+		root, err := snap.Root()
+		assert.Nil(t, err)
+		assert.Nil(t, snap.Commit(root))
+		roots = append(roots, root)
+	}
+
+	config := params.SenateConfig{Period: 5, Epoch: 10}
+	senate := New(&config, nil, db)
+	diff, err := senate.DiffSnapshots(roots[0], roots[0])
+	assert.Nil(t, err)
+	assert.True(t, diff.Empty())
+
+	diff, err = senate.DiffSnapshots(roots[0], roots[1])
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(diff.Tries))
+	assert.Equal(t, &TrieDiff{
+		Added:   []DiffEntry{},
+		Removed: []DiffEntry{},
+		Changed: []DiffEntry{{Key: delegator.Bytes(), A: candidate1.Bytes(), B: candidate2.Bytes()}},
+	}, diff.Tries["vote"])
+	assert.Equal(t, &TrieDiff{
+		Added:   []DiffEntry{{Key: append(candidate2.Bytes(), delegator.Bytes()...), B: delegator.Bytes()}},
+		Removed: []DiffEntry{{Key: append(candidate1.Bytes(), delegator.Bytes()...), A: delegator.Bytes()}},
+		Changed: []DiffEntry{},
+	}, diff.Tries["delegate"])
+
+	// The diff is serializable for operators to compare
+	data, err := json.Marshal(diff)
+	assert.Nil(t, err)
+	var decoded SnapshotDiff
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, *diff, decoded)
+}
+
+func TestVerifyRoot(t *testing.T) {
+	candidate1 := common.HexToAddress("0x44d1ce0b7cb3588bca96151fe1bc05af38f91b6c")
+	candidate2 := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	delegator := common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")
+
+	db := rawdb.NewMemoryDatabase()
+	snap, err := newSnapshot(db)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.BecomeCandidate(candidate1))
+	assert.Nil(t, snap.Delegate(delegator, candidate1))
+	root, err := snap.Root()
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Commit(root))
+	assert.Nil(t, snap.verifyRoot(root))
+
+	// A corrupted hash is reported with its trie
+	corrupted := root
+	corrupted.VoteHash = common.HexToHash("0x01")
+	err = snap.verifyRoot(corrupted)
+	assert.True(t, errors.Is(err, errInvalidTrieRoot))
+	assert.Contains(t, err.Error(), "vote trie")
+
+	// So is a trie whose contents diverged from the header
+	snap, err = loadSnapshot(db, root)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.BecomeCandidate(candidate2))
+	err = snap.verifyRoot(root)
+	assert.True(t, errors.Is(err, errInvalidTrieRoot))
+	assert.Contains(t, err.Error(), "candidate trie")
+
+	// Verifying leaves the snapshot untouched
+	unchanged, err := loadSnapshot(db, root)
+	assert.Nil(t, err)
+	assert.Nil(t, unchanged.verifyRoot(root))
+	have, err := unchanged.Root()
+	assert.Nil(t, err)
+	assert.Equal(t, root, have)
+}
@@ -1,6 +1,8 @@
 package senate
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"math/big"
 	"reflect"
@@ -8,7 +10,9 @@ import (
 	"strings"
 
 	"github.com/SecretBlockChain/go-secret/common"
+	"github.com/SecretBlockChain/go-secret/common/hexutil"
 	"github.com/SecretBlockChain/go-secret/core/types"
+	"github.com/SecretBlockChain/go-secret/crypto"
 	"github.com/SecretBlockChain/go-secret/params"
 )
 
@@ -31,7 +35,14 @@ var (
 		new(Declare),
 		new(Proposal),
 		new(EventDelegate),
+		new(EventBatchDelegate),
+		new(EventUnbond),
 		new(EventBecomeCandidate),
+		new(EventCancelCandidate),
+		new(EventRotateKey),
+		new(EventWithdrawReward),
+		new(EventDeclareCandidate),
+		new(EventSetRewardAddress),
 	}
 	prototypeMapper = map[TransactionType][]Transaction{}
 )
@@ -87,10 +98,14 @@ func NewTransaction(tx *types.Transaction) (Transaction, error) {
 
 // EventDelegate delegate rights to Candidate.
 // data like "senate:1:event:delegate"
-// Sender of tx is Delegator, the tx.to is Candidate
+// data like "senate:1:event:delegate:0xde0b6b3a7640000"
+// Sender of tx is Delegator, the tx.to is Candidate. If the chain locks
+// delegations, the optional amount, or else the whole balance, is bonded for
+// Candidate, otherwise the balance backing the vote stays free to transfer
 type EventDelegate struct {
 	Delegator common.Address
 	Candidate common.Address
+	Amount    *big.Int
 }
 
 func (event *EventDelegate) Type() TransactionType {
@@ -106,6 +121,111 @@ func (event *EventDelegate) Decode(tx *types.Transaction, data []byte) error {
 		return errors.New("missing candidate")
 	}
 
+	txSender, err := types.Sender(types.NewEIP155Signer(tx.ChainId()), tx)
+	if err != nil {
+		return err
+	}
+	event.Delegator = txSender
+	event.Candidate = *tx.To()
+	if len(data) == 0 {
+		return nil
+	}
+
+	value := string(data)
+	if len(value) <= 2 || strings.ToLower(value[:2]) != "0x" {
+		return errors.New("invalid delegate amount")
+	}
+	amount, ok := big.NewInt(0).SetString(value[2:], 16)
+	if !ok || amount.Sign() <= 0 {
+		return errors.New("invalid delegate amount")
+	}
+	event.Amount = amount
+	return nil
+}
+
+// maxBatchDelegates is the max count of candidates a batch delegation splits
+// the amount among.
+const maxBatchDelegates = 16
+
+// EventBatchDelegate lock amounts of Delegator for several candidates at once.
+// data like "senate:1:event:batchdelegate:0x47746e8acb5dafe9c00b7195d0c2d830fcc04910:0xde0b6b3a7640000"
+// data like "senate:1:event:batchdelegate:0x4774...4910:0xde0b6b3a7640000,0x1c1f...9e52:0x1bc16d674ec80000"
+// Sender of tx is Delegator, the amounts are debited from its balance all
+// together or not at all
+type EventBatchDelegate struct {
+	Delegator common.Address
+	Bonds     []Bond
+}
+
+func (event *EventBatchDelegate) Type() TransactionType {
+	return EventTransactionType
+}
+
+func (event *EventBatchDelegate) Action() string {
+	return "batchdelegate"
+}
+
+func (event *EventBatchDelegate) Decode(tx *types.Transaction, data []byte) error {
+	txSender, err := types.Sender(types.NewEIP155Signer(tx.ChainId()), tx)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("missing batch delegates")
+	}
+
+	entries := strings.Split(string(data), ",")
+	if len(entries) > maxBatchDelegates {
+		return errors.New("too many batch delegates")
+	}
+	event.Delegator = txSender
+	event.Bonds = make([]Bond, 0, len(entries))
+	for _, entry := range entries {
+		fields := strings.Split(entry, ":")
+		if len(fields) != 2 || !common.IsHexAddress(fields[0]) {
+			return errors.New("invalid batch delegate")
+		}
+		candidate := common.HexToAddress(fields[0])
+		for _, bond := range event.Bonds {
+			if bond.Candidate == candidate {
+				return errors.New("duplicate batch delegate")
+			}
+		}
+		value := fields[1]
+		if len(value) <= 2 || strings.ToLower(value[:2]) != "0x" {
+			return errors.New("invalid batch delegate amount")
+		}
+		amount, ok := big.NewInt(0).SetString(value[2:], 16)
+		if !ok || amount.Sign() <= 0 {
+			return errors.New("invalid batch delegate amount")
+		}
+		event.Bonds = append(event.Bonds, Bond{Candidate: candidate, Delegator: txSender, Amount: amount})
+	}
+	return nil
+}
+
+// EventUnbond unlock the amount of Delegator for Candidate.
+// data like "senate:1:event:unbond"
+// Sender of tx is Delegator, the tx.to is Candidate, the whole amount locked
+// for it is returned after the unbonding period
+type EventUnbond struct {
+	Delegator common.Address
+	Candidate common.Address
+}
+
+func (event *EventUnbond) Type() TransactionType {
+	return EventTransactionType
+}
+
+func (event *EventUnbond) Action() string {
+	return "unbond"
+}
+
+func (event *EventUnbond) Decode(tx *types.Transaction, data []byte) error {
+	if tx.To() == nil {
+		return errors.New("missing candidate")
+	}
+
 	txSender, err := types.Sender(types.NewEIP155Signer(tx.ChainId()), tx)
 	if err != nil {
 		return err
@@ -117,11 +237,24 @@ func (event *EventDelegate) Decode(tx *types.Transaction, data []byte) error {
 
 // EventBecomeCandidate apply to become Candidate.
 // data like "senate:1:event:candidate"
-// Sender will become a Candidate
+// data like "senate:1:event:candidate:0x56bc75e2d63100000"
+// data like "senate:1:event:candidate:0x56bc75e2d63100000:0x02f9..."
+// data like "senate:1:event:candidate::0x02f9..."
+// data like "senate:1:event:candidate:0x56bc75e2d63100000::acme"
+// Sender will become a Candidate, the optional amount is the self-stake and
+// the optional key is an auxiliary public key: a compressed (33 bytes) or
+// uncompressed (65 bytes) secp256k1 key, or a compressed BLS12-381 G1 key (48 bytes).
+// The optional owner tags the candidates run by the same entity.
 type EventBecomeCandidate struct {
 	Candidate common.Address
+	Stake     *big.Int
+	Key       []byte
+	Owner     string
 }
 
+// maxOwnerSize is the max size in bytes of the owner tag of a candidate.
+const maxOwnerSize = 64
+
 func (event *EventBecomeCandidate) Type() TransactionType {
 	return EventTransactionType
 }
@@ -136,6 +269,210 @@ func (event *EventBecomeCandidate) Decode(tx *types.Transaction, data []byte) er
 		return err
 	}
 	event.Candidate = txSender
+	if len(data) == 0 {
+		return nil
+	}
+
+	fields := strings.Split(string(data), ":")
+	if len(fields) > 3 {
+		return errors.New("invalid candidate data")
+	}
+	if len(fields) == 3 {
+		if len(fields[2]) == 0 || len(fields[2]) > maxOwnerSize {
+			return errors.New("invalid candidate owner")
+		}
+		event.Owner = fields[2]
+		if fields = fields[:2]; len(fields[1]) == 0 {
+			fields = fields[:1]
+		}
+		if len(fields) == 1 && len(fields[0]) == 0 {
+			return nil
+		}
+	}
+	if len(fields) == 2 {
+		key, err := hexutil.Decode(fields[1])
+		if err != nil || !validCandidateKey(key) {
+			return errors.New("invalid candidate key")
+		}
+		event.Key = key
+		if len(fields[0]) == 0 {
+			return nil
+		}
+	}
+
+	value := fields[0]
+	if len(value) <= 2 || strings.ToLower(value[:2]) != "0x" {
+		return errors.New("invalid stake")
+	}
+	stake, ok := big.NewInt(0).SetString(value[2:], 16)
+	if !ok || stake.Sign() <= 0 {
+		return errors.New("invalid stake")
+	}
+	event.Stake = stake
+	return nil
+}
+
+// validCandidateKey reports whether key is a well-formed auxiliary public key.
+func validCandidateKey(key []byte) bool {
+	switch len(key) {
+	case 33:
+		_, err := crypto.DecompressPubkey(key)
+		return err == nil
+	case 65:
+		_, err := crypto.UnmarshalPubkey(key)
+		return err == nil
+	case 48:
+		// Compressed G1 point, the compression flag must be set and
+		// the point at infinity is not a valid key.
+		return key[0]&0x80 != 0 && key[0]&0x40 == 0
+	}
+	return false
+}
+
+// EventCancelCandidate apply to stop being Candidate.
+// data like "senate:1:event:uncandidate"
+// Sender will no longer be a Candidate, the deposit is refunded
+type EventCancelCandidate struct {
+	Candidate common.Address
+}
+
+func (event *EventCancelCandidate) Type() TransactionType {
+	return EventTransactionType
+}
+
+func (event *EventCancelCandidate) Action() string {
+	return "uncandidate"
+}
+
+func (event *EventCancelCandidate) Decode(tx *types.Transaction, data []byte) error {
+	txSender, err := types.Sender(types.NewEIP155Signer(tx.ChainId()), tx)
+	if err != nil {
+		return err
+	}
+	event.Candidate = txSender
+	return nil
+}
+
+// EventRotateKey change the signing key of Candidate.
+// data like "senate:1:event:rotate"
+// Sender of tx is Candidate, the tx.to is the new Signer
+type EventRotateKey struct {
+	Candidate common.Address
+	Signer    common.Address
+}
+
+func (event *EventRotateKey) Type() TransactionType {
+	return EventTransactionType
+}
+
+func (event *EventRotateKey) Action() string {
+	return "rotate"
+}
+
+func (event *EventRotateKey) Decode(tx *types.Transaction, data []byte) error {
+	if tx.To() == nil {
+		return errors.New("missing signer")
+	}
+
+	txSender, err := types.Sender(types.NewEIP155Signer(tx.ChainId()), tx)
+	if err != nil {
+		return err
+	}
+	event.Candidate = txSender
+	event.Signer = *tx.To()
+	return nil
+}
+
+// EventSetRewardAddress redirect the block rewards of Candidate.
+// data like "senate:1:event:payout"
+// Sender of tx is Candidate, the tx.to is the address credited with its rewards,
+// the candidate itself removes the redirection
+type EventSetRewardAddress struct {
+	Candidate common.Address
+	Address   common.Address
+}
+
+func (event *EventSetRewardAddress) Type() TransactionType {
+	return EventTransactionType
+}
+
+func (event *EventSetRewardAddress) Action() string {
+	return "payout"
+}
+
+func (event *EventSetRewardAddress) Decode(tx *types.Transaction, data []byte) error {
+	if tx.To() == nil {
+		return errors.New("missing reward address")
+	}
+
+	txSender, err := types.Sender(types.NewEIP155Signer(tx.ChainId()), tx)
+	if err != nil {
+		return err
+	}
+	event.Candidate = txSender
+	event.Address = *tx.To()
+	return nil
+}
+
+// EventWithdrawReward claim the pending rewards of delegator.
+// data like "senate:1:event:withdraw"
+// Sender of tx is Delegator, the pending rewards are credited to it
+type EventWithdrawReward struct {
+	Delegator common.Address
+}
+
+func (event *EventWithdrawReward) Type() TransactionType {
+	return EventTransactionType
+}
+
+func (event *EventWithdrawReward) Action() string {
+	return "withdraw"
+}
+
+func (event *EventWithdrawReward) Decode(tx *types.Transaction, data []byte) error {
+	txSender, err := types.Sender(types.NewEIP155Signer(tx.ChainId()), tx)
+	if err != nil {
+		return err
+	}
+	event.Delegator = txSender
+	return nil
+}
+
+// maxDeclarationSize is the max size in bytes of the description published by
+// a candidate, it keeps the declare trie from bloating.
+const maxDeclarationSize = 1024
+
+// EventDeclareCandidate publish the self-description of Candidate.
+// data like "senate:1:event:describe:{"name":"node","website":"https://example.org","identity":"keybase:node"}"
+// Sender of tx is Candidate, a later description replaces the previous one but
+// the owner, which is only set at registration
+type EventDeclareCandidate struct {
+	Declaration CandidateDeclaration
+}
+
+func (event *EventDeclareCandidate) Type() TransactionType {
+	return EventTransactionType
+}
+
+func (event *EventDeclareCandidate) Action() string {
+	return "describe"
+}
+
+func (event *EventDeclareCandidate) Decode(tx *types.Transaction, data []byte) error {
+	txSender, err := types.Sender(types.NewEIP155Signer(tx.ChainId()), tx)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 || len(data) > maxDeclarationSize {
+		return errors.New("invalid declaration size")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&event.Declaration); err != nil || decoder.More() || event.Declaration.Owner != "" {
+		return errors.New("invalid declaration")
+	}
+	event.Declaration.Candidate = txSender
 	return nil
 }
 
@@ -145,13 +482,17 @@ func (event *EventBecomeCandidate) Decode(tx *types.Transaction, data []byte) er
 // data like "senate:1:event:proposal:maxValidatorsCount:21"
 // data like "senate:1:event:proposal:minDelegatorBalance:0xde0b6b3a7640000"
 // data like "senate:1:event:proposal:minCandidateBalance:0x56bc75e2d63100000"
+// data like "senate:1:event:proposal:minSelfStakePercent:10"
+// data like "senate:1:event:proposal:treasury:0x47746e8acb5dafe9c00b7195d0c2d830fcc04910"
+// data like "senate:1:event:proposal:treasuryPercent:5"
 // data like "senate:1:event:proposal:rewards:0x69e10de76676d0800000:0x4563918244f40000,0x13da329b6336471800000:0x1bc16d674ec80000,0x422ca8b0a00a425000000:0xde0b6b3a7640000"
 type Proposal struct {
 	Key          string         `json:"key"`
 	Value        string         `json:"value"`
 	Hash         common.Hash    `json:"hash"`
 	Proposer     common.Address `json:"proposer"`
-	ApprovedHash *common.Hash   `json:"approved_hash"`
+	ApprovedHash *common.Hash   `json:"approved_hash" rlp:"nil"`
+	ExpireEpoch  uint64         `json:"expire_epoch,omitempty" rlp:"-"` // Epoch the proposal is removed since if not approved (0 = never)
 }
 
 func (proposal *Proposal) Type() TransactionType {
@@ -162,6 +503,19 @@ func (proposal *Proposal) Action() string {
 	return "proposal"
 }
 
+// Keys of configuration which can be modified by proposals.
+const (
+	ProposalPeriodChange              = "period"
+	ProposalEpochChange               = "epoch"
+	ProposalMaxValidatorsCountChange  = "maxValidatorsCount"
+	ProposalMinDelegatorBalanceChange = "minDelegatorBalance"
+	ProposalMinCandidateBalanceChange = "minCandidateBalance"
+	ProposalMinSelfStakePercentChange = "minSelfStakePercent"
+	ProposalTreasuryChange            = "treasury"
+	ProposalTreasuryPercentChange     = "treasuryPercent"
+	ProposalRewardsChange             = "rewards"
+)
+
 func (proposal *Proposal) applyTo(config *params.SenateConfig) error {
 	if len(proposal.Key) == 0 || len(proposal.Value) == 0 {
 		return errors.New("invalid proposal")
@@ -170,22 +524,22 @@ func (proposal *Proposal) applyTo(config *params.SenateConfig) error {
 	var ok bool
 	var err error
 	switch proposal.Key {
-	case "period":
+	case ProposalPeriodChange:
 		config.Period, err = strconv.ParseUint(proposal.Value, 10, 64)
 		if err != nil || config.Period <= 0 {
 			return errors.New("invalid value: period")
 		}
-	case "epoch":
+	case ProposalEpochChange:
 		config.Epoch, err = strconv.ParseUint(proposal.Value, 10, 64)
 		if err != nil || config.Epoch <= 0 {
 			return errors.New("invalid value: epoch")
 		}
-	case "maxValidatorsCount":
+	case ProposalMaxValidatorsCountChange:
 		config.MaxValidatorsCount, err = strconv.ParseUint(proposal.Value, 10, 64)
 		if err != nil || config.MaxValidatorsCount <= 0 {
 			return errors.New("invalid value: maxValidatorsCount")
 		}
-	case "minDelegatorBalance":
+	case ProposalMinDelegatorBalanceChange:
 		if len(proposal.Value) <= 2 || strings.ToLower(proposal.Value[:2]) != "0x" {
 			return errors.New("invalid value: minDelegatorBalance")
 		}
@@ -193,7 +547,7 @@ func (proposal *Proposal) applyTo(config *params.SenateConfig) error {
 		if !ok || config.MinDelegatorBalance.Cmp(big.NewInt(0)) == -1 {
 			return errors.New("invalid value: minDelegatorBalance")
 		}
-	case "minCandidateBalance":
+	case ProposalMinCandidateBalanceChange:
 		if len(proposal.Value) <= 2 || strings.ToLower(proposal.Value[:2]) != "0x" {
 			return errors.New("invalid value: minDelegatorBalance")
 		}
@@ -201,7 +555,22 @@ func (proposal *Proposal) applyTo(config *params.SenateConfig) error {
 		if !ok || config.MinCandidateBalance.Cmp(big.NewInt(0)) == -1 {
 			return errors.New("invalid value: minCandidateBalance")
 		}
-	case "rewards":
+	case ProposalMinSelfStakePercentChange:
+		config.MinSelfStakePercent, err = strconv.ParseUint(proposal.Value, 10, 64)
+		if err != nil || config.MinSelfStakePercent > 100 {
+			return errors.New("invalid value: minSelfStakePercent")
+		}
+	case ProposalTreasuryChange:
+		if !common.IsHexAddress(proposal.Value) {
+			return errors.New("invalid value: treasury")
+		}
+		config.Treasury = common.HexToAddress(proposal.Value)
+	case ProposalTreasuryPercentChange:
+		config.TreasuryPercent, err = strconv.ParseUint(proposal.Value, 10, 64)
+		if err != nil || config.TreasuryPercent > 100 {
+			return errors.New("invalid value: treasuryPercent")
+		}
+	case ProposalRewardsChange:
 		config.Rewards = nil
 		lastHeight := big.NewInt(-1)
 		for _, s := range strings.Split(proposal.Value, ",") {
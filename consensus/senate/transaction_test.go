@@ -3,9 +3,11 @@ package senate
 import (
 	"fmt"
 	"math/big"
+	"strings"
 	"testing"
 
 	"github.com/SecretBlockChain/go-secret/common"
+	"github.com/SecretBlockChain/go-secret/common/hexutil"
 	"github.com/SecretBlockChain/go-secret/core/types"
 	"github.com/SecretBlockChain/go-secret/crypto"
 	"github.com/stretchr/testify/assert"
@@ -23,6 +25,22 @@ func TestCustomTransactionDecode(t *testing.T) {
 	ctx, err := NewTransaction(tx)
 	assert.Nil(t, err)
 	assert.IsType(t, new(EventDelegate), ctx)
+	assert.Nil(t, ctx.(*EventDelegate).Amount)
+
+	tx = types.NewTransaction(1, address, big.NewInt(0), 99999999, big.NewInt(1000), []byte("senate:1:event:delegate:0xde0b6b3a7640000"))
+	tx, err = types.SignTx(tx, types.HomesteadSigner{}, testKey)
+	assert.Nil(t, err)
+
+	ctx, err = NewTransaction(tx)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(1000000000000000000), ctx.(*EventDelegate).Amount)
+	for _, data := range []string{"senate:1:event:delegate:0x", "senate:1:event:delegate:0x0", "senate:1:event:delegate:100"} {
+		tx = types.NewTransaction(1, address, big.NewInt(0), 99999999, big.NewInt(1000), []byte(data))
+		tx, err = types.SignTx(tx, types.HomesteadSigner{}, testKey)
+		assert.Nil(t, err)
+		_, err = NewTransaction(tx)
+		assert.NotNil(t, err, data)
+	}
 
 	tx = types.NewTransaction(1, address, big.NewInt(1024), 99999999, big.NewInt(1000), []byte("senate:1:event:candidate"))
 	tx, err = types.SignTx(tx, types.HomesteadSigner{}, testKey)
@@ -32,12 +50,24 @@ func TestCustomTransactionDecode(t *testing.T) {
 	assert.Nil(t, err)
 	assert.IsType(t, new(EventBecomeCandidate), ctx)
 
+	tx = types.NewTransaction(1, address, big.NewInt(0), 99999999, big.NewInt(1000), []byte("senate:1:event:withdraw"))
+	tx, err = types.SignTx(tx, types.HomesteadSigner{}, testKey)
+	assert.Nil(t, err)
+
+	ctx, err = NewTransaction(tx)
+	assert.Nil(t, err)
+	assert.IsType(t, new(EventWithdrawReward), ctx)
+	assert.Equal(t, crypto.PubkeyToAddress(testKey.PublicKey), ctx.(*EventWithdrawReward).Delegator)
+
 	proposals := [][]byte{
 		[]byte("senate:1:event:proposal:period:8"),
 		[]byte("senate:1:event:proposal:epoch:86400"),
 		[]byte("senate:1:event:proposal:maxValidatorsCount:21"),
 		[]byte("senate:1:event:proposal:minDelegatorBalance:0xde0b6b3a7640000"),
 		[]byte("senate:1:event:proposal:minCandidateBalance:0x56bc75e2d63100000"),
+		[]byte("senate:1:event:proposal:minSelfStakePercent:10"),
+		[]byte("senate:1:event:proposal:treasury:0x47746e8acb5dafe9c00b7195d0c2d830fcc04910"),
+		[]byte("senate:1:event:proposal:treasuryPercent:5"),
 		[]byte("senate:1:event:proposal:rewards:0x69e10de76676d0800000:0x4563918244f40000,0x13da329b6336471800000:0x1bc16d674ec80000,0x422ca8b0a00a425000000:0xde0b6b3a7640000"),
 	}
 	for _, proposal := range proposals {
@@ -59,3 +89,158 @@ func TestCustomTransactionDecode(t *testing.T) {
 	assert.Nil(t, err)
 	assert.IsType(t, new(Declare), ctx)
 }
+
+func TestBecomeCandidateDecode(t *testing.T) {
+	address := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	compressed := crypto.CompressPubkey(&testKey.PublicKey)
+	uncompressed := crypto.FromECDSAPub(&testKey.PublicKey)
+	bls := append([]byte{0x80}, make([]byte, 47)...)
+
+	decode := func(data string) (*EventBecomeCandidate, error) {
+		tx := types.NewTransaction(1, address, big.NewInt(0), 99999999, big.NewInt(1000), []byte(data))
+		tx, err := types.SignTx(tx, types.HomesteadSigner{}, testKey)
+		assert.Nil(t, err)
+		ctx, err := NewTransaction(tx)
+		if err != nil {
+			return nil, err
+		}
+		return ctx.(*EventBecomeCandidate), nil
+	}
+
+	event, err := decode("senate:1:event:candidate")
+	assert.Nil(t, err)
+	assert.Nil(t, event.Stake)
+	assert.Nil(t, event.Key)
+
+	event, err = decode("senate:1:event:candidate:0x56bc75e2d63100000")
+	assert.Nil(t, err)
+	assert.Equal(t, "100000000000000000000", event.Stake.String())
+	assert.Nil(t, event.Key)
+
+	event, err = decode("senate:1:event:candidate:0x56bc75e2d63100000:" + hexutil.Encode(compressed))
+	assert.Nil(t, err)
+	assert.Equal(t, "100000000000000000000", event.Stake.String())
+	assert.Equal(t, compressed, event.Key)
+
+	for _, key := range [][]byte{compressed, uncompressed, bls} {
+		event, err = decode("senate:1:event:candidate::" + hexutil.Encode(key))
+		assert.Nil(t, err)
+		assert.Nil(t, event.Stake)
+		assert.Equal(t, key, event.Key)
+	}
+
+	// The owner tag follows the optional stake and key
+	event, err = decode("senate:1:event:candidate:0x56bc75e2d63100000:" + hexutil.Encode(compressed) + ":acme")
+	assert.Nil(t, err)
+	assert.Equal(t, "100000000000000000000", event.Stake.String())
+	assert.Equal(t, compressed, event.Key)
+	assert.Equal(t, "acme", event.Owner)
+	event, err = decode("senate:1:event:candidate:0x56bc75e2d63100000::acme")
+	assert.Nil(t, err)
+	assert.Equal(t, "100000000000000000000", event.Stake.String())
+	assert.Nil(t, event.Key)
+	assert.Equal(t, "acme", event.Owner)
+	event, err = decode("senate:1:event:candidate:::acme")
+	assert.Nil(t, err)
+	assert.Nil(t, event.Stake)
+	assert.Nil(t, event.Key)
+	assert.Equal(t, "acme", event.Owner)
+
+	invalid := []string{
+		"senate:1:event:candidate::",
+		"senate:1:event:candidate::0x",
+		"senate:1:event:candidate::0x0102",
+		"senate:1:event:candidate::" + hexutil.Encode(append([]byte{0x05}, compressed[1:]...)),
+		"senate:1:event:candidate::" + hexutil.Encode(make([]byte, 48)),
+		"senate:1:event:candidate:0x56bc75e2d63100000:" + hexutil.Encode(compressed) + ":acme:0x01",
+		"senate:1:event:candidate:0x56bc75e2d63100000:" + hexutil.Encode(compressed) + ":",
+		"senate:1:event:candidate:::" + strings.Repeat("a", maxOwnerSize+1),
+		"senate:1:event:candidate:0x:" + hexutil.Encode(compressed) + ":acme",
+	}
+	for _, data := range invalid {
+		_, err = decode(data)
+		assert.NotNil(t, err, data)
+	}
+}
+
+func TestBatchDelegateDecode(t *testing.T) {
+	address := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	other := common.HexToAddress("0x1c1f1b2e6f0a4f7c8e8b3e7a9d2c5b4a3f2e9e52")
+
+	decode := func(data string) (*EventBatchDelegate, error) {
+		tx := types.NewTransaction(1, address, big.NewInt(0), 99999999, big.NewInt(1000), []byte(data))
+		tx, err := types.SignTx(tx, types.HomesteadSigner{}, testKey)
+		assert.Nil(t, err)
+		ctx, err := NewTransaction(tx)
+		if err != nil {
+			return nil, err
+		}
+		return ctx.(*EventBatchDelegate), nil
+	}
+
+	delegator := crypto.PubkeyToAddress(testKey.PublicKey)
+	event, err := decode("senate:1:event:batchdelegate:" + address.Hex() + ":0xde0b6b3a7640000," + other.Hex() + ":0x1bc16d674ec80000")
+	assert.Nil(t, err)
+	assert.Equal(t, delegator, event.Delegator)
+	assert.Equal(t, []Bond{
+		{Candidate: address, Delegator: delegator, Amount: big.NewInt(1000000000000000000)},
+		{Candidate: other, Delegator: delegator, Amount: big.NewInt(2000000000000000000)},
+	}, event.Bonds)
+
+	entries := make([]string, maxBatchDelegates+1)
+	for i := range entries {
+		entries[i] = common.BigToAddress(big.NewInt(int64(i+1))).Hex() + ":0x1"
+	}
+	invalid := []string{
+		"senate:1:event:batchdelegate",
+		"senate:1:event:batchdelegate:" + address.Hex(),
+		"senate:1:event:batchdelegate:" + address.Hex() + ":0x",
+		"senate:1:event:batchdelegate:" + address.Hex() + ":0x0",
+		"senate:1:event:batchdelegate:" + address.Hex() + ":100",
+		"senate:1:event:batchdelegate:0x4774:0x1",
+		"senate:1:event:batchdelegate:" + address.Hex() + ":0x1," + address.Hex() + ":0x2",
+		"senate:1:event:batchdelegate:" + address.Hex() + ":0x1,",
+		"senate:1:event:batchdelegate:" + strings.Join(entries, ","),
+	}
+	for _, data := range invalid {
+		_, err = decode(data)
+		assert.NotNil(t, err, data)
+	}
+}
+
+func TestDeclareCandidateDecode(t *testing.T) {
+	address := common.HexToAddress("0x47746e8acb5dafe9c00b7195d0c2d830fcc04910")
+	decode := func(data string) (*EventDeclareCandidate, error) {
+		tx := types.NewTransaction(1, address, big.NewInt(0), 99999999, big.NewInt(1000), []byte(data))
+		tx, err := types.SignTx(tx, types.HomesteadSigner{}, testKey)
+		assert.Nil(t, err)
+		ctx, err := NewTransaction(tx)
+		if err != nil {
+			return nil, err
+		}
+		return ctx.(*EventDeclareCandidate), nil
+	}
+
+	// Colons inside the JSON are kept, the candidate is always the sender
+	event, err := decode(`senate:1:event:describe:{"name":"node","website":"https://example.org","candidate":"0x47746e8acb5dafe9c00b7195d0c2d830fcc04910"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, CandidateDeclaration{
+		Candidate: crypto.PubkeyToAddress(testKey.PublicKey),
+		Name:      "node",
+		Website:   "https://example.org",
+	}, event.Declaration)
+
+	invalid := []string{
+		"senate:1:event:describe",
+		"senate:1:event:describe:",
+		"senate:1:event:describe:node",
+		`senate:1:event:describe:{"name":"node"}{}`,
+		`senate:1:event:describe:{"name":"node","logo":"https://example.org/logo.png"}`,
+		`senate:1:event:describe:{"name":"` + strings.Repeat("x", maxDeclarationSize) + `"}`,
+		`senate:1:event:describe:{"name":"node","owner":"acme"}`,
+	}
+	for _, data := range invalid {
+		_, err = decode(data)
+		assert.NotNil(t, err, data)
+	}
+}
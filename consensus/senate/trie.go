@@ -24,6 +24,13 @@ func NewTrieWithPrefix(root common.Hash, prefix []byte, db *trie.Database) (*Tri
 	return &Trie{prefix: prefix, trie: trie}, nil
 }
 
+// Copy returns a copy of the trie, the nodes are shared since they are never
+// modified in place.
+func (t *Trie) Copy() *Trie {
+	cpy := *t.trie
+	return &Trie{prefix: t.prefix, trie: &cpy}
+}
+
 // Hash returns the root hash of the trie. It does not write to the
 // database and can be used even if the trie doesn't have one.
 func (t *Trie) Hash() common.Hash {
@@ -80,6 +80,11 @@ func (v *BlockValidator) ValidateBody(block *types.Block) error {
 // otherwise nil and an error is returned.
 func (v *BlockValidator) ValidateState(block *types.Block, statedb *state.StateDB, receipts types.Receipts, usedGas uint64) error {
 	header := block.Header()
+	if verifier, ok := v.engine.(consensus.FinalizeVerifier); ok {
+		if err := verifier.VerifyFinalize(header); err != nil {
+			return err
+		}
+	}
 	if block.GasUsed() != usedGas {
 		return fmt.Errorf("invalid gas used (remote: %d local: %d)", block.GasUsed(), usedGas)
 	}
@@ -57,6 +57,10 @@ var (
 	// is higher than the balance of the user's account.
 	ErrInsufficientFunds = errors.New("insufficient funds for gas * price + value")
 
+	// ErrFeeCapTooLow is returned if the gas price of a transaction is lower
+	// than the base fee of the block.
+	ErrFeeCapTooLow = errors.New("gas price less than block base fee")
+
 	// ErrGasUintOverflow is returned when calculating gas usage.
 	ErrGasUintOverflow = errors.New("gas uint64 overflow")
 
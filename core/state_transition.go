@@ -17,6 +17,7 @@
 package core
 
 import (
+	"fmt"
 	"math"
 	"math/big"
 
@@ -196,6 +197,12 @@ func (st *StateTransition) preCheck() error {
 		} else if nonce > st.msg.Nonce() {
 			return ErrNonceTooLow
 		}
+		// Make sure the transaction pays at least the base fee of the block,
+		// calls (which skip the nonce check as well) are exempted
+		if baseFee := st.evm.Context.BaseFee; baseFee != nil && st.gasPrice.Cmp(baseFee) < 0 {
+			return fmt.Errorf("%w: address %v, gasPrice: %s baseFee: %s", ErrFeeCapTooLow,
+				st.msg.From().Hex(), st.gasPrice, baseFee)
+		}
 	}
 	return st.buyGas()
 }
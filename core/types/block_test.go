@@ -69,6 +69,42 @@ func TestBlockEncoding(t *testing.T) {
 	}
 }
 
+func TestHeaderBaseFeeEncoding(t *testing.T) {
+	legacy := &Header{Difficulty: big.NewInt(131072), Number: big.NewInt(1), GasLimit: 3141592, Extra: []byte("test")}
+	legacyEnc, err := rlp.EncodeToBytes(legacy)
+	if err != nil {
+		t.Fatal("encode error: ", err)
+	}
+
+	// Headers with base fee carry one more field
+	header := CopyHeader(legacy)
+	header.BaseFee = big.NewInt(params.InitialBaseFee)
+	enc, err := rlp.EncodeToBytes(header)
+	if err != nil {
+		t.Fatal("encode error: ", err)
+	}
+	if bytes.Equal(enc, legacyEnc) || header.Hash() == legacy.Hash() {
+		t.Fatal("base fee is not encoded")
+	}
+
+	var decoded Header
+	if err := rlp.DecodeBytes(enc, &decoded); err != nil {
+		t.Fatal("decode error: ", err)
+	}
+	if decoded.BaseFee == nil || decoded.BaseFee.Cmp(header.BaseFee) != 0 {
+		t.Errorf("base fee mismatch: got %v, want %v", decoded.BaseFee, header.BaseFee)
+	}
+	if decoded.Hash() != header.Hash() {
+		t.Errorf("hash mismatch: got %x, want %x", decoded.Hash(), header.Hash())
+	}
+	if err := rlp.DecodeBytes(legacyEnc, &decoded); err != nil {
+		t.Fatal("decode error: ", err)
+	}
+	if decoded.BaseFee != nil {
+		t.Errorf("base fee of legacy header: got %v, want nil", decoded.BaseFee)
+	}
+}
+
 func TestUncleHash(t *testing.T) {
 	uncles := make([]*Header, 0)
 	h := CalcUncleHash(uncles)
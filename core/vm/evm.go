@@ -97,6 +97,7 @@ type Context struct {
 	BlockNumber *big.Int       // Provides information for NUMBER
 	Time        *big.Int       // Provides information for TIME
 	Difficulty  *big.Int       // Provides information for DIFFICULTY
+	BaseFee     *big.Int       // Base fee of the block, nil before London
 }
 
 // EVM is the Ethereum Virtual Machine base object and provides
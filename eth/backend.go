@@ -130,7 +130,7 @@ func New(stack *node.Node, config *Config) (*Ethereum, error) {
 		chainDb:           chainDb,
 		eventMux:          stack.EventMux(),
 		accountManager:    stack.AccountManager(),
-		engine:            CreateConsensusEngine(stack, chainConfig, &config.Ethash, config.Miner.Notify, config.Miner.Noverify, chainDb),
+		engine:            CreateConsensusEngine(stack, chainConfig, &config.Ethash, &config.Senate, config.Miner.Notify, config.Miner.Noverify, chainDb),
 		closeBloomHandler: make(chan struct{}),
 		networkID:         config.NetworkId,
 		gasPrice:          config.Miner.GasPrice,
@@ -183,6 +183,9 @@ func New(stack *node.Node, config *Config) (*Ethereum, error) {
 		rawdb.WriteChainConfig(chainDb, genesisHash, chainConfig)
 	}
 	eth.bloomIndexer.Start(eth.blockchain)
+	if senate, ok := eth.engine.(*senate.Senate); ok {
+		senate.WatchChainHead(senateChain{eth.blockchain})
+	}
 
 	if config.TxPool.Journal != "" {
 		config.TxPool.Journal = stack.ResolvePath(config.TxPool.Journal)
@@ -241,12 +244,15 @@ func makeExtraData(extra []byte) []byte {
 }
 
 // CreateConsensusEngine creates the required type of consensus engine instance for an Ethereum service
-func CreateConsensusEngine(stack *node.Node, chainConfig *params.ChainConfig, config *ethash.Config, notify []string, noverify bool, db ethdb.Database) consensus.Engine {
+func CreateConsensusEngine(stack *node.Node, chainConfig *params.ChainConfig, config *ethash.Config, options *senate.Options, notify []string, noverify bool, db ethdb.Database) consensus.Engine {
 	// If proof-of-authority is requested, set it up
 	if chainConfig.Clique != nil {
 		return clique.New(chainConfig.Clique, db)
 	} else if chainConfig.Senate != nil {
-		return senate.New(chainConfig.Senate, db)
+		if err := chainConfig.Senate.Validate(); err != nil {
+			log.Crit("Invalid senate config", "err", err)
+		}
+		return senate.NewWithOptions(chainConfig.Senate, chainConfig.ChainID, db, *options)
 	}
 	// Otherwise assume proof-of-work
 	switch config.PowMode {
@@ -557,3 +563,33 @@ func (s *Ethereum) Stop() error {
 	s.eventMux.Stop()
 	return nil
 }
+
+// senateChain adapts the blockchain to senate.ChainHeadSubscriber, handing the
+// engine the headers of new canonical heads instead of core events.
+type senateChain struct {
+	*core.BlockChain
+}
+
+// SubscribeChainHead forwards the header of every new canonical head to ch.
+func (c senateChain) SubscribeChainHead(ch chan<- *types.Header) event.Subscription {
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		events := make(chan core.ChainHeadEvent, 10)
+		sub := c.SubscribeChainHeadEvent(events)
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-events:
+				select {
+				case ch <- ev.Block.Header():
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	})
+}
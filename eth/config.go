@@ -26,6 +26,7 @@ import (
 
 	"github.com/SecretBlockChain/go-secret/common"
 	"github.com/SecretBlockChain/go-secret/consensus/ethash"
+	"github.com/SecretBlockChain/go-secret/consensus/senate"
 	"github.com/SecretBlockChain/go-secret/core"
 	"github.com/SecretBlockChain/go-secret/eth/downloader"
 	"github.com/SecretBlockChain/go-secret/eth/gasprice"
@@ -156,6 +157,9 @@ type Config struct {
 	// Ethash options
 	Ethash ethash.Config
 
+	// Senate options
+	Senate senate.Options
+
 	// Transaction pool options
 	TxPool core.TxPoolConfig
 
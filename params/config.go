@@ -18,6 +18,7 @@ package params
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math/big"
 	"sort"
@@ -241,16 +242,16 @@ var (
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, new(EthashConfig), nil, nil}
+	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, nil, new(EthashConfig), nil, nil}
 
 	// AllCliqueProtocolChanges contains every protocol change (EIPs) introduced
 	// and accepted by the Ethereum core developers into the Clique consensus.
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}, nil}
+	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}, nil}
 
-	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, new(EthashConfig), nil, nil}
+	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, nil, new(EthashConfig), nil, nil}
 	TestRules       = TestChainConfig.Rules(new(big.Int))
 )
 
@@ -321,6 +322,7 @@ type ChainConfig struct {
 	PetersburgBlock     *big.Int `json:"petersburgBlock,omitempty"`     // Petersburg switch block (nil = same as Constantinople)
 	IstanbulBlock       *big.Int `json:"istanbulBlock,omitempty"`       // Istanbul switch block (nil = no fork, 0 = already on istanbul)
 	MuirGlacierBlock    *big.Int `json:"muirGlacierBlock,omitempty"`    // Eip-2384 (bomb delay) switch block (nil = no fork, 0 = already activated)
+	LondonBlock         *big.Int `json:"londonBlock,omitempty"`         // London switch block (nil = no fork, 0 = already on london)
 
 	YoloV1Block *big.Int `json:"yoloV1Block,omitempty"` // YOLO v1: https://github.com/ethereum/EIPs/pull/2657 (Ephemeral testnet)
 	EWASMBlock  *big.Int `json:"ewasmBlock,omitempty"`  // EWASM switch block (nil = no fork, 0 = already activated)
@@ -375,7 +377,60 @@ type SenateConfig struct {
 	GenesisTimestamp    uint64           `json:"genesisTimestamp"`    // The timestamp of first Block
 	Validators          []common.Address `json:"validators"`          // Genesis validator list
 	Rewards             SenateRewards    `json:"rewards"`             // Reward rule of mint block
-}
+
+	// Fields added after launch are optional in RLP, so the configs in the headers
+	// of older blocks still decode. Version is the encoding version of the config,
+	// 0 for configs encoded before it was added.
+	Version             uint64         `json:"-" rlp:"optional"`
+	EpochBlocks         uint64         `json:"epochBlocks,omitempty" rlp:"optional"`         // Number of blocks of an epoch, replaces the time-based rollover of Epoch (0 = time-based)
+	MinValidators       uint64         `json:"minValidators,omitempty" rlp:"optional"`       // Elections shrinking the validators below keep the previous ones (0 = no floor)
+	InitialStakes       []*big.Int     `json:"initialStakes,omitempty" rlp:"optional"`       // Self-stake deposited by each genesis validator, in the order of Validators
+	ReuseValidators     bool           `json:"reuseValidators,omitempty" rlp:"optional"`     // Keep the validators trie if the elected set is unchanged
+	CandidateDeposit    *big.Int       `json:"candidateDeposit,omitempty" rlp:"optional"`    // Deposit locked when becoming a candidate
+	RefundPercent       uint64         `json:"refundPercent,omitempty" rlp:"optional"`       // Percent of the deposit refunded at once on deregistration
+	RefundEpochs        uint64         `json:"refundEpochs,omitempty" rlp:"optional"`        // Number of epochs the rest of the deposit vests over
+	ChainIDBlock        uint64         `json:"chainIdBlock,omitempty" rlp:"optional"`        // Block since which the chain id is bound into the seal hash (0 = disabled)
+	SealDomainBlock     uint64         `json:"sealDomainBlock,omitempty" rlp:"optional"`     // Block since which the seal hash is prefixed by the senate domain tag (0 = disabled)
+	MinMintPercent      uint64         `json:"minMintPercent,omitempty" rlp:"optional"`      // Percent of the expected blocks a validator must mint to remain a candidate
+	SlashPercent        uint64         `json:"slashPercent,omitempty" rlp:"optional"`        // Percent of balance slashed from validators kicked out for inactivity
+	SlashFund           common.Address `json:"slashFund,omitempty" rlp:"optional"`           // Address receiving slashed stake (zero address = burn)
+	NoTurnDelay         uint64         `json:"noTurnDelay,omitempty" rlp:"optional"`         // Seconds an out-of-turn validator waits before sealing (0 = in-turn sealing only)
+	FallbackSlots       uint64         `json:"fallbackSlots,omitempty" rlp:"optional"`       // Missed slots after which a fallback validator may seal in place of the one in turn (0 = disabled)
+	RecentSigners       bool           `json:"recentSigners,omitempty" rlp:"optional"`       // Reject validators signing again within the recent signers window
+	ProposalEpochs      uint64         `json:"proposalEpochs,omitempty" rlp:"optional"`      // Number of epochs a proposal stays open before it expires (0 = never expires)
+	ProposalQuorum      uint64         `json:"proposalQuorum,omitempty" rlp:"optional"`      // Percent of validators declaring yes required to approve a proposal (0 = more than 2/3)
+	StakeWeightedQuorum bool           `json:"stakeWeightedQuorum,omitempty" rlp:"optional"` // Weigh the quorum of proposals by self-stake of validators instead of by head
+	RewardSharing       bool           `json:"rewardSharing,omitempty" rlp:"optional"`       // Share block rewards with the delegators of validator by their stakes fixed at the election
+	Commission          uint64         `json:"commission,omitempty" rlp:"optional"`          // Percent of block reward kept by validator when sharing rewards
+	MinCandidateStake   *big.Int       `json:"minCandidateStake,omitempty" rlp:"optional"`   // Min self-stake of candidate registration, enables staking any amount above it
+	MaxRegistrations    uint64         `json:"maxRegistrations,omitempty" rlp:"optional"`    // Max number of new candidates registered in an epoch (0 = unlimited)
+	MaxOwnerValidators  uint64         `json:"maxOwnerValidators,omitempty" rlp:"optional"`  // Max number of validators sharing an owner tag elected at once (0 = unlimited)
+	UnbondingPeriod     uint64         `json:"unbondingPeriod,omitempty" rlp:"optional"`     // Seconds the refund of a deregistered candidate stays locked (0 = refunded at once)
+	MinEmptyBlockPeriod uint64         `json:"minEmptyBlockPeriod,omitempty" rlp:"optional"` // Min seconds between a block and the next block without transactions (0 = Period)
+	WeightedElection    bool           `json:"weightedElection,omitempty" rlp:"optional"`    // Elect validators randomly weighted by stake instead of uniformly
+	ReputationWeight    uint64         `json:"reputationWeight,omitempty" rlp:"optional"`    // Percent of the stake scaled by minting reliability to rank candidates, replaces random election if set (0 = disabled)
+	ReputationEpochs    uint64         `json:"reputationEpochs,omitempty" rlp:"optional"`    // Number of past epochs minting reliability is measured over (0 = the last epoch)
+	AllowedFutureDrift  uint64         `json:"allowedFutureDrift,omitempty" rlp:"optional"`  // Seconds a header may be ahead of the local clock (0 = default drift)
+	MaxExtraSize        uint64         `json:"maxExtraSize,omitempty" rlp:"optional"`        // Max bytes of header extra-data including vanity and seal (0 = unlimited)
+	StagedDelegation    bool           `json:"stagedDelegation,omitempty" rlp:"optional"`    // Count delegations in elections since the next epoch instead of at once
+	CustomNonces        bool           `json:"customNonces,omitempty" rlp:"optional"`        // Reject custom transactions whose nonce isn't above the last applied one of the sender
+	MinSelfStakePercent uint64         `json:"minSelfStakePercent,omitempty" rlp:"optional"` // Percent of the stake delegated by others a candidate must self-stake to be elected
+	PendingRewards      bool           `json:"pendingRewards,omitempty" rlp:"optional"`      // Accrue shared rewards until delegators withdraw them instead of crediting at once
+	InitialReward       *big.Int       `json:"initialReward,omitempty" rlp:"optional"`       // Reward of mint block before the first halving, replaces the reward rules if set
+	HalvingInterval     uint64         `json:"halvingInterval,omitempty" rlp:"optional"`     // Number of blocks between halvings of the initial reward (0 = never halves)
+	MinReward           *big.Int       `json:"minReward,omitempty" rlp:"optional"`           // Floor of the halved reward of mint block
+	NoRewards           bool           `json:"noRewards,omitempty" rlp:"optional"`           // Mint blocks without any reward, for permissioned chains without inflation
+	Treasury            common.Address `json:"treasury,omitempty" rlp:"optional"`            // Address receiving the treasury share of block reward
+	TreasuryPercent     uint64         `json:"treasuryPercent,omitempty" rlp:"optional"`     // Percent of block reward paid to treasury before the validator
+	ProposalReward      *big.Int       `json:"proposalReward,omitempty" rlp:"optional"`      // Paid by treasury to each validator declared on a proposal once it's approved (nil = no reward)
+	VoteDecayPercent    uint64         `json:"voteDecayPercent,omitempty" rlp:"optional"`    // Percent of weight a vote loses at each epoch boundary until renewed (0 = no decay)
+	EpochStats          bool           `json:"epochStats,omitempty" rlp:"optional"`          // Commit the aggregates of stake, rewards and participation of each epoch to the snapshot
+	RejectConflictBlock uint64         `json:"rejectConflictBlock,omitempty" rlp:"optional"` // Block since which custom operations repeating one of the same block are rejected and rejects are recorded (0 = disabled)
+	LockDelegation      bool           `json:"lockDelegation,omitempty" rlp:"optional"`      // Lock the delegated balance in the staking account, only locked amounts count as votes
+}
+
+// SenateConfigVersion is the current encoding version of SenateConfig.
+const SenateConfigVersion = 1
 
 // DefaultSenateConfig returns default config of senate consensus engine.
 func DefaultSenateConfig() SenateConfig {
@@ -392,6 +447,66 @@ func DefaultSenateConfig() SenateConfig {
 	}
 }
 
+// Validate checks the config is able to launch a chain, a misconfigured engine
+// would otherwise divide by zero or stall deep in consensus.
+func (c *SenateConfig) Validate() error {
+	if c.Period == 0 {
+		return errors.New("senate period must be greater than zero")
+	}
+	if c.Epoch < c.Period {
+		return fmt.Errorf("senate epoch %d shorter than period %d", c.Epoch, c.Period)
+	}
+	if c.MaxValidatorsCount == 0 {
+		return errors.New("senate maxValidatorsCount must be greater than zero")
+	}
+	if c.MinValidators > c.MaxValidatorsCount {
+		return fmt.Errorf("senate minValidators %d exceeds maxValidatorsCount %d", c.MinValidators, c.MaxValidatorsCount)
+	}
+	if c.MinDelegatorBalance == nil || c.MinDelegatorBalance.Sign() < 0 {
+		return fmt.Errorf("invalid senate minDelegatorBalance %v", c.MinDelegatorBalance)
+	}
+	if c.MinCandidateBalance == nil || c.MinCandidateBalance.Sign() < 0 {
+		return fmt.Errorf("invalid senate minCandidateBalance %v", c.MinCandidateBalance)
+	}
+	// The genesis validators mint the first epoch, so there must be at least one
+	if len(c.Validators) == 0 {
+		return errors.New("no senate genesis validators")
+	}
+	if len(c.InitialStakes) > 0 {
+		if len(c.InitialStakes) != len(c.Validators) {
+			return fmt.Errorf("senate initialStakes count %d mismatch with validators count %d", len(c.InitialStakes), len(c.Validators))
+		}
+		for i, stake := range c.InitialStakes {
+			if stake == nil || stake.Sign() < 0 {
+				return fmt.Errorf("invalid senate initial stake %v of validator %s", stake, c.Validators[i].Hex())
+			}
+		}
+	}
+	if c.ProposalReward != nil && c.ProposalReward.Sign() < 0 {
+		return fmt.Errorf("invalid senate proposalReward %v", c.ProposalReward)
+	}
+	percents := []struct {
+		name  string
+		value uint64
+	}{
+		{"refundPercent", c.RefundPercent},
+		{"minMintPercent", c.MinMintPercent},
+		{"slashPercent", c.SlashPercent},
+		{"proposalQuorum", c.ProposalQuorum},
+		{"commission", c.Commission},
+		{"minSelfStakePercent", c.MinSelfStakePercent},
+		{"treasuryPercent", c.TreasuryPercent},
+		{"voteDecayPercent", c.VoteDecayPercent},
+		{"reputationWeight", c.ReputationWeight},
+	}
+	for _, percent := range percents {
+		if percent.value > 100 {
+			return fmt.Errorf("senate %s %d exceeds 100", percent.name, percent.value)
+		}
+	}
+	return nil
+}
+
 // String implements the stringer interface, returning the consensus engine details.
 func (c *SenateConfig) String() string {
 	return "senate"
@@ -405,9 +520,15 @@ func (c *SenateConfig) Equal(other SenateConfig) bool {
 	if c.Epoch != other.Epoch {
 		return false
 	}
+	if c.EpochBlocks != other.EpochBlocks {
+		return false
+	}
 	if c.MaxValidatorsCount != other.MaxValidatorsCount {
 		return false
 	}
+	if c.MinValidators != other.MinValidators {
+		return false
+	}
 	if c.MinDelegatorBalance.Cmp(other.MinDelegatorBalance) != 0 {
 		return false
 	}
@@ -417,6 +538,132 @@ func (c *SenateConfig) Equal(other SenateConfig) bool {
 	if c.GenesisTimestamp != other.GenesisTimestamp {
 		return false
 	}
+	if c.ReuseValidators != other.ReuseValidators {
+		return false
+	}
+	if !bigEqual(c.CandidateDeposit, other.CandidateDeposit) {
+		return false
+	}
+	if c.RefundPercent != other.RefundPercent {
+		return false
+	}
+	if c.RefundEpochs != other.RefundEpochs {
+		return false
+	}
+	if c.ChainIDBlock != other.ChainIDBlock {
+		return false
+	}
+	if c.SealDomainBlock != other.SealDomainBlock {
+		return false
+	}
+	if c.MinMintPercent != other.MinMintPercent {
+		return false
+	}
+	if c.SlashPercent != other.SlashPercent {
+		return false
+	}
+	if c.SlashFund != other.SlashFund {
+		return false
+	}
+	if c.NoTurnDelay != other.NoTurnDelay {
+		return false
+	}
+	if c.FallbackSlots != other.FallbackSlots {
+		return false
+	}
+	if c.RecentSigners != other.RecentSigners {
+		return false
+	}
+	if c.ProposalEpochs != other.ProposalEpochs {
+		return false
+	}
+	if c.ProposalQuorum != other.ProposalQuorum {
+		return false
+	}
+	if c.StakeWeightedQuorum != other.StakeWeightedQuorum {
+		return false
+	}
+	if c.RewardSharing != other.RewardSharing {
+		return false
+	}
+	if c.Commission != other.Commission {
+		return false
+	}
+	if !bigEqual(c.MinCandidateStake, other.MinCandidateStake) {
+		return false
+	}
+	if c.MaxRegistrations != other.MaxRegistrations {
+		return false
+	}
+	if c.MaxOwnerValidators != other.MaxOwnerValidators {
+		return false
+	}
+	if c.UnbondingPeriod != other.UnbondingPeriod {
+		return false
+	}
+	if c.MinEmptyBlockPeriod != other.MinEmptyBlockPeriod {
+		return false
+	}
+	if c.WeightedElection != other.WeightedElection {
+		return false
+	}
+	if c.ReputationWeight != other.ReputationWeight {
+		return false
+	}
+	if c.ReputationEpochs != other.ReputationEpochs {
+		return false
+	}
+	if c.AllowedFutureDrift != other.AllowedFutureDrift {
+		return false
+	}
+	if c.MaxExtraSize != other.MaxExtraSize {
+		return false
+	}
+	if c.StagedDelegation != other.StagedDelegation {
+		return false
+	}
+	if c.CustomNonces != other.CustomNonces {
+		return false
+	}
+	if c.MinSelfStakePercent != other.MinSelfStakePercent {
+		return false
+	}
+	if c.PendingRewards != other.PendingRewards {
+		return false
+	}
+	if !bigEqual(c.InitialReward, other.InitialReward) {
+		return false
+	}
+	if c.HalvingInterval != other.HalvingInterval {
+		return false
+	}
+	if !bigEqual(c.MinReward, other.MinReward) {
+		return false
+	}
+	if c.NoRewards != other.NoRewards {
+		return false
+	}
+	if c.Treasury != other.Treasury {
+		return false
+	}
+	if c.TreasuryPercent != other.TreasuryPercent {
+		return false
+	}
+	if !bigEqual(c.ProposalReward, other.ProposalReward) {
+		return false
+	}
+	if c.VoteDecayPercent != other.VoteDecayPercent {
+		return false
+	}
+	if c.EpochStats != other.EpochStats {
+		return false
+	}
+	if c.RejectConflictBlock != other.RejectConflictBlock {
+		return false
+	}
+	if c.LockDelegation != other.LockDelegation {
+		return false
+	}
 
 	if len(c.Validators) != len(other.Validators) {
 		return false
@@ -426,6 +673,14 @@ func (c *SenateConfig) Equal(other SenateConfig) bool {
 			return false
 		}
 	}
+	if len(c.InitialStakes) != len(other.InitialStakes) {
+		return false
+	}
+	for idx, stake := range c.InitialStakes {
+		if !bigEqual(stake, other.InitialStakes[idx]) {
+			return false
+		}
+	}
 
 	if len(c.Rewards) != len(other.Rewards) {
 		return false
@@ -441,6 +696,18 @@ func (c *SenateConfig) Equal(other SenateConfig) bool {
 	return true
 }
 
+// bigEqual compares two optional big integers, nil is treated as zero since
+// it doesn't survive a rlp round trip.
+func bigEqual(x, y *big.Int) bool {
+	if x == nil {
+		return y == nil || y.Sign() == 0
+	}
+	if y == nil {
+		return x.Sign() == 0
+	}
+	return x.Cmp(y) == 0
+}
+
 // String implements the fmt.Stringer interface.
 func (c *ChainConfig) String() string {
 	var engine interface{}
@@ -452,7 +719,7 @@ func (c *ChainConfig) String() string {
 	default:
 		engine = "unknown"
 	}
-	return fmt.Sprintf("{ChainID: %v Homestead: %v DAO: %v DAOSupport: %v EIP150: %v EIP155: %v EIP158: %v Byzantium: %v Constantinople: %v Petersburg: %v Istanbul: %v, Muir Glacier: %v, YOLO v1: %v, Engine: %v}",
+	return fmt.Sprintf("{ChainID: %v Homestead: %v DAO: %v DAOSupport: %v EIP150: %v EIP155: %v EIP158: %v Byzantium: %v Constantinople: %v Petersburg: %v Istanbul: %v, Muir Glacier: %v, London: %v, YOLO v1: %v, Engine: %v}",
 		c.ChainID,
 		c.HomesteadBlock,
 		c.DAOForkBlock,
@@ -465,6 +732,7 @@ func (c *ChainConfig) String() string {
 		c.PetersburgBlock,
 		c.IstanbulBlock,
 		c.MuirGlacierBlock,
+		c.LondonBlock,
 		c.YoloV1Block,
 		engine,
 	)
@@ -522,6 +790,11 @@ func (c *ChainConfig) IsIstanbul(num *big.Int) bool {
 	return isForked(c.IstanbulBlock, num)
 }
 
+// IsLondon returns whether num is either equal to the London fork block or greater.
+func (c *ChainConfig) IsLondon(num *big.Int) bool {
+	return isForked(c.LondonBlock, num)
+}
+
 // IsYoloV1 returns whether num is either equal to the YoloV1 fork block or greater.
 func (c *ChainConfig) IsYoloV1(num *big.Int) bool {
 	return isForked(c.YoloV1Block, num)
@@ -570,6 +843,7 @@ func (c *ChainConfig) CheckConfigForkOrder() error {
 		{name: "petersburgBlock", block: c.PetersburgBlock},
 		{name: "istanbulBlock", block: c.IstanbulBlock},
 		{name: "muirGlacierBlock", block: c.MuirGlacierBlock, optional: true},
+		{name: "londonBlock", block: c.LondonBlock},
 		{name: "yoloV1Block", block: c.YoloV1Block},
 	} {
 		if lastFork.name != "" {
@@ -634,6 +908,9 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *Confi
 	if isForkIncompatible(c.MuirGlacierBlock, newcfg.MuirGlacierBlock, head) {
 		return newCompatError("Muir Glacier fork block", c.MuirGlacierBlock, newcfg.MuirGlacierBlock)
 	}
+	if isForkIncompatible(c.LondonBlock, newcfg.LondonBlock, head) {
+		return newCompatError("London fork block", c.LondonBlock, newcfg.LondonBlock)
+	}
 	if isForkIncompatible(c.YoloV1Block, newcfg.YoloV1Block, head) {
 		return newCompatError("YOLOv1 fork block", c.YoloV1Block, newcfg.YoloV1Block)
 	}
@@ -19,7 +19,12 @@ package params
 import (
 	"math/big"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/SecretBlockChain/go-secret/common"
+	"github.com/SecretBlockChain/go-secret/common/hexutil"
+	"github.com/SecretBlockChain/go-secret/rlp"
 )
 
 func TestCheckCompatible(t *testing.T) {
@@ -96,3 +101,104 @@ func TestCheckCompatible(t *testing.T) {
 		}
 	}
 }
+
+func TestSenateConfigValidate(t *testing.T) {
+	validSenateConfig := func() *SenateConfig {
+		return &SenateConfig{
+			Period:              5,
+			Epoch:               60,
+			MaxValidatorsCount:  21,
+			MinDelegatorBalance: big.NewInt(1),
+			MinCandidateBalance: big.NewInt(100),
+			Validators:          []common.Address{common.HexToAddress("0x01"), common.HexToAddress("0x02")},
+			InitialStakes:       []*big.Int{big.NewInt(100), big.NewInt(200)},
+		}
+	}
+	if err := validSenateConfig().Validate(); err != nil {
+		t.Fatalf("valid config rejected: %v", err)
+	}
+
+	type test struct {
+		name   string
+		modify func(c *SenateConfig)
+		err    string
+	}
+	tests := []test{
+		{"zero period", func(c *SenateConfig) { c.Period = 0 }, "period"},
+		{"zero epoch", func(c *SenateConfig) { c.Epoch = 0 }, "epoch"},
+		{"epoch shorter than period", func(c *SenateConfig) { c.Epoch = 4 }, "epoch"},
+		{"zero max validators", func(c *SenateConfig) { c.MaxValidatorsCount = 0 }, "maxValidatorsCount"},
+		{"nil min delegator balance", func(c *SenateConfig) { c.MinDelegatorBalance = nil }, "minDelegatorBalance"},
+		{"negative min delegator balance", func(c *SenateConfig) { c.MinDelegatorBalance = big.NewInt(-1) }, "minDelegatorBalance"},
+		{"nil min candidate balance", func(c *SenateConfig) { c.MinCandidateBalance = nil }, "minCandidateBalance"},
+		{"negative min candidate balance", func(c *SenateConfig) { c.MinCandidateBalance = big.NewInt(-1) }, "minCandidateBalance"},
+		{"no validators", func(c *SenateConfig) { c.Validators, c.InitialStakes = nil, nil }, "validators"},
+		{"initial stakes mismatch", func(c *SenateConfig) { c.InitialStakes = c.InitialStakes[:1] }, "initialStakes"},
+		{"nil initial stake", func(c *SenateConfig) { c.InitialStakes[1] = nil }, "initial stake"},
+		{"negative initial stake", func(c *SenateConfig) { c.InitialStakes[0] = big.NewInt(-1) }, "initial stake"},
+		{"refund percent", func(c *SenateConfig) { c.RefundPercent = 101 }, "refundPercent"},
+		{"min mint percent", func(c *SenateConfig) { c.MinMintPercent = 101 }, "minMintPercent"},
+		{"slash percent", func(c *SenateConfig) { c.SlashPercent = 101 }, "slashPercent"},
+		{"proposal quorum", func(c *SenateConfig) { c.ProposalQuorum = 101 }, "proposalQuorum"},
+		{"commission", func(c *SenateConfig) { c.Commission = 101 }, "commission"},
+		{"min self stake percent", func(c *SenateConfig) { c.MinSelfStakePercent = 101 }, "minSelfStakePercent"},
+		{"treasury percent", func(c *SenateConfig) { c.TreasuryPercent = 101 }, "treasuryPercent"},
+		{"vote decay percent", func(c *SenateConfig) { c.VoteDecayPercent = 101 }, "voteDecayPercent"},
+	}
+	for _, test := range tests {
+		config := validSenateConfig()
+		test.modify(config)
+		err := config.Validate()
+		if err == nil {
+			t.Errorf("%s: expected error, got nil", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %q doesn't mention %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestSenateConfigDecodeLegacy(t *testing.T) {
+	// A config as encoded by releases before the optional fields were added
+	legacy := hexutil.MustDecode("0xe9033c150164845f5e1000d594cc7c8317b21e1cea6139700c3c46c21af998d14cc8c78502540be3ff05")
+
+	var config SenateConfig
+	if err := rlp.DecodeBytes(legacy, &config); err != nil {
+		t.Fatalf("failed to decode legacy config: %v", err)
+	}
+	want := SenateConfig{
+		Period:              3,
+		Epoch:               60,
+		MaxValidatorsCount:  21,
+		MinDelegatorBalance: big.NewInt(1),
+		MinCandidateBalance: big.NewInt(100),
+		GenesisTimestamp:    1600000000,
+		Validators:          []common.Address{common.HexToAddress("0xcc7c8317b21e1cea6139700c3c46c21af998d14c")},
+		Rewards:             SenateRewards{{Height: 9999999999, Reward: big.NewInt(5)}},
+	}
+	if !config.Equal(want) {
+		t.Fatalf("legacy config mismatch: have %+v, want %+v", config, want)
+	}
+
+	// Configs without any of the optional fields set keep the legacy encoding
+	enc, err := rlp.EncodeToBytes(want)
+	if err != nil {
+		t.Fatalf("failed to encode config: %v", err)
+	}
+	if !reflect.DeepEqual(enc, legacy) {
+		t.Fatalf("encoding mismatch: have %x, want %x", enc, legacy)
+	}
+
+	want.EpochStats = true
+	if enc, err = rlp.EncodeToBytes(want); err != nil {
+		t.Fatalf("failed to encode config: %v", err)
+	}
+	config = SenateConfig{}
+	if err := rlp.DecodeBytes(enc, &config); err != nil {
+		t.Fatalf("failed to decode config: %v", err)
+	}
+	if !config.EpochStats {
+		t.Fatalf("optional field lost in round trip")
+	}
+}
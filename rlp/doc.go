@@ -102,7 +102,8 @@ Signed integers, floating point numbers, maps, channels and functions cannot be
 
 Struct Tags
 
-Package rlp honours certain struct tags: "-", "tail", "nil", "nilList" and "nilString".
+Package rlp honours certain struct tags: "-", "tail", "nil", "nilList", "nilString" and
+"optional".
 
 The "-" tag ignores fields.
 
@@ -126,5 +127,14 @@ an empty RLP list.
 The choice of null value can be made explicit with the "nilList" and "nilString" struct
 tags. Using these tags encodes/decodes a Go nil pointer value as the kind of empty
 RLP value defined by the tag.
+
+The "optional" tag allows a field to be missing in the input list. When encoding, trailing
+optional fields with their zero value are omitted from the output list. Once a field is
+marked optional, all subsequent fields must be optional as well.
+
+    type StructWithOptionalField struct {
+        Required uint64
+        Optional uint64 `rlp:"optional"`
+    }
 */
 package rlp
@@ -35,6 +35,7 @@ import (
 	"github.com/SecretBlockChain/go-secret/common/hexutil"
 	"github.com/SecretBlockChain/go-secret/common/math"
 	"github.com/SecretBlockChain/go-secret/consensus/clique"
+	"github.com/SecretBlockChain/go-secret/consensus/senate"
 	"github.com/SecretBlockChain/go-secret/core/types"
 	"github.com/SecretBlockChain/go-secret/crypto"
 	"github.com/SecretBlockChain/go-secret/rlp"
@@ -58,6 +59,10 @@ var (
 		accounts.MimetypeClique,
 		0x02,
 	}
+	ApplicationSenate = SigFormat{
+		accounts.MimetypeSenate,
+		0x03,
+	}
 	TextPlain = SigFormat{
 		accounts.MimetypeTextPlain,
 		0x45,
@@ -258,6 +263,39 @@ func (api *SignerAPI) determineSignatureFormat(ctx context.Context, contentType
 		// Clique uses V on the form 0 or 1
 		useEthereumV = false
 		req = &SignDataRequest{ContentType: mediaType, Rawdata: cliqueRlp, Messages: messages, Hash: sighash}
+	case ApplicationSenate.Mime:
+		// Senate sends the signing RLP of the header, which may be prefixed by
+		// the chain id and the domain tag
+		stringData, ok := data.(string)
+		if !ok {
+			return nil, useEthereumV, fmt.Errorf("input for %v must be an hex-encoded string", ApplicationSenate.Mime)
+		}
+		senateRlp, err := hexutil.Decode(stringData)
+		if err != nil {
+			return nil, useEthereumV, err
+		}
+		header, chainID, domain, err := senate.DecodeSenateRLP(senateRlp)
+		if err != nil {
+			return nil, useEthereumV, err
+		}
+		sighash := senate.SealHash(header, chainID, domain)
+		messages := []*NameValueType{
+			{
+				Name:  "Senate header",
+				Typ:   "senate",
+				Value: fmt.Sprintf("senate header %d [0x%x] by %v at %d", header.Number, sighash, header.Coinbase, header.Time),
+			},
+		}
+		if chainID != nil {
+			messages = append(messages, &NameValueType{
+				Name:  "Chain id",
+				Typ:   "uint256",
+				Value: chainID.String(),
+			})
+		}
+		// Senate uses V on the form 0 or 1
+		useEthereumV = false
+		req = &SignDataRequest{ContentType: mediaType, Rawdata: senateRlp, Messages: messages, Hash: sighash.Bytes()}
 	default: // also case TextPlain.Mime:
 		// Calculates an Ethereum ECDSA signature for:
 		// hash = keccak256("\x19${byteVersion}Ethereum Signed Message:\n${message length}${message}")
@@ -22,6 +22,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"path"
 	"strings"
 	"testing"
@@ -30,6 +31,8 @@ import (
 	"github.com/SecretBlockChain/go-secret/common"
 	"github.com/SecretBlockChain/go-secret/common/hexutil"
 	"github.com/SecretBlockChain/go-secret/common/math"
+	"github.com/SecretBlockChain/go-secret/consensus/senate"
+	"github.com/SecretBlockChain/go-secret/core/types"
 	"github.com/SecretBlockChain/go-secret/crypto"
 	"github.com/SecretBlockChain/go-secret/signer/core"
 )
@@ -231,6 +234,48 @@ func TestSignData(t *testing.T) {
 	}
 }
 
+func TestSignDataSenate(t *testing.T) {
+	api, control := setup(t)
+	createAccount(control, api, t)
+	control.approveCh <- "A"
+	list, err := api.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := common.NewMixedcaseAddress(list[0])
+
+	header := &types.Header{
+		ParentHash: common.HexToHash("0x90fcc640d56532c8d4f1255a44533b8d097149c67e298fc7baa1d920925e235f"),
+		Coinbase:   list[0],
+		Difficulty: big.NewInt(1),
+		Number:     big.NewInt(100),
+		GasLimit:   8000000,
+		Time:       1600000000,
+		Extra:      make([]byte, 32+crypto.SignatureLength),
+	}
+	sealHash := senate.SealHash(header, big.NewInt(1337), nil)
+
+	// The signature of the header recovers the account without the Ethereum V
+	control.approveCh <- "Y"
+	control.inputCh <- "a_long_password"
+	signature, err := api.SignData(context.Background(), core.ApplicationSenate.Mime, a, hexutil.Encode(senate.SenateRLP(header, big.NewInt(1337), nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubkey, err := crypto.SigToPub(sealHash.Bytes(), signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer := crypto.PubkeyToAddress(*pubkey); signer != list[0] {
+		t.Errorf("Expected signer %x, got %x", list[0], signer)
+	}
+	// Arbitrary data isn't signed as a senate header
+	signature, err = api.SignData(context.Background(), core.ApplicationSenate.Mime, a, hexutil.Encode([]byte("EHLO world")))
+	if err == nil {
+		t.Errorf("Expected error signing arbitrary data, got signature %x", signature)
+	}
+}
+
 func TestDomainChainId(t *testing.T) {
 	withoutChainID := core.TypedData{
 		Types: core.Types{